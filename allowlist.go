@@ -0,0 +1,92 @@
+package miru
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Allowlist decides which other sites a crawl can follow links into. A host is
+// allowed if it is one of Domains, ends with one of Suffixes or if the link
+// matches one of Patterns.
+type Allowlist struct {
+	Domains  []string
+	Suffixes []string
+	Patterns []*regexp.Regexp
+}
+
+// LoadAllowlist builds the allowlist from the config, it is nil unless cross
+// site crawling is enabled.
+func LoadAllowlist(conf *Config) (*Allowlist, error) {
+	if !conf.Crawl.CrossSite {
+		return nil, nil
+	}
+
+	a := new(Allowlist)
+	for _, domain := range conf.Crawl.AllowDomains {
+		a.Domains = append(a.Domains, strings.ToLower(domain))
+	}
+	for _, suffix := range conf.Crawl.AllowSuffixes {
+		a.Suffixes = append(a.Suffixes, strings.ToLower(suffix))
+	}
+	for _, pattern := range conf.Crawl.AllowPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		a.Patterns = append(a.Patterns, re)
+	}
+	return a, nil
+}
+
+// Allows reports whether a link can be followed. Suffixes match the host
+// itself or any subdomain, so "example.com" allows "news.example.com".
+func (a *Allowlist) Allows(link *url.URL) bool {
+	host := strings.ToLower(link.Host)
+	for _, domain := range a.Domains {
+		if host == domain {
+			return true
+		}
+	}
+	for _, suffix := range a.Suffixes {
+		if strings.HasPrefix(suffix, ".") {
+			if strings.HasSuffix(host, suffix) {
+				return true
+			}
+		} else if host == suffix || strings.HasSuffix(host, "."+suffix) {
+			return true
+		}
+	}
+	for _, re := range a.Patterns {
+		if re.MatchString(link.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// FollowExternal enqueues a page's links to other sites that the context's
+// allowlist accepts. Each site is crawled from its own queue, one hop further
+// from the seed than q, and links are only followed while q is within the hop
+// limit. A site's queue is created and crawled as soon as it is first linked
+// to, and crawled again if it had finished.
+func FollowExternal(c *Context, doc *goquery.Document, q *Queue, page, site string) {
+	if c.Allowlist == nil || q.Hops >= c.Config.Crawl.MaxHops {
+		return
+	}
+
+	for _, href := range ExtractLinks(doc) {
+		target := LinkTarget(page, href)
+		if target == "" {
+			continue
+		}
+		link, err := url.Parse(target)
+		if err != nil || link.Host == site || !c.Allowlist.Allows(link) {
+			continue
+		}
+
+		enqueueSite(c, link, q.Hops+1)
+	}
+}
@@ -0,0 +1,91 @@
+package miru
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowlist_Allows(t *testing.T) {
+	conf := &Config{Crawl: crawl{
+		CrossSite:     true,
+		AllowDomains:  []string{"Example.com"},
+		AllowSuffixes: []string{"example.org", ".gov.uk"},
+		AllowPatterns: []string{`^https://[^/]+/blog/`},
+	}}
+	a, err := LoadAllowlist(conf)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		Link  string
+		Allow bool
+	}{
+		{"http://example.com/", true},
+		{"http://news.example.com/", false},
+		{"http://example.org/", true},
+		{"http://news.example.org/", true},
+		{"http://badexample.org/", false},
+		{"http://www.gov.uk/", true},
+		{"http://gov.uk/", false},
+		{"https://other.net/blog/post", true},
+		{"http://other.net/blog/post", false},
+	}
+
+	for _, test := range tests {
+		link, _ := url.Parse(test.Link)
+		assert.Equal(t, test.Allow, a.Allows(link), test.Link)
+	}
+}
+
+func TestAllowlist_LoadAllowlist(t *testing.T) {
+	a, err := LoadAllowlist(&Config{})
+	assert.NoError(t, err)
+	assert.Nil(t, a)
+
+	_, err = LoadAllowlist(&Config{Crawl: crawl{CrossSite: true, AllowPatterns: []string{"("}}})
+	assert.Error(t, err)
+}
+
+func TestAllowlist_FollowExternal(t *testing.T) {
+	ctx := writerContext()
+	ctx.Config.Crawl = crawl{
+		CrossSite:     true,
+		MaxHops:       1,
+		AllowDomains:  []string{"allowed.com"},
+		AllowSuffixes: []string{"example.org"},
+	}
+	ctx.Allowlist, _ = LoadAllowlist(ctx.Config)
+
+	// Queues that are already being crawled are only added to.
+	allowed, _ := ctx.Queues.Open("allowed.com", 1)
+	news, _ := ctx.Queues.Open("news.example.org", 1)
+
+	doc := newDocument([]byte(`
+<p>
+	<a href="http://allowed.com/a">A</a>
+	<a href="http://allowed.com/a#top">A again</a>
+	<a href="http://news.example.org/b">B</a>
+	<a href="http://blocked.net/">Blocked</a>
+	<a href="/internal">Internal</a>
+</p>`))
+
+	q := NewQueue()
+	q.Name = "site.com"
+	FollowExternal(ctx, doc, q, "http://site.com/", "site.com")
+
+	assert.Equal(t, []string{"http://allowed.com/a"}, allowed.Items)
+	assert.Equal(t, []string{"http://news.example.org/b"}, news.Items)
+	assert.Equal(t, 2, len(ctx.Queues.List()))
+
+	// Links aren't followed from a queue at the hop limit.
+	far := NewQueue()
+	far.Hops = 1
+	FollowExternal(ctx, newDocument([]byte(`<a href="http://allowed.com/c">C</a>`)), far, "http://site.com/", "site.com")
+	assert.Equal(t, []string{"http://allowed.com/a"}, allowed.Items)
+
+	// Nothing is followed when cross site crawling is disabled.
+	ctx.Allowlist = nil
+	FollowExternal(ctx, newDocument([]byte(`<a href="http://allowed.com/d">D</a>`)), q, "http://site.com/", "site.com")
+	assert.Equal(t, []string{"http://allowed.com/a"}, allowed.Items)
+}
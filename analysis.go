@@ -0,0 +1,305 @@
+package miru
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/reiver/go-porterstemmer"
+)
+
+// ErrUnknownStopWords for when a stop word list isn't built in.
+var ErrUnknownStopWords = errors.New("Stop word list does not exist.")
+
+// Token is a single word produced by an analyzer. Text is the normalised form
+// of the word as it appeared and Term is what gets stored in the index.
+type Token struct {
+	Text string
+	Term string
+}
+
+// Tokenizer splits text into words.
+type Tokenizer interface {
+	Tokenize(text string) []string
+}
+
+// TokenFilter transforms a single word, returning an empty string drops the
+// word entirely.
+type TokenFilter interface {
+	Filter(word string) string
+}
+
+// Stemmer reduces a word to its stem.
+type Stemmer interface {
+	Stem(word string) string
+}
+
+// Analyzer turns text into tokens. The same analyzer has to be used when
+// indexing and when searching, otherwise terms won't line up.
+type Analyzer interface {
+	Analyze(text string) []Token
+	Normalise(word string) string
+}
+
+// Pipeline is an Analyzer made up of a tokenizer, a chain of filters that are
+// applied in order and an optional stemmer.
+type Pipeline struct {
+	Tokenizer Tokenizer
+	Filters   []TokenFilter
+	Stemmer   Stemmer
+}
+
+// NewAnalyzer creates the standard pipeline: unicode tokenisation, lowercasing,
+// folding, stop word removal and Porter stemming.
+func NewAnalyzer(stop map[string]bool) *Pipeline {
+	return &Pipeline{
+		Tokenizer: UnicodeTokenizer{},
+		Filters: []TokenFilter{
+			LowercaseFilter{},
+			FoldFilter{},
+			StopFilter{Words: stop},
+		},
+		Stemmer: PorterStemmer{},
+	}
+}
+
+// DefaultAnalyzer is used when no analyzer has been configured.
+var DefaultAnalyzer Analyzer = NewAnalyzer(stopWords)
+
+// Analyze tokenises text and passes each word through the filters and stemmer.
+func (p *Pipeline) Analyze(text string) []Token {
+	tokens := []Token{}
+	for _, word := range p.Tokenizer.Tokenize(text) {
+		word = p.filter(word)
+		if word == "" {
+			continue
+		}
+		tokens = append(tokens, Token{Text: word, Term: p.stem(word)})
+	}
+	return tokens
+}
+
+// Normalise runs a single word through the filters and stemmer without
+// tokenising it first.
+func (p *Pipeline) Normalise(word string) string {
+	word = p.filter(word)
+	if word == "" {
+		return ""
+	}
+	return p.stem(word)
+}
+
+func (p *Pipeline) filter(word string) string {
+	for _, f := range p.Filters {
+		if word = f.Filter(word); word == "" {
+			return ""
+		}
+	}
+	return word
+}
+
+func (p *Pipeline) stem(word string) string {
+	if p.Stemmer == nil {
+		return word
+	}
+	return p.Stemmer.Stem(word)
+}
+
+// Terms returns the indexed term of each token.
+func Terms(tokens []Token) []string {
+	terms := []string{}
+	for _, token := range tokens {
+		terms = append(terms, token.Term)
+	}
+	return terms
+}
+
+// UnicodeTokenizer splits text on word boundaries. Letters, digits and
+// combining marks make up words, an apostrophe is only kept when it joins two
+// parts of a word (e.g. "wasn't"). Ideographic characters are treated as words
+// on their own.
+type UnicodeTokenizer struct{}
+
+// Tokenize splits text into words.
+func (UnicodeTokenizer) Tokenize(text string) []string {
+	words := []string{}
+	runes := []rune(text)
+	start := -1
+
+	for i, r := range runes {
+		if isIdeograph(r) {
+			if start >= 0 {
+				words = append(words, string(runes[start:i]))
+				start = -1
+			}
+			words = append(words, string(r))
+			continue
+		}
+
+		joined := start >= 0 && isApostrophe(r) &&
+			i+1 < len(runes) && isWordRune(runes[i+1])
+		if isWordRune(r) || joined {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+
+		if start >= 0 {
+			words = append(words, string(runes[start:i]))
+			start = -1
+		}
+	}
+	if start >= 0 {
+		words = append(words, string(runes[start:]))
+	}
+	return words
+}
+
+func isWordRune(r rune) bool {
+	return !isIdeograph(r) &&
+		(unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r))
+}
+
+func isIdeograph(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r)
+}
+
+func isApostrophe(r rune) bool {
+	return r == '\'' || r == '’'
+}
+
+// LowercaseFilter lowercases words.
+type LowercaseFilter struct{}
+
+// Filter lowercases a word.
+func (LowercaseFilter) Filter(word string) string {
+	return strings.ToLower(word)
+}
+
+// diacritics maps groups of lowercase accented letters to their plain form.
+var diacritics = map[string]string{
+	"àáâãäåāăą":  "a",
+	"çćĉċč":      "c",
+	"ďđð":        "d",
+	"èéêëēĕėęě":  "e",
+	"ĝğġģ":       "g",
+	"ĥħ":         "h",
+	"ìíîïĩīĭįı":  "i",
+	"ĵ":          "j",
+	"ķ":          "k",
+	"ĺļľŀł":      "l",
+	"ñńņňŉ":      "n",
+	"òóôõöøōŏő":  "o",
+	"ŕŗř":        "r",
+	"śŝşšſ":      "s",
+	"ţťŧ":        "t",
+	"ùúûüũūŭůűų": "u",
+	"ŵ":          "w",
+	"ýÿŷ":        "y",
+	"źżž":        "z",
+	"æ":          "ae",
+	"œ":          "oe",
+	"ß":          "ss",
+	"þ":          "th",
+}
+
+var foldings = map[rune]string{}
+
+func init() {
+	for letters, plain := range diacritics {
+		for _, r := range letters {
+			foldings[r] = plain
+		}
+	}
+}
+
+// FoldFilter strips diacritics and punctuation so that "café" and "cafe" are
+// the same word. Apostrophes inside a word are kept but normalised.
+type FoldFilter struct{}
+
+// Filter folds a word.
+func (FoldFilter) Filter(word string) string {
+	word = strings.TrimFunc(word, func(r rune) bool {
+		return unicode.IsPunct(r) || unicode.IsSymbol(r)
+	})
+
+	folded := make([]rune, 0, len(word))
+	for _, r := range word {
+		switch {
+		case isApostrophe(r):
+			folded = append(folded, '\'')
+		case unicode.Is(unicode.Mn, r):
+			// Combining marks left over from decomposed text.
+		case foldings[r] != "":
+			folded = append(folded, []rune(foldings[r])...)
+		default:
+			folded = append(folded, r)
+		}
+	}
+	return string(folded)
+}
+
+// StopFilter drops any word found in Words.
+type StopFilter struct {
+	Words map[string]bool
+}
+
+// Filter drops stop words.
+func (f StopFilter) Filter(word string) string {
+	if f.Words[word] {
+		return ""
+	}
+	return word
+}
+
+// PorterStemmer stems English words using the Porter algorithm.
+type PorterStemmer struct{}
+
+// Stem stems a word, the word is expected to be lowercase already. Possessive
+// endings are removed before stemming.
+func (PorterStemmer) Stem(word string) string {
+	word = strings.TrimSuffix(strings.TrimSuffix(word, "'s"), "'")
+	return string(porterstemmer.StemWithoutLowerCasing([]rune(word)))
+}
+
+// StopWords holds the built in stop word lists by name.
+var StopWords = map[string]map[string]bool{
+	"english": stopWords,
+	"none":    map[string]bool{},
+}
+
+// LoadStopWords builds a stop word list from one of the built in lists and an
+// optional file containing extra words, one per line.
+func LoadStopWords(name, file string) (map[string]bool, error) {
+	list, ok := StopWords[name]
+	if !ok {
+		return nil, ErrUnknownStopWords
+	}
+
+	words := map[string]bool{}
+	for word := range list {
+		words[word] = true
+	}
+
+	if file == "" {
+		return words, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		word := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if word != "" && !strings.HasPrefix(word, "#") {
+			words[word] = true
+		}
+	}
+	return words, scanner.Err()
+}
@@ -0,0 +1,134 @@
+package miru
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalysis_UnicodeTokenizer(t *testing.T) {
+	tests := []struct {
+		Input  string
+		Output []string
+	}{
+		{
+			"Hello, world!",
+			[]string{"Hello", "world"},
+		},
+		{
+			"news, news. (news)",
+			[]string{"news", "news", "news"},
+		},
+		{
+			"It wasn't me' 'really'",
+			[]string{"It", "wasn't", "me", "really"},
+		},
+		{
+			"l’homme über-cool naïve",
+			[]string{"l’homme", "über", "cool", "naïve"},
+		},
+		{
+			"version 2.0",
+			[]string{"version", "2", "0"},
+		},
+		{
+			"東京タワー",
+			[]string{"東", "京", "タワー"},
+		},
+		{
+			"",
+			[]string{},
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.Output, UnicodeTokenizer{}.Tokenize(test.Input))
+	}
+}
+
+func TestAnalysis_FoldFilter(t *testing.T) {
+	tests := []struct {
+		Input  string
+		Output string
+	}{
+		{"café", "cafe"},
+		{"straße", "strasse"},
+		{"œuvre", "oeuvre"},
+		{"wasn’t", "wasn't"},
+		{"\"quoted\"", "quoted"},
+		{"café", "cafe"},
+		{"?!", ""},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.Output, FoldFilter{}.Filter(test.Input))
+	}
+}
+
+func TestAnalysis_StopFilter(t *testing.T) {
+	f := StopFilter{Words: map[string]bool{"the": true}}
+
+	assert.Equal(t, "", f.Filter("the"))
+	assert.Equal(t, "cat", f.Filter("cat"))
+}
+
+func TestAnalysis_Analyze(t *testing.T) {
+	tokens := DefaultAnalyzer.Analyze("The News, and NEWS’s café wasn’t open")
+
+	assert.Equal(t, []Token{
+		{Text: "news", Term: "new"},
+		{Text: "news's", Term: "new"},
+		{Text: "cafe", Term: "cafe"},
+		{Text: "open", Term: "open"},
+	}, tokens)
+	assert.Equal(t, []string{"new", "new", "cafe", "open"}, Terms(tokens))
+}
+
+func TestAnalysis_Pipeline_NoStemmer(t *testing.T) {
+	p := &Pipeline{
+		Tokenizer: UnicodeTokenizer{},
+		Filters:   []TokenFilter{LowercaseFilter{}},
+	}
+
+	assert.Equal(t, []string{"running", "fast"}, Terms(p.Analyze("Running FAST")))
+	assert.Equal(t, "running", p.Normalise("Running"))
+}
+
+func TestAnalysis_LoadStopWords(t *testing.T) {
+	words, err := LoadStopWords("english", "")
+	assert.NoError(t, err)
+	assert.True(t, words["the"])
+
+	words, err = LoadStopWords("none", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(words))
+
+	_, err = LoadStopWords("klingon", "")
+	assert.Equal(t, ErrUnknownStopWords, err)
+
+	_, err = LoadStopWords("none", "does-not-exist.txt")
+	assert.Error(t, err)
+}
+
+func TestAnalysis_LoadStopWords_File(t *testing.T) {
+	f, err := ioutil.TempFile("", "stopwords")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString("# custom words\nLorem\n\nipsum\n")
+	f.Close()
+
+	words, err := LoadStopWords("english", f.Name())
+	assert.NoError(t, err)
+	assert.True(t, words["lorem"])
+	assert.True(t, words["ipsum"])
+	assert.True(t, words["the"])
+	assert.False(t, words["# custom words"])
+
+	// The built in list shouldn't be modified.
+	assert.False(t, stopWords["lorem"])
+}
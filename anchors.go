@@ -0,0 +1,88 @@
+package miru
+
+// AnchorIndexes indexes the anchor text of links to a document under
+// FieldAnchor, so a page can be found by how other pages describe it. Links
+// from the document itself and nofollow links are left out.
+func AnchorIndexes(a Analyzer, d *Document, links []*Link) Indexes {
+	text := ""
+	for _, l := range links {
+		if l.Source == d.DocID || l.NoFollow || l.Anchor == "" {
+			continue
+		}
+		text += l.Anchor + "\n"
+	}
+	if text == "" {
+		return Indexes{}
+	}
+	return FieldIndexer(a, text, d.DocID, FieldAnchor)
+}
+
+// linksTo returns the links to a document's URL and to its canonical URL.
+func linksTo(s Store, d *Document) ([]*Link, error) {
+	links, err := s.LinksTo(d.Url)
+	if err != nil {
+		return nil, err
+	}
+	if d.Canonical == "" || d.Canonical == d.Url {
+		return links, nil
+	}
+
+	canonical, err := s.LinksTo(d.Canonical)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, l := range links {
+		seen[l.LinkID] = true
+	}
+	for _, l := range canonical {
+		if !seen[l.LinkID] {
+			links = append(links, l)
+		}
+	}
+	return links, nil
+}
+
+// anchorIndexes returns the anchor indexes for a batch, each written document
+// gets the anchor text of every stored link to it, and documents stored before
+// the batch get the anchor text of the batch's links to them. A document whose
+// links or target can't be read is left out and the first such error is
+// returned along with the rest.
+func anchorIndexes(c *Context, written []*Document, links []*Link) (Indexes, error) {
+	var first error
+	ixs := Indexes{}
+	batch := map[string]bool{}
+	for _, d := range written {
+		batch[d.Url] = true
+
+		to, err := linksTo(c.Store, d)
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		ixs = append(ixs, AnchorIndexes(c.AnalyzerFor(d.Language), d, to)...)
+	}
+
+	targets := map[string][]*Link{}
+	for _, l := range links {
+		if !batch[l.Target] {
+			targets[l.Target] = append(targets[l.Target], l)
+		}
+	}
+	for target, to := range targets {
+		d, err := c.Store.DocumentByURL(target)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		ixs = append(ixs, AnchorIndexes(c.AnalyzerFor(d.Language), d, to)...)
+	}
+	return ixs, first
+}
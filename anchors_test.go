@@ -0,0 +1,177 @@
+package miru
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnchors_AnchorIndexes(t *testing.T) {
+	d := NewDocument("http://example.com/", "example.com", "", "")
+	links := []*Link{
+		NewLink("other", d.Url, "Weather forecasts", false),
+		NewLink("another", d.Url, "weather", false),
+		NewLink(d.DocID, d.Url, "Home", false),
+		NewLink("spam", d.Url, "Cheap pills", true),
+	}
+
+	counts := map[string]int64{}
+	for _, i := range AnchorIndexes(DefaultAnalyzer, d, links) {
+		assert.Equal(t, FieldAnchor, i.Field)
+		assert.Equal(t, d.DocID, i.DocID)
+		counts[i.Word] = i.Count
+	}
+	assert.Equal(t, map[string]int64{"weather": 2, "forecast": 1}, counts)
+
+	assert.Equal(t, 0, len(AnchorIndexes(DefaultAnalyzer, d, nil)))
+}
+
+// anchorWords returns the words indexed from anchor text for a document.
+func anchorWords(c *Context, docID string) map[string]int64 {
+	words := map[string]int64{}
+	for _, i := range storedIndexes(c) {
+		if i.DocID == docID && i.Field == FieldAnchor {
+			words[i.Word] += i.Count
+		}
+	}
+	return words
+}
+
+func TestAnchors_Writer(t *testing.T) {
+	ctx := writerContext()
+	defer ctx.Writer.Close()
+
+	// The target is written before the page linking to it.
+	target := NewDocument("http://example.com/storms", "example.com", "", "")
+	ctx.Writer.Add(target, IndexDocument(ctx.Analyzer, target), nil)
+	assert.NoError(t, ctx.Writer.Flush())
+
+	source := NewDocument("http://example.com/", "example.com", "", "")
+	ctx.Writer.AddPage(&Page{
+		Document: source,
+		Indexes:  IndexDocument(ctx.Analyzer, source),
+		Links: []*Link{
+			NewLink(source.DocID, target.Url, "Storm warnings", false),
+			NewLink(source.DocID, "http://example.com/later", "Flood maps", false),
+		},
+	}, nil)
+	assert.NoError(t, ctx.Writer.Flush())
+	assert.Equal(t, map[string]int64{"storm": 1, "warn": 1}, anchorWords(ctx, target.DocID))
+
+	// The target is written after the page linking to it.
+	later := NewDocument("http://example.com/later", "example.com", "", "")
+	ctx.Writer.Add(later, IndexDocument(ctx.Analyzer, later), nil)
+	assert.NoError(t, ctx.Writer.Flush())
+	assert.Equal(t, map[string]int64{"flood": 1, "map": 1}, anchorWords(ctx, later.DocID))
+
+	// A page with no text of its own is found by its anchor text.
+	res := new(Results)
+	assert.NoError(t, res.Search("flood", ctx))
+	if assert.Equal(t, int64(1), res.Count) {
+		assert.Equal(t, later.Url, res.Results[0].Url)
+	}
+}
+
+func TestAnchors_Writer_SameBatch(t *testing.T) {
+	ctx := writerContext()
+	defer ctx.Writer.Close()
+
+	source := NewDocument("http://example.com/", "example.com", "", "")
+	target := NewDocument("http://example.com/storms", "example.com", "", "")
+	target.Canonical = "http://example.com/weather/storms"
+	ctx.Writer.AddPage(&Page{
+		Document: source,
+		Indexes:  IndexDocument(ctx.Analyzer, source),
+		Links: []*Link{
+			NewLink(source.DocID, target.Url, "Storms", false),
+			NewLink(source.DocID, target.Canonical, "Storm news", false),
+		},
+	}, nil)
+	ctx.Writer.Add(target, IndexDocument(ctx.Analyzer, target), nil)
+	assert.NoError(t, ctx.Writer.Flush())
+
+	assert.Equal(t, map[string]int64{"storm": 2, "new": 1}, anchorWords(ctx, target.DocID))
+}
+
+func TestAnchors_Writer_Duplicate(t *testing.T) {
+	ctx := writerContext()
+	defer ctx.Writer.Close()
+
+	target := NewDocument("http://example.com/storms", "example.com", "", "")
+	first := NewDocument("http://example.com/1", "example.com", "", "")
+	ctx.Writer.Add(target, IndexDocument(ctx.Analyzer, target), nil)
+	ctx.Writer.AddPage(&Page{
+		Document: first,
+		Links:    []*Link{NewLink(first.DocID, target.Url, "Storms", false)},
+	}, nil)
+	assert.NoError(t, ctx.Writer.Flush())
+
+	links := []*Link{NewLink("second", target.Url, "Storms", false)}
+	ix := AnchorIndexes(ctx.Analyzer, target, links)[0]
+	assert.Equal(t, target.DocID+":"+FieldAnchor+":"+ix.Word, ix.IndexID)
+	frequency := ctx.Vocabulary.Frequency(ix.Text)
+
+	// The target already has the anchor word, so its count goes up and the
+	// word is counted again.
+	second := NewDocument("http://example.com/2", "example.com", "", "")
+	links[0].Source = second.DocID
+	ctx.Writer.AddPage(&Page{Document: second, Links: links}, nil)
+	assert.NoError(t, ctx.Writer.Flush())
+	assert.Equal(t, map[string]int64{"storm": 2}, anchorWords(ctx, target.DocID))
+	assert.Equal(t, frequency+1, ctx.Vocabulary.Frequency(ix.Text))
+
+	// Links from one batch with the same text are added together.
+	third := NewDocument("http://example.com/3", "example.com", "", "")
+	ctx.Writer.AddPage(&Page{
+		Document: third,
+		Links:    []*Link{NewLink(third.DocID, target.Url, "Storms", false)},
+	}, nil)
+	fourth := NewDocument("http://example.com/4", "example.com", "", "")
+	ctx.Writer.AddPage(&Page{
+		Document: fourth,
+		Links:    []*Link{NewLink(fourth.DocID, target.Url, "Storms", false)},
+	}, nil)
+	assert.NoError(t, ctx.Writer.Flush())
+	assert.Equal(t, map[string]int64{"storm": 4}, anchorWords(ctx, target.DocID))
+	assert.Equal(t, frequency+3, ctx.Vocabulary.Frequency(ix.Text))
+}
+
+// failingLinksTo fails to read the links to one URL.
+type failingLinksTo struct {
+	Store
+	url string
+}
+
+func (s failingLinksTo) LinksTo(url string) ([]*Link, error) {
+	if url == s.url {
+		return nil, errors.New("Links unavailable.")
+	}
+	return s.Store.LinksTo(url)
+}
+
+func TestAnchors_Writer_LookupError(t *testing.T) {
+	ctx := writerContext()
+	defer ctx.Writer.Close()
+
+	source := NewDocument("http://example.com/", "example.com", "", "")
+	ctx.Writer.AddPage(&Page{
+		Document: source,
+		Links: []*Link{
+			NewLink(source.DocID, "http://example.com/storms", "Storms", false),
+			NewLink(source.DocID, "http://example.com/floods", "Floods", false),
+		},
+	}, nil)
+	assert.NoError(t, ctx.Writer.Flush())
+
+	// The document whose links can't be read is left out, the other still
+	// gets its anchor text.
+	broken := NewDocument("http://example.com/storms", "example.com", "", "")
+	floods := NewDocument("http://example.com/floods", "example.com", "", "")
+	ctx.Store = failingLinksTo{Store: ctx.Store, url: broken.Url}
+	ctx.Writer.Add(broken, nil, nil)
+	ctx.Writer.Add(floods, nil, nil)
+	assert.Error(t, ctx.Writer.Flush())
+	assert.Equal(t, 0, len(anchorWords(ctx, broken.DocID)))
+	assert.Equal(t, map[string]int64{"flood": 1}, anchorWords(ctx, floods.DocID))
+}
@@ -1,11 +1,17 @@
 package miru
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
+	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 
-	rdb "github.com/dancannon/gorethink"
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
 )
@@ -19,6 +25,7 @@ type Response struct {
 type queueList struct {
 	Name   string `json:"name"`
 	Status string `json:"status"`
+	Hops   int    `json:"hops,omitempty"`
 }
 
 // QueueList is a sortable interface for keeping queue items in order.
@@ -31,7 +38,8 @@ func (ql QueueList) Swap(i, j int) { ql[i], ql[j] = ql[j], ql[i] }
 func (ql QueueList) Less(i, j int) bool { return ql[i].Name < ql[j].Name }
 
 // APIRoutes configures the routes for the API, cross-origin resource sharing is
-// applied to each route then can be reached by external requests.
+// applied to each route then can be reached by external requests. Routes that
+// delete or rebuild documents are left out of it and need the API token.
 func APIRoutes(m *mux.Router, c *Context) {
 	s := m.PathPrefix("/api").Subrouter()
 
@@ -39,9 +47,323 @@ func APIRoutes(m *mux.Router, c *Context) {
 
 	s.Handle("/queue/{name}", _c.Handler(APIQueueHandler(c))).Methods("GET")
 	s.Handle("/queues/", _c.Handler(APIQueuesHandler(c))).Methods("GET")
+	s.Handle("/feeds", _c.Handler(APIFeedsHandler(c))).Methods("GET")
 	s.Handle("/crawl", _c.Handler(APICrawlHandler(c))).Methods("GET")
 	s.Handle("/search", _c.Handler(APISearchHandler(c))).Methods("GET")
 	s.Handle("/sites", _c.Handler(APISitesHandler(c))).Methods("GET")
+	s.Handle("/sites/{site}", authorized(c, APIDeleteSiteHandler(c))).Methods("DELETE")
+	s.Handle("/sites/{site}/documents", _c.Handler(APISiteDocumentsHandler(c))).Methods("GET")
+	s.Handle("/documents", _c.Handler(APIDocumentByURLHandler(c))).Methods("GET")
+	s.Handle("/documents", authorized(c, APIPurgeHandler(c))).Methods("DELETE")
+	s.Handle("/documents/{id}", _c.Handler(APIDocumentHandler(c))).Methods("GET")
+	s.Handle("/documents/{id}", authorized(c, APIDeleteDocumentHandler(c))).Methods("DELETE")
+	s.Handle("/suggest", _c.Handler(APISuggestHandler(c))).Methods("GET")
+	s.Handle("/stats", _c.Handler(APIStatsHandler(c))).Methods("GET")
+	s.Handle("/reindex", _c.Handler(APIReindexProgressHandler(c))).Methods("GET")
+	s.Handle("/reindex", authorized(c, APIReindexHandler(c))).Methods("POST")
+
+	m.Handle("/healthz", HealthHandler(c)).Methods("GET")
+	m.Handle("/readyz", ReadyHandler(c)).Methods("GET")
+}
+
+// authorized serves a handler only to requests that give the token set under
+// [api] as "Authorization: Bearer <token>". Without a token configured every
+// request is refused.
+func authorized(c *Context, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := []byte("Bearer " + c.Config.Api.Token)
+		given := []byte(r.Header.Get("Authorization"))
+		if c.Config.Api.Token == "" || subtle.ConstantTimeCompare(given, token) != 1 {
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(Response{
+				Status:  http.StatusUnauthorized,
+				Message: "A valid API token is required.",
+			})
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// APIStatsHandler (GET) returns statistics about the index.
+func APIStatsHandler(c *Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+
+		encoder := json.NewEncoder(w)
+
+		stats, err := c.Stats.Get(c)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			encoder.Encode(Response{
+				Status:  http.StatusInternalServerError,
+				Message: "Could not retrieve stats.",
+			})
+			return
+		}
+
+		encoder.Encode(stats)
+	})
+}
+
+// HealthHandler (GET) reports whether miru is running with a store it can still
+// write crawled pages to.
+func HealthHandler(c *Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+
+		encoder := json.NewEncoder(w)
+
+		err := c.Writer.Err()
+		if c.Store == nil {
+			err = ErrNotConnected
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			encoder.Encode(Response{
+				Status:  http.StatusServiceUnavailable,
+				Message: err.Error(),
+			})
+			return
+		}
+
+		encoder.Encode(Response{
+			Status:  http.StatusOK,
+			Message: "OK",
+		})
+	})
+}
+
+// ReadyHandler (GET) reports whether the store can be reached, so that requests
+// can be served.
+func ReadyHandler(c *Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+
+		encoder := json.NewEncoder(w)
+
+		if err := c.Ping(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			encoder.Encode(Response{
+				Status:  http.StatusServiceUnavailable,
+				Message: err.Error(),
+			})
+			return
+		}
+
+		encoder.Encode(Response{
+			Status:  http.StatusOK,
+			Message: "OK",
+		})
+	})
+}
+
+// writeDocument encodes a document's metadata, or a 404 if it wasn't found.
+func writeDocument(w http.ResponseWriter, d *Document, err error) {
+	encoder := json.NewEncoder(w)
+
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		encoder.Encode(Response{
+			Status:  http.StatusNotFound,
+			Message: "Document not found.",
+		})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		encoder.Encode(Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Could not retrieve document.",
+		})
+		return
+	}
+
+	encoder.Encode(d.Info())
+}
+
+// APIDocumentHandler (GET) returns the metadata of a single document.
+func APIDocumentHandler(c *Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+
+		d, err := c.Store.Document(mux.Vars(r)["id"])
+		writeDocument(w, d, err)
+	})
+}
+
+// APIDocumentByURLHandler (GET) returns the metadata of the latest document
+// fetched from the URL given by the parameter 'url'.
+func APIDocumentByURLHandler(c *Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+
+		link := r.URL.Query().Get("url")
+		if len(link) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(Response{
+				Status:  http.StatusBadRequest,
+				Message: "URL parameter 'url' was empty.",
+			})
+			return
+		}
+
+		d, err := c.Store.DocumentByURL(link)
+		writeDocument(w, d, err)
+	})
+}
+
+// APISiteDocumentsHandler (GET) lists the metadata of a site's documents
+// ordered by URL. Pages are chosen with 'offset' and 'limit', which defaults to
+// 20 and can be at most 100.
+func APISiteDocumentsHandler(c *Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+
+		encoder := json.NewEncoder(w)
+		site := mux.Vars(r)["site"]
+
+		offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+		if err != nil || offset < 0 {
+			offset = 0
+		}
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 {
+			limit = 20
+		}
+		if limit > 100 {
+			limit = 100
+		}
+
+		docs, total, err := c.Store.SiteDocuments(site, offset, limit)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			encoder.Encode(Response{
+				Status:  http.StatusInternalServerError,
+				Message: "Could not retrieve documents.",
+			})
+			return
+		}
+
+		type page struct {
+			Site      string         `json:"site"`
+			Total     int            `json:"total"`
+			Offset    int            `json:"offset"`
+			Limit     int            `json:"limit"`
+			Documents []DocumentInfo `json:"documents"`
+		}
+
+		p := page{Site: site, Total: total, Offset: offset, Limit: limit, Documents: []DocumentInfo{}}
+		for _, d := range docs {
+			p.Documents = append(p.Documents, d.Info())
+		}
+		encoder.Encode(p)
+	})
+}
+
+// APIDeleteDocumentHandler (DELETE) removes a document and its indexes from the
+// datastore.
+func APIDeleteDocumentHandler(c *Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+
+		encoder := json.NewEncoder(w)
+		id := mux.Vars(r)["id"]
+
+		if err := DeleteDocument(c, id); err != nil {
+			if err == ErrNotFound {
+				w.WriteHeader(http.StatusNotFound)
+				encoder.Encode(Response{
+					Status:  http.StatusNotFound,
+					Message: "Document not found.",
+				})
+				return
+			}
+
+			w.WriteHeader(http.StatusInternalServerError)
+			encoder.Encode(Response{
+				Status:  http.StatusInternalServerError,
+				Message: "Could not delete document.",
+			})
+			return
+		}
+
+		encoder.Encode(Response{
+			Status:  http.StatusOK,
+			Message: "Document deleted.",
+		})
+	})
+}
+
+// APIDeleteSiteHandler (DELETE) stops any crawl of a site and removes all of
+// its documents and indexes from the datastore.
+func APIDeleteSiteHandler(c *Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+
+		encoder := json.NewEncoder(w)
+
+		deleted, err := DeleteSite(c, mux.Vars(r)["site"])
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			encoder.Encode(Response{
+				Status:  http.StatusInternalServerError,
+				Message: "Could not delete site.",
+			})
+			return
+		}
+
+		encoder.Encode(Response{
+			Status:  http.StatusOK,
+			Message: fmt.Sprintf("Deleted %d documents.", deleted),
+		})
+	})
+}
+
+// APIPurgeHandler (DELETE) removes every document with a URL matching the
+// parameter 'url', where '*' matches any characters. A pattern matching every
+// URL is refused unless 'all=true' is passed too.
+func APIPurgeHandler(c *Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+
+		encoder := json.NewEncoder(w)
+		pattern := r.URL.Query().Get("url")
+
+		if len(pattern) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			encoder.Encode(Response{
+				Status:  http.StatusBadRequest,
+				Message: "URL parameter 'url' was empty.",
+			})
+			return
+		}
+
+		if strings.Trim(pattern, "*") == "" && r.URL.Query().Get("all") != "true" {
+			w.WriteHeader(http.StatusBadRequest)
+			encoder.Encode(Response{
+				Status:  http.StatusBadRequest,
+				Message: "URL parameter 'url' matches every document, pass 'all=true' to delete them all.",
+			})
+			return
+		}
+
+		deleted, err := Purge(c, URLPattern(pattern))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			encoder.Encode(Response{
+				Status:  http.StatusInternalServerError,
+				Message: "Could not delete documents.",
+			})
+			return
+		}
+
+		encoder.Encode(Response{
+			Status:  http.StatusOK,
+			Message: fmt.Sprintf("Deleted %d documents.", deleted),
+		})
+	})
 }
 
 // APISitesHandler (GET) returns a list of sites.
@@ -52,12 +374,12 @@ func APISitesHandler(c *Context) http.Handler {
 		encoder := json.NewEncoder(w)
 
 		type site struct {
-			Site string `gorethinkdb:"site" json:"site"`
+			Site string `json:"site"`
 		}
 
 		sites := []site{}
 
-		res, err := rdb.Db(c.Config.Database.Name).Table(c.Config.Tables.Document).Pluck("site").Distinct().Run(c.Db)
+		names, err := c.Store.Sites()
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			encoder.Encode(Response{
@@ -67,14 +389,23 @@ func APISitesHandler(c *Context) http.Handler {
 			return
 		}
 
-		res.All(&sites)
+		for _, name := range names {
+			sites = append(sites, site{Site: name})
+		}
 
 		encoder.Encode(sites)
 	})
 }
 
-// APISearchHandler (GET) allows one to search the datastore. Accepts one
-// parameter: 'q', which is a URL encoded string.
+// APISearchHandler (GET) allows one to search the datastore. Accepts the
+// parameter 'q', which is a URL encoded string, and optionally 'lang' to choose
+// the language the query is analysed in and restrict results to. Results can
+// be filtered by 'site', 'type', fetch dates with 'from' and 'to' and
+// structured data with 'entity' and 'entity.<property>', facet counts are
+// returned alongside. When nothing matches suggestions are returned,
+// 'autocorrect' overrides whether the best one is searched instead. Passing
+// 'match=all' only returns documents containing every word. With 'type=image'
+// images are searched instead of documents.
 func APISearchHandler(c *Context) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Content-Type", "application/json")
@@ -92,8 +423,47 @@ func APISearchHandler(c *Context) http.Handler {
 			return
 		}
 
+		filter, err := ParseFilter(r.URL.Query())
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			encoder.Encode(Response{
+				Status:  http.StatusBadRequest,
+				Message: "Date parameters 'from' and 'to' must be formatted as YYYY-MM-DD.",
+			})
+			return
+		}
+
+		q := &Query{
+			Text:        query,
+			Language:    filter.Language,
+			AutoCorrect: c.Config.Search.AutoCorrect,
+			MatchAll:    r.URL.Query().Get("match") == "all",
+			Filter:      filter,
+		}
+		if autocorrect := r.URL.Query().Get("autocorrect"); autocorrect != "" {
+			q.AutoCorrect = autocorrect == "true" || autocorrect == "1"
+		}
+
+		if filter.Type == MediaImage {
+			res := MediaResults{}
+			if err := res.Find(q, c); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				encoder.Encode(Response{
+					Status:  http.StatusInternalServerError,
+					Message: "Search failed.",
+				})
+				return
+			}
+			encoder.Encode(res)
+			return
+		}
+
 		res := Results{}
-		if err := res.Search(query, c); err != nil {
+		err = res.Find(q, c)
+		if err == nil {
+			err = res.Suggest(q, c)
+		}
+		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			encoder.Encode(Response{
 				Status:  http.StatusInternalServerError,
@@ -102,10 +472,91 @@ func APISearchHandler(c *Context) http.Handler {
 			return
 		}
 
+		if res.Count > 0 && res.Corrected == "" {
+			c.Completer.RecordQuery(q.Text, c.AnalyzerFor(res.Language))
+		}
+
 		encoder.Encode(res)
 	})
 }
 
+// ParseFilter builds a search filter from the 'site', 'lang', 'type' and
+// 'entity' parameters, 'from' and 'to' are dates in either YYYY-MM-DD or RFC
+// 3339 form. Parameters named 'entity.<property>' filter on entity properties.
+func ParseFilter(values url.Values) (Filter, error) {
+	filter := Filter{
+		Site:     values.Get("site"),
+		Language: values.Get("lang"),
+		Type:     values.Get("type"),
+		Entity:   values.Get("entity"),
+	}
+
+	for key := range values {
+		if name := strings.TrimPrefix(key, "entity."); name != key && name != "" {
+			if filter.Properties == nil {
+				filter.Properties = map[string]string{}
+			}
+			filter.Properties[name] = values.Get(key)
+		}
+	}
+
+	var err error
+	if filter.From, err = parseDate(values.Get("from")); err != nil {
+		return filter, err
+	}
+	if filter.To, err = parseDate(values.Get("to")); err != nil {
+		return filter, err
+	}
+
+	// A date on its own covers the whole day.
+	if len(values.Get("to")) == len("2006-01-02") {
+		filter.To = filter.To.Add(24*time.Hour - time.Nanosecond)
+	}
+	return filter, nil
+}
+
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// APISuggestHandler (GET) returns completions for a partially typed query.
+// Accepts the parameter 'prefix' and optionally 'limit', the number of
+// completions to return (default 10, at most 50).
+func APISuggestHandler(c *Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+
+		encoder := json.NewEncoder(w)
+		prefix := r.URL.Query().Get("prefix")
+
+		// No 'prefix' parameter, nothing to complete.
+		if len(prefix) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			encoder.Encode(Response{
+				Status:  http.StatusBadRequest,
+				Message: "Query parameter 'prefix' was empty.",
+			})
+			return
+		}
+
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 {
+			limit = 10
+		}
+		if limit > 50 {
+			limit = 50
+		}
+
+		encoder.Encode(c.Completer.Complete(prefix, limit))
+	})
+}
+
 // APICrawlHandler (GET) allows one to provide a URL to be crawled. Will recursively
 // crawl in the background.
 func APICrawlHandler(c *Context) http.Handler {
@@ -153,8 +604,8 @@ func APIQueuesHandler(c *Context) http.Handler {
 		w.Header().Add("Content-Type", "application/json")
 
 		queues := []queueList{}
-		for _, q := range c.Queues.Queues {
-			item := queueList{Name: q.Name, Status: q.Status}
+		for _, q := range c.Queues.List() {
+			item := queueList{Name: q.Name, Status: q.Status, Hops: q.Hops}
 			queues = append(queues, item)
 		}
 		sort.Sort(QueueList(queues))
@@ -164,6 +615,76 @@ func APIQueuesHandler(c *Context) http.Handler {
 	})
 }
 
+// APIFeedsHandler (GET) returns the feeds being polled, ordered by URL.
+func APIFeedsHandler(c *Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+
+		encoder := json.NewEncoder(w)
+		encoder.Encode(c.Feeds.List())
+	})
+}
+
+// APIReindexHandler (POST) starts rebuilding every document from its stored raw
+// content, or with source=archive from the files in the archive directory. The
+// file being archived to is left out, as its last record may be half written.
+func APIReindexHandler(c *Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+
+		encoder := json.NewEncoder(w)
+
+		archives := []string{}
+		if r.URL.Query().Get("source") == "archive" {
+			if c.Config == nil {
+				w.WriteHeader(http.StatusBadRequest)
+				encoder.Encode(Response{
+					Status:  http.StatusBadRequest,
+					Message: "No archive is configured.",
+				})
+				return
+			}
+			names, _ := filepath.Glob(filepath.Join(c.Config.Archive.Path, "*.warc*"))
+			for _, name := range names {
+				if c.Archive == nil || name != c.Archive.Name() {
+					archives = append(archives, name)
+				}
+			}
+			if len(archives) == 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				encoder.Encode(Response{
+					Status:  http.StatusBadRequest,
+					Message: "No archives were found.",
+				})
+				return
+			}
+		}
+
+		if err := c.Reindexer.Start(c, archives); err != nil {
+			w.WriteHeader(http.StatusConflict)
+			encoder.Encode(Response{
+				Status:  http.StatusConflict,
+				Message: err.Error(),
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		encoder.Encode(c.Reindexer.Progress())
+	})
+}
+
+// APIReindexProgressHandler (GET) returns the progress of the running reindex,
+// or of the last one.
+func APIReindexProgressHandler(c *Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+
+		encoder := json.NewEncoder(w)
+		encoder.Encode(c.Reindexer.Progress())
+	})
+}
+
 // APIQueueHandler (GET) returns a single queue.
 func APIQueueHandler(c *Context) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -173,8 +694,9 @@ func APIQueueHandler(c *Context) http.Handler {
 		name := mux.Vars(r)["name"]
 
 		type item struct {
-			Item string `json:"item"`
-			Done bool   `json:"done"`
+			Item  string `json:"item"`
+			Done  bool   `json:"done"`
+			Error string `json:"error,omitempty"`
 		}
 
 		type queue struct {
@@ -184,7 +706,7 @@ func APIQueueHandler(c *Context) http.Handler {
 		}
 
 		// Queue not found, return Bad Request.
-		_q, ok := c.Queues.Queues[name]
+		_q, ok := c.Queues.Get(name)
 		if !ok {
 			w.WriteHeader(http.StatusBadRequest)
 			encoder.Encode(Response{
@@ -204,6 +726,9 @@ func APIQueueHandler(c *Context) http.Handler {
 					i.Done = false
 				}
 			}
+			if i.Done {
+				i.Error = _q.Error(k)
+			}
 			q.Items = append(q.Items, i)
 		}
 
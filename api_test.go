@@ -7,8 +7,8 @@ import (
 	"net/url"
 	"sort"
 	"testing"
+	"time"
 
-	rdb "github.com/dancannon/gorethink"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -49,14 +49,8 @@ func TestAPI_CrawlHandler(t *testing.T) {
 		w.Body.String(),
 	)
 
-	var response []interface{}
-	res, err := rdb.Db(_db).Table(_index).Run(_ctx.Db)
-	if err != nil {
-		t.Error(err.Error())
-	}
-
-	res.All(&response)
-	assert.Equal(t, 4, len(response))
+	assert.NoError(t, _ctx.Writer.Flush())
+	assert.Equal(t, 5, len(storedIndexes(_ctx)))
 }
 
 func TestAPI_CrawlHandler_BadURL(t *testing.T) {
@@ -141,8 +135,9 @@ func TestAPI_SearchHandler_RaisesError(t *testing.T) {
 		t.Error(err.Error())
 	}
 
-	// Remove index
-	rdb.Db(_db).Table(_index).IndexDrop("word").Exec(_ctx.Db)
+	store := _ctx.Store
+	_ctx.Store = brokenStore{store}
+	defer func() { _ctx.Store = store }()
 
 	w := httptest.NewRecorder()
 	APIRoutes(m, _ctx)
@@ -154,9 +149,57 @@ func TestAPI_SearchHandler_RaisesError(t *testing.T) {
 		"{\"status\":500,\"message\":\"Search failed.\"}\n",
 		w.Body.String(),
 	)
+}
+
+func TestAPI_SearchHandler_BadDate(t *testing.T) {
+	r, err := http.NewRequest("GET", "/api/search?q=hello&from=yesterday", nil)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	w := httptest.NewRecorder()
+	h := APISearchHandler(_ctx)
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, 400, w.Code)
+	assert.Equal(
+		t,
+		"{\"status\":400,\"message\":\"Date parameters 'from' and 'to' must be formatted as YYYY-MM-DD.\"}\n",
+		w.Body.String(),
+	)
+}
 
-	// Re-add index
-	rdb.Db(_db).Table(_index).IndexCreate("word").Exec(_ctx.Db)
+func TestAPI_ParseFilter(t *testing.T) {
+	values := url.Values{}
+	values.Set("site", "example.com")
+	values.Set("lang", "fr")
+	values.Set("type", "html")
+	values.Set("from", "2015-03-01")
+	values.Set("to", "2015-03-02")
+	values.Set("entity", "Product")
+	values.Set("entity.brand", "Acme")
+	values.Set("entity.", "ignored")
+
+	filter, err := ParseFilter(values)
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com", filter.Site)
+	assert.Equal(t, "fr", filter.Language)
+	assert.Equal(t, "html", filter.Type)
+	assert.Equal(t, time.Date(2015, 3, 1, 0, 0, 0, 0, time.UTC), filter.From)
+	assert.Equal(t, time.Date(2015, 3, 2, 23, 59, 59, 999999999, time.UTC), filter.To)
+	assert.Equal(t, "Product", filter.Entity)
+	assert.Equal(t, map[string]string{"brand": "Acme"}, filter.Properties)
+
+	values = url.Values{}
+	values.Set("to", "2015-03-02T10:00:00Z")
+	filter, err = ParseFilter(values)
+	assert.NoError(t, err)
+	assert.True(t, filter.From.IsZero())
+	assert.Equal(t, time.Date(2015, 3, 2, 10, 0, 0, 0, time.UTC), filter.To)
+
+	values.Set("from", "03/01/2015")
+	_, err = ParseFilter(values)
+	assert.Error(t, err)
 }
 
 func TestAPI_APIQueuesHandler(t *testing.T) {
@@ -185,6 +228,50 @@ func TestAPI_APIQueuesHandler(t *testing.T) {
 	)
 }
 
+func TestAPI_APIFeedsHandler(t *testing.T) {
+	defer func() { _ctx.Feeds = NewFeeds() }()
+	_ctx.Feeds.Subscribe(_ctx, "http://example.com/feed", "example.com")
+
+	r, err := http.NewRequest("GET", "/api/feeds", nil)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	w := httptest.NewRecorder()
+	APIRoutes(m, _ctx)
+	m.ServeHTTP(w, r)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(
+		t,
+		"[{\"url\":\"http://example.com/feed\",\"site\":\"example.com\",\"polled\":\"0001-01-01T00:00:00Z\",\"items\":0}]\n",
+		w.Body.String(),
+	)
+}
+
+// queueItems decodes a queue's name and whether each of its items is done
+// from the queue API, the order of the items isn't fixed.
+func queueItems(t *testing.T, body []byte) (string, map[string]bool) {
+	var q struct {
+		Name   string `json:"name"`
+		Status string `json:"status"`
+		Items  []struct {
+			Item string `json:"item"`
+			Done bool   `json:"done"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(body, &q); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "active", q.Status)
+
+	items := map[string]bool{}
+	for _, i := range q.Items {
+		items[i.Item] = i.Done
+	}
+	return q.Name, items
+}
+
 func TestAPI_APIQueueHandler(t *testing.T) {
 	_ctx.Queues = nil
 	_ctx.InitQueues()
@@ -207,13 +294,40 @@ func TestAPI_APIQueueHandler(t *testing.T) {
 
 	assert.Equal(t, 200, w.Code)
 
-	assert.Equal(
-		t,
-		"{\"name\":\"1\",\"status\":\"active\",\"items\":[{\"item\""+
-			":\"http://1.com/contact/\",\"done\":false},"+
-			"{\"item\":\"http://1.com/about/\",\"done\":false}]}\n",
-		w.Body.String(),
-	)
+	name, items := queueItems(t, w.Body.Bytes())
+	assert.Equal(t, "1", name)
+	assert.Equal(t, map[string]bool{
+		"http://1.com/contact/": false,
+		"http://1.com/about/":   false,
+	}, items)
+}
+
+func TestAPI_APIQueueHandler_Done(t *testing.T) {
+	_ctx.Queues = nil
+	_ctx.InitQueues()
+
+	q := NewQueue()
+	q.Name = "1"
+	_ctx.Queues.Add(q)
+
+	q.Enqueue("http://1.com/contact/")
+	q.Enqueue("http://1.com/about/")
+	q.Dequeue()
+
+	r, err := http.NewRequest("GET", "/api/queue/"+q.Name, nil)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	w := httptest.NewRecorder()
+	APIRoutes(m, _ctx)
+	m.ServeHTTP(w, r)
+
+	_, items := queueItems(t, w.Body.Bytes())
+	assert.Equal(t, map[string]bool{
+		"http://1.com/contact/": true,
+		"http://1.com/about/":   false,
+	}, items)
 }
 
 func TestAPI_APIQueueHandler_InvalidQueue(t *testing.T) {
@@ -272,7 +386,9 @@ func TestAPI_Sites(t *testing.T) {
 }
 
 func TestAPI_Sites_DbError(t *testing.T) {
-	_ctx.Config.Tables.Document = "error"
+	store := _ctx.Store
+	_ctx.Store = brokenStore{store}
+	defer func() { _ctx.Store = store }()
 
 	r, err := http.NewRequest("GET", "/api/sites", nil)
 	if err != nil {
@@ -290,6 +406,368 @@ func TestAPI_Sites_DbError(t *testing.T) {
 		"{\"status\":500,\"message\":\"Could not retrieve sites\"}\n",
 		w.Body.String(),
 	)
+}
+
+func TestAPI_SuggestHandler(t *testing.T) {
+	ctx := NewContext()
+	ctx.Completer.AddTerm("example", 2)
+	ctx.Completer.AddTerm("examine", 1)
+
+	r, err := http.NewRequest("GET", "/api/suggest?prefix=exa&limit=1", nil)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	w := httptest.NewRecorder()
+	h := APISuggestHandler(ctx)
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(
+		t,
+		"[{\"text\":\"example\",\"kind\":\"term\",\"documents\":2,\"searches\":0}]\n",
+		w.Body.String(),
+	)
+}
 
-	_ctx.Config.Tables.Document = "documents"
+func TestAPI_SuggestHandler_EmptyParameter(t *testing.T) {
+	r, err := http.NewRequest("GET", "/api/suggest?prefix=", nil)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	w := httptest.NewRecorder()
+	h := APISuggestHandler(_ctx)
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, 400, w.Code)
+	assert.Equal(
+		t,
+		"{\"status\":400,\"message\":\"Query parameter 'prefix' was empty.\"}\n",
+		w.Body.String(),
+	)
+}
+
+func TestAPI_DeleteDocumentHandler(t *testing.T) {
+	defer TearDown(_ctx)
+
+	docs := putPages(_ctx, "http://example.com/")
+
+	for _, test := range []struct {
+		Code int
+		Body string
+	}{
+		{200, "{\"status\":200,\"message\":\"Document deleted.\"}\n"},
+		{404, "{\"status\":404,\"message\":\"Document not found.\"}\n"},
+	} {
+		r, err := http.NewRequest("DELETE", "/api/documents/"+docs[0].DocID, nil)
+		if err != nil {
+			t.Error(err.Error())
+		}
+		r.Header.Set("Authorization", "Bearer "+testToken)
+
+		w := httptest.NewRecorder()
+		APIRoutes(m, _ctx)
+		m.ServeHTTP(w, r)
+
+		assert.Equal(t, test.Code, w.Code)
+		assert.Equal(t, test.Body, w.Body.String())
+	}
+}
+
+func TestAPI_DeleteSiteHandler(t *testing.T) {
+	defer TearDown(_ctx)
+
+	putPages(_ctx, "http://example.com/", "http://example.com/about")
+
+	r, err := http.NewRequest("DELETE", "/api/sites/example.com", nil)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	r.Header.Set("Authorization", "Bearer "+testToken)
+
+	w := httptest.NewRecorder()
+	APIRoutes(m, _ctx)
+	m.ServeHTTP(w, r)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "{\"status\":200,\"message\":\"Deleted 2 documents.\"}\n", w.Body.String())
+}
+
+func TestAPI_PurgeHandler(t *testing.T) {
+	defer TearDown(_ctx)
+
+	putPages(_ctx, "http://example.com/news/1", "http://example.com/about")
+
+	r, err := http.NewRequest(
+		"DELETE",
+		"/api/documents?url="+url.QueryEscape("http://example.com/news/*"),
+		nil,
+	)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	r.Header.Set("Authorization", "Bearer "+testToken)
+
+	w := httptest.NewRecorder()
+	APIRoutes(m, _ctx)
+	m.ServeHTTP(w, r)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "{\"status\":200,\"message\":\"Deleted 1 documents.\"}\n", w.Body.String())
+}
+
+func TestAPI_PurgeHandler_All(t *testing.T) {
+	defer TearDown(_ctx)
+
+	putPages(_ctx, "http://example.com/news/1", "http://example.com/about")
+
+	for _, test := range []struct {
+		Path string
+		Code int
+		Body string
+	}{
+		{
+			"/api/documents?url=**", 400,
+			"{\"status\":400,\"message\":\"URL parameter 'url' matches every document, pass 'all=true' to delete them all.\"}\n",
+		},
+		{
+			"/api/documents?url=*&all=true", 200,
+			"{\"status\":200,\"message\":\"Deleted 2 documents.\"}\n",
+		},
+	} {
+		r, err := http.NewRequest("DELETE", test.Path, nil)
+		if err != nil {
+			t.Error(err.Error())
+		}
+
+		w := httptest.NewRecorder()
+		h := APIPurgeHandler(_ctx)
+		h.ServeHTTP(w, r)
+
+		assert.Equal(t, test.Code, w.Code)
+		assert.Equal(t, test.Body, w.Body.String())
+	}
+}
+
+func TestAPI_DeleteHandlers_Unauthorized(t *testing.T) {
+	defer TearDown(_ctx)
+
+	docs := putPages(_ctx, "http://example.com/")
+	_ctx.Writer.Flush()
+
+	for _, test := range []struct {
+		Token  string
+		Header string
+	}{
+		{testToken, ""},
+		{testToken, "Bearer wrong"},
+		{testToken, testToken},
+		// Nothing can be deleted without a token configured.
+		{"", "Bearer "},
+	} {
+		_ctx.Config.Api.Token = test.Token
+		for _, path := range []string{
+			"/api/documents/" + docs[0].DocID,
+			"/api/sites/example.com",
+			"/api/documents?url=*&all=true",
+		} {
+			r, err := http.NewRequest("DELETE", path, nil)
+			if err != nil {
+				t.Error(err.Error())
+			}
+			r.Header.Set("Origin", "http://other.example.org")
+			if test.Header != "" {
+				r.Header.Set("Authorization", test.Header)
+			}
+
+			w := httptest.NewRecorder()
+			APIRoutes(m, _ctx)
+			m.ServeHTTP(w, r)
+
+			assert.Equal(t, 401, w.Code, path)
+			assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"))
+		}
+	}
+	_ctx.Config.Api.Token = testToken
+
+	d, err := _ctx.Store.Document(docs[0].DocID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, docs[0].Url, d.Url)
+	}
+}
+
+func TestAPI_PurgeHandler_EmptyParameter(t *testing.T) {
+	r, err := http.NewRequest("DELETE", "/api/documents", nil)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	w := httptest.NewRecorder()
+	h := APIPurgeHandler(_ctx)
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, 400, w.Code)
+	assert.Equal(t, "{\"status\":400,\"message\":\"URL parameter 'url' was empty.\"}\n", w.Body.String())
+}
+
+func TestAPI_DocumentHandler(t *testing.T) {
+	defer TearDown(_ctx)
+
+	docs := putPages(_ctx, "http://example.com/")
+	_ctx.Writer.Flush()
+
+	for _, path := range []string{
+		"/api/documents/" + docs[0].DocID,
+		"/api/documents?url=" + url.QueryEscape("http://example.com/"),
+	} {
+		r, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Error(err.Error())
+		}
+
+		w := httptest.NewRecorder()
+		APIRoutes(m, _ctx)
+		m.ServeHTTP(w, r)
+
+		assert.Equal(t, 200, w.Code)
+
+		var info DocumentInfo
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+		assert.Equal(t, docs[0].DocID, info.DocID)
+		assert.Equal(t, len(docs[0].Content), info.ContentLength)
+	}
+}
+
+func TestAPI_DocumentHandler_NotFound(t *testing.T) {
+	for _, path := range []string{
+		"/api/documents/missing",
+		"/api/documents?url=" + url.QueryEscape("http://example.com/missing"),
+	} {
+		r, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Error(err.Error())
+		}
+
+		w := httptest.NewRecorder()
+		APIRoutes(m, _ctx)
+		m.ServeHTTP(w, r)
+
+		assert.Equal(t, 404, w.Code)
+		assert.Equal(t, "{\"status\":404,\"message\":\"Document not found.\"}\n", w.Body.String())
+	}
+}
+
+func TestAPI_SiteDocumentsHandler(t *testing.T) {
+	defer TearDown(_ctx)
+
+	putPages(_ctx, "http://example.com/c", "http://example.com/a", "http://example.com/b")
+	_ctx.Writer.Flush()
+
+	r, err := http.NewRequest("GET", "/api/sites/example.com/documents?offset=1&limit=1", nil)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	w := httptest.NewRecorder()
+	APIRoutes(m, _ctx)
+	m.ServeHTTP(w, r)
+
+	assert.Equal(t, 200, w.Code)
+
+	var page struct {
+		Total     int
+		Offset    int
+		Limit     int
+		Documents []DocumentInfo
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, 3, page.Total)
+	assert.Equal(t, 1, page.Offset)
+	assert.Equal(t, 1, page.Limit)
+	assert.Equal(t, 1, len(page.Documents))
+	assert.Equal(t, "http://example.com/b", page.Documents[0].Url)
+}
+
+func TestAPI_StatsHandler(t *testing.T) {
+	defer TearDown(_ctx)
+
+	putPages(_ctx, "http://example.com/")
+	_ctx.Writer.Flush()
+
+	r, err := http.NewRequest("GET", "/api/stats", nil)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	w := httptest.NewRecorder()
+	APIRoutes(m, _ctx)
+	m.ServeHTTP(w, r)
+
+	assert.Equal(t, 200, w.Code)
+
+	var stats Stats
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.Equal(t, 1, stats.Documents)
+	assert.Equal(t, 1, stats.Sites["example.com"].Documents)
+}
+
+func TestAPI_HealthHandlers(t *testing.T) {
+	for _, path := range []string{"/healthz", "/readyz"} {
+		r, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Error(err.Error())
+		}
+
+		w := httptest.NewRecorder()
+		APIRoutes(m, _ctx)
+		m.ServeHTTP(w, r)
+
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "{\"status\":200,\"message\":\"OK\"}\n", w.Body.String())
+	}
+}
+
+func TestAPI_ReadyHandler_Down(t *testing.T) {
+	store := _ctx.Store
+	_ctx.Store = pingStore{store, ErrNotConnected}
+	defer func() { _ctx.Store = store }()
+
+	r, err := http.NewRequest("GET", "/readyz", nil)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	w := httptest.NewRecorder()
+	h := ReadyHandler(_ctx)
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, 503, w.Code)
+	assert.Equal(t, "{\"status\":503,\"message\":\"Database is not connected.\"}\n", w.Body.String())
+}
+
+func TestAPI_HealthHandler_WriteFailing(t *testing.T) {
+	ctx := writerContext()
+	defer ctx.Writer.Close()
+	ctx.Store = failingDocIndexes{Store: ctx.Store, docID: "broken"}
+
+	d := NewDocument("http://example.com/", "example.com", "", "news")
+	d.DocID = "broken"
+	ctx.Writer.Add(d, Indexer(d.Content, d.DocID), nil)
+	ctx.Writer.Flush()
+
+	r, _ := http.NewRequest("GET", "/healthz", nil)
+	w := httptest.NewRecorder()
+	HealthHandler(ctx).ServeHTTP(w, r)
+	assert.Equal(t, 503, w.Code)
+	assert.Contains(t, w.Body.String(), "Index too large.")
+
+	// A later batch written without errors makes it healthy again.
+	ok := NewDocument("http://example.com/ok", "example.com", "", "news")
+	ctx.Writer.Add(ok, Indexer(ok.Content, ok.DocID), nil)
+	ctx.Writer.Flush()
+
+	w = httptest.NewRecorder()
+	HealthHandler(ctx).ServeHTTP(w, r)
+	assert.Equal(t, 200, w.Code)
 }
@@ -0,0 +1,377 @@
+package miru
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Kinds of completion.
+const (
+	CompletionTerm  = "term"
+	CompletionTitle = "title"
+)
+
+// PopularityWeight is how much more a search counts for than a document when
+// ranking completions.
+var PopularityWeight int64 = 5
+
+// Completion is a suggested term or title for a prefix. Documents is the number
+// of documents it appears in, Searches the number of searches made for it.
+type Completion struct {
+	Text      string `json:"text"`
+	Kind      string `json:"kind"`
+	Documents int64  `json:"documents"`
+	Searches  int64  `json:"searches"`
+}
+
+// Score ranks a completion by document frequency and query popularity.
+func (cmp *Completion) Score() int64 {
+	return cmp.Documents + cmp.Searches*PopularityWeight
+}
+
+// MaxCompletions is the number of best completions kept at each node of the
+// trie, it is the most that Complete returns.
+var MaxCompletions = 50
+
+// better reports whether a completion ranks above another.
+func better(a, b *Completion) bool {
+	if a.Score() != b.Score() {
+		return a.Score() > b.Score()
+	}
+	return a.Text < b.Text
+}
+
+type byCompletion []*Completion
+
+func (cs byCompletion) Len() int { return len(cs) }
+
+func (cs byCompletion) Swap(i, j int) { cs[i], cs[j] = cs[j], cs[i] }
+
+func (cs byCompletion) Less(i, j int) bool { return better(cs[i], cs[j]) }
+
+// trieNode holds the completions of a key, top is the best MaxCompletions of
+// them and of every node below, best first.
+type trieNode struct {
+	children    map[rune]*trieNode
+	completions []*Completion
+	top         []*Completion
+}
+
+func newTrieNode() *trieNode {
+	return &trieNode{children: make(map[rune]*trieNode)}
+}
+
+// raise moves a completion whose score went up into the node's top
+// completions, if it is good enough.
+func (n *trieNode) raise(completion *Completion) {
+	pos := -1
+	for i, other := range n.top {
+		if other == completion {
+			pos = i
+			break
+		}
+	}
+	if pos < 0 {
+		if len(n.top) >= MaxCompletions && !better(completion, n.top[len(n.top)-1]) {
+			return
+		}
+		pos = len(n.top)
+		n.top = append(n.top, completion)
+	}
+
+	for ; pos > 0 && better(completion, n.top[pos-1]); pos-- {
+		n.top[pos], n.top[pos-1] = n.top[pos-1], n.top[pos]
+	}
+	if len(n.top) > MaxCompletions {
+		n.top = n.top[:MaxCompletions]
+	}
+}
+
+// lower rebuilds the node's top completions from its own and its children's
+// after a completion in them scored less or was removed. Children have to be
+// rebuilt first.
+func (n *trieNode) lower(completion *Completion) {
+	held := false
+	for _, other := range n.top {
+		held = held || other == completion
+	}
+	if !held {
+		return
+	}
+
+	top := append([]*Completion{}, n.completions...)
+	for _, child := range n.children {
+		top = append(top, child.top...)
+	}
+	sort.Sort(byCompletion(top))
+	if len(top) > MaxCompletions {
+		top = top[:MaxCompletions]
+	}
+	n.top = top
+}
+
+// Completer serves completions from a trie of indexed terms and page titles,
+// it is kept up to date as documents and indexes are written. Each node keeps
+// its best completions so a prefix is completed without walking the nodes below
+// it.
+type Completer struct {
+	root *trieNode
+	sync.RWMutex
+}
+
+// NewCompleter creates an empty completer.
+func NewCompleter() *Completer {
+	cmp := new(Completer)
+	cmp.root = newTrieNode()
+	return cmp
+}
+
+// completionKey lowercases, folds and collapses the whitespace of some text so
+// that prefixes match regardless of case and accents.
+func completionKey(text string) string {
+	words := strings.Fields(strings.ToLower(text))
+	for i, word := range words {
+		words[i] = FoldFilter{}.Filter(word)
+	}
+	return strings.TrimSpace(strings.Join(words, " "))
+}
+
+// path returns the nodes from the root to the one for key, creating any that
+// are missing when create is true, or else returning nil.
+func (cmp *Completer) path(key string, create bool) []*trieNode {
+	node := cmp.root
+	nodes := []*trieNode{node}
+	for _, r := range key {
+		child, ok := node.children[r]
+		if !ok {
+			if !create {
+				return nil
+			}
+			child = newTrieNode()
+			node.children[r] = child
+		}
+		node = child
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// find returns the completion of a kind stored at the end of a path, creating
+// it when create is true.
+func (cmp *Completer) find(path []*trieNode, kind, text string, create bool) *Completion {
+	node := path[len(path)-1]
+	for _, completion := range node.completions {
+		if completion.Kind == kind && (kind == CompletionTerm || completion.Text == text) {
+			return completion
+		}
+	}
+	if !create {
+		return nil
+	}
+
+	completion := &Completion{Text: text, Kind: kind}
+	node.completions = append(node.completions, completion)
+	return completion
+}
+
+// raise updates the top completions along a path after a completion's score
+// went up.
+func (cmp *Completer) raise(path []*trieNode, completion *Completion) {
+	for _, node := range path {
+		node.raise(completion)
+	}
+}
+
+// AddTerm records a term appearing in a number of documents.
+func (cmp *Completer) AddTerm(term string, documents int64) {
+	key := completionKey(term)
+	if key == "" {
+		return
+	}
+
+	cmp.Lock()
+	defer cmp.Unlock()
+
+	path := cmp.path(key, true)
+	completion := cmp.find(path, CompletionTerm, key, true)
+	completion.Documents += documents
+	cmp.raise(path, completion)
+}
+
+// AddTitle records a document's title.
+func (cmp *Completer) AddTitle(title string) {
+	title = strings.TrimSpace(title)
+	key := completionKey(title)
+	if key == "" {
+		return
+	}
+
+	cmp.Lock()
+	defer cmp.Unlock()
+
+	path := cmp.path(key, true)
+	completion := cmp.find(path, CompletionTitle, title, true)
+	completion.Documents++
+	cmp.raise(path, completion)
+}
+
+// RemoveTitle forgets one document with a title, used when documents are
+// deleted.
+func (cmp *Completer) RemoveTitle(title string) {
+	title = strings.TrimSpace(title)
+	key := completionKey(title)
+	if key == "" {
+		return
+	}
+
+	cmp.Lock()
+	defer cmp.Unlock()
+
+	if path := cmp.path(key, false); path != nil {
+		cmp.remove(path, cmp.find(path, CompletionTitle, title, false), 1)
+	}
+}
+
+// RemoveTerm forgets a term appearing in a number of documents.
+func (cmp *Completer) RemoveTerm(term string, documents int64) {
+	key := completionKey(term)
+	if key == "" {
+		return
+	}
+
+	cmp.Lock()
+	defer cmp.Unlock()
+
+	if path := cmp.path(key, false); path != nil {
+		cmp.remove(path, cmp.find(path, CompletionTerm, key, false), documents)
+	}
+}
+
+// remove takes a number of documents off a completion stored at the end of a
+// path, dropping it once it has none left, and rebuilds the top completions
+// along the path from the bottom up.
+func (cmp *Completer) remove(path []*trieNode, completion *Completion, documents int64) {
+	if completion == nil {
+		return
+	}
+
+	node := path[len(path)-1]
+	if completion.Documents -= documents; completion.Documents <= 0 {
+		for n, other := range node.completions {
+			if other == completion {
+				node.completions = append(node.completions[:n], node.completions[n+1:]...)
+				break
+			}
+		}
+	}
+
+	for n := len(path) - 1; n >= 0; n-- {
+		path[n].lower(completion)
+	}
+}
+
+// AddIndexes records the terms of a set of indexes, each term is counted once
+// per document.
+func (cmp *Completer) AddIndexes(ixs Indexes) {
+	seen := map[string]bool{}
+	for _, i := range ixs {
+		if i.Text == "" || seen[i.DocID+":"+i.Text] {
+			continue
+		}
+		seen[i.DocID+":"+i.Text] = true
+		cmp.AddTerm(i.Text, 1)
+	}
+}
+
+// RemoveIndexes forgets the terms of a set of indexes, as AddIndexes counted
+// them, used when documents are deleted.
+func (cmp *Completer) RemoveIndexes(ixs Indexes) {
+	seen := map[string]bool{}
+	for _, i := range ixs {
+		if i.Text == "" || seen[i.DocID+":"+i.Text] {
+			continue
+		}
+		seen[i.DocID+":"+i.Text] = true
+		cmp.RemoveTerm(i.Text, 1)
+	}
+}
+
+// RecordQuery counts a search towards the popularity of its terms, and of a
+// title if the query matches one exactly.
+func (cmp *Completer) RecordQuery(query string, a Analyzer) {
+	cmp.Lock()
+	defer cmp.Unlock()
+
+	for _, token := range a.Analyze(query) {
+		path := cmp.path(token.Text, false)
+		if path == nil {
+			continue
+		}
+		if completion := cmp.find(path, CompletionTerm, token.Text, false); completion != nil {
+			completion.Searches++
+			cmp.raise(path, completion)
+		}
+	}
+
+	key := completionKey(query)
+	if key == "" {
+		return
+	}
+	path := cmp.path(key, false)
+	if path == nil {
+		return
+	}
+	for _, completion := range path[len(path)-1].completions {
+		if completion.Kind == CompletionTitle {
+			completion.Searches++
+			cmp.raise(path, completion)
+		}
+	}
+}
+
+// Complete returns up to limit completions that start with prefix, best first.
+// No more than MaxCompletions are returned.
+func (cmp *Completer) Complete(prefix string, limit int) []Completion {
+	cmp.RLock()
+	defer cmp.RUnlock()
+
+	completions := []Completion{}
+
+	key := completionKey(prefix)
+	if key == "" {
+		return completions
+	}
+
+	path := cmp.path(key, false)
+	if path == nil {
+		return completions
+	}
+
+	for _, completion := range path[len(path)-1].top {
+		if limit > 0 && len(completions) == limit {
+			break
+		}
+		completions = append(completions, *completion)
+	}
+	return completions
+}
+
+// Load fills the completer from the terms and titles already in the datastore.
+func (cmp *Completer) Load(c *Context) error {
+	seen := map[string]bool{}
+	if err := c.Store.EachIndex(func(i *Index) error {
+		if i.Text != "" && !seen[i.DocID+":"+i.Text] {
+			seen[i.DocID+":"+i.Text] = true
+			cmp.AddTerm(i.Text, 1)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	return c.Store.EachDocument(func(d *Document) error {
+		cmp.AddTitle(d.Title)
+		return nil
+	})
+}
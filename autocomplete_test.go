@@ -0,0 +1,113 @@
+package miru
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAutocomplete_Complete(t *testing.T) {
+	cmp := NewCompleter()
+	cmp.AddTerm("example", 3)
+	cmp.AddTerm("exam", 5)
+	cmp.AddTerm("other", 10)
+	cmp.AddTitle("Examples, Examples Everywhere")
+	cmp.AddTitle("")
+
+	completions := cmp.Complete("Exa", 10)
+	assert.Equal(t, []Completion{
+		{Text: "exam", Kind: CompletionTerm, Documents: 5},
+		{Text: "example", Kind: CompletionTerm, Documents: 3},
+		{Text: "Examples, Examples Everywhere", Kind: CompletionTitle, Documents: 1},
+	}, completions)
+
+	assert.Equal(t, 1, len(cmp.Complete("exa", 1)))
+	assert.Equal(t, 0, len(cmp.Complete("zzz", 10)))
+	assert.Equal(t, 0, len(cmp.Complete("", 10)))
+}
+
+func TestAutocomplete_Complete_Folded(t *testing.T) {
+	cmp := NewCompleter()
+	cmp.AddTitle("Café  Culture")
+
+	completions := cmp.Complete("cafe c", 10)
+	assert.Equal(t, 1, len(completions))
+	assert.Equal(t, "Café  Culture", completions[0].Text)
+}
+
+func TestAutocomplete_AddIndexes(t *testing.T) {
+	cmp := NewCompleter()
+	cmp.AddIndexes(Indexes{
+		{DocID: "1", Field: FieldTitle, Text: "garden"},
+		{DocID: "1", Field: FieldContent, Text: "garden"},
+		{DocID: "2", Field: FieldContent, Text: "garden"},
+		{DocID: "2", Field: FieldContent, Text: ""},
+	})
+
+	completions := cmp.Complete("gar", 10)
+	assert.Equal(t, 1, len(completions))
+	assert.Equal(t, int64(2), completions[0].Documents)
+}
+
+func TestAutocomplete_RecordQuery(t *testing.T) {
+	cmp := NewCompleter()
+	cmp.AddTerm("gardens", 10)
+	cmp.AddTerm("garden", 2)
+	cmp.AddTitle("Garden Tips")
+
+	cmp.RecordQuery("garden", DefaultAnalyzer)
+	cmp.RecordQuery("Garden tips", DefaultAnalyzer)
+	cmp.RecordQuery("unknown", DefaultAnalyzer)
+
+	// garden scores 12, gardens 10 and Garden Tips 6.
+	completions := cmp.Complete("garden", 10)
+	assert.Equal(t, "garden", completions[0].Text)
+	assert.Equal(t, int64(2), completions[0].Searches)
+	assert.Equal(t, "gardens", completions[1].Text)
+	assert.Equal(t, int64(0), completions[1].Searches)
+	assert.Equal(t, "Garden Tips", completions[2].Text)
+	assert.Equal(t, int64(1), completions[2].Searches)
+}
+
+func TestAutocomplete_RemoveTitle(t *testing.T) {
+	cmp := NewCompleter()
+	cmp.AddTitle("World News")
+	cmp.AddTitle("World News")
+
+	cmp.RemoveTitle("World News")
+	assert.Equal(t, 1, len(cmp.Complete("world", 10)))
+
+	cmp.RemoveTitle("World News")
+	cmp.RemoveTitle("Unknown")
+	assert.Equal(t, 0, len(cmp.Complete("world", 10)))
+}
+
+func TestAutocomplete_Complete_Top(t *testing.T) {
+	defer func(max int) { MaxCompletions = max }(MaxCompletions)
+	MaxCompletions = 2
+
+	cmp := NewCompleter()
+	cmp.AddTerm("tea", 3)
+	cmp.AddTerm("team", 2)
+	cmp.AddTerm("teapot", 1)
+
+	texts := func(prefix string) []string {
+		texts := []string{}
+		for _, completion := range cmp.Complete(prefix, 10) {
+			texts = append(texts, completion.Text)
+		}
+		return texts
+	}
+	assert.Equal(t, []string{"tea", "team"}, texts("te"))
+
+	// A completion that scores more moves into the best kept.
+	cmp.AddTerm("teapot", 5)
+	assert.Equal(t, []string{"teapot", "tea"}, texts("te"))
+	assert.Equal(t, []string{"teapot", "tea"}, texts("tea"))
+
+	// Removing one brings back the next best from below.
+	cmp.RemoveTerm("teapot", 6)
+	assert.Equal(t, []string{"tea", "team"}, texts("te"))
+	cmp.RemoveTerm("tea", 2)
+	assert.Equal(t, []string{"team", "tea"}, texts("te"))
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/nylar/miru"
@@ -18,11 +24,149 @@ func main() {
 		return
 	}
 
-	if err := ctx.Connect(ctx.Config.Database.Host); err != nil {
+	if err := ctx.Open(); err != nil {
 		log.Fatalln("Could not connect to the database.")
 		return
 	}
 
+	command := "serve"
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	var err error
+	switch command {
+	case "serve":
+		serve(ctx)
+		return
+	case "export":
+		err = export(ctx, os.Args[2:])
+	case "import":
+		err = load(ctx, os.Args[2:])
+	case "reindex":
+		err = reindex(ctx, os.Args[2:])
+	default:
+		log.Fatalln("Unknown command, expected serve, export, import or reindex.")
+	}
+
+	if closeErr := ctx.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Fatalf("Could not %s the index: %s", command, err)
+	}
+}
+
+// export writes the index to a file, or stdout if no file is given.
+func export(ctx *miru.Context, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	output := flags.String("o", "", "file to write the export to")
+	flags.Parse(args)
+
+	if *output == "" {
+		return miru.Export(os.Stdout, ctx.Store)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := miru.Export(f, ctx.Store); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// load reads an export from a file, or stdin if no file is given.
+func load(ctx *miru.Context, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	flags.Parse(args)
+
+	var r io.Reader = os.Stdin
+	if flags.NArg() > 0 {
+		f, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	result, err := miru.Import(r, ctx.Store)
+	log.Printf("Imported %d documents, %d indexes, %d links and %d media, skipped %d documents, %d indexes, %d links and %d media already stored.",
+		result.Documents, result.Indexes, result.Links, result.Media, result.SkippedDocuments, result.SkippedIndexes, result.SkippedLinks, result.SkippedMedia)
+	return err
+}
+
+// reindex rebuilds documents from the given WARC files, or from their stored
+// raw content if none are given, logging progress as it goes.
+func reindex(ctx *miru.Context, args []string) error {
+	flags := flag.NewFlagSet("reindex", flag.ExitOnError)
+	flags.Parse(args)
+
+	done := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p := ctx.Reindexer.Progress()
+				log.Printf("Reindexed %d of %d documents, %d skipped and %d failed.", p.Done, p.Total, p.Skipped, p.Failed)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err := ctx.Reindexer.Run(ctx, flags.Args())
+	close(done)
+
+	p := ctx.Reindexer.Progress()
+	log.Printf("Reindexed %d documents in %s, skipped %d and %d failed.", p.Done, p.Finished.Sub(p.Started), p.Skipped, p.Failed)
+	return err
+}
+
+// serve runs the API until the process is interrupted.
+func serve(ctx *miru.Context) {
+	// Write out buffered pages before exiting.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		if err := ctx.Close(); err != nil {
+			log.Fatalln("Could not write buffered pages:", err)
+		}
+		os.Exit(0)
+	}()
+
+	if err := ctx.Vocabulary.Load(ctx); err != nil {
+		log.Println("Could not load the vocabulary, spelling suggestions will be limited.")
+	}
+
+	if err := ctx.Completer.Load(ctx); err != nil {
+		log.Println("Could not load completions, autocomplete will be limited.")
+	}
+
+	if ctx.Config.Authority.Weight != 0 {
+		if err := ctx.Authority.Compute(ctx.Store); err != nil {
+			log.Println("Could not compute authority, results will be ranked on text alone.")
+		}
+		if ctx.Config.Authority.Interval > 0 {
+			ctx.Authority.Start(ctx.Store, time.Duration(ctx.Config.Authority.Interval)*time.Second)
+		}
+	}
+
+	if err := ctx.Feeds.Load(ctx); err != nil {
+		log.Println("Could not load feeds, they will be found again as sites are crawled.")
+	}
+
+	if ctx.Config.Feeds.PollInterval > 0 {
+		ctx.Feeds.Start(ctx, time.Duration(ctx.Config.Feeds.PollInterval)*time.Second)
+	}
+
 	r := mux.NewRouter()
 	r.StrictSlash(true)
 
@@ -6,42 +6,257 @@ import "github.com/BurntSushi/toml"
 // acceptable defaults.
 var DefaultConfig = `
 [database]
+driver = "rethinkdb"
 host = "localhost:28015"
 name = "miru"
+path = "miru.db"
 
 [tables]
 index = "indexes"
 document = "documents"
+link = "links"
+media = "media"
+raw = "raw"
+feed = "feeds"
 
 [api]
 port = "8036"
+token = ""
+
+[analysis]
+stopwords = "english"
+stopwords_file = ""
+languages = ["en", "fr", "de", "es"]
+default_language = "en"
+
+[search]
+autocorrect = false
+suggestions = 3
+
+[writer]
+batch_documents = 100
+batch_indexes = 10000
+flush_interval = 5
+
+[crawl]
+cross_site = false
+max_hops = 1
+allow_domains = []
+allow_suffixes = []
+allow_patterns = []
+
+[authority]
+weight = 1.0
+interval = 3600
+
+[feeds]
+poll_interval = 900
+
+[raw]
+store = false
+compress = true
+
+[archive]
+enabled = false
+path = "warc"
+prefix = "miru"
+max_size = 1073741824
+compress = true
+
+[extraction]
+strategy = "readability"
+
+[boost]
+title = 3.0
+headings = 2.0
+content = 1.0
+url = 1.5
+description = 2.0
+anchor = 2.5
+keywords = 2.0
+author = 1.5
+entity = 1.5
 `
 
 // Config holds configuration information regarding the database and the port in
 // which to serve on.
 type Config struct {
-	Database database
-	Tables   tables
-	Api      api
+	Database   database
+	Tables     tables
+	Api        api
+	Analysis   analysis
+	Search     search
+	Writer     writer
+	Crawl      crawl
+	Authority  authority
+	Feeds      feeds
+	Raw        raw
+	Archive    archive
+	Extraction extraction
+	Boost      boost
 }
 
+// database selects where documents and indexes are stored. The "rethinkdb"
+// driver connects to Host and uses the Name database, the "embedded" driver
+// keeps everything in a single file at Path and the "memory" driver keeps
+// nothing once the process exits. The "segment" driver stores the inverted
+// index as compressed segments in a directory at Path.
 type database struct {
-	Host string
-	Name string
+	Driver string
+	Host   string
+	Name   string
+	Path   string
 }
 
 type tables struct {
 	Index    string
 	Document string
+	Link     string
+	Media    string
+	Raw      string
+	Feed     string
 }
 
+// api configures the port the API is served on. Token has to be sent as a
+// bearer token to delete documents, those routes are refused while it is empty.
 type api struct {
-	Port string
+	Port  string
+	Token string
+}
+
+// analysis configures the languages that text is analysed in. The stop words
+// apply to the default language, extra stop words can be loaded from a file
+// with one word per line.
+type analysis struct {
+	StopWords       string   `toml:"stopwords"`
+	StopWordsFile   string   `toml:"stopwords_file"`
+	Languages       []string `toml:"languages"`
+	DefaultLanguage string   `toml:"default_language"`
+}
+
+// search configures spelling suggestions, with autocorrect enabled a query with
+// no results is retried using the best suggestion.
+type search struct {
+	AutoCorrect bool
+	Suggestions int
+}
+
+// writer configures how many documents and indexes are buffered before being
+// written, and how many seconds they can wait at most.
+type writer struct {
+	BatchDocuments int `toml:"batch_documents"`
+	BatchIndexes   int `toml:"batch_indexes"`
+	FlushInterval  int `toml:"flush_interval"`
+}
+
+// crawl configures following links to other sites. With CrossSite enabled a
+// link to another site is followed if the site is allowed by AllowDomains,
+// AllowSuffixes or AllowPatterns, at most MaxHops sites away from the seed.
+type crawl struct {
+	CrossSite     bool     `toml:"cross_site"`
+	MaxHops       int      `toml:"max_hops"`
+	AllowDomains  []string `toml:"allow_domains"`
+	AllowSuffixes []string `toml:"allow_suffixes"`
+	AllowPatterns []string `toml:"allow_patterns"`
+}
+
+// authority configures PageRank scoring over the link graph, it is recomputed
+// every Interval seconds and a result's score is multiplied by 1 + Weight times
+// its document's authority.
+type authority struct {
+	Weight   float64
+	Interval int
+}
+
+// feeds configures how often, in seconds, the feeds found while crawling are
+// polled for new pages, they aren't polled when PollInterval is 0.
+type feeds struct {
+	PollInterval int `toml:"poll_interval"`
+}
+
+// raw configures keeping the body each document was built from, gzipped when
+// Compress is enabled, so the index can be rebuilt without crawling again.
+type raw struct {
+	Store    bool
+	Compress bool
+}
+
+// archive configures recording every fetch as WARC request and response
+// records in files under Path, a new file is started once one reaches MaxSize
+// bytes. With Compress enabled the files are gzipped record by record.
+type archive struct {
+	Enabled  bool
+	Path     string
+	Prefix   string
+	MaxSize  int64 `toml:"max_size"`
+	Compress bool
+}
+
+// extraction selects how the text of a page is found, "readability" scores the
+// page for its main content while "paragraphs" joins the text of every p tag.
+// Sites holds rules for pages whose layout either strategy gets wrong.
+type extraction struct {
+	Strategy string
+	Sites    []siteRule
+}
+
+// siteRule overrides extraction for pages of Host and its subdomains. Title,
+// Body and Date are CSS selectors for the page's title, the elements holding
+// its text and its published date. Exclude selects regions to leave out and
+// UnwantedTags names tags stripped in addition to those always stripped.
+type siteRule struct {
+	Host         string
+	Title        string
+	Body         string
+	Date         string
+	Exclude      []string
+	UnwantedTags []string `toml:"unwanted_tags"`
+}
+
+// boost weights each indexed field when ranking results, a field missing from
+// the config falls back to a weight of 1.
+type boost struct {
+	Title       float64
+	Headings    float64
+	Content     float64
+	Url         float64
+	Description float64
+	Anchor      float64
+	Keywords    float64
+	Author      float64
+	Entity      float64
+}
+
+// Weight returns the boost for a given field.
+func (b boost) Weight(field string) float64 {
+	weights := map[string]float64{
+		FieldTitle:       b.Title,
+		FieldHeadings:    b.Headings,
+		FieldContent:     b.Content,
+		FieldURL:         b.Url,
+		FieldDescription: b.Description,
+		FieldAnchor:      b.Anchor,
+		FieldKeywords:    b.Keywords,
+		FieldAuthor:      b.Author,
+		FieldEntity:      b.Entity,
+	}
+	if w, ok := weights[field]; ok && w > 0 {
+		return w
+	}
+	return 1
 }
 
 // LoadConfig loads configuration data into the Config struct.
 func LoadConfig(data string) (*Config, error) {
-	var conf Config
+	conf := Config{
+		Database:  database{Driver: DriverRethinkDB},
+		Tables:    tables{Link: "links", Media: "media", Raw: "raw", Feed: "feeds"},
+		Analysis:  analysis{DefaultLanguage: DefaultLanguage},
+		Search:    search{Suggestions: 3},
+		Authority: authority{Weight: 1, Interval: 3600},
+		Feeds:     feeds{PollInterval: 900},
+		Raw:       raw{Compress: true},
+	}
 	if _, err := toml.Decode(data, &conf); err != nil {
 		return nil, err
 	}
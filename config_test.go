@@ -13,11 +13,57 @@ func TestConfig_LoadConfig(t *testing.T) {
 
 	assert.Equal(t, conf.Database.Host, "localhost:28015")
 	assert.Equal(t, conf.Database.Name, "miru")
+	assert.Equal(t, conf.Database.Driver, DriverRethinkDB)
+	assert.Equal(t, conf.Database.Path, "miru.db")
+
+	assert.Equal(t, conf.Writer.BatchDocuments, 100)
+	assert.Equal(t, conf.Writer.BatchIndexes, 10000)
+	assert.Equal(t, conf.Writer.FlushInterval, 5)
 
 	assert.Equal(t, conf.Tables.Index, "indexes")
 	assert.Equal(t, conf.Tables.Document, "documents")
+	assert.Equal(t, conf.Tables.Link, "links")
+	assert.Equal(t, conf.Tables.Media, "media")
+	assert.Equal(t, conf.Tables.Raw, "raw")
+	assert.Equal(t, conf.Tables.Feed, "feeds")
 
 	assert.Equal(t, conf.Api.Port, "8036")
+	assert.Equal(t, conf.Api.Token, "")
+
+	assert.Equal(t, conf.Analysis.DefaultLanguage, "en")
+	assert.Equal(t, conf.Analysis.Languages, []string{"en", "fr", "de", "es"})
+
+	assert.Equal(t, conf.Search.AutoCorrect, false)
+	assert.Equal(t, conf.Search.Suggestions, 3)
+
+	assert.Equal(t, conf.Extraction.Strategy, StrategyReadability)
+
+	assert.Equal(t, conf.Crawl.CrossSite, false)
+	assert.Equal(t, conf.Crawl.MaxHops, 1)
+
+	assert.Equal(t, conf.Authority.Weight, 1.0)
+	assert.Equal(t, conf.Authority.Interval, 3600)
+
+	assert.Equal(t, conf.Feeds.PollInterval, 900)
+
+	assert.Equal(t, conf.Archive.Enabled, false)
+	assert.Equal(t, conf.Archive.MaxSize, int64(1073741824))
+
+	assert.Equal(t, conf.Raw.Store, false)
+	assert.Equal(t, conf.Raw.Compress, true)
+
+	assert.Equal(t, conf.Boost.Title, 3.0)
+	assert.Equal(t, conf.Boost.Content, 1.0)
+}
+
+func TestConfig_BoostWeight(t *testing.T) {
+	conf, err := LoadConfig("[boost]\ntitle = 4.0")
+	assert.NoError(t, err)
+
+	assert.Equal(t, 4.0, conf.Boost.Weight(FieldTitle))
+	// Missing and unknown fields aren't boosted.
+	assert.Equal(t, 1.0, conf.Boost.Weight(FieldContent))
+	assert.Equal(t, 1.0, conf.Boost.Weight("unknown"))
 }
 
 func TestConfig_LoadConfig_BadData(t *testing.T) {
@@ -0,0 +1,221 @@
+package miru
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// Strategies for extracting the text of a page.
+const (
+	StrategyReadability = "readability"
+	StrategyParagraphs  = "paragraphs"
+)
+
+// ErrUnknownStrategy for when the configured extraction strategy doesn't exist.
+var ErrUnknownStrategy = errors.New("Extraction strategy is not supported.")
+
+// Extractor returns the text of a page.
+type Extractor func(doc *goquery.Document) string
+
+// Extractors holds the extractor for each strategy.
+var Extractors = map[string]Extractor{
+	StrategyReadability: ExtractMainContent,
+	StrategyParagraphs:  ExtractText,
+}
+
+// LoadExtractor returns the extractor for the configured strategy, falling back
+// to readability when none is set.
+func LoadExtractor(conf *Config) (Extractor, error) {
+	strategy := conf.Extraction.Strategy
+	if strategy == "" {
+		strategy = StrategyReadability
+	}
+	if e, ok := Extractors[strategy]; ok {
+		return e, nil
+	}
+	return nil, ErrUnknownStrategy
+}
+
+var (
+	// blockTags are the elements whose text is scored, a div only counts when
+	// it has no blocks inside it.
+	blockTags = "p, pre, td, li, dd, blockquote, h1, h2, h3, h4, h5, h6, div"
+	// boilerplateTags never hold a page's main content.
+	boilerplateTags = "nav, header, footer, aside, form, button, noscript, select, textarea"
+
+	unlikelyPattern = regexp.MustCompile(`(?i)banner|breadcrumb|combx|comment|community|consent|cookie|disqus|extra|foot|header|menu|modal|nav|pager|pagination|popup|promo|related|remark|replies|rss|share|shoutbox|sidebar|skyscraper|social|sponsor|subscribe`)
+	likelyPattern   = regexp.MustCompile(`(?i)and|article|body|column|main|shadow`)
+	positivePattern = regexp.MustCompile(`(?i)article|blog|body|content|entry|main|page|post|story|text`)
+	negativePattern = regexp.MustCompile(`(?i)comment|combx|contact|foot|footer|footnote|masthead|media|meta|outbrain|promo|related|scroll|shoutbox|sidebar|sponsor|shopping|tags|tool|widget`)
+)
+
+// ExtractMainContent finds the element holding a page's main content by scoring
+// blocks of text on their length and commas, adding each block's score to its
+// parent and half to its grandparent. Candidates are penalised for the share of
+// their text in links and by class and id names. The text of the best
+// candidate and of similarly scored siblings is returned, falling back to
+// ExtractText when no block has enough text to score.
+func ExtractMainContent(doc *goquery.Document) string {
+	root := doc.Find("body").First()
+	if root.Length() == 0 {
+		root = doc.Selection
+	}
+	// Work on a copy so links in the boilerplate can still be followed.
+	root = root.Clone()
+
+	root.Find(boilerplateTags).Remove()
+	unlikely := []*html.Node{}
+	root.Find("*").Each(func(i int, s *goquery.Selection) {
+		names := classAndID(s)
+		if unlikelyPattern.MatchString(names) && !likelyPattern.MatchString(names) && !s.Is("article, main") {
+			unlikely = append(unlikely, s.Nodes[0])
+		}
+	})
+	for _, n := range unlikely {
+		if n.Parent != nil {
+			n.Parent.RemoveChild(n)
+		}
+	}
+
+	scores := map[*html.Node]float64{}
+	candidates := []*goquery.Selection{}
+	addScore := func(s *goquery.Selection, score float64) {
+		if s.Length() == 0 {
+			return
+		}
+		n := s.Nodes[0]
+		if _, ok := scores[n]; !ok {
+			scores[n] = classWeight(s)
+			candidates = append(candidates, s)
+		}
+		scores[n] += score
+	}
+
+	root.Find(blockTags).Each(func(i int, s *goquery.Selection) {
+		if s.Is("div") && s.Find(blockTags).Length() > 0 {
+			return
+		}
+		text := normaliseSpace(s.Text())
+		if len(text) < 25 {
+			return
+		}
+
+		score := 1 + float64(strings.Count(text, ","))
+		if bonus := float64(len(text) / 100); bonus < 3 {
+			score += bonus
+		} else {
+			score += 3
+		}
+
+		addScore(s.Parent(), score)
+		addScore(s.Parent().Parent(), score/2)
+	})
+
+	var top *goquery.Selection
+	for _, s := range candidates {
+		n := s.Nodes[0]
+		scores[n] *= 1 - linkDensity(s)
+		if top == nil || scores[n] > scores[top.Nodes[0]] {
+			top = s
+		}
+	}
+	if top == nil {
+		return ExtractText(doc)
+	}
+
+	// Siblings scoring close to the top candidate are often split up parts of
+	// the same article.
+	threshold := scores[top.Nodes[0]] * 0.2
+	if threshold < 10 {
+		threshold = 10
+	}
+	texts := []string{}
+	siblings := top.Parent().Children()
+	if top.Parent().Length() == 0 {
+		siblings = top
+	}
+	siblings.Each(func(i int, s *goquery.Selection) {
+		n := s.Nodes[0]
+		score, scored := scores[n]
+		switch {
+		case n == top.Nodes[0], scored && score >= threshold:
+			texts = append(texts, blockText(s)...)
+		case s.Is("p"):
+			text := normaliseSpace(s.Text())
+			if len(text) > 80 && linkDensity(s) < 0.25 {
+				texts = append(texts, text)
+			}
+		}
+	})
+	return strings.Join(texts, "\n")
+}
+
+// blockText returns the text of each block in s that has no blocks inside it,
+// leaving out blocks that are mostly links.
+func blockText(s *goquery.Selection) []string {
+	texts := []string{}
+	blocks := s.Find(blockTags)
+	if blocks.Length() == 0 {
+		blocks = s
+	}
+	blocks.Each(func(i int, b *goquery.Selection) {
+		if b.Find(blockTags).Length() > 0 || linkDensity(b) > 0.5 {
+			return
+		}
+		if text := normaliseSpace(b.Text()); text != "" {
+			texts = append(texts, text)
+		}
+	})
+	return texts
+}
+
+// linkDensity returns the share of the text of s that is inside links.
+func linkDensity(s *goquery.Selection) float64 {
+	length := len(normaliseSpace(s.Text()))
+	if length == 0 {
+		return 0
+	}
+	links := 0
+	s.Find("a").Each(func(i int, a *goquery.Selection) {
+		links += len(normaliseSpace(a.Text()))
+	})
+	return float64(links) / float64(length)
+}
+
+// classWeight scores an element on whether its class and id suggest content or
+// boilerplate.
+func classWeight(s *goquery.Selection) float64 {
+	weight := 0.0
+	for _, attr := range []string{"class", "id"} {
+		name, _ := s.Attr(attr)
+		if name == "" {
+			continue
+		}
+		if negativePattern.MatchString(name) {
+			weight -= 25
+		}
+		if positivePattern.MatchString(name) {
+			weight += 25
+		}
+	}
+	if s.Is("article, main") {
+		weight += 25
+	}
+	return weight
+}
+
+// classAndID returns an element's class and id joined by a space.
+func classAndID(s *goquery.Selection) string {
+	class, _ := s.Attr("class")
+	id, _ := s.Attr("id")
+	return class + " " + id
+}
+
+// normaliseSpace collapses runs of whitespace into single spaces.
+func normaliseSpace(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -0,0 +1,86 @@
+package miru
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var articleHTML = []byte(`
+<!DOCTYPE html>
+<html>
+<body>
+	<nav><a href="/">Home</a> <a href="/news">News</a></nav>
+	<div class="cookie-banner"><p>We use cookies to improve your experience, by using this site you agree.</p></div>
+	<div id="main">
+		<div class="article-body">
+			<h2>Storm expected over the weekend</h2>
+			<p>Forecasters say heavy rain, strong winds and thunder will sweep across the country on Saturday.</p>
+			<div>Residents near rivers, especially in low lying areas, have been told to prepare for flooding.</div>
+			<ul>
+				<li>Check on elderly neighbours, friends and relatives before the storm arrives.</li>
+				<li>Move valuables upstairs, away from doors, windows and drains.</li>
+			</ul>
+			<p>The storm is expected to clear by Monday morning, leaving cooler weather behind it.</p>
+		</div>
+	</div>
+	<div class="comments">
+		<p>Great article, thanks for sharing this with everyone, really helpful.</p>
+	</div>
+	<footer><p>Copyright Example News, all rights reserved, registered in England.</p></footer>
+</body>
+</html>`)
+
+func TestContent_ExtractMainContent(t *testing.T) {
+	doc := newDocument(articleHTML)
+	text := ExtractMainContent(doc)
+
+	assert.Contains(t, text, "Storm expected over the weekend")
+	assert.Contains(t, text, "heavy rain, strong winds")
+	assert.Contains(t, text, "prepare for flooding")
+	assert.Contains(t, text, "Move valuables upstairs")
+	assert.Contains(t, text, "clear by Monday morning")
+
+	assert.NotContains(t, text, "cookies")
+	assert.NotContains(t, text, "Great article")
+	assert.NotContains(t, text, "Copyright")
+	assert.NotContains(t, text, "Home")
+
+	// The page itself is left alone so its links can still be followed.
+	assert.Equal(t, 2, doc.Find("nav a").Length())
+}
+
+func TestContent_ExtractMainContent_Fallback(t *testing.T) {
+	doc := newDocument([]byte(`<p>world news</p><p>more</p>`))
+	assert.Equal(t, "world news\nmore", ExtractMainContent(doc))
+}
+
+func TestContent_ExtractText_KeepsBoilerplate(t *testing.T) {
+	text := ExtractText(newDocument(articleHTML))
+
+	assert.Contains(t, text, "cookies")
+	assert.False(t, strings.Contains(text, "prepare for flooding"))
+}
+
+func TestContent_LoadExtractor(t *testing.T) {
+	tests := []struct {
+		Strategy string
+		Text     string
+		Err      error
+	}{
+		{"", "Forecasters", nil},
+		{StrategyReadability, "Forecasters", nil},
+		{StrategyParagraphs, "cookies", nil},
+		{"magic", "", ErrUnknownStrategy},
+	}
+
+	for _, test := range tests {
+		conf := &Config{Extraction: extraction{Strategy: test.Strategy}}
+		extract, err := LoadExtractor(conf)
+		assert.Equal(t, test.Err, err, test.Strategy)
+		if err == nil {
+			assert.Contains(t, extract(newDocument(articleHTML)), test.Text, test.Strategy)
+		}
+	}
+}
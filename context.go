@@ -2,21 +2,59 @@ package miru
 
 import (
 	"io/ioutil"
+	"time"
 
 	rdb "github.com/dancannon/gorethink"
 )
 
-// Context holds database, configuration and queue data.
+// Context holds the store, configuration and crawl state shared by miru.
 type Context struct {
+	// Db is only set when the RethinkDB driver is used.
 	Db     *rdb.Session
+	Store  Store
 	Config *Config
 	Queues *Queues
+	// Analyzer is for the default language, Analyzers has one per configured
+	// language.
+	Analyzer  Analyzer
+	Analyzers map[string]Analyzer
+	// Vocabulary has every indexed word for spelling suggestions.
+	Vocabulary *Vocabulary
+	// Completer has every indexed word for autocompletion.
+	Completer *Completer
+	// Writer writes crawled pages to the store in batches.
+	Writer *IndexWriter
+	// Extractor finds the text of pages whose host has no rule in SiteRules.
+	Extractor Extractor
+	SiteRules SiteRules
+	// Authority scores documents by the links between them.
+	Authority *Authority
+	// Allowlist is set when links to other sites can be followed.
+	Allowlist *Allowlist
+	// Feeds are found on crawled pages and polled for new pages.
+	Feeds *Feeds
+	// Archive records every fetch when archiving is enabled.
+	Archive *WARCWriter
+	// Reindexer rebuilds documents from their stored content.
+	Reindexer *Reindexer
+	// Stats caches statistics about the store.
+	Stats *StatsCache
 }
 
 // NewContext instantiates a new context and initialises a queue.
 func NewContext() *Context {
 	ctx := new(Context)
 	ctx.InitQueues()
+	ctx.Analyzer = DefaultAnalyzer
+	ctx.Analyzers = DefaultAnalyzers()
+	ctx.Vocabulary = NewVocabulary()
+	ctx.Completer = NewCompleter()
+	ctx.Writer = NewIndexWriter(ctx)
+	ctx.Extractor = ExtractMainContent
+	ctx.Authority = NewAuthority()
+	ctx.Feeds = NewFeeds()
+	ctx.Reindexer = NewReindexer()
+	ctx.Stats = NewStatsCache(time.Minute)
 	return ctx
 }
 
@@ -34,10 +72,101 @@ func (c *Context) LoadConfig(f string) error {
 		return err
 	}
 
+	analyzers, err := LoadAnalyzers(conf)
+	if err != nil {
+		return err
+	}
+
+	extractor, err := LoadExtractor(conf)
+	if err != nil {
+		return err
+	}
+
+	rules, err := LoadSiteRules(conf)
+	if err != nil {
+		return err
+	}
+
+	allowlist, err := LoadAllowlist(conf)
+	if err != nil {
+		return err
+	}
+
 	c.Config = conf
+	c.Extractor = extractor
+	c.SiteRules = rules
+	c.Allowlist = allowlist
+	c.Analyzers = analyzers
+	c.Analyzer = analyzers[conf.Analysis.DefaultLanguage]
+
+	// The old writer is closed once replaced, writing anything still pending.
+	old := c.Writer
+	c.Writer = NewIndexWriter(c)
+	if old != nil {
+		return old.Close()
+	}
 	return nil
 }
 
+// Language returns code if it is a configured language, otherwise the default
+// language is returned.
+func (c *Context) Language(code string) string {
+	if _, ok := c.Analyzers[code]; ok {
+		return code
+	}
+	if c.Config != nil {
+		return c.Config.Analysis.DefaultLanguage
+	}
+	return DefaultLanguage
+}
+
+// AnalyzerFor returns the analyzer for a language, falling back to the
+// analyzer for the default language.
+func (c *Context) AnalyzerFor(code string) Analyzer {
+	if a, ok := c.Analyzers[code]; ok {
+		return a
+	}
+	return c.Analyzer
+}
+
+// Open opens the store selected by the configured database driver, and the
+// archive if it is enabled.
+func (c *Context) Open() error {
+	if c.Config.Archive.Enabled {
+		archive, err := OpenWARCWriter(c.Config)
+		if err != nil {
+			return err
+		}
+		c.Archive = archive
+	}
+
+	switch c.Config.Database.Driver {
+	case DriverRethinkDB, "":
+		if err := c.Connect(c.Config.Database.Host); err != nil {
+			return err
+		}
+		return c.Store.(*RethinkStore).CreateIndexes()
+	case DriverEmbedded:
+		store, err := OpenEmbeddedStore(c.Config.Database.Path)
+		if err != nil {
+			return err
+		}
+		c.Store = store
+		return nil
+	case DriverMemory:
+		c.Store = NewMemoryStore()
+		return nil
+	case DriverSegment:
+		store, err := OpenSegmentStore(c.Config.Database.Path)
+		if err != nil {
+			return err
+		}
+		c.Store = store
+		return nil
+	}
+	return ErrUnknownDriver
+}
+
 // Connect creates a connection to the database.
 func (c *Context) Connect(host string) error {
 	session, err := rdb.Connect(rdb.ConnectOpts{
@@ -50,9 +179,31 @@ func (c *Context) Connect(host string) error {
 	}
 
 	c.Db = session
+	c.Store = NewRethinkStore(session, c.Config)
 	return nil
 }
 
+// Close stops recomputing authority and polling feeds, writes any buffered
+// documents and closes the archive and the store. Each is closed even if an
+// earlier one fails, the first error is returned.
+func (c *Context) Close() error {
+	c.Authority.Stop()
+	c.Feeds.Stop()
+
+	err := c.Writer.Close()
+	if c.Archive != nil {
+		if archiveErr := c.Archive.Close(); err == nil {
+			err = archiveErr
+		}
+	}
+	if c.Store != nil {
+		if storeErr := c.Store.Close(); err == nil {
+			err = storeErr
+		}
+	}
+	return err
+}
+
 // InitQueues initialises a new queue list.
 func (c *Context) InitQueues() {
 	c.Queues = NewQueues()
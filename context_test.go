@@ -1,8 +1,11 @@
 package miru
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -30,6 +33,22 @@ func TestContext_LoadConfig_NoConfig(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestContext_LoadConfig_Writer(t *testing.T) {
+	ctx := writerContext()
+	old := ctx.Writer
+
+	d := NewDocument("http://example.com/", "example.com", "", "news")
+	assert.NoError(t, old.Add(d, Indexer(d.Content, d.DocID), nil))
+	assert.NoError(t, ctx.LoadConfig(DefaultConfig))
+	defer ctx.Writer.Close()
+
+	// The old writer is closed after writing what it had pending.
+	assert.NotEqual(t, old, ctx.Writer)
+	assert.Equal(t, ErrWriterClosed, old.Add(d, nil, nil))
+	_, err := ctx.Store.Document(d.DocID)
+	assert.NoError(t, err)
+}
+
 func TestContext_LoadConfig_InvalidConfig(t *testing.T) {
 	ctx := NewContext()
 
@@ -43,14 +62,48 @@ func TestContext_LoadConfig_InvalidConfig(t *testing.T) {
 	DefaultConfig = old
 }
 
-func TestContext_Connect(t *testing.T) {
-	ctx := NewContext()
-	if err := ctx.LoadConfig(DefaultConfig); err != nil {
-		t.Fatalf("Could not load config")
+func TestContext_LoadConfig_Analyzer(t *testing.T) {
+	f, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err.Error())
 	}
+	defer os.Remove(f.Name())
+
+	f.WriteString("[analysis]\nstopwords = \"none\"\n")
+	f.Close()
+
+	ctx := NewContext()
+	assert.Equal(t, DefaultAnalyzer, ctx.Analyzer)
 
-	err := ctx.Connect(os.Getenv("RETHINKDB_URL"))
+	err = ctx.LoadConfig(f.Name())
 	assert.NoError(t, err)
+	assert.Equal(t, []string{"the", "cat"}, Terms(ctx.Analyzer.Analyze("The cat")))
+}
+
+func TestContext_LoadConfig_UnknownStopWords(t *testing.T) {
+	f, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString("[analysis]\nstopwords = \"klingon\"\n")
+	f.Close()
+
+	ctx := NewContext()
+	err = ctx.LoadConfig(f.Name())
+	assert.Equal(t, ErrUnknownStopWords, err)
+}
+
+func TestContext_Language(t *testing.T) {
+	ctx := NewContext()
+
+	assert.Equal(t, "fr", ctx.Language("fr"))
+	assert.Equal(t, DefaultLanguage, ctx.Language("xx"))
+	assert.Equal(t, DefaultLanguage, ctx.Language(""))
+
+	assert.Equal(t, ctx.Analyzers["de"], ctx.AnalyzerFor("de"))
+	assert.Equal(t, ctx.Analyzer, ctx.AnalyzerFor("xx"))
 }
 
 func TestContext_Connect_BadDB(t *testing.T) {
@@ -62,3 +115,83 @@ func TestContext_Connect_BadDB(t *testing.T) {
 	err := ctx.Connect("")
 	assert.Error(t, err)
 }
+
+func TestContext_Open_Embedded(t *testing.T) {
+	ctx := NewContext()
+	if err := ctx.LoadConfig(DefaultConfig); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	dir, err := ioutil.TempDir("", "miru")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	ctx.Config.Database.Driver = DriverEmbedded
+	ctx.Config.Database.Path = filepath.Join(dir, "miru.db")
+
+	assert.NoError(t, ctx.Open())
+	assert.NotNil(t, ctx.Store)
+	assert.NoError(t, ctx.Store.Close())
+}
+
+func TestContext_Open_UnknownDriver(t *testing.T) {
+	ctx := NewContext()
+	if err := ctx.LoadConfig(DefaultConfig); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ctx.Config.Database.Driver = "mongodb"
+	assert.Equal(t, ErrUnknownDriver, ctx.Open())
+}
+
+func TestContext_LoadConfig_SiteRules(t *testing.T) {
+	f, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString("[[extraction.sites]]\nhost = \"example.com\"\nbody = \"div[\"\n")
+	f.Close()
+
+	ctx := NewContext()
+	err = ctx.LoadConfig(f.Name())
+	assert.Error(t, err)
+}
+
+// closeStore records whether it was closed.
+type closeStore struct {
+	Store
+	closed bool
+}
+
+func (s *closeStore) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestContext_Close_ArchiveError(t *testing.T) {
+	ctx := writerContext()
+	store := &closeStore{Store: ctx.Store}
+	ctx.Store = store
+
+	ts := Handler(200, []byte("<html></html>"))
+	defer ts.Close()
+	ctx.Archive = tempWARCWriter(t, false)
+	defer os.RemoveAll(ctx.Archive.Dir)
+	resp, body := fetch(t, ts.URL)
+	assert.NoError(t, ctx.Archive.Write(resp, body, time.Now()))
+	ctx.Archive.file.Close()
+
+	d := NewDocument("http://example.com/", "example.com", "", "news")
+	ctx.Writer.Add(d, Indexer(d.Content, d.DocID), nil)
+
+	// The archive failing to close doesn't stop the writer being flushed or
+	// the store being closed.
+	assert.Error(t, ctx.Close())
+	assert.True(t, store.closed)
+	_, err := store.Store.Document(d.DocID)
+	assert.NoError(t, err)
+}
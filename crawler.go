@@ -5,6 +5,8 @@ import (
 	"errors"
 	"io"
 	"io/ioutil"
+	"log"
+	"mime"
 	"net/http"
 	"net/url"
 	"time"
@@ -21,48 +23,144 @@ var (
 	ErrUnreachableURL = errors.New("Url did not return a 200 OK response.")
 	// ErrInvalidURL for when not a valid URL.
 	ErrInvalidURL = errors.New("Url was invalid.")
+	// ErrQueueStopped for when a page of a stopped queue is indexed.
+	ErrQueueStopped = errors.New("Queue was stopped.")
 	// Delay is time in between each crawl
 	Delay int64 = 5
 )
 
-func newDocument(document []byte) *goquery.Document {
+// parseDocument parses a page without stripping anything.
+func parseDocument(document []byte) *goquery.Document {
 	doc, _ := goquery.NewDocumentFromReader(bytes.NewReader(document))
+	return doc
+}
+
+func newDocument(document []byte) *goquery.Document {
+	doc := parseDocument(document)
 	doc.Find(UnwantedTags).Remove()
 
 	return doc
 }
 
-// IndexPage is called by ProcessPages and handles dealing with individual pages
+// Page is a document built from a fetched page along with its indexes, the
+// links, media and feeds found on it and, when raw content is stored, its body.
+type Page struct {
+	Document *Document
+	Indexes  Indexes
+	Links    []*Link
+	Media    []*Media
+	Feeds    []string
+	Raw      *Raw
+}
+
+// NewPage builds a page from the body of a response, its document is made by
+// NewSiteDoc with the context's extractor and analysed in its language. The
+// document is extracted from a copy of the page, so regions a site's rule
+// strips still have their links and media found. The parsed page is returned
+// too, stripped only of UnwantedTags.
+func NewPage(c *Context, contents []byte, url, site, contentType string, status int) (*Page, *goquery.Document, error) {
+	doc := parseDocument(contents)
+	feeds := DiscoverFeeds(doc, url)
+
+	d := NewSiteDoc(parseDocument(contents), url, site, c.Extractor, c.SiteRules.Match(url))
+	doc.Find(UnwantedTags).Remove()
+	d.Language = c.Language(d.Language)
+	d.ContentType, _, _ = mime.ParseMediaType(contentType)
+	d.Status = status
+
+	p := &Page{
+		Document: d,
+		Indexes:  IndexDocument(c.AnalyzerFor(d.Language), d),
+		Links:    ExtractPageLinks(doc, d),
+		Media:    ExtractMedia(doc, d, c.AnalyzerFor(d.Language)),
+		Feeds:    feeds,
+	}
+	if c.Config != nil && c.Config.Raw.Store {
+		raw, err := NewRaw(d.DocID, contents, c.Config.Raw.Compress)
+		if err != nil {
+			return nil, nil, err
+		}
+		p.Raw = raw
+	}
+	return p, doc, nil
+}
+
+// IndexPage is called by ProcessPages and handles dealing with individual
+// pages, the page and its links are handed to the context's writer and any
+// error writing it is recorded against the queue item. Pages of a stopped queue
+// aren't indexed.
 func IndexPage(c *Context, q *Queue, url, site string) error {
+	if !q.Begin() {
+		return ErrQueueStopped
+	}
+	defer q.Done()
+
 	req := Request(url)
-	resp, err := MustGet(req)
+	resp, err := Get(req)
 	if err != nil {
+		q.Fail(url, err)
 		return err
 	}
 
+	contentType := resp.Header.Get("Content-Type")
 	contents := Contents(resp)
+	if c.Archive != nil {
+		if err := c.Archive.Write(resp, contents, time.Now()); err != nil {
+			log.Println("Could not archive", url, err)
+		}
+	}
+	if resp.StatusCode != 200 {
+		q.Fail(url, ErrUnreachableURL)
+		return ErrUnreachableURL
+	}
 
-	doc := newDocument(contents)
-
-	d := NewDoc(doc, url, site)
-	d.Put(c)
-
-	i := Indexer(d.Content, d.DocID)
-	i.Put(c)
+	p, doc, err := NewPage(c, contents, url, site, contentType, resp.StatusCode)
+	if err != nil {
+		q.Fail(url, err)
+		return err
+	}
+	for _, feed := range p.Feeds {
+		if _, err := c.Feeds.Subscribe(c, feed, site); err != nil {
+			log.Println("Could not store feed", feed, err)
+		}
+	}
+	if err := c.Writer.AddPage(p, func(err error) {
+		if err != nil {
+			q.Fail(url, err)
+		}
+	}); err != nil {
+		q.Fail(url, err)
+		return err
+	}
 
 	Links(doc, q, site)
+	FollowExternal(c, doc, q, url, site)
 	return nil
 }
 
 // ProcessPages process all queue items and proceeds to index them.
 func ProcessPages(c *Context, q *Queue, site string, delay int64) {
-	for q.Len() > 0 {
-		item, _ := q.Dequeue()
-		IndexPage(c, q, item, site)
-		time.Sleep(time.Duration(delay) * time.Second)
+	for {
+		for q.Len() > 0 {
+			item, _ := q.Dequeue()
+			IndexPage(c, q, item, site)
+			time.Sleep(time.Duration(delay) * time.Second)
+		}
+		if q.Finish() {
+			return
+		}
+	}
+}
+
+// enqueueSite adds a link to the queue of its site, creating the queue with the
+// given number of hops from a seed if there isn't one. The queue is crawled if
+// it was just created or had finished.
+func enqueueSite(c *Context, link *url.URL, hops int) {
+	q, created := c.Queues.Open(link.Host, hops)
+	q.Enqueue(link.String())
+	if created || q.Resume() {
+		go ProcessPages(c, q, link.Host, Delay)
 	}
-	q.Status = "finished"
-	return
 }
 
 // Crawl processes pages concurrently
@@ -122,18 +220,56 @@ func MustGet(req *http.Request) (*http.Response, error) {
 
 // Contents reads data from a response into a byte slice, limits to 4mb.
 func Contents(resp *http.Response) []byte {
-	d, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4194304)) // Limit to 4mb
+	d, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxContents)) // Limit to 4mb
 	defer resp.Body.Close()
 
 	return d
 }
 
-// NewDoc extracts data from a page and creates a new document.
-func NewDoc(doc *goquery.Document, url, site string) *Document {
+// NewDoc extracts data from a page and creates a new document, its text is
+// found by extract. The page's metadata and structured data are read first, as
+// JSON-LD and canonical links are in tags that are then stripped from doc.
+func NewDoc(doc *goquery.Document, url, site string, extract Extractor) *Document {
+	return NewSiteDoc(doc, url, site, extract, nil)
+}
+
+// NewSiteDoc creates a document as NewDoc, applying a site's extraction rule
+// when it isn't nil. Whatever the rule's selectors don't find is extracted as
+// usual.
+func NewSiteDoc(doc *goquery.Document, url, site string, extract Extractor, rule *SiteRule) *Document {
+	meta := ExtractMetadata(doc)
+	entities := ExtractEntities(doc)
+	doc.Find(UnwantedTags).Remove()
+	if rule != nil {
+		rule.Strip(doc)
+	}
+
 	title := ExtractTitle(doc)
-	content := ExtractText(doc)
+	content := ""
+	if rule != nil {
+		if t := rule.ExtractTitle(doc); t != "" {
+			title = t
+		}
+		content = rule.ExtractBody(doc)
+	}
+	if content == "" {
+		content = extract(doc)
+	}
 
 	d := NewDocument(url, site, title, content)
+	d.Headings = ExtractHeadings(doc)
+	d.SetMetadata(meta)
+	d.Entities = entities
+	if rule != nil {
+		if date := rule.ExtractDate(doc); !date.IsZero() {
+			d.Published = &date
+		}
+	}
+
+	d.Language = ExtractLanguage(doc)
+	if d.Language == "" {
+		d.Language = DetectLanguage(title + "\n" + content)
+	}
 
 	return d
 }
@@ -2,10 +2,10 @@ package miru
 
 import (
 	"net/http"
+	"sort"
 	"testing"
 
 	"github.com/PuerkitoBio/goquery"
-	rdb "github.com/dancannon/gorethink"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -69,15 +69,10 @@ func TestCrawler_Crawl(t *testing.T) {
 	err := Crawl(ts.URL, _ctx, NewQueue())
 	assert.NoError(t, err)
 
-	var response []interface{}
-	res, err := rdb.Db(_db).Table(_index).Run(_ctx.Db)
-	if err != nil {
-		t.Error(err.Error())
-	}
-
-	res.All(&response)
+	assert.NoError(t, _ctx.Writer.Flush())
 
-	assert.Equal(t, len(response), 1)
+	// One index for the title and one for the content.
+	assert.Equal(t, len(storedIndexes(_ctx)), 2)
 }
 
 func TestCrawler_Crawl_BadURL(t *testing.T) {
@@ -85,6 +80,16 @@ func TestCrawler_Crawl_BadURL(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestCrawler_IndexPage_RecordsError(t *testing.T) {
+	ts := Handler(404, nil)
+	defer ts.Close()
+
+	q := NewQueue()
+	err := IndexPage(_ctx, q, ts.URL, "")
+	assert.Equal(t, ErrUnreachableURL, err)
+	assert.Equal(t, ErrUnreachableURL.Error(), q.Error(ts.URL))
+}
+
 func TestCrawler_RootURL(t *testing.T) {
 	root, err := RootURL("http://example.com/about/")
 	assert.Equal(t, "example.com", root)
@@ -196,3 +201,25 @@ func TestCrawler_ProcessPages(t *testing.T) {
 
 	assert.Equal(t, 2, len(q.Manager))
 }
+
+func TestCrawler_IndexPage_Links(t *testing.T) {
+	defer TearDown(_ctx)
+
+	ts := Handler(200, []byte(`
+<p>
+    <a href="foo">Link 1</a>
+    <a href="http://example.org/" rel="nofollow">Link 2</a>
+</p>`))
+	defer ts.Close()
+
+	assert.NoError(t, IndexPage(_ctx, NewQueue(), ts.URL+"/", ts.URL[len("http://"):]))
+	assert.NoError(t, _ctx.Writer.Flush())
+
+	targets := []string{}
+	_ctx.Store.EachLink(func(l *Link) error {
+		targets = append(targets, l.Target)
+		return nil
+	})
+	sort.Strings(targets)
+	assert.Equal(t, []string{ts.URL + "/foo", "http://example.org/"}, targets)
+}
@@ -0,0 +1,298 @@
+package miru
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ExportVersion is the version of the export format written by Export.
+const ExportVersion = 3
+
+// Kinds of export record.
+const (
+	RecordHeader   = "header"
+	RecordDocument = "document"
+	RecordIndex    = "index"
+	RecordLink     = "link"
+	RecordMedia    = "media"
+)
+
+var (
+	// ErrExportHeader for when an import doesn't start with a valid header.
+	ErrExportHeader = errors.New("Import is not a miru export.")
+	// ErrExportVersion for when an import's header has no version or one newer
+	// than ExportVersion.
+	ErrExportVersion = errors.New("Export version is not supported.")
+)
+
+// Record is a line of an export, Kind says which of its fields is set. An
+// export is a header followed by every document, then every index, every link
+// and every media, one JSON record per line. Links were added in version 2 and
+// media in version 3.
+type Record struct {
+	Kind     string    `json:"kind"`
+	Version  int       `json:"version,omitempty"`
+	Document *Document `json:"document,omitempty"`
+	Index    *Index    `json:"index,omitempty"`
+	Link     *Link     `json:"link,omitempty"`
+	Media    *Media    `json:"media,omitempty"`
+}
+
+// Export streams every document, index, link and media in a store to w.
+func Export(w io.Writer, s Store) error {
+	buf := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buf)
+
+	header := Record{Kind: RecordHeader, Version: ExportVersion}
+	if err := encoder.Encode(header); err != nil {
+		return err
+	}
+
+	if err := s.EachDocument(func(d *Document) error {
+		return encoder.Encode(Record{Kind: RecordDocument, Document: d})
+	}); err != nil {
+		return err
+	}
+
+	if err := s.EachIndex(func(i *Index) error {
+		return encoder.Encode(Record{Kind: RecordIndex, Index: i})
+	}); err != nil {
+		return err
+	}
+
+	if err := s.EachLink(func(l *Link) error {
+		return encoder.Encode(Record{Kind: RecordLink, Link: l})
+	}); err != nil {
+		return err
+	}
+
+	if err := s.EachMedia(func(m *Media) error {
+		return encoder.Encode(Record{Kind: RecordMedia, Media: m})
+	}); err != nil {
+		return err
+	}
+	return buf.Flush()
+}
+
+// ImportResult counts what an import wrote and what it skipped because it was
+// already stored.
+type ImportResult struct {
+	Documents        int
+	Indexes          int
+	Links            int
+	Media            int
+	SkippedDocuments int
+	SkippedIndexes   int
+	SkippedLinks     int
+	SkippedMedia     int
+}
+
+// validate checks a record has the fields a store needs. An index's ID is
+// worked out again from its document, field and word, so it always matches
+// them.
+func (r *Record) validate() error {
+	switch r.Kind {
+	case RecordDocument:
+		if r.Document == nil || r.Document.DocID == "" || r.Document.Url == "" {
+			return errors.New("Document must have an ID and a URL.")
+		}
+	case RecordIndex:
+		if r.Index == nil || r.Index.DocID == "" || r.Index.Word == "" {
+			return errors.New("Index must have a document ID and a word.")
+		}
+		r.Index.IndexID = indexID(r.Index.DocID, r.Index.Field, r.Index.Word)
+	case RecordLink:
+		if r.Link == nil || r.Link.LinkID == "" || r.Link.Source == "" || r.Link.Target == "" {
+			return errors.New("Link must have an ID, a source and a target.")
+		}
+	case RecordMedia:
+		if r.Media == nil || r.Media.MediaID == "" || r.Media.DocID == "" || r.Media.URL == "" {
+			return errors.New("Media must have an ID, a document ID and a URL.")
+		}
+	default:
+		return fmt.Errorf("Unknown record kind %q.", r.Kind)
+	}
+	return nil
+}
+
+// Import reads an export into a store. Documents whose ID is already stored
+// are skipped. Their indexes, links and media are written one at a time and
+// skipped if their ID is already stored, so an import that failed part way
+// can be run again. The first invalid line stops the import, records before
+// it will have been written.
+func Import(r io.Reader, s Store) (*ImportResult, error) {
+	result := new(ImportResult)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return result, err
+		}
+		return result, ErrExportHeader
+	}
+	header := new(Record)
+	if err := json.Unmarshal(scanner.Bytes(), header); err != nil {
+		return result, ErrExportHeader
+	}
+	if header.Kind != RecordHeader {
+		return result, ErrExportHeader
+	}
+	if header.Version < 1 || header.Version > ExportVersion {
+		return result, ErrExportVersion
+	}
+
+	// Whether each document seen is being imported, false if it was already
+	// stored.
+	imported := map[string]bool{}
+	// stored writes a record of a document that was already stored on its own,
+	// reporting whether it was stored already too.
+	stored := func(put func() error) (bool, error) {
+		err := put()
+		if err == ErrDuplicateKey {
+			return true, nil
+		}
+		return false, err
+	}
+	batch := Indexes{}
+	links := []*Link{}
+	media := []*Media{}
+	flush := func() error {
+		if len(batch) > 0 {
+			if err := s.PutIndexes(batch); err != nil && err != ErrDuplicateKey {
+				return err
+			}
+			batch = Indexes{}
+		}
+		if len(links) > 0 {
+			if err := s.PutLinks(links); err != nil && err != ErrDuplicateKey {
+				return err
+			}
+			links = []*Link{}
+		}
+		if len(media) > 0 {
+			if err := s.PutMedia(media); err != nil && err != ErrDuplicateKey {
+				return err
+			}
+			media = []*Media{}
+		}
+		return nil
+	}
+
+	for line := 2; scanner.Scan(); line++ {
+		record := new(Record)
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			return result, fmt.Errorf("Line %d: %s", line, err)
+		}
+		if err := record.validate(); err != nil {
+			return result, fmt.Errorf("Line %d: %s", line, err)
+		}
+
+		switch record.Kind {
+		case RecordDocument:
+			d := record.Document
+			if _, seen := imported[d.DocID]; seen {
+				result.SkippedDocuments++
+				continue
+			}
+
+			_, err := s.Document(d.DocID)
+			if err == nil {
+				imported[d.DocID] = false
+				result.SkippedDocuments++
+				continue
+			}
+			if err != ErrNotFound {
+				return result, err
+			}
+
+			if err := s.PutDocument(d); err != nil {
+				return result, err
+			}
+			imported[d.DocID] = true
+			result.Documents++
+
+		case RecordIndex:
+			importing, seen := imported[record.Index.DocID]
+			if !seen {
+				result.SkippedIndexes++
+				continue
+			}
+			if !importing {
+				skip, err := stored(func() error {
+					return s.PutIndexes(Indexes{record.Index})
+				})
+				if err != nil {
+					return result, err
+				}
+				if skip {
+					result.SkippedIndexes++
+				} else {
+					result.Indexes++
+				}
+				continue
+			}
+			batch = append(batch, record.Index)
+			result.Indexes++
+
+		case RecordLink:
+			importing, seen := imported[record.Link.Source]
+			if !seen {
+				result.SkippedLinks++
+				continue
+			}
+			if !importing {
+				skip, err := stored(func() error {
+					return s.PutLinks([]*Link{record.Link})
+				})
+				if err != nil {
+					return result, err
+				}
+				if skip {
+					result.SkippedLinks++
+				} else {
+					result.Links++
+				}
+				continue
+			}
+			links = append(links, record.Link)
+			result.Links++
+
+		case RecordMedia:
+			importing, seen := imported[record.Media.DocID]
+			if !seen {
+				result.SkippedMedia++
+				continue
+			}
+			if !importing {
+				skip, err := stored(func() error {
+					return s.PutMedia([]*Media{record.Media})
+				})
+				if err != nil {
+					return result, err
+				}
+				if skip {
+					result.SkippedMedia++
+				} else {
+					result.Media++
+				}
+				continue
+			}
+			media = append(media, record.Media)
+			result.Media++
+		}
+
+		if len(batch)+len(links)+len(media) >= 1000 {
+			if err := flush(); err != nil {
+				return result, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return result, err
+	}
+	return result, flush()
+}
@@ -0,0 +1,131 @@
+package miru
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExport_RoundTrip(t *testing.T) {
+	from := NewMemoryStore()
+	d := NewDocument("http://example.com/", "example.com", "Example", "world news")
+	assert.NoError(t, from.PutDocument(d))
+	ixs := IndexDocument(DefaultAnalyzer, d)
+	assert.NoError(t, from.PutIndexes(ixs))
+	assert.NoError(t, from.PutLinks([]*Link{NewLink(d.DocID, "http://example.org/", "Elsewhere", false)}))
+	assert.NoError(t, from.PutMedia([]*Media{{MediaID: "1", DocID: d.DocID, URL: "http://example.com/map.png", Words: []string{"map"}}}))
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, Export(buf, from))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 4+len(ixs), len(lines))
+	assert.Contains(t, lines[0], `"kind":"header"`)
+
+	to := NewMemoryStore()
+	result, err := Import(bytes.NewReader(buf.Bytes()), to)
+	assert.NoError(t, err)
+	assert.Equal(t, &ImportResult{Documents: 1, Indexes: len(ixs), Links: 1, Media: 1}, result)
+
+	stored, err := to.Document(d.DocID)
+	assert.NoError(t, err)
+	assert.Equal(t, d.Url, stored.Url)
+
+	rows, err := to.Lookup([]string{"world"}, new(Filter))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(rows))
+
+	media, err := to.LookupMedia([]string{"map"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(media))
+
+	// Importing again skips everything already stored.
+	result, err = Import(bytes.NewReader(buf.Bytes()), to)
+	assert.NoError(t, err)
+	assert.Equal(t, &ImportResult{SkippedDocuments: 1, SkippedIndexes: len(ixs), SkippedLinks: 1, SkippedMedia: 1}, result)
+}
+
+// failingIndexes fails to write indexes, as if an import was interrupted.
+type failingIndexes struct {
+	Store
+}
+
+func (s failingIndexes) PutIndexes(ixs Indexes) error {
+	return errors.New("Connection closed.")
+}
+
+func TestExport_Import_Rerun(t *testing.T) {
+	from := NewMemoryStore()
+	d := NewDocument("http://example.com/", "example.com", "Example", "world news")
+	from.PutDocument(d)
+	ixs := IndexDocument(DefaultAnalyzer, d)
+	from.PutIndexes(ixs)
+	from.PutLinks([]*Link{NewLink(d.DocID, "http://example.org/", "Elsewhere", false)})
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, Export(buf, from))
+
+	// Documents are written before the import stops in its indexes.
+	to := NewMemoryStore()
+	_, err := Import(bytes.NewReader(buf.Bytes()), failingIndexes{to})
+	assert.Error(t, err)
+	_, err = to.Document(d.DocID)
+	assert.NoError(t, err)
+	rows, _ := to.Lookup([]string{"world"}, new(Filter))
+	assert.Equal(t, 0, len(rows))
+
+	// Running it again writes what is missing.
+	result, err := Import(bytes.NewReader(buf.Bytes()), to)
+	assert.NoError(t, err)
+	assert.Equal(t, &ImportResult{Indexes: len(ixs), Links: 1, SkippedDocuments: 1}, result)
+	rows, _ = to.Lookup([]string{"world"}, new(Filter))
+	assert.Equal(t, 1, len(rows))
+}
+
+func TestExport_Import_IndexID(t *testing.T) {
+	d := NewDocument("http://example.com/", "example.com", "Example", "world news")
+	data, _ := json.Marshal(Record{Kind: RecordDocument, Document: d})
+	input := "{\"kind\":\"header\",\"version\":3}\n" + string(data) + "\n" +
+		"{\"kind\":\"index\",\"index\":{\"index_id\":\"other\",\"document_id\":\"" + d.DocID + "\",\"field\":\"content\",\"word\":\"world\",\"count\":1}}"
+
+	// An index is stored under the ID made from its document, field and word.
+	s := NewMemoryStore()
+	_, err := Import(strings.NewReader(input), s)
+	assert.NoError(t, err)
+	ixs, err := s.DocumentIndexes(d.DocID)
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(ixs)) {
+		assert.Equal(t, indexID(d.DocID, FieldContent, "world"), ixs[0].IndexID)
+	}
+}
+
+func TestExport_Import_Invalid(t *testing.T) {
+	tests := []struct {
+		Input string
+		Error string
+	}{
+		{"", ErrExportHeader.Error()},
+		{`{"kind":"document"}`, ErrExportHeader.Error()},
+		{`{"kind":"header","version":99}`, ErrExportVersion.Error()},
+		{`{"kind":"header"}`, ErrExportVersion.Error()},
+		{"{\"kind\":\"header\",\"version\":1}\n{\"kind\":\"link\",\"link\":{\"source\":\"1\"}}", "Line 2: Link must have an ID, a source and a target."},
+		{"{\"kind\":\"header\",\"version\":2}\n{\"kind\":\"link\",\"link\":{\"source\":\"1\",\"target\":\"http://example.com/\"}}", "Line 2: Link must have an ID, a source and a target."},
+		{"{\"kind\":\"header\",\"version\":1}\nnot json", "Line 2: "},
+		{"{\"kind\":\"header\",\"version\":1}\n{\"kind\":\"document\",\"document\":{\"url\":\"http://example.com/\"}}", "Line 2: Document must have an ID and a URL."},
+		{"{\"kind\":\"header\",\"version\":1}\n{\"kind\":\"index\",\"index\":{\"document_id\":\"1\"}}", "Line 2: Index must have a document ID and a word."},
+		{"{\"kind\":\"header\",\"version\":3}\n{\"kind\":\"media\",\"media\":{\"doc_id\":\"1\"}}", "Line 2: Media must have an ID, a document ID and a URL."},
+		{"{\"kind\":\"header\",\"version\":3}\n{\"kind\":\"media\",\"media\":{\"doc_id\":\"1\",\"url\":\"http://example.com/a.png\"}}", "Line 2: Media must have an ID, a document ID and a URL."},
+		{"{\"kind\":\"header\",\"version\":1}\n{\"kind\":\"page\"}", `Line 2: Unknown record kind "page".`},
+	}
+
+	for _, test := range tests {
+		_, err := Import(strings.NewReader(test.Input), NewMemoryStore())
+		if assert.Error(t, err, test.Input) {
+			assert.Contains(t, err.Error(), test.Error, test.Input)
+		}
+	}
+}
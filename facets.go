@@ -0,0 +1,176 @@
+package miru
+
+import (
+	"mime"
+	"strings"
+	"time"
+
+	rdb "github.com/dancannon/gorethink"
+)
+
+// Filter restricts search results by document fields, zero values match any
+// document. From and To bound the time a document was fetched. Entity and
+// Properties match documents with an entity of that type whose properties have
+// the given values, ignoring case.
+type Filter struct {
+	Site       string
+	Language   string
+	Type       string
+	From       time.Time
+	To         time.Time
+	Entity     string
+	Properties map[string]string
+}
+
+// ContentType turns a file extension such as "html" or "pdf" into a media type,
+// media types are returned unchanged.
+func ContentType(t string) string {
+	t = strings.ToLower(strings.TrimSpace(t))
+	if t == "" || strings.Contains(t, "/") {
+		return t
+	}
+	if full := mime.TypeByExtension("." + t); full != "" {
+		if media, _, err := mime.ParseMediaType(full); err == nil {
+			return media
+		}
+	}
+	return t
+}
+
+// Match reports whether a document passes the filter.
+func (f *Filter) Match(d *Document) bool {
+	switch {
+	case f.Site != "" && f.Site != d.Site:
+		return false
+	case f.Language != "" && f.Language != d.Language:
+		return false
+	case f.Type != "" && ContentType(f.Type) != d.ContentType:
+		return false
+	case !f.From.IsZero() && d.Fetched.Before(f.From):
+		return false
+	case !f.To.IsZero() && d.Fetched.After(f.To):
+		return false
+	case f.Entity != "" || len(f.Properties) > 0:
+		return f.matchEntities(d.Entities)
+	}
+	return true
+}
+
+// matchEntities reports whether any entity has the filter's type and
+// properties.
+func (f *Filter) matchEntities(entities []Entity) bool {
+	for _, e := range entities {
+		if f.Entity != "" && EntityType(f.Entity) != e.Type {
+			continue
+		}
+
+		match := true
+		for name, value := range f.Properties {
+			if !e.Has(name, value) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// apply narrows a query over documents down to those that pass the filter.
+func (f *Filter) apply(t rdb.Term) rdb.Term {
+	fields := map[string]interface{}{}
+	if f.Site != "" {
+		fields["site"] = f.Site
+	}
+	if f.Language != "" {
+		fields["language"] = f.Language
+	}
+	if f.Type != "" {
+		fields["content_type"] = ContentType(f.Type)
+	}
+	if len(fields) > 0 {
+		t = t.Filter(fields)
+	}
+
+	if !f.From.IsZero() {
+		t = t.Filter(rdb.Row.Field("fetched").Ge(f.From))
+	}
+	if !f.To.IsZero() {
+		t = t.Filter(rdb.Row.Field("fetched").Le(f.To))
+	}
+
+	if f.Entity != "" || len(f.Properties) > 0 {
+		t = t.Filter(func(d rdb.Term) rdb.Term {
+			return d.Field("entities").Default([]interface{}{}).Contains(func(e rdb.Term) rdb.Term {
+				match := rdb.Expr(true)
+				if f.Entity != "" {
+					match = match.And(e.Field("type").Eq(EntityType(f.Entity)))
+				}
+				for name, value := range f.Properties {
+					match = match.And(e.Field("properties").Field(name).Default("").
+						Downcase().Split("\n").Contains(strings.ToLower(value)))
+				}
+				return match
+			})
+		})
+	}
+	return t
+}
+
+// Facets counts results by site, language, content type and entity type.
+type Facets struct {
+	Sites     map[string]int64 `json:"sites"`
+	Languages map[string]int64 `json:"languages"`
+	Types     map[string]int64 `json:"types"`
+	Entities  map[string]int64 `json:"entities"`
+}
+
+// unfaceted returns the parts of the filter that aren't counted as facets, the
+// fetch dates.
+func (f *Filter) unfaceted() *Filter {
+	return &Filter{From: f.From, To: f.To}
+}
+
+// NewFacets counts the documents of a set of results that pass the filter. Each
+// facet is counted without its own part of the filter, so that picking
+// another site, language, type or entity shows how many results it would have.
+func NewFacets(results []Result, f Filter) Facets {
+	facets := Facets{
+		Sites:     map[string]int64{},
+		Languages: map[string]int64{},
+		Types:     map[string]int64{},
+		Entities:  map[string]int64{},
+	}
+
+	sites, languages, types, entities := f, f, f, f
+	sites.Site = ""
+	languages.Language = ""
+	types.Type = ""
+	entities.Entity, entities.Properties = "", nil
+
+	for _, r := range results {
+		if r.Site != "" && sites.Match(&r.Document) {
+			facets.Sites[r.Site]++
+		}
+		if r.Language != "" && languages.Match(&r.Document) {
+			facets.Languages[r.Language]++
+		}
+		if r.ContentType != "" && types.Match(&r.Document) {
+			facets.Types[r.ContentType]++
+		}
+		if !entities.Match(&r.Document) {
+			continue
+		}
+
+		kinds := map[string]bool{}
+		for _, e := range r.Entities {
+			kinds[e.Type] = true
+		}
+		for kind := range kinds {
+			facets.Entities[kind]++
+		}
+	}
+	return facets
+}
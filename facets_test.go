@@ -0,0 +1,91 @@
+package miru
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFacets_ContentType(t *testing.T) {
+	tests := []struct {
+		Input  string
+		Output string
+	}{
+		{"html", "text/html"},
+		{"PDF", "application/pdf"},
+		{"text/plain", "text/plain"},
+		{"unknownext", "unknownext"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.Output, ContentType(test.Input))
+	}
+}
+
+func TestFacets_FilterMatch(t *testing.T) {
+	d := NewDocument("http://example.com/", "example.com", "", "")
+	d.Language = "fr"
+	d.ContentType = "text/html"
+	d.Fetched = time.Date(2015, 3, 10, 12, 0, 0, 0, time.UTC)
+	d.Entities = []Entity{
+		{Type: EntityProduct, Properties: map[string]string{"brand": "Acme"}},
+		{Type: EntityEvent, Properties: map[string]string{"name": "Sale"}},
+	}
+
+	tests := []struct {
+		Filter Filter
+		Match  bool
+	}{
+		{Filter{}, true},
+		{Filter{Site: "example.com"}, true},
+		{Filter{Site: "example.org"}, false},
+		{Filter{Language: "fr", Type: "html"}, true},
+		{Filter{Language: "en"}, false},
+		{Filter{Type: "pdf"}, false},
+		{Filter{From: time.Date(2015, 3, 1, 0, 0, 0, 0, time.UTC)}, true},
+		{Filter{From: time.Date(2015, 4, 1, 0, 0, 0, 0, time.UTC)}, false},
+		{Filter{To: time.Date(2015, 3, 1, 0, 0, 0, 0, time.UTC)}, false},
+		{Filter{Entity: "Product"}, true},
+		{Filter{Entity: "Recipe"}, false},
+		{Filter{Entity: "Product", Properties: map[string]string{"brand": "acme"}}, true},
+		{Filter{Entity: "Product", Properties: map[string]string{"name": "Sale"}}, false},
+		{Filter{Properties: map[string]string{"name": "Sale"}}, true},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.Match, test.Filter.Match(d))
+	}
+}
+
+func TestFacets_NewFacets(t *testing.T) {
+	result := func(site, lang, contentType string) Result {
+		r := Result{}
+		r.Site = site
+		r.Language = lang
+		r.ContentType = contentType
+		return r
+	}
+
+	results := []Result{
+		result("a.com", "en", "text/html"),
+		result("a.com", "fr", "text/html"),
+		result("b.com", "en", ""),
+	}
+	facets := NewFacets(results, Filter{})
+
+	assert.Equal(t, map[string]int64{"a.com": 2, "b.com": 1}, facets.Sites)
+	assert.Equal(t, map[string]int64{"en": 2, "fr": 1}, facets.Languages)
+	assert.Equal(t, map[string]int64{"text/html": 2}, facets.Types)
+	assert.Equal(t, 0, len(facets.Entities))
+
+	// Each facet is counted without its own filter.
+	facets = NewFacets(results, Filter{Site: "a.com", Language: "en"})
+	assert.Equal(t, map[string]int64{"a.com": 1, "b.com": 1}, facets.Sites)
+	assert.Equal(t, map[string]int64{"en": 1, "fr": 1}, facets.Languages)
+	assert.Equal(t, map[string]int64{"text/html": 1}, facets.Types)
+
+	facets = NewFacets(nil, Filter{})
+	assert.Equal(t, 0, len(facets.Sites))
+}
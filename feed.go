@@ -0,0 +1,415 @@
+package miru
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Formats of feed that can be read.
+const (
+	FeedRSS  = "rss"
+	FeedAtom = "atom"
+	FeedJSON = "json"
+)
+
+// ErrUnknownFeed for when a feed isn't RSS, Atom or JSON Feed.
+var ErrUnknownFeed = errors.New("Feed format is not supported.")
+
+// feedTypes are the media types of the alternate links that are feeds.
+var feedTypes = map[string]bool{
+	"application/rss+xml":   true,
+	"application/rdf+xml":   true,
+	"application/atom+xml":  true,
+	"application/feed+json": true,
+	"application/json":      true,
+}
+
+// Feed is an RSS, Atom or JSON feed along with the pages it links to.
+type Feed struct {
+	Format string
+	Title  string
+	Items  []FeedItem
+}
+
+// FeedItem is an entry of a feed, URL is the page it links to.
+type FeedItem struct {
+	URL       string
+	Title     string
+	Published time.Time
+}
+
+// DiscoverFeeds returns the feeds a page links to with <link rel="alternate">,
+// resolved against the page's URL. It must be called before link tags are
+// stripped.
+func DiscoverFeeds(doc *goquery.Document, page string) []string {
+	feeds := []string{}
+	seen := map[string]bool{}
+	doc.Find("link[rel][href]").Each(func(i int, s *goquery.Selection) {
+		rel, _ := s.Attr("rel")
+		alternate := false
+		for _, value := range strings.Fields(strings.ToLower(rel)) {
+			if value == "alternate" {
+				alternate = true
+			}
+		}
+		t, _ := s.Attr("type")
+		if !alternate || !feedTypes[strings.ToLower(strings.TrimSpace(t))] {
+			return
+		}
+
+		href, _ := s.Attr("href")
+		if feed := LinkTarget(page, href); feed != "" && !seen[feed] {
+			seen[feed] = true
+			feeds = append(feeds, feed)
+		}
+	})
+	return feeds
+}
+
+// ParseFeed reads an RSS 2.0, RSS 1.0, Atom or JSON feed, item URLs are
+// resolved against base and items without a web URL are left out.
+func ParseFeed(data []byte, base string) (*Feed, error) {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("{")) {
+		return parseJSONFeed(data, base)
+	}
+	return parseXMLFeed(data, base)
+}
+
+type jsonFeed struct {
+	Version string `json:"version"`
+	Title   string `json:"title"`
+	Items   []struct {
+		URL           string `json:"url"`
+		ExternalURL   string `json:"external_url"`
+		Title         string `json:"title"`
+		DatePublished string `json:"date_published"`
+	} `json:"items"`
+}
+
+func parseJSONFeed(data []byte, base string) (*Feed, error) {
+	var f jsonFeed
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	if !strings.Contains(f.Version, "jsonfeed.org") {
+		return nil, ErrUnknownFeed
+	}
+
+	feed := &Feed{Format: FeedJSON, Title: f.Title, Items: []FeedItem{}}
+	for _, item := range f.Items {
+		link := item.URL
+		if link == "" {
+			link = item.ExternalURL
+		}
+		feed.add(base, link, item.Title, item.DatePublished)
+	}
+	return feed, nil
+}
+
+type rssItem struct {
+	Title   string `xml:"title"`
+	Link    string `xml:"link"`
+	GUID    string `xml:"guid"`
+	PubDate string `xml:"pubDate"`
+	Date    string `xml:"date"`
+}
+
+type atomEntry struct {
+	Title string `xml:"title"`
+	ID    string `xml:"id"`
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"link"`
+	Published string `xml:"published"`
+	Updated   string `xml:"updated"`
+}
+
+// xmlFeed holds the elements of RSS 2.0 (channel), RSS 1.0 (item at the root)
+// and Atom (entry) feeds.
+type xmlFeed struct {
+	XMLName xml.Name
+	Title   string `xml:"title"`
+	Channel struct {
+		Title string    `xml:"title"`
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	Items   []rssItem   `xml:"item"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+func parseXMLFeed(data []byte, base string) (*Feed, error) {
+	var f xmlFeed
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	// Feeds in other charsets are read as if they were UTF-8, which holds for
+	// the ASCII the item links are written in.
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	if err := decoder.Decode(&f); err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(f.XMLName.Local) {
+	case "rss", "rdf":
+		feed := &Feed{Format: FeedRSS, Title: f.Channel.Title, Items: []FeedItem{}}
+		for _, item := range append(f.Channel.Items, f.Items...) {
+			link := item.Link
+			if link == "" {
+				link = item.GUID
+			}
+			published := item.PubDate
+			if published == "" {
+				published = item.Date
+			}
+			feed.add(base, link, item.Title, published)
+		}
+		return feed, nil
+	case "feed":
+		feed := &Feed{Format: FeedAtom, Title: f.Title, Items: []FeedItem{}}
+		for _, entry := range f.Entries {
+			link := ""
+			for _, l := range entry.Links {
+				rel := strings.ToLower(l.Rel)
+				if (rel == "" || rel == "alternate") && link == "" {
+					link = l.Href
+				}
+			}
+			if link == "" {
+				link = entry.ID
+			}
+			published := entry.Published
+			if published == "" {
+				published = entry.Updated
+			}
+			feed.add(base, link, entry.Title, published)
+		}
+		return feed, nil
+	}
+	return nil, ErrUnknownFeed
+}
+
+// add appends an item if its link resolves to a web URL.
+func (f *Feed) add(base, link, title, published string) {
+	target := LinkTarget(base, link)
+	if target == "" {
+		return
+	}
+	f.Items = append(f.Items, FeedItem{
+		URL:       target,
+		Title:     strings.TrimSpace(title),
+		Published: pageDate(strings.TrimSpace(published)),
+	})
+}
+
+// Subscription is a feed found on a site, Items is how many items it had when
+// it was last polled and Error why the last poll failed.
+type Subscription struct {
+	URL    string    `gorethink:"id" json:"url"`
+	Site   string    `gorethink:"site" json:"site"`
+	Polled time.Time `gorethink:"polled" json:"polled"`
+	Items  int       `gorethink:"items" json:"items"`
+	Error  string    `gorethink:"error" json:"error,omitempty"`
+}
+
+// Subscriptions is a sortable list of subscriptions, ordered by URL.
+type Subscriptions []Subscription
+
+func (s Subscriptions) Len() int           { return len(s) }
+func (s Subscriptions) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s Subscriptions) Less(i, j int) bool { return s[i].URL < s[j].URL }
+
+// Feeds holds the feeds discovered while crawling, they are polled for new
+// pages to crawl. Subscriptions are written to the datastore so they are kept
+// across restarts.
+type Feeds struct {
+	subscriptions map[string]*Subscription
+	stop          chan bool
+	wg            sync.WaitGroup
+	sync.Mutex
+}
+
+// NewFeeds creates an empty set of feeds.
+func NewFeeds() *Feeds {
+	return &Feeds{subscriptions: map[string]*Subscription{}}
+}
+
+// Load fills the feeds from the subscriptions in the datastore.
+func (fs *Feeds) Load(c *Context) error {
+	fs.Lock()
+	defer fs.Unlock()
+
+	return c.Store.EachSubscription(func(sub *Subscription) error {
+		fs.subscriptions[sub.URL] = sub
+		return nil
+	})
+}
+
+// Subscribe adds a feed of a site and stores it, it reports whether the feed
+// is new.
+func (fs *Feeds) Subscribe(c *Context, feed, site string) (bool, error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	if _, ok := fs.subscriptions[feed]; ok {
+		return false, nil
+	}
+	sub := &Subscription{URL: feed, Site: site}
+	if err := c.Store.PutSubscription(sub); err != nil {
+		return false, err
+	}
+	stored := *sub
+	fs.subscriptions[feed] = &stored
+	return true, nil
+}
+
+// Remove drops every feed of a site, from the datastore too.
+func (fs *Feeds) Remove(c *Context, site string) error {
+	fs.Lock()
+	defer fs.Unlock()
+
+	for feed, s := range fs.subscriptions {
+		if s.Site != site {
+			continue
+		}
+		if err := c.Store.DeleteSubscription(feed); err != nil {
+			return err
+		}
+		delete(fs.subscriptions, feed)
+	}
+	return nil
+}
+
+// List returns a copy of every subscription ordered by URL.
+func (fs *Feeds) List() []Subscription {
+	fs.Lock()
+	defer fs.Unlock()
+
+	list := []Subscription{}
+	for _, s := range fs.subscriptions {
+		list = append(list, *s)
+	}
+	sort.Sort(Subscriptions(list))
+	return list
+}
+
+// Poll fetches every feed and enqueues its items, a feed that fails keeps its
+// error until it is next polled. The result of each poll is stored.
+func (fs *Feeds) Poll(c *Context) {
+	for _, s := range fs.List() {
+		items, err := PollFeed(c, s.URL, s.Site)
+
+		fs.Lock()
+		if sub, ok := fs.subscriptions[s.URL]; ok {
+			sub.Polled = time.Now()
+			sub.Error = ""
+			if err != nil {
+				sub.Error = err.Error()
+			} else {
+				sub.Items = items
+			}
+			stored := *sub
+			if err := c.Store.PutSubscription(&stored); err != nil {
+				log.Println("Could not store feed", s.URL, err)
+			}
+		}
+		fs.Unlock()
+	}
+}
+
+// Start polls the feeds every interval until Stop is called, nothing is started
+// for an interval that isn't positive.
+func (fs *Feeds) Start(c *Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	fs.Lock()
+	if fs.stop != nil {
+		fs.Unlock()
+		return
+	}
+	fs.stop = make(chan bool)
+	stop := fs.stop
+	fs.Unlock()
+
+	fs.wg.Add(1)
+	go func() {
+		defer fs.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				fs.Poll(c)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the job started by Start.
+func (fs *Feeds) Stop() {
+	fs.Lock()
+	stop := fs.stop
+	fs.stop = nil
+	fs.Unlock()
+
+	if stop != nil {
+		close(stop)
+		fs.wg.Wait()
+	}
+}
+
+// PollFeed fetches a site's feed and enqueues the items that are on the site,
+// or on another site the allowlist accepts, for crawling. Items that are
+// already stored, or were crawled by their queue, are skipped. It returns the
+// number of items in the feed.
+func PollFeed(c *Context, feed, site string) (int, error) {
+	resp, err := Get(Request(feed))
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		return 0, ErrUnreachableURL
+	}
+
+	f, err := ParseFeed(Contents(resp), feed)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, item := range f.Items {
+		if _, err := c.Store.DocumentByURL(item.URL); err == nil {
+			continue
+		}
+		link, err := url.Parse(item.URL)
+		if err != nil {
+			continue
+		}
+		switch {
+		case link.Host == site:
+			enqueueSite(c, link, 0)
+		case c.Allowlist != nil && c.Allowlist.Allows(link):
+			enqueueSite(c, link, 1)
+		}
+	}
+	return len(f.Items), nil
+}
@@ -0,0 +1,247 @@
+package miru
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	rssFeed = []byte(`<?xml version="1.0" encoding="ISO-8859-1"?>
+<rss version="2.0">
+<channel>
+	<title>News</title>
+	<item>
+		<title>First</title>
+		<link>http://example.com/first</link>
+		<pubDate>Mon, 2 Jan 2006 15:04:05 -0700</pubDate>
+	</item>
+	<item>
+		<title>Second</title>
+		<guid>/second</guid>
+	</item>
+	<item>
+		<title>No link</title>
+		<link>mailto:news@example.com</link>
+	</item>
+</channel>
+</rss>`)
+
+	atomFeed = []byte(`<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+	<title>Blog</title>
+	<entry>
+		<title>Post</title>
+		<link rel="edit" href="http://example.com/edit/1"/>
+		<link rel="alternate" type="text/html" href="/posts/1#comments"/>
+		<updated>2015-06-01T10:00:00Z</updated>
+	</entry>
+	<entry>
+		<title>Linked by id</title>
+		<id>http://example.com/posts/2</id>
+	</entry>
+</feed>`)
+
+	jsonFeedData = []byte(`{
+	"version": "https://jsonfeed.org/version/1",
+	"title": "Updates",
+	"items": [
+		{"id": "1", "url": "http://example.com/updates/1", "title": "One", "date_published": "2015-06-01T10:00:00+01:00"},
+		{"id": "2", "external_url": "http://other.com/2", "title": "Two"}
+	]
+}`)
+)
+
+func TestFeed_ParseFeed(t *testing.T) {
+	tests := []struct {
+		Data   []byte
+		Format string
+		Title  string
+		URLs   []string
+	}{
+		{rssFeed, FeedRSS, "News", []string{"http://example.com/first", "http://example.com/second"}},
+		{atomFeed, FeedAtom, "Blog", []string{"http://example.com/posts/1", "http://example.com/posts/2"}},
+		{jsonFeedData, FeedJSON, "Updates", []string{"http://example.com/updates/1", "http://other.com/2"}},
+		{[]byte(`<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"><item><link>http://example.com/rdf</link></item></rdf:RDF>`), FeedRSS, "", []string{"http://example.com/rdf"}},
+	}
+
+	for _, test := range tests {
+		feed, err := ParseFeed(test.Data, "http://example.com/feed")
+		assert.NoError(t, err)
+		assert.Equal(t, test.Format, feed.Format)
+		assert.Equal(t, test.Title, feed.Title)
+
+		urls := []string{}
+		for _, item := range feed.Items {
+			urls = append(urls, item.URL)
+		}
+		assert.Equal(t, test.URLs, urls)
+	}
+}
+
+func TestFeed_ParseFeed_Dates(t *testing.T) {
+	feed, err := ParseFeed(rssFeed, "http://example.com/feed")
+	assert.NoError(t, err)
+	assert.Equal(t, "First", feed.Items[0].Title)
+	assert.True(t, feed.Items[0].Published.Equal(time.Date(2006, 1, 2, 22, 4, 5, 0, time.UTC)))
+	assert.True(t, feed.Items[1].Published.IsZero())
+
+	feed, err = ParseFeed(atomFeed, "http://example.com/feed")
+	assert.NoError(t, err)
+	assert.True(t, feed.Items[0].Published.Equal(time.Date(2015, 6, 1, 10, 0, 0, 0, time.UTC)))
+}
+
+func TestFeed_ParseFeed_Unknown(t *testing.T) {
+	tests := [][]byte{
+		[]byte(`<html><body>Not a feed</body></html>`),
+		[]byte(`{"title": "Not a feed"}`),
+	}
+	for _, data := range tests {
+		_, err := ParseFeed(data, "http://example.com/")
+		assert.Equal(t, ErrUnknownFeed, err)
+	}
+
+	_, err := ParseFeed([]byte(`<rss><channel>`), "http://example.com/")
+	assert.Error(t, err)
+}
+
+func TestFeed_DiscoverFeeds(t *testing.T) {
+	doc := parseDocument([]byte(`
+<html><head>
+	<link rel="alternate" type="application/rss+xml" href="/feed.xml">
+	<link rel="alternate" type="application/atom+xml" href="http://example.com/atom">
+	<link rel="alternate" type="application/feed+json" href="feed.json">
+	<link rel="alternate" type="application/rss+xml" href="/feed.xml">
+	<link rel="alternate" hreflang="fr" href="/fr/">
+	<link rel="stylesheet" type="text/css" href="/style.css">
+</head></html>`))
+
+	assert.Equal(t, []string{
+		"http://example.com/feed.xml",
+		"http://example.com/atom",
+		"http://example.com/news/feed.json",
+	}, DiscoverFeeds(doc, "http://example.com/news/"))
+}
+
+func TestFeed_Feeds(t *testing.T) {
+	ctx := writerContext()
+	fs := ctx.Feeds
+
+	for _, sub := range []struct {
+		feed, site string
+		added      bool
+	}{
+		{"http://b.com/feed", "b.com", true},
+		{"http://a.com/feed", "a.com", true},
+		{"http://a.com/feed", "a.com", false},
+	} {
+		added, err := fs.Subscribe(ctx, sub.feed, sub.site)
+		assert.NoError(t, err)
+		assert.Equal(t, sub.added, added)
+	}
+
+	list := fs.List()
+	assert.Equal(t, 2, len(list))
+	assert.Equal(t, "http://a.com/feed", list[0].URL)
+	assert.Equal(t, "a.com", list[0].Site)
+
+	assert.NoError(t, fs.Remove(ctx, "a.com"))
+	list = fs.List()
+	assert.Equal(t, 1, len(list))
+	assert.Equal(t, "http://b.com/feed", list[0].URL)
+
+	// Subscriptions are read back from the store.
+	loaded := NewFeeds()
+	assert.NoError(t, loaded.Load(ctx))
+	assert.Equal(t, list, loaded.List())
+}
+
+func TestFeed_PollFeed(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<rss><channel>
+			<item><link>/new</link></item>
+			<item><link>/seen</link></item>
+			<item><link>/stored</link></item>
+			<item><link>http://elsewhere.com/</link></item>
+		</channel></rss>`))
+	}))
+	defer ts.Close()
+	site := ts.URL[len("http://"):]
+
+	ctx := writerContext()
+	// The site's queue is already being crawled, so items are only added to it.
+	q, _ := ctx.Queues.Open(site, 0)
+	q.Manager[ts.URL+"/seen"] = true
+	// A page stored by an earlier crawl isn't crawled again.
+	assert.NoError(t, ctx.Store.PutDocument(NewDocument(ts.URL+"/stored", site, "", "")))
+
+	items, err := PollFeed(ctx, ts.URL+"/feed", site)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, items)
+	assert.Equal(t, []string{ts.URL + "/new"}, q.Items)
+	assert.Equal(t, 1, len(ctx.Queues.List()))
+
+	broken := Handler(404, []byte("Not found"))
+	defer broken.Close()
+	_, err = PollFeed(ctx, broken.URL, site)
+	assert.Equal(t, ErrUnreachableURL, err)
+}
+
+func TestFeed_Poll(t *testing.T) {
+	ts := Handler(200, jsonFeedData)
+	defer ts.Close()
+	broken := Handler(500, nil)
+	defer broken.Close()
+
+	ctx := writerContext()
+	q, _ := ctx.Queues.Open("example.com", 0)
+	ctx.Feeds.Subscribe(ctx, ts.URL, "example.com")
+	ctx.Feeds.Subscribe(ctx, broken.URL, "example.com")
+
+	ctx.Feeds.Poll(ctx)
+
+	// The result of the poll is stored.
+	loaded := NewFeeds()
+	assert.NoError(t, loaded.Load(ctx))
+	assert.Equal(t, 2, len(loaded.List()))
+
+	for _, s := range loaded.List() {
+		assert.False(t, s.Polled.IsZero())
+		if s.URL == ts.URL {
+			assert.Equal(t, 2, s.Items)
+			assert.Equal(t, "", s.Error)
+		} else {
+			assert.Equal(t, ErrUnreachableURL.Error(), s.Error)
+		}
+	}
+	assert.Equal(t, []string{"http://example.com/updates/1"}, q.Items)
+}
+
+func TestFeed_StartStop(t *testing.T) {
+	fs := NewFeeds()
+	fs.Start(writerContext(), time.Hour)
+	fs.Start(writerContext(), time.Hour)
+	fs.Stop()
+	fs.Stop()
+}
+
+func TestCrawler_IndexPage_Feeds(t *testing.T) {
+	defer TearDown(_ctx)
+	defer func() { _ctx.Feeds = NewFeeds() }()
+
+	ts := Handler(200, []byte(`<html><head>
+	<link rel="alternate" type="application/rss+xml" href="/rss">
+</head><body><p>Some text</p></body></html>`))
+	defer ts.Close()
+	site := ts.URL[len("http://"):]
+
+	assert.NoError(t, IndexPage(_ctx, NewQueue(), ts.URL+"/", site))
+
+	feeds := _ctx.Feeds.List()
+	assert.Equal(t, 1, len(feeds))
+	assert.Equal(t, ts.URL+"/rss", feeds[0].URL)
+	assert.Equal(t, site, feeds[0].Site)
+}
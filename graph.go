@@ -0,0 +1,299 @@
+package miru
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/satori/go.uuid"
+)
+
+// Damping is the chance of following a link rather than jumping to a random
+// page when computing PageRank.
+var Damping = 0.85
+
+// Link is an edge of the link graph, from a document to the URL it links to.
+// NoFollow is set for links marked nofollow, ugc or sponsored, these are kept
+// but don't pass on authority.
+type Link struct {
+	LinkID   string `gorethink:"id" json:"link_id"`
+	Source   string `gorethink:"source" json:"source"`
+	Target   string `gorethink:"target" json:"target"`
+	Anchor   string `gorethink:"anchor" json:"anchor"`
+	NoFollow bool   `gorethink:"nofollow" json:"nofollow"`
+}
+
+// NewLink creates a link from a document to a URL.
+func NewLink(source, target, anchor string, nofollow bool) *Link {
+	return &Link{
+		LinkID:   uuid.NewV4().String(),
+		Source:   source,
+		Target:   target,
+		Anchor:   anchor,
+		NoFollow: nofollow,
+	}
+}
+
+// ExtractPageLinks returns a link for each anchor of a page with a web URL,
+// relative URLs are resolved against the page's URL and fragments are dropped.
+// The anchor text falls back to the alt text of an image inside the anchor.
+func ExtractPageLinks(doc *goquery.Document, d *Document) []*Link {
+	links := []*Link{}
+	doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		target := LinkTarget(d.Url, href)
+		if target == "" {
+			return
+		}
+
+		anchor := normaliseSpace(s.Text())
+		if anchor == "" {
+			anchor, _ = s.Find("img[alt]").First().Attr("alt")
+			anchor = normaliseSpace(anchor)
+		}
+
+		nofollow := false
+		rel, _ := s.Attr("rel")
+		for _, value := range strings.Fields(strings.ToLower(rel)) {
+			if value == "nofollow" || value == "ugc" || value == "sponsored" {
+				nofollow = true
+			}
+		}
+
+		links = append(links, NewLink(d.DocID, target, anchor, nofollow))
+	})
+	return links
+}
+
+// LinkTarget resolves href against the URL of the page it's on, returning ""
+// for links that aren't to a web page.
+func LinkTarget(base, href string) string {
+	b, err := url.Parse(base)
+	if err != nil {
+		return ""
+	}
+	ref, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return ""
+	}
+
+	target := b.ResolveReference(ref)
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return ""
+	}
+	target.Fragment = ""
+	return target.String()
+}
+
+// Authority holds a score between 0 and 1 for each document from the PageRank
+// of its URL over the stored link graph, the best linked page scores 1.
+type Authority struct {
+	scores map[string]float64
+	stop   chan bool
+	wg     sync.WaitGroup
+	sync.RWMutex
+}
+
+// NewAuthority creates an empty set of scores.
+func NewAuthority() *Authority {
+	return &Authority{scores: map[string]float64{}}
+}
+
+// Score returns the authority of a document, 0 if it isn't known.
+func (a *Authority) Score(docID string) float64 {
+	a.RLock()
+	defer a.RUnlock()
+
+	return a.scores[docID]
+}
+
+// Len returns the number of documents with a score.
+func (a *Authority) Len() int {
+	a.RLock()
+	defer a.RUnlock()
+
+	return len(a.scores)
+}
+
+// Compute replaces the scores with those computed from a store.
+func (a *Authority) Compute(s Store) error {
+	scores, err := ComputeAuthority(s)
+	if err != nil {
+		return err
+	}
+
+	a.Lock()
+	a.scores = scores
+	a.Unlock()
+	return nil
+}
+
+// Start computes the scores from a store every interval until Stop is called,
+// errors leave the previous scores in place. Nothing is started for an interval
+// that isn't positive.
+func (a *Authority) Start(s Store, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	a.Lock()
+	if a.stop != nil {
+		a.Unlock()
+		return
+	}
+	a.stop = make(chan bool)
+	stop := a.stop
+	a.Unlock()
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				a.Compute(s)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the job started by Start.
+func (a *Authority) Stop() {
+	a.Lock()
+	stop := a.stop
+	a.stop = nil
+	a.Unlock()
+
+	if stop != nil {
+		close(stop)
+		a.wg.Wait()
+	}
+}
+
+// ComputeAuthority runs PageRank over the link graph of a store. Each stored
+// URL is a page, a document's canonical URL being the same page as its own,
+// and every followed link between two pages is an edge. Documents are scored
+// by their page's rank divided by the highest rank.
+func ComputeAuthority(s Store) (map[string]float64, error) {
+	docs := []docURL{}
+	canonical := map[string]string{}
+	if err := s.EachDocument(func(d *Document) error {
+		docs = append(docs, docURL{d.DocID, d.Url})
+		if d.Canonical != "" && d.Canonical != d.Url {
+			canonical[d.Url] = d.Canonical
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	// Pages are keyed by their canonical URL, so a URL is mapped onto its
+	// canonical page before a page is made for it.
+	pages := map[string]int{}
+	key := func(u string) string {
+		if c, ok := canonical[u]; ok {
+			return c
+		}
+		return u
+	}
+	docPages := map[string]int{}
+	for _, d := range docs {
+		u := key(d.url)
+		n, ok := pages[u]
+		if !ok {
+			n = len(pages)
+			pages[u] = n
+		}
+		docPages[d.id] = n
+	}
+
+	out := make([]map[int]bool, len(pages))
+	for n := range out {
+		out[n] = map[int]bool{}
+	}
+	if err := s.EachLink(func(l *Link) error {
+		from, ok := docPages[l.Source]
+		if !ok || l.NoFollow {
+			return nil
+		}
+		if to, ok := pages[key(l.Target)]; ok && to != from {
+			out[from][to] = true
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	ranks := pageRank(out, 50, 1e-6)
+
+	highest := 0.0
+	for _, r := range ranks {
+		if r > highest {
+			highest = r
+		}
+	}
+	scores := map[string]float64{}
+	for id, n := range docPages {
+		if highest > 0 {
+			scores[id] = ranks[n] / highest
+		}
+	}
+	return scores, nil
+}
+
+// pageRank iterates PageRank over a graph given as the outgoing edges of each
+// page until no rank changes by more than tolerance. The rank of pages without
+// links is shared between every page.
+func pageRank(out []map[int]bool, iterations int, tolerance float64) []float64 {
+	n := len(out)
+	ranks := make([]float64, n)
+	if n == 0 {
+		return ranks
+	}
+	for i := range ranks {
+		ranks[i] = 1 / float64(n)
+	}
+
+	for iteration := 0; iteration < iterations; iteration++ {
+		dangling := 0.0
+		for from, targets := range out {
+			if len(targets) == 0 {
+				dangling += ranks[from]
+			}
+		}
+
+		next := make([]float64, n)
+		base := (1-Damping)/float64(n) + Damping*dangling/float64(n)
+		for i := range next {
+			next[i] = base
+		}
+		for from, targets := range out {
+			share := Damping * ranks[from] / float64(len(targets))
+			for to := range targets {
+				next[to] += share
+			}
+		}
+
+		change := 0.0
+		for i := range next {
+			if d := next[i] - ranks[i]; d > 0 {
+				change += d
+			} else {
+				change -= d
+			}
+		}
+		ranks = next
+		if change < tolerance {
+			break
+		}
+	}
+	return ranks
+}
@@ -0,0 +1,172 @@
+package miru
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraph_LinkTarget(t *testing.T) {
+	tests := []struct {
+		Href     string
+		Expected string
+	}{
+		{"/about", "http://example.com/about"},
+		{"b#top", "http://example.com/news/b"},
+		{"https://example.org/x?y=1", "https://example.org/x?y=1"},
+		{"#top", "http://example.com/news/a"},
+		{"mailto:someone@example.com", ""},
+		{"javascript:void(0)", ""},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.Expected, LinkTarget("http://example.com/news/a", test.Href), test.Href)
+	}
+}
+
+func TestGraph_ExtractPageLinks(t *testing.T) {
+	d := NewDocument("http://example.com/news/", "example.com", "", "")
+	doc := newDocument([]byte(`
+<p>
+	<a href="/about">About  us</a>
+	<a href="http://example.org/" rel="external nofollow">Elsewhere</a>
+	<a href="story"><img src="s.jpg" alt="A story"></a>
+	<a href="mailto:someone@example.com">Email</a>
+	<a>No href</a>
+</p>`))
+
+	links := ExtractPageLinks(doc, d)
+	if assert.Equal(t, 3, len(links)) {
+		assert.Equal(t, d.DocID, links[0].Source)
+		assert.Equal(t, "http://example.com/about", links[0].Target)
+		assert.Equal(t, "About us", links[0].Anchor)
+		assert.False(t, links[0].NoFollow)
+
+		assert.True(t, links[1].NoFollow)
+
+		assert.Equal(t, "http://example.com/news/story", links[2].Target)
+		assert.Equal(t, "A story", links[2].Anchor)
+	}
+}
+
+func TestGraph_pageRank(t *testing.T) {
+	// 0 and 2 both link to 1, which links back to 0, 3 has no links.
+	out := []map[int]bool{
+		{1: true},
+		{0: true},
+		{1: true},
+		{},
+	}
+	ranks := pageRank(out, 100, 1e-9)
+
+	total := 0.0
+	for _, r := range ranks {
+		total += r
+	}
+	assert.InDelta(t, 1.0, total, 1e-6)
+	assert.True(t, ranks[1] > ranks[0])
+	assert.True(t, ranks[0] > ranks[2])
+	assert.InDelta(t, ranks[2], ranks[3], 1e-9)
+
+	assert.Equal(t, 0, len(pageRank(nil, 10, 1e-6)))
+}
+
+func TestGraph_ComputeAuthority(t *testing.T) {
+	s := NewMemoryStore()
+	home := NewDocument("http://example.com/", "example.com", "", "")
+	about := NewDocument("http://example.com/about", "example.com", "", "")
+	news := NewDocument("http://example.com/news?page=1", "example.com", "", "")
+	news.Canonical = "http://example.com/news"
+	for _, d := range []*Document{home, about, news} {
+		s.PutDocument(d)
+	}
+
+	s.PutLinks([]*Link{
+		NewLink(about.DocID, "http://example.com/", "Home", false),
+		NewLink(news.DocID, "http://example.com/", "Home", false),
+		NewLink(home.DocID, "http://example.com/news", "News", false),
+		NewLink(home.DocID, "http://example.com/about", "About", true),
+		NewLink(home.DocID, "http://example.org/", "Elsewhere", false),
+	})
+
+	scores, err := ComputeAuthority(s)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, scores[home.DocID])
+	// The news page is linked to through its canonical URL, the link to the
+	// about page isn't followed.
+	assert.True(t, scores[news.DocID] > scores[about.DocID])
+
+	a := NewAuthority()
+	assert.Equal(t, 0.0, a.Score(home.DocID))
+	assert.NoError(t, a.Compute(s))
+	assert.Equal(t, 3, a.Len())
+	assert.Equal(t, 1.0, a.Score(home.DocID))
+}
+
+func TestGraph_Authority_Start(t *testing.T) {
+	a := NewAuthority()
+	// A zero interval would panic in the ticker.
+	a.Start(NewMemoryStore(), 0)
+	a.Stop()
+
+	a.Start(NewMemoryStore(), time.Hour)
+	a.Stop()
+}
+
+func TestGraph_Search(t *testing.T) {
+	defer TearDown(_ctx)
+
+	other := NewDocument("http://example.com/other", "example.com", "", "world news")
+	popular := NewDocument("http://example.com/popular", "example.com", "", "world news")
+	_ctx.Writer.AddPage(&Page{
+		Document: other,
+		Indexes:  IndexDocument(_ctx.Analyzer, other),
+		Links: []*Link{
+			NewLink(other.DocID, popular.Url, "Popular", false),
+		},
+	}, nil)
+	_ctx.Writer.Add(popular, IndexDocument(_ctx.Analyzer, popular), nil)
+	assert.NoError(t, _ctx.Writer.Flush())
+
+	res := new(Results)
+	assert.NoError(t, res.Search("world", _ctx))
+	assert.Equal(t, other.Url, res.Results[0].Url)
+
+	assert.NoError(t, _ctx.Authority.Compute(_ctx.Store))
+
+	res = new(Results)
+	assert.NoError(t, res.Search("world", _ctx))
+	if assert.Equal(t, int64(2), res.Count) {
+		assert.Equal(t, popular.Url, res.Results[0].Url)
+		assert.True(t, res.Results[0].Score > res.Results[1].Score)
+	}
+}
+
+func TestGraph_ComputeAuthority_Canonical(t *testing.T) {
+	s := NewMemoryStore()
+	home := NewDocument("http://example.com/", "example.com", "", "")
+	home.DocID = "1"
+	news := NewDocument("http://example.com/news", "example.com", "", "")
+	news.DocID = "2"
+	// The duplicate is read after the page it is canonical to.
+	duplicate := NewDocument("http://example.com/news?page=1", "example.com", "", "")
+	duplicate.DocID = "3"
+	duplicate.Canonical = news.Url
+	for _, d := range []*Document{home, news, duplicate} {
+		s.PutDocument(d)
+	}
+
+	s.PutLinks([]*Link{
+		NewLink(home.DocID, duplicate.Url, "News", false),
+		NewLink(news.DocID, home.Url, "Home", false),
+	})
+
+	// Both pages link to each other, the link to the duplicate counting for
+	// the page it is canonical to.
+	scores, err := ComputeAuthority(s)
+	assert.NoError(t, err)
+	assert.InDelta(t, 1.0, scores[home.DocID], 1e-6)
+	assert.InDelta(t, 1.0, scores[news.DocID], 1e-6)
+	assert.Equal(t, scores[news.DocID], scores[duplicate.DocID])
+}
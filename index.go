@@ -1,9 +1,23 @@
 package miru
 
 import (
+	"net/url"
 	"strings"
+	"unicode"
+)
 
-	"github.com/reiver/go-porterstemmer"
+// Fields a document is indexed under, each field can be boosted separately
+// when ranking results.
+const (
+	FieldTitle       = "title"
+	FieldHeadings    = "headings"
+	FieldContent     = "content"
+	FieldURL         = "url"
+	FieldDescription = "description"
+	FieldAnchor      = "anchor"
+	FieldKeywords    = "keywords"
+	FieldAuthor      = "author"
+	FieldEntity      = "entity"
 )
 
 var stopWords = map[string]bool{
@@ -191,65 +205,99 @@ func Stopper(word string) bool {
 	return false
 }
 
-// Normalise transform a word by lowercasing and applying stemming.
+// Normalise transform a word by lowercasing, folding and applying stemming
+// using the default analyzer.
 func Normalise(word string) string {
-	word = strings.ToLower(word)
-	if Stopper(word) {
-		return ""
-	}
-	// TODO: Remove punctuation
-	word = string(porterstemmer.StemWithoutLowerCasing([]rune(word)))
-	return word
+	return DefaultAnalyzer.Normalise(word)
 }
 
 // RemoveDuplicates counts the number of duplicates and then keeps only the
-// unique values.
+// unique values, words are only considered duplicates within the same field.
 func RemoveDuplicates(i Indexes) Indexes {
 	result := Indexes{}
 	seen := map[string]int64{}
 	for _, val := range i {
-		if _, ok := seen[val.Word]; !ok {
+		key := val.Field + ":" + val.Word
+		if _, ok := seen[key]; !ok {
 			result = append(result, val)
-			seen[val.Word] = seen[val.Word] + 1
-		} else {
-			seen[val.Word] = seen[val.Word] + 1
 		}
+		seen[key] = seen[key] + 1
 	}
 	finalResults := Indexes{}
 	for _, res := range result {
-		count := seen[res.Word]
+		count := seen[res.Field+":"+res.Word]
 		res.Count = count
 		finalResults = append(finalResults, res)
 	}
 	return finalResults
 }
 
-// processText concurrently processesa list of words.
-func processText(words []string, docID string, c chan *Index) {
-	for _, word := range words {
-		word = Normalise(word)
-		if word == "" {
-			continue
-		}
-
-		index := NewIndex(docID, word, 1)
+// processText concurrently processesa list of tokens.
+func processText(tokens []Token, docID, field string, c chan *Index) {
+	for _, token := range tokens {
+		index := NewFieldIndex(docID, field, token.Term, 1)
+		index.Text = token.Text
 
 		c <- index
 	}
 	close(c)
 }
 
-// Indexer tokenises and counts occurences of words in a document
-func Indexer(text, docID string) Indexes {
+// FieldIndexer analyses and counts occurences of words in a single field of a
+// document.
+func FieldIndexer(a Analyzer, text, docID, field string) Indexes {
 	indexes := Indexes{}
-	words := strings.Fields(text)
+	tokens := a.Analyze(text)
 
-	c := make(chan *Index, len(words))
+	c := make(chan *Index, len(tokens))
 
-	processText(words, docID, c)
+	processText(tokens, docID, field, c)
 	for i := range c {
 		indexes = append(indexes, i)
 	}
 
 	return RemoveDuplicates(indexes)
 }
+
+// Indexer tokenises and counts occurences of words in a document
+func Indexer(text, docID string) Indexes {
+	return FieldIndexer(DefaultAnalyzer, text, docID, FieldContent)
+}
+
+// IndexDocument indexes each field of a document separately so that matches
+// can be weighted by where they occurred.
+func IndexDocument(a Analyzer, d *Document) Indexes {
+	fields := []struct {
+		name string
+		text string
+	}{
+		{FieldTitle, d.Title},
+		{FieldHeadings, d.Headings},
+		{FieldContent, d.Content},
+		{FieldURL, URLText(d.Url)},
+		{FieldDescription, d.Description},
+		{FieldKeywords, strings.Join(d.Keywords, "\n")},
+		{FieldAuthor, d.Author},
+		{FieldEntity, entityText(d.Entities)},
+	}
+
+	indexes := Indexes{}
+	for _, field := range fields {
+		indexes = append(indexes, FieldIndexer(a, field.text, d.DocID, field.name)...)
+	}
+	return indexes
+}
+
+// URLText splits the path of a URL into words, the host is left out as it is
+// already stored as the document's site.
+func URLText(link string) string {
+	_url, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+
+	words := strings.FieldsFunc(_url.Path, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
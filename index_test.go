@@ -58,10 +58,14 @@ func TestIndex_Normalise(t *testing.T) {
 			"the",
 			"",
 		},
-		// {
-		// 	"with-punctuation?!",
-		// 	"with-punctuation",
-		// },
+		{
+			"news,",
+			"new",
+		},
+		{
+			"Café",
+			"cafe",
+		},
 		{
 			"stemmed",
 			"stem",
@@ -107,3 +111,69 @@ func TestIndex_RemoveDuplicates(t *testing.T) {
 	indexes := Indexer("hello world cruel world hello world", "")
 	assert.Equal(t, len(indexes), 3)
 }
+
+func TestIndex_RemoveDuplicates_PerField(t *testing.T) {
+	indexes := Indexes{
+		NewIndex("", "hello", 1),
+		NewIndex("", "hello", 1),
+		NewIndex("", "hello", 1),
+	}
+	indexes[2].Field = FieldTitle
+
+	indexes = RemoveDuplicates(indexes)
+	assert.Equal(t, 2, len(indexes))
+	assert.Equal(t, int64(2), indexes[0].Count)
+	assert.Equal(t, int64(1), indexes[1].Count)
+}
+
+func TestIndex_IndexDocument(t *testing.T) {
+	d := NewDocument(
+		"http://example.com/guides/gardening/",
+		"example.com",
+		"Gardening",
+		"Roses need sunlight",
+	)
+	d.Headings = "Roses"
+
+	fields := map[string][]string{}
+	for _, i := range IndexDocument(DefaultAnalyzer, d) {
+		assert.Equal(t, d.DocID, i.DocID)
+		fields[i.Field] = append(fields[i.Field], i.Word)
+	}
+
+	assert.Equal(t, []string{"garden"}, fields[FieldTitle])
+	assert.Equal(t, []string{"rose"}, fields[FieldHeadings])
+	assert.Equal(t, []string{"rose", "need", "sunlight"}, fields[FieldContent])
+	assert.Equal(t, []string{"guid", "garden"}, fields[FieldURL])
+
+	for _, i := range IndexDocument(DefaultAnalyzer, d) {
+		if i.Field == FieldTitle {
+			assert.Equal(t, "gardening", i.Text)
+		}
+	}
+	assert.Equal(t, 0, len(fields[FieldDescription]))
+}
+
+func TestIndex_URLText(t *testing.T) {
+	tests := []struct {
+		Input  string
+		Output string
+	}{
+		{
+			"http://example.com/about-us/team.html",
+			"about us team html",
+		},
+		{
+			"http://example.com",
+			"",
+		},
+		{
+			"%",
+			"",
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.Output, URLText(test.Input))
+	}
+}
@@ -0,0 +1,356 @@
+package miru
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrCorruptRecord for when a record in the log fails its checksum.
+var ErrCorruptRecord = errors.New("Log record is corrupt.")
+
+// KV is a minimal key-value store used by the embedded store.
+type KV interface {
+	Get(key string) ([]byte, error)
+	Put(key string, value []byte) error
+	Delete(key string) error
+	// Keys returns every key starting with prefix in sorted order.
+	Keys(prefix string) ([]string, error)
+	// Size returns the number of bytes used by the store.
+	Size() int64
+	Close() error
+}
+
+// tombstone marks a deleted key in place of a value length.
+const tombstone = ^uint32(0)
+
+// headerSize is the length of a record's header: the key and value lengths.
+const headerSize = 8
+
+// CompactSize is the number of bytes of overwritten and deleted records a log
+// holds before it is compacted, once they are also half of the log.
+var CompactSize int64 = 16 << 20
+
+// LogKV is a KV backed by an append-only log file. Each record is the key and
+// value lengths, the key, the value and a CRC32 of all of them. An in-memory
+// map points each key at the offset of its latest value, which is read back
+// from the file on Get. Deletes append a tombstone record. The log is compacted
+// once enough of it is dead, see CompactSize.
+type LogKV struct {
+	file    *os.File
+	offsets map[string]int64
+	size    int64
+	dead    int64
+	sync.RWMutex
+}
+
+// OpenLogKV opens or creates a log file and replays it to rebuild the key map.
+// A partially written or corrupt record at the end of the log is discarded, a
+// corrupt record anywhere else fails with ErrCorruptRecord. A record that runs
+// past the end of the log is only taken to be partially written when no valid
+// record follows where it starts.
+func OpenLogKV(path string) (*LogKV, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	kv := &LogKV{file: file, offsets: make(map[string]int64)}
+	if err := kv.replay(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return kv, nil
+}
+
+func (kv *LogKV) replay() error {
+	info, err := kv.file.Stat()
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(kv.file)
+	var offset int64
+
+	for {
+		key, value, n, err := readRecord(r, info.Size()-offset)
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF && kv.recordAfter(offset, info.Size()) {
+			return ErrCorruptRecord
+		}
+		// The last write was interrupted, drop it.
+		if err == io.ErrUnexpectedEOF || (err == ErrCorruptRecord && offset+n == info.Size()) {
+			if err := kv.file.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if old, ok := kv.offsets[key]; ok {
+			kv.dead += kv.recordSize(old)
+		}
+		if value == nil {
+			kv.dead += n
+			delete(kv.offsets, key)
+		} else {
+			kv.offsets[key] = offset
+		}
+		offset += n
+	}
+
+	kv.size = offset
+	_, err = kv.file.Seek(offset, 0)
+	return err
+}
+
+// recordAfter reports whether a valid record starts anywhere in the log after
+// offset, up to size.
+func (kv *LogKV) recordAfter(offset, size int64) bool {
+	for start := offset + 1; start+headerSize+4 <= size; start++ {
+		r := io.NewSectionReader(kv.file, start, size-start)
+		if _, _, _, err := readRecord(r, size-start); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// readRecord reads a record of at most limit bytes, returning a nil value for a
+// tombstone and the length of the record. A record longer than limit is cut
+// short, a corrupt record's length is still returned.
+func readRecord(r io.Reader, limit int64) (string, []byte, int64, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", nil, 0, err
+	}
+	keyLen := binary.BigEndian.Uint32(header[:4])
+	valueLen := binary.BigEndian.Uint32(header[4:])
+
+	bodyLen := int64(keyLen) + 4
+	if valueLen != tombstone {
+		bodyLen += int64(valueLen)
+	}
+	if headerSize+bodyLen > limit {
+		return "", nil, 0, io.ErrUnexpectedEOF
+	}
+
+	body := make([]byte, bodyLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", nil, 0, err
+	}
+
+	data, sum := body[:bodyLen-4], body[bodyLen-4:]
+	crc := crc32.NewIEEE()
+	crc.Write(header)
+	crc.Write(data)
+	if crc.Sum32() != binary.BigEndian.Uint32(sum) {
+		return "", nil, headerSize + bodyLen, ErrCorruptRecord
+	}
+
+	key := string(data[:keyLen])
+	var value []byte
+	if valueLen != tombstone {
+		value = data[keyLen:]
+	}
+	return key, value, headerSize + bodyLen, nil
+}
+
+// recordSize returns the length of the record at offset from its header, 0 if
+// it can't be read.
+func (kv *LogKV) recordSize(offset int64) int64 {
+	header := make([]byte, headerSize)
+	if _, err := kv.file.ReadAt(header, offset); err != nil {
+		return 0
+	}
+	size := headerSize + int64(binary.BigEndian.Uint32(header[:4])) + 4
+	if valueLen := binary.BigEndian.Uint32(header[4:]); valueLen != tombstone {
+		size += int64(valueLen)
+	}
+	return size
+}
+
+func encodeRecord(key string, value []byte) []byte {
+	valueLen := uint32(len(value))
+	if value == nil {
+		valueLen = tombstone
+	}
+
+	record := make([]byte, headerSize, headerSize+len(key)+len(value)+4)
+	binary.BigEndian.PutUint32(record[:4], uint32(len(key)))
+	binary.BigEndian.PutUint32(record[4:], valueLen)
+	record = append(record, key...)
+	record = append(record, value...)
+
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, crc32.ChecksumIEEE(record))
+	return append(record, sum...)
+}
+
+func (kv *LogKV) append(key string, value []byte) (int64, error) {
+	record := encodeRecord(key, value)
+	offset := kv.size
+	if _, err := kv.file.WriteAt(record, offset); err != nil {
+		return 0, err
+	}
+	kv.size += int64(len(record))
+	return offset, nil
+}
+
+// Get reads the latest value of a key.
+func (kv *LogKV) Get(key string) ([]byte, error) {
+	kv.RLock()
+	defer kv.RUnlock()
+
+	offset, ok := kv.offsets[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	_, value, _, err := readRecord(io.NewSectionReader(kv.file, offset, kv.size-offset), kv.size-offset)
+	return value, err
+}
+
+// Put appends a new value for a key.
+func (kv *LogKV) Put(key string, value []byte) error {
+	kv.Lock()
+	defer kv.Unlock()
+
+	if value == nil {
+		value = []byte{}
+	}
+	offset, err := kv.append(key, value)
+	if err != nil {
+		return err
+	}
+	if old, ok := kv.offsets[key]; ok {
+		kv.dead += kv.recordSize(old)
+	}
+	kv.offsets[key] = offset
+	return kv.maybeCompact()
+}
+
+// Delete appends a tombstone for a key.
+func (kv *LogKV) Delete(key string) error {
+	kv.Lock()
+	defer kv.Unlock()
+
+	old, ok := kv.offsets[key]
+	if !ok {
+		return nil
+	}
+	offset, err := kv.append(key, nil)
+	if err != nil {
+		return err
+	}
+	kv.dead += kv.recordSize(old) + kv.size - offset
+	delete(kv.offsets, key)
+	return kv.maybeCompact()
+}
+
+// maybeCompact compacts the log once it holds CompactSize dead bytes and they
+// are at least half of it.
+func (kv *LogKV) maybeCompact() error {
+	if kv.dead < CompactSize || kv.dead*2 < kv.size {
+		return nil
+	}
+	return kv.compact()
+}
+
+// Keys returns the keys starting with prefix in sorted order.
+func (kv *LogKV) Keys(prefix string) ([]string, error) {
+	kv.RLock()
+	defer kv.RUnlock()
+
+	keys := []string{}
+	for key := range kv.offsets {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Size returns the length of the log file.
+func (kv *LogKV) Size() int64 {
+	kv.RLock()
+	defer kv.RUnlock()
+
+	return kv.size
+}
+
+// Compact rewrites the log with only the latest value of each key.
+func (kv *LogKV) Compact() error {
+	kv.Lock()
+	defer kv.Unlock()
+
+	return kv.compact()
+}
+
+func (kv *LogKV) compact() error {
+	path := kv.file.Name()
+	tmp, err := os.Create(path + ".compact")
+	if err != nil {
+		return err
+	}
+
+	offsets := make(map[string]int64, len(kv.offsets))
+	var size int64
+	for key, offset := range kv.offsets {
+		_, value, _, err := readRecord(io.NewSectionReader(kv.file, offset, kv.size-offset), kv.size-offset)
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return err
+		}
+
+		record := encodeRecord(key, value)
+		if _, err := tmp.Write(record); err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return err
+		}
+		offsets[key] = size
+		size += int64(len(record))
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	kv.file.Close()
+	kv.file = tmp
+	kv.offsets = offsets
+	kv.size = size
+	kv.dead = 0
+	return nil
+}
+
+// Close syncs and closes the log file.
+func (kv *LogKV) Close() error {
+	kv.Lock()
+	defer kv.Unlock()
+
+	if err := kv.file.Sync(); err != nil {
+		return err
+	}
+	return kv.file.Close()
+}
@@ -0,0 +1,244 @@
+package miru
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tempLogKV(t *testing.T) (*LogKV, string) {
+	dir, err := ioutil.TempDir("", "miru")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	path := filepath.Join(dir, "test.db")
+	kv, err := OpenLogKV(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return kv, path
+}
+
+func TestKV_PutGet(t *testing.T) {
+	kv, path := tempLogKV(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	defer kv.Close()
+
+	assert.NoError(t, kv.Put("a", []byte("one")))
+	assert.NoError(t, kv.Put("a", []byte("two")))
+	assert.NoError(t, kv.Put("b", []byte{}))
+
+	value, err := kv.Get("a")
+	assert.NoError(t, err)
+	assert.Equal(t, "two", string(value))
+
+	value, err = kv.Get("b")
+	assert.NoError(t, err)
+	assert.Equal(t, "", string(value))
+
+	_, err = kv.Get("c")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestKV_DeleteKeys(t *testing.T) {
+	kv, path := tempLogKV(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	defer kv.Close()
+
+	kv.Put("doc/2", []byte("2"))
+	kv.Put("doc/1", []byte("1"))
+	kv.Put("idx/1", []byte("1"))
+
+	assert.NoError(t, kv.Delete("doc/2"))
+	assert.NoError(t, kv.Delete("doc/3"))
+
+	keys, err := kv.Keys("doc/")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"doc/1"}, keys)
+
+	_, err = kv.Get("doc/2")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestKV_Reopen(t *testing.T) {
+	kv, path := tempLogKV(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	kv.Put("a", []byte("one"))
+	kv.Put("b", []byte("two"))
+	kv.Delete("a")
+	assert.NoError(t, kv.Close())
+
+	kv, err := OpenLogKV(path)
+	assert.NoError(t, err)
+	defer kv.Close()
+
+	keys, _ := kv.Keys("")
+	assert.Equal(t, []string{"b"}, keys)
+
+	value, err := kv.Get("b")
+	assert.NoError(t, err)
+	assert.Equal(t, "two", string(value))
+}
+
+func TestKV_TornWrite(t *testing.T) {
+	kv, path := tempLogKV(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	kv.Put("a", []byte("one"))
+	size := kv.Size()
+	kv.Put("b", []byte("two"))
+	kv.Close()
+
+	// Cut the last record short as if the process died mid-write.
+	assert.NoError(t, os.Truncate(path, size+5))
+
+	kv, err := OpenLogKV(path)
+	assert.NoError(t, err)
+	defer kv.Close()
+
+	keys, _ := kv.Keys("")
+	assert.Equal(t, []string{"a"}, keys)
+	assert.Equal(t, size, kv.Size())
+
+	assert.NoError(t, kv.Put("c", []byte("three")))
+	value, err := kv.Get("c")
+	assert.NoError(t, err)
+	assert.Equal(t, "three", string(value))
+}
+
+func TestKV_Compact(t *testing.T) {
+	kv, path := tempLogKV(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	defer kv.Close()
+
+	for i := 0; i < 10; i++ {
+		kv.Put("a", []byte("value"))
+	}
+	kv.Put("b", []byte("value"))
+	kv.Delete("b")
+
+	before := kv.Size()
+	assert.NoError(t, kv.Compact())
+	assert.True(t, kv.Size() < before)
+
+	value, err := kv.Get("a")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", string(value))
+
+	_, err = kv.Get("b")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestKV_Corrupt(t *testing.T) {
+	for _, last := range []bool{true, false} {
+		kv, path := tempLogKV(t)
+		defer os.RemoveAll(filepath.Dir(path))
+
+		kv.Put("a", []byte("one"))
+		middle := kv.Size()
+		kv.Put("b", []byte("two"))
+		end := kv.Size()
+		kv.Put("c", []byte("three"))
+		size := kv.Size()
+		kv.Close()
+
+		offset := middle
+		if last {
+			offset = end
+		}
+		f, _ := os.OpenFile(path, os.O_RDWR, 0644)
+		f.WriteAt([]byte("X"), offset+headerSize)
+		f.Close()
+
+		kv, err := OpenLogKV(path)
+		if last {
+			// A bad last record is a torn write and is dropped.
+			assert.NoError(t, err)
+			keys, _ := kv.Keys("")
+			assert.Equal(t, []string{"a", "b"}, keys)
+			assert.Equal(t, end, kv.Size())
+			kv.Close()
+			continue
+		}
+
+		// A bad record followed by good ones isn't, so nothing is dropped.
+		assert.Equal(t, ErrCorruptRecord, err)
+		info, _ := os.Stat(path)
+		assert.Equal(t, size, info.Size())
+	}
+}
+
+func TestKV_CorruptLength(t *testing.T) {
+	kv, path := tempLogKV(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	kv.Put("a", []byte("one"))
+	size := kv.Size()
+	kv.Close()
+
+	// A header claiming more than the file holds isn't read into memory.
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write([]byte{0, 0, 0, 1, 0x7f, 0xff, 0xff, 0xff})
+	f.Close()
+
+	kv, err := OpenLogKV(path)
+	assert.NoError(t, err)
+	defer kv.Close()
+	assert.Equal(t, size, kv.Size())
+}
+
+func TestKV_CorruptLength_Middle(t *testing.T) {
+	kv, path := tempLogKV(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	kv.Put("a", []byte("one"))
+	middle := kv.Size()
+	kv.Put("b", []byte("two"))
+	kv.Put("c", []byte("three"))
+	size := kv.Size()
+	kv.Close()
+
+	// A length running past the end of the log with good records after it
+	// isn't a torn write, so nothing is dropped.
+	f, _ := os.OpenFile(path, os.O_RDWR, 0644)
+	f.WriteAt([]byte{0, 0, 0x10, 0}, middle+4)
+	f.Close()
+
+	_, err := OpenLogKV(path)
+	assert.Equal(t, ErrCorruptRecord, err)
+	info, _ := os.Stat(path)
+	assert.Equal(t, size, info.Size())
+}
+
+func TestKV_AutoCompact(t *testing.T) {
+	compactSize := CompactSize
+	CompactSize = 100
+	defer func() { CompactSize = compactSize }()
+
+	kv, path := tempLogKV(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	defer kv.Close()
+
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, kv.Put("a", []byte("value")))
+		assert.NoError(t, kv.Put("b", []byte("value")))
+		assert.NoError(t, kv.Delete("b"))
+	}
+	// The log never holds much more than CompactSize dead bytes.
+	assert.True(t, kv.Size() < 2*CompactSize)
+	keys, _ := kv.Keys("")
+	assert.Equal(t, []string{"a"}, keys)
+
+	assert.NoError(t, kv.Close())
+	kv, err := OpenLogKV(path)
+	assert.NoError(t, err)
+	defer kv.Close()
+	value, err := kv.Get("a")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", string(value))
+}
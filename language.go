@@ -0,0 +1,220 @@
+package miru
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrUnknownLanguage for when a configured language isn't supported.
+var ErrUnknownLanguage = errors.New("Language is not supported.")
+
+// Language describes how text written in a language is analysed.
+type Language struct {
+	Code      string
+	StopWords string
+	Stemmer   Stemmer
+}
+
+// Languages holds every supported language by its ISO 639-1 code.
+var Languages = map[string]Language{
+	"en": {Code: "en", StopWords: "english", Stemmer: PorterStemmer{}},
+	"fr": {Code: "fr", StopWords: "french", Stemmer: FrenchStemmer{}},
+	"de": {Code: "de", StopWords: "german", Stemmer: GermanStemmer{}},
+	"es": {Code: "es", StopWords: "spanish", Stemmer: SpanishStemmer{}},
+}
+
+// DefaultLanguage is used when a language can't be detected.
+var DefaultLanguage = "en"
+
+var frenchStopWords = stopList(`
+	a ai aie aient aies ait alors as au aucun aura aurai auraient aurais aurait
+	aux avaient avais avait avec avez aviez avions avoir avons ayant c ce ceci
+	cela celle celles celui ces cet cette ceux chaque comme d dans de des du
+	elle elles en encore est et etaient etais etait ete etes etre eu eux fait
+	il ils j je l la le les leur leurs lui m ma mais me meme mes moi mon n ne
+	ni nos notre nous on ont ou par pas pour qu que quel quelle quelles quels
+	qui s sa sans se ses si son sont sous sur t ta te tes toi ton tous tout
+	tres tu un une vos votre vous y
+`)
+
+var germanStopWords = stopList(`
+	aber alle allem allen aller alles als also am an ander andere anderen auch
+	auf aus bei bin bis bist da damit dann das dass dein deine dem den der des
+	dich die dies diese diesem diesen dieser dieses dir doch dort du durch ein
+	eine einem einen einer eines er es etwas euch euer eure fur hab habe haben
+	hat hatte hatten hier hin ich ihm ihn ihnen ihr ihre im in ist ja jede
+	jedem jeden jeder jedes kann kein keine man manche mein meine mich mir mit
+	muss nach nicht nichts noch nun nur ob oder ohne sehr sein seine sich sie
+	sind so solche soll sondern sonst uber um und uns unser unter viel vom von
+	vor war waren warst was weil welche wenn wer werde werden wie wieder will
+	wir wird wo zu zum zur zwar zwischen
+`)
+
+var spanishStopWords = stopList(`
+	a al algo algunas algunos ante antes como con contra cual cuando de del
+	desde donde durante e el ella ellas ellos en entre era erais eran eras eres
+	es esa esas ese eso esos esta estaba estado estan estar este esto estos fue
+	fueron fui ha habia han has hasta hay la las le les lo los mas me mi mis
+	mucho muchos muy nada ni no nos nosotros o os otra otras otro otros para
+	pero poco por porque que quien se sea ser si sido siempre sin sobre son su
+	sus tambien tanto te tiene tienen todo todos tu tus un una uno unos usted
+	vosotros y ya yo
+`)
+
+func init() {
+	StopWords["french"] = frenchStopWords
+	StopWords["german"] = germanStopWords
+	StopWords["spanish"] = spanishStopWords
+}
+
+// stopList builds a stop word list from whitespace separated words.
+func stopList(words string) map[string]bool {
+	list := map[string]bool{}
+	for _, word := range strings.Fields(words) {
+		list[word] = true
+	}
+	return list
+}
+
+// elisions are French articles and pronouns that get joined to the following
+// word with an apostrophe, e.g. "l'homme".
+var elisions = []string{
+	"l", "d", "j", "m", "n", "s", "t", "c", "qu", "jusqu", "lorsqu", "puisqu",
+}
+
+// ElisionFilter removes an elided article from the front of a word.
+type ElisionFilter struct{}
+
+// Filter strips elisions, the word is expected to be folded already.
+func (ElisionFilter) Filter(word string) string {
+	i := strings.Index(word, "'")
+	if i < 0 {
+		return word
+	}
+	for _, elision := range elisions {
+		if word[:i] == elision {
+			return word[i+1:]
+		}
+	}
+	return word
+}
+
+// NewLanguageAnalyzer creates the standard pipeline for a language using the
+// given stop words.
+func NewLanguageAnalyzer(code string, stop map[string]bool) *Pipeline {
+	p := NewAnalyzer(stop)
+
+	lang, ok := Languages[code]
+	if !ok {
+		return p
+	}
+	p.Stemmer = lang.Stemmer
+
+	if code == "fr" {
+		p.Filters = []TokenFilter{
+			LowercaseFilter{},
+			FoldFilter{},
+			ElisionFilter{},
+			StopFilter{Words: stop},
+		}
+	}
+	return p
+}
+
+// DefaultAnalyzers creates an analyzer for every supported language using the
+// built in stop word lists.
+func DefaultAnalyzers() map[string]Analyzer {
+	analyzers := map[string]Analyzer{}
+	for code, lang := range Languages {
+		analyzers[code] = NewLanguageAnalyzer(code, StopWords[lang.StopWords])
+	}
+	return analyzers
+}
+
+// LoadAnalyzers creates an analyzer for each configured language, every
+// supported language is used if none are configured. The configured stop words
+// replace the built in list of the default language.
+func LoadAnalyzers(conf *Config) (map[string]Analyzer, error) {
+	codes := conf.Analysis.Languages
+	if len(codes) == 0 {
+		for code := range Languages {
+			codes = append(codes, code)
+		}
+	}
+
+	analyzers := map[string]Analyzer{}
+	for _, code := range codes {
+		lang, ok := Languages[code]
+		if !ok {
+			return nil, ErrUnknownLanguage
+		}
+
+		name, file := lang.StopWords, ""
+		if code == conf.Analysis.DefaultLanguage {
+			if conf.Analysis.StopWords != "" {
+				name = conf.Analysis.StopWords
+			}
+			file = conf.Analysis.StopWordsFile
+		}
+
+		stop, err := LoadStopWords(name, file)
+		if err != nil {
+			return nil, err
+		}
+		analyzers[code] = NewLanguageAnalyzer(code, stop)
+	}
+
+	if _, ok := analyzers[conf.Analysis.DefaultLanguage]; !ok {
+		return nil, ErrUnknownLanguage
+	}
+	return analyzers, nil
+}
+
+// languageHints are letters that are only common in a single language.
+var languageHints = map[rune]string{
+	'ß': "de", 'ä': "de", 'ö': "de", 'ü': "de",
+	'ñ': "es", '¿': "es", '¡': "es", 'á': "es", 'í': "es", 'ó': "es", 'ú': "es",
+	'ç': "fr", 'è': "fr", 'ê': "fr", 'à': "fr", 'œ': "fr", 'ù': "fr", 'â': "fr",
+}
+
+// DetectLanguage guesses the language of some text by counting stop words and
+// language specific letters, an empty string is returned when nothing stands
+// out.
+func DetectLanguage(text string) string {
+	scores := map[string]int{}
+
+	for _, r := range strings.ToLower(text) {
+		if code, ok := languageHints[r]; ok {
+			scores[code]++
+		}
+	}
+
+	fold := FoldFilter{}
+	elide := ElisionFilter{}
+	for _, word := range (UnicodeTokenizer{}).Tokenize(text) {
+		word = fold.Filter(strings.ToLower(word))
+		if elide.Filter(word) != word {
+			scores["fr"] += 2
+			continue
+		}
+		for code, lang := range Languages {
+			if StopWords[lang.StopWords][word] {
+				scores[code] += 2
+			}
+		}
+	}
+
+	best, bestScore, tied := "", 0, false
+	for code, score := range scores {
+		switch {
+		case score > bestScore:
+			best, bestScore, tied = code, score, false
+		case score == bestScore:
+			tied = true
+		}
+	}
+	if tied {
+		return ""
+	}
+	return best
+}
@@ -0,0 +1,74 @@
+package miru
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLanguage_DetectLanguage(t *testing.T) {
+	tests := []struct {
+		Input  string
+		Output string
+	}{
+		{"The dog is in the garden and sleeps", "en"},
+		{"Le chat est sur la table et il dort", "fr"},
+		{"L'homme", "fr"},
+		{"Der Hund ist im Garten und schläft", "de"},
+		{"El perro está en el jardín y duerme", "es"},
+		{"mañana", "es"},
+		{"chat", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.Output, DetectLanguage(test.Input))
+	}
+}
+
+func TestLanguage_ElisionFilter(t *testing.T) {
+	f := ElisionFilter{}
+
+	assert.Equal(t, "homme", f.Filter("l'homme"))
+	assert.Equal(t, "il", f.Filter("qu'il"))
+	assert.Equal(t, "don't", f.Filter("don't"))
+	assert.Equal(t, "maison", f.Filter("maison"))
+}
+
+func TestLanguage_NewLanguageAnalyzer(t *testing.T) {
+	fr := NewLanguageAnalyzer("fr", StopWords["french"])
+	assert.Equal(t, []string{"homm", "maison"}, Terms(fr.Analyze("L'homme et les maisons")))
+
+	de := NewLanguageAnalyzer("de", StopWords["german"])
+	assert.Equal(t, []string{"haus", "kind"}, Terms(de.Analyze("Die Häuser der Kinder")))
+
+	// Unknown languages get the standard English pipeline.
+	xx := NewLanguageAnalyzer("xx", stopWords)
+	assert.Equal(t, []string{"hous"}, Terms(xx.Analyze("the houses")))
+}
+
+func TestLanguage_LoadAnalyzers(t *testing.T) {
+	conf, err := LoadConfig(DefaultConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	analyzers, err := LoadAnalyzers(conf)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(analyzers))
+
+	conf.Analysis.Languages = []string{"fr"}
+	_, err = LoadAnalyzers(conf)
+	assert.Equal(t, ErrUnknownLanguage, err)
+
+	conf.Analysis.DefaultLanguage = "fr"
+	conf.Analysis.StopWords = "none"
+	analyzers, err = LoadAnalyzers(conf)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(analyzers))
+	assert.Equal(t, []string{"le", "chat"}, Terms(analyzers["fr"].Analyze("le chat")))
+
+	conf.Analysis.Languages = []string{"xx"}
+	_, err = LoadAnalyzers(conf)
+	assert.Equal(t, ErrUnknownLanguage, err)
+}
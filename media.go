@@ -0,0 +1,301 @@
+package miru
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/satori/go.uuid"
+)
+
+// MediaImage is the type of media found in img tags, and the search type that
+// looks up images.
+const MediaImage = "image"
+
+// contextLength is the most characters of surrounding text kept for an image.
+const contextLength = 300
+
+// mediaWeights weights a query word found in each part of an image's text.
+var mediaWeights = map[string]float64{
+	"alt":     3,
+	"title":   2,
+	"caption": 2,
+	"context": 1,
+}
+
+// Media is an image found on a page, indexed by its alt and title text, the
+// caption of the figure it's in and the text around it. Words holds the
+// analysed terms of that text and Counts how often each occurs in each part of
+// it, keyed by the names in mediaWeights.
+type Media struct {
+	MediaID string                    `gorethink:"id" json:"media_id"`
+	DocID   string                    `gorethink:"doc_id" json:"doc_id"`
+	Type    string                    `gorethink:"type" json:"type"`
+	URL     string                    `gorethink:"url" json:"url"`
+	Page    string                    `gorethink:"page" json:"page"`
+	Site    string                    `gorethink:"site" json:"site"`
+	Alt     string                    `gorethink:"alt" json:"alt,omitempty"`
+	Title   string                    `gorethink:"title" json:"title,omitempty"`
+	Caption string                    `gorethink:"caption" json:"caption,omitempty"`
+	Context string                    `gorethink:"context" json:"context,omitempty"`
+	Width   int                       `gorethink:"width" json:"width,omitempty"`
+	Height  int                       `gorethink:"height" json:"height,omitempty"`
+	Words   []string                  `gorethink:"words" json:"words"`
+	Counts  map[string]map[string]int `gorethink:"counts" json:"counts,omitempty"`
+	Fetched time.Time                 `gorethink:"fetched" json:"fetched"`
+}
+
+// ExtractMedia returns the images of a document's page that have alt text, a
+// title or a caption, which are indexed along with the text around them.
+// Sources are resolved against the page's URL, images repeated on the page are
+// only kept once and images of a pixel or less, such as tracking pixels, are
+// left out.
+func ExtractMedia(doc *goquery.Document, d *Document, a Analyzer) []*Media {
+	media := []*Media{}
+	seen := map[string]bool{}
+	doc.Find("img").Each(func(i int, s *goquery.Selection) {
+		src := imageSource(s)
+		target := LinkTarget(d.Url, src)
+		if src == "" || target == "" || seen[target] {
+			return
+		}
+
+		m := &Media{
+			MediaID: uuid.NewV4().String(),
+			DocID:   d.DocID,
+			Type:    MediaImage,
+			URL:     target,
+			Page:    d.Url,
+			Site:    d.Site,
+			Alt:     attrText(s, "alt"),
+			Title:   attrText(s, "title"),
+			Width:   dimension(s, "width"),
+			Height:  dimension(s, "height"),
+			Fetched: d.Fetched,
+		}
+		if (m.Width > 0 && m.Width <= 1) || (m.Height > 0 && m.Height <= 1) {
+			return
+		}
+
+		container := s.Parent()
+		if figure := s.Closest("figure"); figure.Length() > 0 {
+			m.Caption = normaliseSpace(figure.Find("figcaption").First().Text())
+			container = figure.Parent()
+		}
+		if m.Alt == "" && m.Title == "" && m.Caption == "" {
+			return
+		}
+		m.Context = imageContext(container, m.Caption)
+
+		m.Words = distinctTerms(a.Analyze(strings.Join([]string{m.Alt, m.Title, m.Caption, m.Context}, "\n")))
+		if len(m.Words) == 0 {
+			return
+		}
+		m.Counts = mediaCounts(m, a)
+
+		seen[target] = true
+		media = append(media, m)
+	})
+	return media
+}
+
+// imageSource returns the URL of an image, falling back to the first candidate
+// of its srcset and to the data-src attribute used by lazy loading scripts.
+func imageSource(s *goquery.Selection) string {
+	if src := attrText(s, "src"); src != "" && !strings.HasPrefix(src, "data:") {
+		return src
+	}
+	if srcset := attrText(s, "srcset"); srcset != "" {
+		if fields := strings.Fields(strings.Split(srcset, ",")[0]); len(fields) > 0 {
+			return fields[0]
+		}
+	}
+	return attrText(s, "data-src")
+}
+
+// attrText returns an attribute with its whitespace normalised.
+func attrText(s *goquery.Selection, name string) string {
+	value, _ := s.Attr(name)
+	return normaliseSpace(value)
+}
+
+// dimension reads a width or height attribute in pixels, 0 if it isn't set.
+func dimension(s *goquery.Selection, name string) int {
+	n, err := strconv.Atoi(strings.TrimSuffix(attrText(s, name), "px"))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// imageContext returns the text of the element an image is in, walking up until
+// some text is found but stopping short of sections of the page. The image's
+// caption is left out and the text is cut to contextLength characters at a
+// word boundary.
+func imageContext(s *goquery.Selection, caption string) string {
+	text := ""
+	for depth := 0; depth < 3 && s.Length() > 0 && !s.Is("article, section, main, body, html"); depth++ {
+		text = normaliseSpace(s.Text())
+		if caption != "" {
+			text = normaliseSpace(strings.Replace(text, caption, " ", 1))
+		}
+		if text != "" {
+			break
+		}
+		s = s.Parent()
+	}
+
+	if len(text) > contextLength {
+		text = text[:contextLength]
+		if i := strings.LastIndex(text, " "); i > 0 {
+			text = text[:i]
+		}
+	}
+	return text
+}
+
+// mediaCounts counts the terms in each part of an image's text.
+func mediaCounts(m *Media, a Analyzer) map[string]map[string]int {
+	texts := map[string]string{"alt": m.Alt, "title": m.Title, "caption": m.Caption, "context": m.Context}
+	counts := map[string]map[string]int{}
+	for part, text := range texts {
+		for _, term := range Terms(a.Analyze(text)) {
+			if counts[part] == nil {
+				counts[part] = map[string]int{}
+			}
+			counts[part][term]++
+		}
+	}
+	return counts
+}
+
+// distinctTerms returns the terms of some tokens in order, without repeats.
+func distinctTerms(tokens []Token) []string {
+	terms := []string{}
+	seen := map[string]bool{}
+	for _, term := range Terms(tokens) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// MediaResult is an image matching a search, Score weights the query words
+// found in each part of its text by mediaWeights.
+type MediaResult struct {
+	MediaID string  `json:"media_id"`
+	URL     string  `json:"url"`
+	Width   int     `json:"width,omitempty"`
+	Height  int     `json:"height,omitempty"`
+	Alt     string  `json:"alt,omitempty"`
+	Title   string  `json:"title,omitempty"`
+	Caption string  `json:"caption,omitempty"`
+	Page    string  `json:"page"`
+	Site    string  `json:"site"`
+	DocID   string  `json:"doc_id"`
+	Score   float64 `json:"score"`
+}
+
+type byMediaScore []MediaResult
+
+func (rs byMediaScore) Len() int { return len(rs) }
+
+func (rs byMediaScore) Swap(i, j int) { rs[i], rs[j] = rs[j], rs[i] }
+
+func (rs byMediaScore) Less(i, j int) bool { return rs[i].Score > rs[j].Score }
+
+// MediaResults holds the images matching a search, along with the time taken
+// and the number found.
+type MediaResults struct {
+	Speed    float64       `json:"speed"`
+	Count    int64         `json:"count"`
+	Language string        `json:"language"`
+	Results  []MediaResult `json:"results"`
+}
+
+// Find fills the results with the images matching a query, highest score first.
+// Only the query's site, from and to filters apply to images, from and to
+// being compared with when the page was fetched.
+func (mrs *MediaResults) Find(q *Query, c *Context) error {
+	start := time.Now()
+
+	lang := q.Language
+	if lang == "" {
+		lang = DetectLanguage(q.Text)
+	}
+	mrs.Language = c.Language(lang)
+	mrs.Results = []MediaResult{}
+
+	a := c.AnalyzerFor(mrs.Language)
+	keywords := distinctTerms(a.Analyze(q.Text))
+	if len(keywords) > 0 {
+		media, err := c.Store.LookupMedia(keywords)
+		if err != nil {
+			return err
+		}
+		mrs.Results = rankMedia(media, keywords, a, q)
+	}
+
+	mrs.Speed = time.Since(start).Seconds()
+	mrs.Count = int64(len(mrs.Results))
+	return nil
+}
+
+// rankMedia filters images by a query and scores them on the words of the query
+// in their text, using the counts stored with them. Images stored without
+// counts have their text analysed again.
+func rankMedia(media []*Media, keywords []string, a Analyzer, q *Query) []MediaResult {
+	f := q.Filter
+	results := []MediaResult{}
+	for _, m := range media {
+		switch {
+		case f.Site != "" && m.Site != f.Site,
+			!f.From.IsZero() && m.Fetched.Before(f.From),
+			!f.To.IsZero() && m.Fetched.After(f.To):
+			continue
+		}
+
+		words := map[string]bool{}
+		for _, word := range m.Words {
+			words[word] = true
+		}
+		found := 0
+		for _, word := range keywords {
+			if words[word] {
+				found++
+			}
+		}
+		if found == 0 || (q.MatchAll && found < len(keywords)) {
+			continue
+		}
+
+		r := MediaResult{
+			MediaID: m.MediaID,
+			URL:     m.URL,
+			Width:   m.Width,
+			Height:  m.Height,
+			Alt:     m.Alt,
+			Title:   m.Title,
+			Caption: m.Caption,
+			Page:    m.Page,
+			Site:    m.Site,
+			DocID:   m.DocID,
+		}
+		counts := m.Counts
+		if counts == nil {
+			counts = mediaCounts(m, a)
+		}
+		for part, terms := range counts {
+			for _, word := range keywords {
+				r.Score += mediaWeights[part] * float64(terms[word])
+			}
+		}
+		results = append(results, r)
+	}
+	sort.Stable(byMediaScore(results))
+	return results
+}
@@ -0,0 +1,156 @@
+package miru
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var mediaPage = []byte(`<html><body>
+<article>
+	<p>Heavy rain is expected across the north.
+		<img src="/img/rain.jpg" alt="Rain clouds" width="640" height="480">
+	</p>
+	<figure>
+		<img src="http://cdn.example.com/map.png" title="Weather map">
+		<figcaption>Tomorrow's forecast</figcaption>
+	</figure>
+	<img src="/img/rain.jpg" alt="Rain again">
+	<img src="/pixel.gif" width="1" height="1" alt="Tracking">
+	<img src="data:image/gif;base64,R0lGOD" data-src="/img/lazy.jpg" alt="Lazy sunshine">
+	<div><img src="/img/spacer.gif"></div>
+</article>
+</body></html>`)
+
+func TestMedia_ExtractMedia(t *testing.T) {
+	d := NewDocument("http://example.com/news/", "example.com", "", "")
+	media := ExtractMedia(parseDocument(mediaPage), d, DefaultAnalyzer)
+
+	urls := []string{}
+	for _, m := range media {
+		urls = append(urls, m.URL)
+		assert.Equal(t, MediaImage, m.Type)
+		assert.Equal(t, d.DocID, m.DocID)
+		assert.Equal(t, d.Url, m.Page)
+		assert.Equal(t, "example.com", m.Site)
+	}
+	assert.Equal(t, []string{
+		"http://example.com/img/rain.jpg",
+		"http://cdn.example.com/map.png",
+		"http://example.com/img/lazy.jpg",
+	}, urls)
+
+	rain := media[0]
+	assert.Equal(t, "Rain clouds", rain.Alt)
+	assert.Equal(t, 640, rain.Width)
+	assert.Equal(t, 480, rain.Height)
+	assert.Equal(t, "Heavy rain is expected across the north.", rain.Context)
+	assert.Contains(t, rain.Words, "rain")
+	assert.Contains(t, rain.Words, "cloud")
+
+	weather := media[1]
+	assert.Equal(t, "Weather map", weather.Title)
+	assert.Equal(t, "Tomorrow's forecast", weather.Caption)
+	assert.Equal(t, "", weather.Context)
+	assert.Contains(t, weather.Words, "forecast")
+	assert.Equal(t, 1, weather.Counts["caption"]["forecast"])
+	assert.Equal(t, 0, weather.Counts["alt"]["forecast"])
+}
+
+func TestMedia_RankMedia_Counts(t *testing.T) {
+	stored := &Media{Alt: "Rain", Words: []string{"rain"}, Counts: map[string]map[string]int{
+		"alt":     {"rain": 1},
+		"context": {"rain": 2},
+	}}
+	// Media stored before counts were kept have their text analysed.
+	old := &Media{Alt: "Rain", Context: "rain", Words: []string{"rain"}}
+
+	results := rankMedia([]*Media{stored, old}, []string{"rain"}, DefaultAnalyzer, &Query{})
+	assert.Equal(t, 5.0, results[0].Score)
+	assert.Equal(t, 4.0, results[1].Score)
+}
+
+func TestMedia_ImageContext_Length(t *testing.T) {
+	long := ""
+	for i := 0; i < 100; i++ {
+		long += "words "
+	}
+	doc := parseDocument([]byte(`<p>` + long + `<img src="/a.jpg"></p>`))
+
+	context := imageContext(doc.Find("p"), "")
+	assert.True(t, len(context) <= contextLength)
+	assert.Equal(t, "words", context[len(context)-5:])
+}
+
+func TestMedia_Find(t *testing.T) {
+	ctx := writerContext()
+
+	d := NewDocument("http://example.com/news/", "example.com", "", "")
+	other := NewDocument("http://example.org/", "example.org", "", "")
+	media := ExtractMedia(parseDocument(mediaPage), d, DefaultAnalyzer)
+	media = append(media, ExtractMedia(parseDocument([]byte(`<p>A map of the rain<img src="/rain-map.png" alt="Map"></p>`)), other, DefaultAnalyzer)...)
+	assert.NoError(t, ctx.Store.PutMedia(media))
+
+	res := MediaResults{}
+	assert.NoError(t, res.Find(&Query{Text: "rain"}, ctx))
+	assert.Equal(t, int64(2), res.Count)
+	// Words in alt text count for more than the text around an image.
+	assert.Equal(t, "http://example.com/img/rain.jpg", res.Results[0].URL)
+	assert.Equal(t, "http://example.org/rain-map.png", res.Results[1].URL)
+
+	res = MediaResults{}
+	assert.NoError(t, res.Find(&Query{Text: "rain", Filter: Filter{Site: "example.org"}}, ctx))
+	assert.Equal(t, int64(1), res.Count)
+	assert.Equal(t, "http://example.org/", res.Results[0].Page)
+
+	res = MediaResults{}
+	assert.NoError(t, res.Find(&Query{Text: "rain map"}, ctx))
+	assert.Equal(t, int64(3), res.Count)
+
+	res = MediaResults{}
+	assert.NoError(t, res.Find(&Query{Text: "rain map", MatchAll: true}, ctx))
+	assert.Equal(t, int64(1), res.Count)
+	assert.Equal(t, "http://example.org/rain-map.png", res.Results[0].URL)
+
+	res = MediaResults{}
+	assert.NoError(t, res.Find(&Query{Text: "the"}, ctx))
+	assert.Equal(t, int64(0), res.Count)
+	assert.Equal(t, []MediaResult{}, res.Results)
+}
+
+func TestAPI_SearchHandler_Images(t *testing.T) {
+	defer TearDown(_ctx)
+
+	d := NewDocument("http://example.com/news/", "example.com", "", "")
+	assert.NoError(t, _ctx.Store.PutMedia(ExtractMedia(parseDocument(mediaPage), d, DefaultAnalyzer)))
+
+	r, err := http.NewRequest("GET", "/api/search?q=clouds&type=image", nil)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	w := httptest.NewRecorder()
+	APIRoutes(m, _ctx)
+	m.ServeHTTP(w, r)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"url":"http://example.com/img/rain.jpg","width":640,"height":480`)
+	assert.Contains(t, w.Body.String(), `"page":"http://example.com/news/"`)
+}
+
+func TestCrawler_IndexPage_Media(t *testing.T) {
+	defer TearDown(_ctx)
+
+	ts := Handler(200, mediaPage)
+	defer ts.Close()
+
+	assert.NoError(t, IndexPage(_ctx, NewQueue(), ts.URL+"/", ts.URL[len("http://"):]))
+	assert.NoError(t, _ctx.Writer.Flush())
+
+	media, err := _ctx.Store.LookupMedia([]string{"cloud"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(media))
+	assert.Equal(t, ts.URL+"/img/rain.jpg", media[0].URL)
+}
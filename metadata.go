@@ -0,0 +1,214 @@
+package miru
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// dateLayouts are the formats tried when parsing published and modified dates.
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	time.RFC1123,
+	time.RFC1123Z,
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+}
+
+// Metadata is what a page says about itself in meta tags, its canonical link
+// and JSON-LD. OpenGraph holds every Open Graph and Twitter card property.
+type Metadata struct {
+	Description string
+	Keywords    []string
+	Author      string
+	Image       string
+	Canonical   string
+	Published   time.Time
+	Modified    time.Time
+	OpenGraph   map[string]string
+}
+
+// ExtractMetadata reads a page's metadata, it must be called before script and
+// link tags are stripped. Meta tags take precedence over JSON-LD.
+func ExtractMetadata(doc *goquery.Document) Metadata {
+	meta := metaTags(doc)
+	first := func(names ...string) string {
+		for _, name := range names {
+			if meta[name] != "" {
+				return meta[name]
+			}
+		}
+		return ""
+	}
+
+	m := Metadata{
+		Description: first("description", "og:description", "twitter:description"),
+		Keywords:    splitKeywords(meta["keywords"]),
+		Author:      first("author", "dc.creator", "article:author"),
+		Image:       first("og:image", "twitter:image"),
+		Published:   pageDate(first("article:published_time", "datepublished", "dc.date", "date", "pubdate")),
+		Modified:    pageDate(first("article:modified_time", "og:updated_time", "datemodified", "last-modified")),
+		OpenGraph:   map[string]string{},
+	}
+
+	for name, content := range meta {
+		if strings.HasPrefix(name, "og:") || strings.HasPrefix(name, "twitter:") {
+			m.OpenGraph[name] = content
+		}
+	}
+
+	m.Canonical, _ = doc.Find(`link[rel="canonical"]`).First().Attr("href")
+	m.Canonical = strings.TrimSpace(m.Canonical)
+	if m.Canonical == "" {
+		m.Canonical = meta["og:url"]
+	}
+
+	for _, item := range jsonLD(doc) {
+		m.merge(item)
+	}
+	return m
+}
+
+// metaTags returns the content of each meta tag keyed by its lowercased name,
+// property or itemprop, the first tag with a name wins.
+func metaTags(doc *goquery.Document) map[string]string {
+	meta := map[string]string{}
+	doc.Find("meta").Each(func(i int, s *goquery.Selection) {
+		content, ok := s.Attr("content")
+		content = strings.TrimSpace(content)
+		if !ok || content == "" {
+			return
+		}
+
+		for _, attr := range []string{"name", "property", "itemprop", "http-equiv"} {
+			name, _ := s.Attr(attr)
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if _, seen := meta[name]; !seen {
+				meta[name] = content
+			}
+		}
+	})
+	return meta
+}
+
+// linkedData flattens a JSON-LD value into its objects, following arrays and
+// @graph.
+func linkedData(data interface{}) []map[string]interface{} {
+	items := []map[string]interface{}{}
+	switch v := data.(type) {
+	case []interface{}:
+		for _, item := range v {
+			items = append(items, linkedData(item)...)
+		}
+	case map[string]interface{}:
+		items = append(items, v)
+		if graph, ok := v["@graph"]; ok {
+			items = append(items, linkedData(graph)...)
+		}
+	}
+	return items
+}
+
+// merge fills in any metadata that is still missing from a JSON-LD object.
+func (m *Metadata) merge(item map[string]interface{}) {
+	if m.Description == "" {
+		m.Description = linkedText(item["description"])
+	}
+	if len(m.Keywords) == 0 {
+		switch keywords := item["keywords"].(type) {
+		case string:
+			m.Keywords = splitKeywords(keywords)
+		case []interface{}:
+			for _, keyword := range keywords {
+				if text := linkedText(keyword); text != "" {
+					m.Keywords = append(m.Keywords, text)
+				}
+			}
+		}
+	}
+	if m.Author == "" {
+		m.Author = linkedText(item["author"])
+	}
+	if m.Image == "" {
+		m.Image = linkedText(item["image"])
+	}
+	if m.Published.IsZero() {
+		m.Published = pageDate(linkedText(item["datePublished"]))
+	}
+	if m.Modified.IsZero() {
+		m.Modified = pageDate(linkedText(item["dateModified"]))
+	}
+}
+
+// linkedText returns the text of a JSON-LD value, for an object that is its
+// name or url and for an array its first value.
+func linkedText(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case map[string]interface{}:
+		if name := linkedText(v["name"]); name != "" {
+			return name
+		}
+		return linkedText(v["url"])
+	case []interface{}:
+		for _, item := range v {
+			if text := linkedText(item); text != "" {
+				return text
+			}
+		}
+	}
+	return ""
+}
+
+// splitKeywords splits a comma separated list of keywords.
+func splitKeywords(keywords string) []string {
+	words := []string{}
+	for _, keyword := range strings.Split(keywords, ",") {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			words = append(words, keyword)
+		}
+	}
+	if len(words) == 0 {
+		return nil
+	}
+	return words
+}
+
+// pageDate parses a date in any of dateLayouts, returning the zero time if it
+// can't be parsed.
+func pageDate(value string) time.Time {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// resolveURL resolves ref against base, returning ref unchanged if either can't
+// be parsed.
+func resolveURL(base, ref string) string {
+	if ref == "" {
+		return ""
+	}
+	b, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return b.ResolveReference(r).String()
+}
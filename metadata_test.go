@@ -0,0 +1,97 @@
+package miru
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var metadataHTML = []byte(`
+<!DOCTYPE html>
+<html>
+<head>
+	<title>Storm warning</title>
+	<meta name="keywords" content="weather, storms, , rain">
+	<meta property="og:description" content="Heavy rain expected">
+	<meta property="og:image" content="/images/storm.jpg">
+	<meta name="twitter:card" content="summary">
+	<meta property="article:published_time" content="2015-06-01T09:30:00+01:00">
+	<link rel="canonical" href="/news/storm">
+	<script type="application/ld+json">
+	{
+		"@context": "http://schema.org",
+		"@graph": [{
+			"@type": "NewsArticle",
+			"author": [{"@type": "Person", "name": "Jane Smith"}],
+			"datePublished": "2014-01-01",
+			"dateModified": "2015-06-02"
+		}]
+	}
+	</script>
+</head>
+<body><p>A storm is coming.</p></body>
+</html>`)
+
+func TestMetadata_ExtractMetadata(t *testing.T) {
+	m := ExtractMetadata(parseDocument(metadataHTML))
+
+	assert.Equal(t, "Heavy rain expected", m.Description)
+	assert.Equal(t, []string{"weather", "storms", "rain"}, m.Keywords)
+	assert.Equal(t, "Jane Smith", m.Author)
+	assert.Equal(t, "/images/storm.jpg", m.Image)
+	assert.Equal(t, "/news/storm", m.Canonical)
+	assert.Equal(t, "summary", m.OpenGraph["twitter:card"])
+	assert.Equal(t, 3, len(m.OpenGraph))
+
+	// The meta tag wins over JSON-LD.
+	assert.Equal(t, time.Date(2015, 6, 1, 8, 30, 0, 0, time.UTC), m.Published.UTC())
+	assert.Equal(t, time.Date(2015, 6, 2, 0, 0, 0, 0, time.UTC), m.Modified)
+}
+
+func TestMetadata_ExtractMetadata_Empty(t *testing.T) {
+	m := ExtractMetadata(parseDocument([]byte(`<p>Nothing here</p><script type="application/ld+json">{not json</script>`)))
+
+	assert.Equal(t, "", m.Description)
+	assert.Nil(t, m.Keywords)
+	assert.True(t, m.Published.IsZero())
+	assert.Equal(t, 0, len(m.OpenGraph))
+}
+
+func TestMetadata_pageDate(t *testing.T) {
+	tests := []struct {
+		Input    string
+		Expected time.Time
+	}{
+		{"2015-06-01", time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"2015-06-01T09:30:00Z", time.Date(2015, 6, 1, 9, 30, 0, 0, time.UTC)},
+		{"2015-06-01T09:30:00+0000", time.Date(2015, 6, 1, 9, 30, 0, 0, time.UTC)},
+		{"2015-06-01 09:30:00", time.Date(2015, 6, 1, 9, 30, 0, 0, time.UTC)},
+		{"yesterday", time.Time{}},
+		{"", time.Time{}},
+	}
+
+	for _, test := range tests {
+		assert.True(t, test.Expected.Equal(pageDate(test.Input)), test.Input)
+	}
+}
+
+func TestMetadata_NewDoc(t *testing.T) {
+	doc := parseDocument(metadataHTML)
+	d := NewDoc(doc, "http://example.com/news/storm?ref=home", "example.com", ExtractMainContent)
+
+	assert.Equal(t, "http://example.com/news/storm", d.Canonical)
+	assert.Equal(t, "http://example.com/images/storm.jpg", d.Image)
+	assert.Equal(t, "Jane Smith", d.Author)
+	assert.Equal(t, "A storm is coming.", d.Content)
+
+	// Scripts are still stripped once the metadata has been read.
+	assert.Equal(t, 0, doc.Find("script, link").Length())
+
+	fields := map[string]bool{}
+	for _, i := range IndexDocument(DefaultAnalyzer, d) {
+		fields[i.Field+":"+i.Text] = true
+	}
+	assert.True(t, fields[FieldKeywords+":storms"])
+	assert.True(t, fields[FieldAuthor+":smith"])
+}
@@ -1,21 +1,21 @@
 package miru
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"net/http/httptest"
-	"os"
+	"time"
 
 	"github.com/gorilla/mux"
-
-	rdb "github.com/dancannon/gorethink"
 )
 
+// testToken is the API token the test context is configured with.
+const testToken = "secret"
+
 var (
 	_ctx *Context
 
-	_db, _index, _document string
-
 	m = mux.NewRouter().StrictSlash(true)
 )
 
@@ -26,21 +26,13 @@ func init() {
 		log.Fatalln(err.Error())
 	}
 
-	_db = "miru_test"
-	_index = ctx.Config.Tables.Index
-	_document = ctx.Config.Tables.Document
-
-	ctx.Config.Database.Name = _db
-	ctx.Config.Tables.Index = _index
-	ctx.Config.Tables.Document = _document
-
-	if err := ctx.Connect(os.Getenv("RETHINKDB_URL")); err != nil {
+	ctx.Config.Database.Driver = DriverMemory
+	ctx.Config.Api.Token = testToken
+	if err := ctx.Open(); err != nil {
 		log.Fatalln(err.Error())
 	}
 
 	_ctx = ctx
-
-	SetUp(_ctx)
 }
 
 func Handler(status int, data []byte) *httptest.Server {
@@ -51,23 +43,36 @@ func Handler(status int, data []byte) *httptest.Server {
 		}))
 }
 
-func SetUp(c *Context) {
-	rdb.DbCreate(_db).Exec(c.Db)
-	rdb.Db(_db).TableCreate(_document).Exec(c.Db)
-	rdb.Db(_db).TableCreate(_index).Exec(c.Db)
-	rdb.Db(_db).Table(_index).IndexCreate("word").Exec(c.Db)
+// TearDown replaces the store along with everything built from it.
+func TearDown(c *Context) {
+	c.Writer.Close()
+	c.Writer = NewIndexWriter(c)
+	c.Store = NewMemoryStore()
+	c.Vocabulary = NewVocabulary()
+	c.Completer = NewCompleter()
+	c.Authority = NewAuthority()
+	c.Stats = NewStatsCache(time.Minute)
 }
 
-func TearDown(c *Context) {
-	// Clear 'Document' table
-	rdb.Db(_db).Table(_document).Delete(rdb.DeleteOpts{
-		Durability:    "soft",
-		ReturnChanges: false,
-	}).Exec(c.Db)
+// brokenStore fails every read.
+type brokenStore struct {
+	Store
+}
+
+func (s brokenStore) Lookup(words []string, f *Filter) ([]Result, error) {
+	return nil, errors.New("Lookup failed.")
+}
+
+func (s brokenStore) Sites() ([]string, error) {
+	return nil, errors.New("Sites failed.")
+}
 
-	// Clear 'Index' table
-	rdb.Db(_db).Table(_index).Delete(rdb.DeleteOpts{
-		Durability:    "soft",
-		ReturnChanges: false,
-	}).Exec(c.Db)
+// storedIndexes returns every index in the context's store.
+func storedIndexes(c *Context) Indexes {
+	ixs := Indexes{}
+	c.Store.EachIndex(func(i *Index) error {
+		ixs = append(ixs, i)
+		return nil
+	})
+	return ixs
 }
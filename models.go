@@ -1,19 +1,36 @@
 package miru
 
 import (
-	"errors"
+	"time"
 
-	rdb "github.com/dancannon/gorethink"
 	"github.com/satori/go.uuid"
 )
 
-// Document stores data about a page.
+// Document stores data about a page. Keywords, Author, Image, Canonical,
+// Published, Modified and OpenGraph are read from the page's metadata, Entities
+// from its structured data. Published and Modified are nil when the page
+// doesn't give them.
 type Document struct {
-	DocID   string `gorethink:"id" json:"document_id"`
-	Url     string `gorethink:"url" json:"url"`
-	Site    string `gorethink:"site" json:"site"`
-	Title   string `gorethink:"title" json:"title"`
-	Content string `gorethink:"content" json:"content"`
+	DocID       string    `gorethink:"id" json:"document_id"`
+	Url         string    `gorethink:"url" json:"url"`
+	Site        string    `gorethink:"site" json:"site"`
+	Title       string    `gorethink:"title" json:"title"`
+	Headings    string    `gorethink:"headings" json:"headings"`
+	Description string    `gorethink:"description" json:"description"`
+	Content     string    `gorethink:"content" json:"content"`
+	Language    string    `gorethink:"language" json:"language"`
+	ContentType string    `gorethink:"content_type" json:"content_type"`
+	Status      int       `gorethink:"status" json:"status"`
+	Fetched     time.Time `gorethink:"fetched" json:"fetched"`
+
+	Keywords  []string          `gorethink:"keywords" json:"keywords,omitempty"`
+	Author    string            `gorethink:"author" json:"author,omitempty"`
+	Image     string            `gorethink:"image" json:"image,omitempty"`
+	Canonical string            `gorethink:"canonical" json:"canonical,omitempty"`
+	Published *time.Time        `gorethink:"published,omitempty" json:"published,omitempty"`
+	Modified  *time.Time        `gorethink:"modified,omitempty" json:"modified,omitempty"`
+	OpenGraph map[string]string `gorethink:"open_graph" json:"open_graph,omitempty"`
+	Entities  []Entity          `gorethink:"entities" json:"entities,omitempty"`
 }
 
 // NewDocument creates a new document instance
@@ -24,48 +41,124 @@ func NewDocument(url, site, title, content string) *Document {
 	doc.Site = site
 	doc.Title = title
 	doc.Content = content
+	doc.Fetched = time.Now()
 
 	return doc
 }
 
+// SetMetadata copies a page's metadata onto the document, relative image and
+// canonical links are resolved against the document's URL.
+func (d *Document) SetMetadata(m Metadata) {
+	d.Description = m.Description
+	d.Keywords = m.Keywords
+	d.Author = m.Author
+	d.Image = resolveURL(d.Url, m.Image)
+	d.Canonical = resolveURL(d.Url, m.Canonical)
+	d.Published = datePointer(m.Published)
+	d.Modified = datePointer(m.Modified)
+	if len(m.OpenGraph) > 0 {
+		d.OpenGraph = m.OpenGraph
+	}
+}
+
+// datePointer returns nil for the zero time, or else a pointer to t.
+func datePointer(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
 // Put writes a document to the datastore.
 func (d *Document) Put(c *Context) error {
-	res, _ := rdb.Db(c.Config.Database.Name).Table(
-		c.Config.Tables.Document).Insert(d).RunWrite(c.Db)
-
-	if res.Errors > 0 {
-		return errors.New(res.FirstError)
+	if err := c.Store.PutDocument(d); err != nil {
+		return err
 	}
+	c.Completer.AddTitle(d.Title)
 	return nil
 }
 
+// DocumentInfo is a document's stored metadata without its text.
+type DocumentInfo struct {
+	DocID         string     `json:"document_id"`
+	Url           string     `json:"url"`
+	Site          string     `json:"site"`
+	Title         string     `json:"title"`
+	Language      string     `json:"language"`
+	ContentType   string     `json:"content_type"`
+	ContentLength int        `json:"content_length"`
+	Status        int        `json:"status"`
+	Fetched       time.Time  `json:"fetched"`
+	Description   string     `json:"description"`
+	Author        string     `json:"author,omitempty"`
+	Canonical     string     `json:"canonical,omitempty"`
+	Published     *time.Time `json:"published,omitempty"`
+	Modified      *time.Time `json:"modified,omitempty"`
+	Entities      []Entity   `json:"entities,omitempty"`
+}
+
+// Info returns the metadata of a document.
+func (d *Document) Info() DocumentInfo {
+	return DocumentInfo{
+		DocID:         d.DocID,
+		Url:           d.Url,
+		Site:          d.Site,
+		Title:         d.Title,
+		Language:      d.Language,
+		ContentType:   d.ContentType,
+		ContentLength: len(d.Content),
+		Status:        d.Status,
+		Fetched:       d.Fetched,
+		Description:   d.Description,
+		Author:        d.Author,
+		Canonical:     d.Canonical,
+		Published:     d.Published,
+		Modified:      d.Modified,
+		Entities:      d.Entities,
+	}
+}
+
 // Index stores data on a given word in a document.
 type Index struct {
 	IndexID string `gorethink:"id" json:"index_id"`
 	DocID   string `gorethink:"doc_id" json:"document_id"`
+	Field   string `gorethink:"field" json:"field"`
 	Word    string `gorethink:"word" json:"word"`
+	Text    string `gorethink:"text" json:"text"`
 	Count   int64  `gorethink:"count" json:"count"`
 }
 
-// NewIndex creates a new index instance
+// NewIndex creates a new index instance, the word is assumed to come from the
+// document's content.
 func NewIndex(docID, word string, count int64) *Index {
+	return NewFieldIndex(docID, FieldContent, word, count)
+}
+
+// NewFieldIndex creates an index of a word in one field of a document. Its ID
+// is made from all three, so a document has one index for each field and word
+// whichever store it is written to.
+func NewFieldIndex(docID, field, word string, count int64) *Index {
 	index := new(Index)
-	index.IndexID = uuid.NewV4().String()
+	index.IndexID = indexID(docID, field, word)
 	index.DocID = docID
+	index.Field = field
 	index.Word = word
 	index.Count = count
 
 	return index
 }
 
+func indexID(docID, field, word string) string {
+	return docID + ":" + field + ":" + word
+}
+
 // Put writes an index to the datastore
 func (i *Index) Put(c *Context) error {
-	res, _ := rdb.Db(c.Config.Database.Name).Table(
-		c.Config.Tables.Index).Insert(i).RunWrite(c.Db)
-
-	if res.Errors > 0 {
-		return errors.New(res.FirstError)
+	if err := c.Store.PutIndexes(Indexes{i}); err != nil {
+		return err
 	}
+	c.Vocabulary.Add(i.Text, i.Count)
+	c.Completer.AddIndexes(Indexes{i})
 	return nil
 }
 
@@ -74,11 +167,10 @@ type Indexes []*Index
 
 // Put writes a slice of index to the datastore.
 func (ixs *Indexes) Put(c *Context) error {
-	res, _ := rdb.Db(c.Config.Database.Name).Table(
-		c.Config.Tables.Index).Insert(ixs).RunWrite(c.Db)
-
-	if res.Errors > 0 {
-		return errors.New(res.FirstError)
+	if err := c.Store.PutIndexes(*ixs); err != nil {
+		return err
 	}
+	c.Vocabulary.AddIndexes(*ixs)
+	c.Completer.AddIndexes(*ixs)
 	return nil
 }
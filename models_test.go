@@ -1,9 +1,10 @@
 package miru
 
 import (
+	"encoding/json"
 	"testing"
+	"time"
 
-	rdb "github.com/dancannon/gorethink"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -34,13 +35,8 @@ func TestModels_DocumentPut(t *testing.T) {
 	err := doc.Put(_ctx)
 	assert.NoError(t, err)
 
-	res, err := rdb.Db(_db).Table(_document).Get(doc.DocID).Run(_ctx.Db)
+	d, err := _ctx.Store.Document(doc.DocID)
 	assert.NoError(t, err)
-
-	var d Document
-	err = res.One(&d)
-	assert.NoError(t, err)
-
 	assert.NotEqual(t, d.DocID, "")
 }
 
@@ -82,14 +78,9 @@ func TestModels_IndexPut(t *testing.T) {
 	err := index.Put(_ctx)
 	assert.NoError(t, err)
 
-	res, err := rdb.Db(_db).Table(_index).Get(index.IndexID).Run(_ctx.Db)
-	assert.NoError(t, err)
-
-	var i Index
-	err = res.One(&i)
-	assert.NoError(t, err)
-
-	assert.Equal(t, i.Word, "make")
+	ixs := storedIndexes(_ctx)
+	assert.Equal(t, len(ixs), 1)
+	assert.Equal(t, ixs[0].Word, "make")
 }
 
 func TestModels_IndexPut_Duplicate(t *testing.T) {
@@ -137,3 +128,29 @@ func TestModels_IndexesPut_Duplicate(t *testing.T) {
 	err := indexes.Put(_ctx)
 	assert.Error(t, err)
 }
+
+func TestModels_DocumentInfo(t *testing.T) {
+	doc := NewDocument("http://example.com/", "example.com", "Example", "Some content")
+	doc.Status = 200
+
+	info := doc.Info()
+	assert.Equal(t, doc.DocID, info.DocID)
+	assert.Equal(t, "Example", info.Title)
+	assert.Equal(t, 12, info.ContentLength)
+	assert.Equal(t, 200, info.Status)
+}
+
+func TestModels_DocumentSetMetadata_Dates(t *testing.T) {
+	doc := NewDocument("http://example.com/", "example.com", "Example", "Some content")
+	doc.SetMetadata(Metadata{Published: time.Date(2015, 6, 1, 10, 0, 0, 0, time.UTC)})
+	if assert.NotNil(t, doc.Published) {
+		assert.Equal(t, time.Date(2015, 6, 1, 10, 0, 0, 0, time.UTC), *doc.Published)
+	}
+	assert.Nil(t, doc.Modified)
+
+	// Dates the page doesn't give are left out.
+	data, err := json.Marshal(doc.Info())
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"published":"2015-06-01T10:00:00Z"`)
+	assert.NotContains(t, string(data), `"modified"`)
+}
@@ -19,6 +19,32 @@ func ExtractTitle(doc *goquery.Document) string {
 	return ""
 }
 
+// ExtractHeadings returns the text of every heading (h1 through h6) in a page.
+func ExtractHeadings(doc *goquery.Document) string {
+	headings := []string{}
+	doc.Find("h1, h2, h3, h4, h5, h6").Each(func(i int, s *goquery.Selection) {
+		text := strings.TrimSpace(s.Text())
+		if text != "" {
+			headings = append(headings, text)
+		}
+	})
+	return strings.Join(headings, "\n")
+}
+
+// ExtractLanguage returns the language declared by a page's html tag if it is a
+// supported language, "en-GB" and "en" are both treated as "en".
+func ExtractLanguage(doc *goquery.Document) string {
+	lang, _ := doc.Find("html").First().Attr("lang")
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if i := strings.IndexAny(lang, "-_"); i >= 0 {
+		lang = lang[:i]
+	}
+	if _, ok := Languages[lang]; !ok {
+		return ""
+	}
+	return lang
+}
+
 // ExtractText returns all p tags in a page
 func ExtractText(doc *goquery.Document) string {
 	texts := []string{}
@@ -71,6 +71,43 @@ func TestParser_ExtractTitleEmpty(t *testing.T) {
 	assert.Equal(t, "", title)
 }
 
+func TestParser_ExtractHeadings(t *testing.T) {
+	html := []byte(`
+<!DOCTYPE html>
+<html>
+<head></head>
+<body>
+	<h1>Main heading</h1>
+	<p>Some text</p>
+	<h3> Sub heading </h3>
+	<h2></h2>
+</body>
+</html>`)
+
+	doc := newDocument(html)
+
+	headings := ExtractHeadings(doc)
+
+	assert.Equal(t, "Main heading\nSub heading", headings)
+}
+
+func TestParser_ExtractLanguage(t *testing.T) {
+	tests := []struct {
+		Input  string
+		Output string
+	}{
+		{`<html lang="fr"><body></body></html>`, "fr"},
+		{`<html lang="en-GB"><body></body></html>`, "en"},
+		{`<html lang="ja"><body></body></html>`, ""},
+		{`<html><body></body></html>`, ""},
+	}
+
+	for _, test := range tests {
+		doc := newDocument([]byte(test.Input))
+		assert.Equal(t, test.Output, ExtractLanguage(doc))
+	}
+}
+
 func TestParser_ExtractTextEmpty(t *testing.T) {
 	doc := newDocument([]byte(""))
 	text := ExtractText(doc)
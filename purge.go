@@ -0,0 +1,91 @@
+package miru
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Purge deletes every document that match returns true for along with its
+// indexes, their titles and terms are removed from the completer and their
+// words from the vocabulary. Buffered pages are written first so they can be
+// purged too. The number of deleted documents is returned.
+func Purge(c *Context, match func(d *Document) bool) (int, error) {
+	c.Writer.Flush()
+
+	docs := []*Document{}
+	if err := c.Store.EachDocument(func(d *Document) error {
+		if match(d) {
+			docs = append(docs, d)
+		}
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+
+	for n, d := range docs {
+		if err := deleteDocument(c, d); err != nil {
+			return n, err
+		}
+	}
+	return len(docs), nil
+}
+
+// deleteDocument deletes a stored document and forgets its title, terms and
+// words.
+func deleteDocument(c *Context, d *Document) error {
+	ixs, err := c.Store.DocumentIndexes(d.DocID)
+	if err != nil {
+		return err
+	}
+	if err := c.Store.DeleteDocument(d.DocID); err != nil {
+		return err
+	}
+
+	c.Completer.RemoveTitle(d.Title)
+	c.Completer.RemoveIndexes(ixs)
+	c.Vocabulary.RemoveIndexes(ixs)
+	return nil
+}
+
+// DeleteDocument deletes a single document and its indexes, returning
+// ErrNotFound if it doesn't exist.
+func DeleteDocument(c *Context, id string) error {
+	c.Writer.Flush()
+
+	d, err := c.Store.Document(id)
+	if err != nil {
+		return err
+	}
+	return deleteDocument(c, d)
+}
+
+// DeleteSite stops any crawl of a site, stops polling its feeds and deletes all
+// of its documents. Pages of the crawl that were already being indexed are
+// waited for, so they are deleted rather than added afterwards.
+func DeleteSite(c *Context, site string) (int, error) {
+	q, crawling := c.Queues.Get(site)
+	c.Queues.Remove(site)
+	if crawling {
+		q.Wait()
+	}
+	if err := c.Feeds.Remove(c, site); err != nil {
+		return 0, err
+	}
+	return Purge(c, func(d *Document) bool {
+		return d.Site == site
+	})
+}
+
+// URLPattern compiles a pattern where '*' matches any run of characters into a
+// function matching document URLs, the whole URL has to match.
+func URLPattern(pattern string) func(d *Document) bool {
+	parts := strings.Split(pattern, "*")
+	for n, part := range parts {
+		parts[n] = regexp.QuoteMeta(part)
+	}
+	re := regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+
+	return func(d *Document) bool {
+		return re.MatchString(d.Url)
+	}
+}
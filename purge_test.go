@@ -0,0 +1,155 @@
+package miru
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func putPages(c *Context, urls ...string) []*Document {
+	docs := []*Document{}
+	for _, url := range urls {
+		site, _ := RootURL(url)
+		d := NewDocument(url, site, "Page "+url, "world news")
+		c.Writer.Add(d, IndexDocument(c.Analyzer, d), nil)
+		docs = append(docs, d)
+	}
+	return docs
+}
+
+func TestPurge_URLPattern(t *testing.T) {
+	tests := []struct {
+		Pattern string
+		Url     string
+		Match   bool
+	}{
+		{"http://example.com/news/*", "http://example.com/news/1", true},
+		{"http://example.com/news/*", "http://example.com/about", false},
+		{"*.pdf", "http://example.com/a/b.pdf", true},
+		{"*.pdf", "http://example.com/a/bxpdf", false},
+		{"http://example.com/", "http://example.com/", true},
+		{"http://example.com/", "http://example.com/about", false},
+	}
+
+	for _, test := range tests {
+		d := &Document{Url: test.Url}
+		assert.Equal(t, test.Match, URLPattern(test.Pattern)(d), test.Pattern)
+	}
+}
+
+func TestPurge_Purge(t *testing.T) {
+	defer TearDown(_ctx)
+
+	putPages(_ctx,
+		"http://example.com/news/1",
+		"http://example.com/news/2",
+		"http://example.com/about",
+	)
+
+	deleted, err := Purge(_ctx, URLPattern("http://example.com/news/*"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	res := new(Results)
+	res.Search("world", _ctx)
+	assert.Equal(t, int64(1), res.Count)
+	assert.Equal(t, "http://example.com/about", res.Results[0].Url)
+
+	assert.Equal(t, 0, len(_ctx.Completer.Complete("page http://example.com/news", 10)))
+}
+
+func TestPurge_DeleteDocument(t *testing.T) {
+	defer TearDown(_ctx)
+
+	docs := putPages(_ctx, "http://example.com/")
+
+	assert.NoError(t, DeleteDocument(_ctx, docs[0].DocID))
+	assert.Equal(t, ErrNotFound, DeleteDocument(_ctx, docs[0].DocID))
+	assert.Equal(t, 0, len(storedIndexes(_ctx)))
+}
+
+func TestPurge_DeleteSite(t *testing.T) {
+	defer TearDown(_ctx)
+
+	q := NewQueue()
+	q.Name = "example.com"
+	q.Enqueue("http://example.com/next")
+	_ctx.Queues.Add(q)
+
+	putPages(_ctx, "http://example.com/", "http://example.com/about", "http://example.org/")
+
+	deleted, err := DeleteSite(_ctx, "example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	sites, _ := _ctx.Store.Sites()
+	assert.Equal(t, []string{"example.org"}, sites)
+
+	_, ok := _ctx.Queues.Queues["example.com"]
+	assert.False(t, ok)
+	assert.Equal(t, "stopped", q.Status)
+	assert.Equal(t, 0, q.Len())
+}
+
+func TestPurge_Purge_Terms(t *testing.T) {
+	defer TearDown(_ctx)
+
+	docs := putPages(_ctx, "http://example.com/zebras", "http://example.com/about")
+	_ctx.Writer.Flush()
+	ixs, err := _ctx.Store.DocumentIndexes(docs[0].DocID)
+	assert.NoError(t, err)
+	assert.NotEqual(t, 0, len(ixs))
+
+	var zebra *Index
+	for _, i := range ixs {
+		if i.Field == FieldURL && i.Word == Normalise("zebras") {
+			zebra = i
+		}
+	}
+	if !assert.NotNil(t, zebra) {
+		return
+	}
+	assert.NotEqual(t, int64(0), _ctx.Vocabulary.Frequency(zebra.Text))
+	world := _ctx.Completer.Complete("world", 1)[0].Documents
+
+	_, err = Purge(_ctx, URLPattern("http://example.com/zebras"))
+	assert.NoError(t, err)
+
+	// Words only the purged document had are forgotten, shared ones are
+	// counted for one document fewer.
+	assert.Equal(t, int64(0), _ctx.Vocabulary.Frequency(zebra.Text))
+	assert.Equal(t, 0, len(_ctx.Completer.Complete(zebra.Text, 10)))
+	assert.Equal(t, world-1, _ctx.Completer.Complete("world", 1)[0].Documents)
+}
+
+func TestPurge_DeleteSite_Indexing(t *testing.T) {
+	defer TearDown(_ctx)
+
+	q := NewQueue()
+	q.Name = "example.com"
+	_ctx.Queues.Add(q)
+	assert.True(t, q.Begin())
+
+	deleted := make(chan int)
+	go func() {
+		n, _ := DeleteSite(_ctx, "example.com")
+		deleted <- n
+	}()
+
+	// A page that was being indexed when the site was deleted is deleted
+	// too, rather than added afterwards.
+	putPages(_ctx, "http://example.com/late")
+	select {
+	case <-deleted:
+		t.Fatal("Site was deleted while a page was being indexed.")
+	case <-time.After(20 * time.Millisecond):
+	}
+	q.Done()
+	assert.Equal(t, 1, <-deleted)
+
+	assert.False(t, q.Begin())
+	assert.Equal(t, ErrQueueStopped, IndexPage(_ctx, q, "http://example.com/next", "example.com"))
+	sites, _ := _ctx.Store.Sites()
+	assert.Equal(t, 0, len(sites))
+}
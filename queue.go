@@ -8,13 +8,66 @@ import (
 // Queues is a map of queue's
 type Queues struct {
 	Queues map[string]*Queue `json:"queues"`
+	sync.Mutex
 }
 
 // Add pushes a new queue onto the queue list
 func (qs *Queues) Add(q *Queue) {
+	qs.Lock()
+	defer qs.Unlock()
+
 	qs.Queues[q.Name] = q
 }
 
+// Open returns the queue with a name, creating it with the given number of hops
+// from a seed if it doesn't exist. created reports whether it was created.
+func (qs *Queues) Open(name string, hops int) (q *Queue, created bool) {
+	qs.Lock()
+	defer qs.Unlock()
+
+	if q, ok := qs.Queues[name]; ok {
+		return q, false
+	}
+
+	q = NewQueue()
+	q.Name = name
+	q.Hops = hops
+	qs.Queues[name] = q
+	return q, true
+}
+
+// Get returns the queue with a name.
+func (qs *Queues) Get(name string) (*Queue, bool) {
+	qs.Lock()
+	defer qs.Unlock()
+
+	q, ok := qs.Queues[name]
+	return q, ok
+}
+
+// List returns every queue.
+func (qs *Queues) List() []*Queue {
+	qs.Lock()
+	defer qs.Unlock()
+
+	queues := []*Queue{}
+	for _, q := range qs.Queues {
+		queues = append(queues, q)
+	}
+	return queues
+}
+
+// Remove stops a queue and takes it off the queue list.
+func (qs *Queues) Remove(name string) {
+	qs.Lock()
+	defer qs.Unlock()
+
+	if q, ok := qs.Queues[name]; ok {
+		q.Stop()
+		delete(qs.Queues, name)
+	}
+}
+
 // NewQueues return a new queue list
 func NewQueues() *Queues {
 	qs := new(Queues)
@@ -22,12 +75,16 @@ func NewQueues() *Queues {
 	return qs
 }
 
-// Queue holds data regarding a queue
+// Queue holds data regarding a queue, Hops is how many links to other sites
+// were followed from the seed of a crawl to reach it.
 type Queue struct {
-	Manager map[string]bool `json:"manager"`
-	Items   []string        `json:"items"`
-	Name    string          `json:"name"`
-	Status  string          `json:"status"`
+	Manager map[string]bool   `json:"manager"`
+	Items   []string          `json:"items"`
+	Errors  map[string]string `json:"errors"`
+	Name    string            `json:"name"`
+	Status  string            `json:"status"`
+	Hops    int               `json:"hops,omitempty"`
+	pages   sync.WaitGroup
 	sync.Mutex
 }
 
@@ -35,6 +92,7 @@ type Queue struct {
 func NewQueue() *Queue {
 	q := new(Queue)
 	q.Manager = make(map[string]bool)
+	q.Errors = make(map[string]string)
 	q.Status = "active"
 
 	return q
@@ -45,6 +103,10 @@ func (q *Queue) Enqueue(item string) {
 	q.Lock()
 	defer q.Unlock()
 
+	if q.Status == "stopped" {
+		return
+	}
+
 	if _, ok := q.Manager[item]; !ok {
 		q.Manager[item] = true
 		q.Items = append(q.Items, item)
@@ -71,3 +133,79 @@ func (q *Queue) Dequeue() (string, error) {
 	q.Items = newQueue
 	return x, nil
 }
+
+// Fail records why an item couldn't be indexed.
+func (q *Queue) Fail(item string, err error) {
+	q.Lock()
+	defer q.Unlock()
+
+	q.Errors[item] = err.Error()
+}
+
+// Error returns why an item couldn't be indexed, if it failed.
+func (q *Queue) Error(item string) string {
+	q.Lock()
+	defer q.Unlock()
+
+	return q.Errors[item]
+}
+
+// Stop empties the queue, no more items can be added to a stopped queue.
+func (q *Queue) Stop() {
+	q.Lock()
+	defer q.Unlock()
+
+	q.Items = nil
+	q.Status = "stopped"
+}
+
+// Begin marks a page of the queue as being indexed, it returns false once the
+// queue has been stopped.
+func (q *Queue) Begin() bool {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.Status == "stopped" {
+		return false
+	}
+	q.pages.Add(1)
+	return true
+}
+
+// Done marks a page begun with Begin as handed to the writer.
+func (q *Queue) Done() {
+	q.pages.Done()
+}
+
+// Wait blocks until every page begun before the queue was stopped is done.
+func (q *Queue) Wait() {
+	q.pages.Wait()
+}
+
+// Finish marks an empty queue as finished, unless it was stopped. It returns
+// false if items were added since the queue was last found empty.
+func (q *Queue) Finish() bool {
+	q.Lock()
+	defer q.Unlock()
+
+	if len(q.Items) > 0 {
+		return false
+	}
+	if q.Status != "stopped" {
+		q.Status = "finished"
+	}
+	return true
+}
+
+// Resume marks a finished queue that has items again as active, it reports
+// whether the queue needs to be crawled again.
+func (q *Queue) Resume() bool {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.Status != "finished" || len(q.Items) == 0 {
+		return false
+	}
+	q.Status = "active"
+	return true
+}
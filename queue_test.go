@@ -67,3 +67,63 @@ func TestQueues_Add(t *testing.T) {
 	qs.Add(q)
 	assert.Equal(t, 1, len(qs.Queues))
 }
+
+func TestQueue_Fail(t *testing.T) {
+	q := NewQueue()
+	q.Enqueue("http://example.com/")
+
+	assert.Equal(t, "", q.Error("http://example.com/"))
+
+	q.Fail("http://example.com/", ErrUnreachableURL)
+	assert.Equal(t, ErrUnreachableURL.Error(), q.Error("http://example.com/"))
+}
+
+func TestQueue_Stop(t *testing.T) {
+	q := NewQueue()
+	q.Enqueue("http://example.com/")
+
+	q.Stop()
+	q.Enqueue("http://example.com/about/")
+
+	assert.Equal(t, "stopped", q.Status)
+	assert.Equal(t, 0, q.Len())
+}
+
+func TestQueues_Open(t *testing.T) {
+	qs := NewQueues()
+
+	q, created := qs.Open("example.com", 2)
+	assert.True(t, created)
+	assert.Equal(t, "example.com", q.Name)
+	assert.Equal(t, 2, q.Hops)
+
+	again, created := qs.Open("example.com", 1)
+	assert.False(t, created)
+	assert.Equal(t, q, again)
+	assert.Equal(t, 2, again.Hops)
+
+	got, ok := qs.Get("example.com")
+	assert.True(t, ok)
+	assert.Equal(t, q, got)
+	assert.Equal(t, 1, len(qs.List()))
+}
+
+func TestQueue_FinishResume(t *testing.T) {
+	q := NewQueue()
+	q.Enqueue("a")
+	assert.False(t, q.Finish())
+	assert.False(t, q.Resume())
+
+	q.Dequeue()
+	assert.True(t, q.Finish())
+	assert.Equal(t, "finished", q.Status)
+	assert.False(t, q.Resume())
+
+	q.Enqueue("b")
+	assert.True(t, q.Resume())
+	assert.Equal(t, "active", q.Status)
+
+	q.Stop()
+	assert.True(t, q.Finish())
+	assert.Equal(t, "stopped", q.Status)
+}
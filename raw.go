@@ -0,0 +1,51 @@
+package miru
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+)
+
+// Raw is the body of the response a document was built from, kept so the
+// document can be rebuilt without fetching it again. Data is gzipped when
+// Compressed is set.
+type Raw struct {
+	DocID      string `gorethink:"id" json:"document_id"`
+	Compressed bool   `gorethink:"compressed" json:"compressed"`
+	Data       []byte `gorethink:"data" json:"data"`
+}
+
+// NewRaw holds the body of a document's response, gzipping it if compress is
+// set.
+func NewRaw(docID string, contents []byte, compress bool) (*Raw, error) {
+	r := &Raw{DocID: docID, Data: contents}
+	if !compress {
+		return r, nil
+	}
+
+	buf := new(bytes.Buffer)
+	gz := gzip.NewWriter(buf)
+	if _, err := gz.Write(contents); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	r.Data = buf.Bytes()
+	r.Compressed = true
+	return r, nil
+}
+
+// Contents returns the body the raw content was made from.
+func (r *Raw) Contents() ([]byte, error) {
+	if !r.Compressed {
+		return r.Data, nil
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(r.Data))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return ioutil.ReadAll(gz)
+}
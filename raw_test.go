@@ -0,0 +1,51 @@
+package miru
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRaw_Contents(t *testing.T) {
+	contents := []byte("<p>The same page, over and over, over and over.</p>")
+
+	for _, compress := range []bool{false, true} {
+		r, err := NewRaw("1", contents, compress)
+		assert.NoError(t, err)
+		assert.Equal(t, compress, r.Compressed)
+		if compress {
+			assert.NotEqual(t, contents, r.Data)
+		}
+
+		out, err := r.Contents()
+		assert.NoError(t, err)
+		assert.Equal(t, contents, out)
+	}
+}
+
+func TestRaw_Contents_Corrupt(t *testing.T) {
+	r := &Raw{DocID: "1", Compressed: true, Data: []byte("not gzip")}
+	_, err := r.Contents()
+	assert.Error(t, err)
+}
+
+func TestCrawler_IndexPage_Raw(t *testing.T) {
+	defer TearDown(_ctx)
+
+	_ctx.Config.Raw.Store = true
+	defer func() { _ctx.Config.Raw.Store = false }()
+
+	ts := Handler(200, []byte("<p>Kept as it was</p>"))
+	defer ts.Close()
+
+	assert.NoError(t, IndexPage(_ctx, NewQueue(), ts.URL+"/", ts.URL[len("http://"):]))
+	assert.NoError(t, _ctx.Writer.Flush())
+
+	d, err := _ctx.Store.DocumentByURL(ts.URL + "/")
+	assert.NoError(t, err)
+	raw, err := _ctx.Store.Raw(d.DocID)
+	assert.NoError(t, err)
+	assert.True(t, raw.Compressed)
+	contents, _ := raw.Contents()
+	assert.Equal(t, "<p>Kept as it was</p>", string(contents))
+}
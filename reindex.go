@@ -0,0 +1,327 @@
+package miru
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrReindexRunning for when a reindex is started while another is running.
+var ErrReindexRunning = errors.New("A reindex is already running.")
+
+// ReindexProgress reports how far a reindex has got. Total is the number of
+// documents to rebuild, or for archives the number of responses read so far.
+type ReindexProgress struct {
+	Running  bool      `json:"running"`
+	Total    int       `json:"total"`
+	Done     int       `json:"done"`
+	Skipped  int       `json:"skipped"`
+	Failed   int       `json:"failed"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Reindexer rebuilds documents from their stored raw content, or from the
+// responses in WARC archives, with the context's current extractor and
+// analyzers. Only one reindex runs at a time.
+type Reindexer struct {
+	progress ReindexProgress
+	wg       sync.WaitGroup
+	sync.Mutex
+}
+
+// NewReindexer creates a reindexer that hasn't run.
+func NewReindexer() *Reindexer {
+	return new(Reindexer)
+}
+
+// Progress returns the progress of the running reindex, or of the last one.
+func (r *Reindexer) Progress() ReindexProgress {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.progress
+}
+
+// Start runs a reindex in the background, from the given archives if there are
+// any and otherwise from stored raw content.
+func (r *Reindexer) Start(c *Context, archives []string) error {
+	if err := r.begin(); err != nil {
+		return err
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.finish(c, r.reindex(c, archives))
+	}()
+	return nil
+}
+
+// Run reindexes as Start but waits for the reindex to finish.
+func (r *Reindexer) Run(c *Context, archives []string) error {
+	if err := r.begin(); err != nil {
+		return err
+	}
+
+	err := r.reindex(c, archives)
+	r.finish(c, err)
+	return err
+}
+
+// Wait blocks until a reindex started by Start has finished.
+func (r *Reindexer) Wait() {
+	r.wg.Wait()
+}
+
+// begin resets the progress, failing if a reindex is running.
+func (r *Reindexer) begin() error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.progress.Running {
+		return ErrReindexRunning
+	}
+	r.progress = ReindexProgress{Running: true, Started: time.Now()}
+	return nil
+}
+
+// finish records the outcome of a reindex.
+func (r *Reindexer) finish(c *Context, err error) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.progress.Running = false
+	r.progress.Finished = time.Now()
+	if err != nil {
+		r.progress.Error = err.Error()
+	}
+}
+
+// update changes the progress under the lock.
+func (r *Reindexer) update(fn func(p *ReindexProgress)) {
+	r.Lock()
+	fn(&r.progress)
+	r.Unlock()
+}
+
+func (r *Reindexer) reindex(c *Context, archives []string) error {
+	// Documents waiting to be written are reindexed along with the rest.
+	if err := c.Writer.Flush(); err != nil {
+		return err
+	}
+
+	if len(archives) == 0 {
+		return r.reindexStored(c)
+	}
+	for _, name := range archives {
+		if err := r.reindexArchive(c, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reindexStored rebuilds every document with raw content, documents stored
+// without it are skipped.
+func (r *Reindexer) reindexStored(c *Context) error {
+	ids := []string{}
+	if err := c.Store.EachDocument(func(d *Document) error {
+		ids = append(ids, d.DocID)
+		return nil
+	}); err != nil {
+		return err
+	}
+	r.update(func(p *ReindexProgress) { p.Total = len(ids) })
+
+	for _, id := range ids {
+		d, err := c.Store.Document(id)
+		if err == ErrNotFound {
+			r.update(func(p *ReindexProgress) { p.Skipped++ })
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		raw, err := c.Store.Raw(id)
+		if err == ErrNotFound {
+			r.update(func(p *ReindexProgress) { p.Skipped++ })
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		contents, err := raw.Contents()
+		if err != nil {
+			r.update(func(p *ReindexProgress) { p.Failed++ })
+			continue
+		}
+		if err := r.replace(c, d, contents, d.ContentType, d.Status, raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reindexArchive rebuilds a document from each successful response in an
+// archive. Documents already stored for the response's URL are replaced,
+// keeping their ID, and the rest are added as new documents of the URL's host.
+func (r *Reindexer) reindexArchive(c *Context, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return ReadWARC(f, func(record *WARCRecord) error {
+		if record.Type != WARCResponse {
+			return nil
+		}
+		r.update(func(p *ReindexProgress) { p.Total++ })
+
+		resp, body, err := record.Response()
+		if err != nil {
+			r.update(func(p *ReindexProgress) { p.Failed++ })
+			return nil
+		}
+		if resp.StatusCode != 200 {
+			r.update(func(p *ReindexProgress) { p.Skipped++ })
+			return nil
+		}
+		contentType := resp.Header.Get("Content-Type")
+
+		d, err := c.Store.DocumentByURL(record.TargetURI)
+		if err == nil {
+			return r.replace(c, d, body, contentType, resp.StatusCode, nil)
+		}
+		if err != ErrNotFound {
+			return err
+		}
+
+		u, err := url.Parse(record.TargetURI)
+		if err != nil || u.Host == "" {
+			r.update(func(p *ReindexProgress) { p.Failed++ })
+			return nil
+		}
+		page, _, err := NewPage(c, body, record.TargetURI, u.Host, contentType, resp.StatusCode)
+		if err != nil {
+			r.update(func(p *ReindexProgress) { p.Failed++ })
+			return nil
+		}
+		if !record.Date.IsZero() {
+			page.Document.Fetched = record.Date
+		}
+		return r.write(c, page)
+	})
+}
+
+// replace rebuilds a stored document from contents, keeping its ID and fetch
+// time, and swaps it for the old one. Raw content is kept if given, otherwise
+// it is stored as the config says. If the rebuilt page can't be written the old
+// document is put back before the reindex stops.
+func (r *Reindexer) replace(c *Context, d *Document, contents []byte, contentType string, status int, raw *Raw) error {
+	page, _, err := NewPage(c, contents, d.Url, d.Site, contentType, status)
+	if err != nil {
+		r.update(func(p *ReindexProgress) { p.Failed++ })
+		return nil
+	}
+	page.setDocID(d.DocID)
+	page.Document.Fetched = d.Fetched
+	if raw != nil {
+		page.Raw = raw
+	}
+
+	old, err := storedPage(c, d)
+	if err != nil {
+		return err
+	}
+	if err := deleteDocument(c, d); err != nil {
+		return err
+	}
+	if err := r.write(c, page); err != nil {
+		if restoreErr := r.restore(c, old); restoreErr != nil {
+			return restoreErr
+		}
+		return err
+	}
+	return nil
+}
+
+// restore removes whatever was written of a rebuilt page and writes back the
+// page it replaced.
+func (r *Reindexer) restore(c *Context, old *Page) error {
+	written, err := c.Store.Document(old.Document.DocID)
+	if err == nil {
+		err = deleteDocument(c, written)
+	}
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	return c.Writer.WritePage(old)
+}
+
+// storedPage reads everything stored for a document, so that it can be written
+// again.
+func storedPage(c *Context, d *Document) (*Page, error) {
+	p := &Page{Document: d}
+
+	ixs, err := c.Store.DocumentIndexes(d.DocID)
+	if err != nil {
+		return nil, err
+	}
+	// Anchor indexes are worked out again from the links to the page when it
+	// is written.
+	for _, i := range ixs {
+		if i.Field != FieldAnchor {
+			p.Indexes = append(p.Indexes, i)
+		}
+	}
+	if p.Links, err = c.Store.LinksFrom(d.DocID); err != nil {
+		return nil, err
+	}
+	if p.Media, err = c.Store.DocumentMedia(d.DocID); err != nil {
+		return nil, err
+	}
+	p.Raw, err = c.Store.Raw(d.DocID)
+	if err == ErrNotFound {
+		p.Raw, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// write stores a rebuilt page straight away rather than in a batch, a failure
+// stops the reindex.
+func (r *Reindexer) write(c *Context, page *Page) error {
+	if err := c.Writer.WritePage(page); err != nil {
+		r.update(func(p *ReindexProgress) { p.Failed++ })
+		return err
+	}
+	r.update(func(p *ReindexProgress) { p.Done++ })
+	return nil
+}
+
+// setDocID moves everything built from a page to another document ID.
+func (p *Page) setDocID(id string) {
+	p.Document.DocID = id
+	for _, ix := range p.Indexes {
+		ix.DocID = id
+		ix.IndexID = indexID(id, ix.Field, ix.Word)
+	}
+	for _, l := range p.Links {
+		l.Source = id
+	}
+	for _, m := range p.Media {
+		m.DocID = id
+	}
+	if p.Raw != nil {
+		p.Raw.DocID = id
+	}
+}
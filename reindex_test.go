@@ -0,0 +1,271 @@
+package miru
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/stretchr/testify/assert"
+)
+
+var reindexPage = []byte(`<html><head><title>Otters</title></head><body>
+<div class="content">Otters hold hands while they sleep.</div>
+</body></html>`)
+
+// extractContent is an extractor that only reads the content container.
+func extractContent(doc *goquery.Document) string {
+	return doc.Find(".content").Text()
+}
+
+func lookupDocIDs(t *testing.T, c *Context, word string) []string {
+	rows, err := c.Store.Lookup([]string{word}, &Filter{})
+	assert.NoError(t, err)
+	ids := []string{}
+	for _, row := range rows {
+		ids = append(ids, row.Document.DocID)
+	}
+	return ids
+}
+
+func TestReindex_Stored(t *testing.T) {
+	ctx := writerContext()
+	ctx.Config.Raw.Store = true
+	ctx.Extractor = func(doc *goquery.Document) string { return "" }
+
+	p, _, err := NewPage(ctx, reindexPage, "http://example.com/otters", "example.com", "text/html", 200)
+	assert.NoError(t, err)
+	fetched := time.Date(2015, 6, 1, 10, 0, 0, 0, time.UTC)
+	p.Document.Fetched = fetched
+	assert.NoError(t, ctx.Writer.AddPage(p, nil))
+	// Documents without raw content can't be rebuilt.
+	d := NewDocument("http://example.com/", "example.com", "Home", "")
+	assert.NoError(t, ctx.Writer.Add(d, Indexer(d.Content, d.DocID), nil))
+	assert.NoError(t, ctx.Writer.Flush())
+	assert.Equal(t, 0, len(lookupDocIDs(t, ctx, "sleep")))
+
+	ctx.Extractor = extractContent
+	assert.NoError(t, ctx.Reindexer.Run(ctx, nil))
+
+	progress := ctx.Reindexer.Progress()
+	assert.False(t, progress.Running)
+	assert.Equal(t, 2, progress.Total)
+	assert.Equal(t, 1, progress.Done)
+	assert.Equal(t, 1, progress.Skipped)
+	assert.Equal(t, 0, progress.Failed)
+	assert.Equal(t, "", progress.Error)
+	assert.False(t, progress.Finished.Before(progress.Started))
+
+	// The document keeps its ID, fetch time and raw content.
+	assert.Equal(t, []string{p.Document.DocID}, lookupDocIDs(t, ctx, "sleep"))
+	stored, err := ctx.Store.Document(p.Document.DocID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Otters hold hands while they sleep.", stored.Content)
+	assert.True(t, fetched.Equal(stored.Fetched))
+	_, err = ctx.Store.Raw(p.Document.DocID)
+	assert.NoError(t, err)
+}
+
+func TestReindex_Archive(t *testing.T) {
+	ctx := writerContext()
+	ctx.Extractor = extractContent
+
+	ts := Handler(200, reindexPage)
+	defer ts.Close()
+	missing := Handler(404, []byte("Not found"))
+	defer missing.Close()
+
+	w := tempWARCWriter(t, true)
+	defer os.RemoveAll(w.Dir)
+	fetched := time.Date(2015, 6, 1, 10, 0, 0, 0, time.UTC)
+	for _, url := range []string{ts.URL + "/stored", ts.URL + "/new", missing.URL} {
+		resp, body := fetch(t, url)
+		assert.NoError(t, w.Write(resp, body, fetched))
+	}
+	name := w.Name()
+	assert.NoError(t, w.Close())
+
+	d := NewDocument(ts.URL+"/stored", "example.com", "", "Stale")
+	assert.NoError(t, ctx.Store.PutDocument(d))
+
+	assert.NoError(t, ctx.Reindexer.Run(ctx, []string{name}))
+
+	progress := ctx.Reindexer.Progress()
+	assert.Equal(t, 3, progress.Total)
+	assert.Equal(t, 2, progress.Done)
+	assert.Equal(t, 1, progress.Skipped)
+
+	stored, err := ctx.Store.DocumentByURL(ts.URL + "/stored")
+	assert.NoError(t, err)
+	assert.Equal(t, d.DocID, stored.DocID)
+	assert.Equal(t, "example.com", stored.Site)
+	assert.Equal(t, "Otters hold hands while they sleep.", stored.Content)
+
+	added, err := ctx.Store.DocumentByURL(ts.URL + "/new")
+	assert.NoError(t, err)
+	assert.Equal(t, ts.URL[len("http://"):], added.Site)
+	assert.True(t, fetched.Equal(added.Fetched))
+	assert.Equal(t, 2, len(lookupDocIDs(t, ctx, "sleep")))
+}
+
+func TestReindex_Running(t *testing.T) {
+	ctx := writerContext()
+	r := ctx.Reindexer
+
+	r.Lock()
+	r.progress.Running = true
+	r.Unlock()
+	assert.Equal(t, ErrReindexRunning, r.Start(ctx, nil))
+	assert.Equal(t, ErrReindexRunning, r.Run(ctx, nil))
+
+	r.Lock()
+	r.progress.Running = false
+	r.Unlock()
+	assert.NoError(t, r.Start(ctx, nil))
+	r.Wait()
+	assert.False(t, r.Progress().Running)
+
+	assert.Error(t, r.Run(ctx, []string{"missing.warc"}))
+	assert.NotEqual(t, "", r.Progress().Error)
+}
+
+func TestAPI_APIReindexHandler(t *testing.T) {
+	defer TearDown(_ctx)
+
+	APIRoutes(m, _ctx)
+
+	// Reindexing needs the API token.
+	r, _ := http.NewRequest("POST", "/api/reindex", nil)
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	r, _ = http.NewRequest("POST", "/api/reindex", nil)
+	r.Header.Set("Authorization", "Bearer "+testToken)
+	w = httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Contains(t, w.Body.String(), `"running":true`)
+	_ctx.Reindexer.Wait()
+
+	r, _ = http.NewRequest("GET", "/api/reindex", nil)
+	w = httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"running":false,"total":0,"done":0`)
+
+	dir := _ctx.Config.Archive.Path
+	_ctx.Config.Archive.Path = os.TempDir() + "/miru-no-archives"
+	defer func() { _ctx.Config.Archive.Path = dir }()
+
+	r, _ = http.NewRequest("POST", "/api/reindex?source=archive", nil)
+	r.Header.Set("Authorization", "Bearer "+testToken)
+	w = httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAPI_APIReindexHandler_LiveArchive(t *testing.T) {
+	defer TearDown(_ctx)
+
+	ts := Handler(200, reindexPage)
+	defer ts.Close()
+
+	archive := tempWARCWriter(t, false)
+	defer os.RemoveAll(archive.Dir)
+	resp, body := fetch(t, ts.URL)
+	assert.NoError(t, archive.Write(resp, body, time.Now()))
+
+	dir := _ctx.Config.Archive.Path
+	_ctx.Config.Archive.Path = archive.Dir
+	_ctx.Archive = archive
+	defer func() {
+		_ctx.Config.Archive.Path = dir
+		_ctx.Archive = nil
+		archive.Close()
+	}()
+
+	// The only file is the one being written to, so there is nothing to
+	// reindex from.
+	APIRoutes(m, _ctx)
+	r, _ := http.NewRequest("POST", "/api/reindex?source=archive", nil)
+	r.Header.Set("Authorization", "Bearer "+testToken)
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "No archives were found.")
+}
+
+// failingPut fails to write documents a number of times.
+type failingPut struct {
+	Store
+	fails int
+}
+
+func (s *failingPut) PutDocuments(docs []*Document) error {
+	if s.fails > 0 {
+		s.fails--
+		return errors.New("Documents unavailable.")
+	}
+	return s.Store.PutDocuments(docs)
+}
+
+func TestReindex_Replace_Restore(t *testing.T) {
+	ctx := writerContext()
+	defer ctx.Writer.Close()
+
+	d := NewDocument("http://example.com/otters", "example.com", "Otters", "Stale otters")
+	link := NewLink(d.DocID, "http://example.com/", "Home", false)
+	media := &Media{MediaID: "1", DocID: d.DocID, URL: "http://example.com/otter.jpg", Words: []string{"otter"}}
+	raw := &Raw{DocID: d.DocID, Data: reindexPage}
+	assert.NoError(t, ctx.Writer.WritePage(&Page{
+		Document: d,
+		Indexes:  Indexer(d.Content, d.DocID),
+		Links:    []*Link{link},
+		Media:    []*Media{media},
+		Raw:      raw,
+	}))
+
+	// The rebuilt page can't be written, so the old document is put back.
+	ctx.Store = &failingPut{Store: ctx.Store, fails: 1}
+	assert.Error(t, ctx.Reindexer.replace(ctx, d, reindexPage, "text/html", 200, raw))
+	assert.Equal(t, 1, ctx.Reindexer.Progress().Failed)
+
+	stored, err := ctx.Store.Document(d.DocID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Stale otters", stored.Content)
+	}
+	assert.Equal(t, []string{d.DocID}, lookupDocIDs(t, ctx, "stale"))
+	links, _ := ctx.Store.LinksFrom(d.DocID)
+	assert.Equal(t, []*Link{link}, links)
+	found, _ := ctx.Store.DocumentMedia(d.DocID)
+	assert.Equal(t, 1, len(found))
+	_, err = ctx.Store.Raw(d.DocID)
+	assert.NoError(t, err)
+}
+
+func TestReindex_Replace_Unbuffered(t *testing.T) {
+	ctx := writerContext()
+	ctx.Config.Raw.Store = true
+	ctx.Writer.FlushInterval = time.Hour
+	defer ctx.Writer.Close()
+
+	p, _, err := NewPage(ctx, reindexPage, "http://example.com/otters", "example.com", "text/html", 200)
+	assert.NoError(t, err)
+	assert.NoError(t, ctx.Writer.WritePage(p))
+
+	d, _ := ctx.Store.Document(p.Document.DocID)
+	raw, _ := ctx.Store.Raw(d.DocID)
+	assert.NoError(t, ctx.Reindexer.replace(ctx, d, reindexPage, d.ContentType, d.Status, raw))
+
+	// The rebuilt document and its raw content are stored without a flush.
+	assert.Equal(t, 0, ctx.Writer.Len())
+	_, err = ctx.Store.Document(d.DocID)
+	assert.NoError(t, err)
+	_, err = ctx.Store.Raw(d.DocID)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{d.DocID}, lookupDocIDs(t, ctx, "sleep"))
+}
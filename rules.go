@@ -0,0 +1,139 @@
+package miru
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"code.google.com/p/cascadia"
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ErrRuleHost for when a site's extraction rule doesn't name a host.
+var ErrRuleHost = errors.New("Extraction rule has no host.")
+
+// SiteRule changes how pages of a host and its subdomains are parsed. Title,
+// Body and Date are CSS selectors, when they match they replace the page's
+// title, the text found by the extraction strategy and its published date.
+// Exclude selects regions removed from the page along with UnwantedTags, a
+// selector of tags stripped as well as the usual ones.
+type SiteRule struct {
+	Host         string
+	Title        string
+	Body         string
+	Date         string
+	Exclude      []string
+	UnwantedTags string
+}
+
+// SiteRules holds the extraction rules of each configured host.
+type SiteRules []*SiteRule
+
+// LoadSiteRules builds the rules from the config, checking every selector.
+func LoadSiteRules(conf *Config) (SiteRules, error) {
+	rules := SiteRules{}
+	for _, site := range conf.Extraction.Sites {
+		host := strings.ToLower(strings.TrimSpace(site.Host))
+		if host == "" {
+			return nil, ErrRuleHost
+		}
+
+		rule := &SiteRule{
+			Host:         host,
+			Title:        site.Title,
+			Body:         site.Body,
+			Date:         site.Date,
+			Exclude:      site.Exclude,
+			UnwantedTags: strings.Join(site.UnwantedTags, ", "),
+		}
+		selectors := append([]string{rule.Title, rule.Body, rule.Date, rule.UnwantedTags}, rule.Exclude...)
+		for _, selector := range selectors {
+			if selector == "" {
+				continue
+			}
+			if _, err := cascadia.Compile(selector); err != nil {
+				return nil, err
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Match returns the rule for a page's URL, nil if there is none. A rule for
+// "example.com" matches "news.example.com" too, the most specific host wins.
+func (rs SiteRules) Match(link string) *SiteRule {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil
+	}
+	host := strings.ToLower(u.Host)
+	if i := strings.LastIndex(host, ":"); i >= 0 && !strings.HasSuffix(host, "]") {
+		host = host[:i]
+	}
+
+	var match *SiteRule
+	for _, rule := range rs {
+		if host != rule.Host && !strings.HasSuffix(host, "."+rule.Host) {
+			continue
+		}
+		if match == nil || len(rule.Host) > len(match.Host) {
+			match = rule
+		}
+	}
+	return match
+}
+
+// Strip removes the rule's unwanted tags and excluded regions from a page.
+func (r *SiteRule) Strip(doc *goquery.Document) {
+	if r.UnwantedTags != "" {
+		doc.Find(r.UnwantedTags).Remove()
+	}
+	for _, selector := range r.Exclude {
+		doc.Find(selector).Remove()
+	}
+}
+
+// ExtractTitle returns the text of the first element matching the title
+// selector.
+func (r *SiteRule) ExtractTitle(doc *goquery.Document) string {
+	if r.Title == "" {
+		return ""
+	}
+	return strings.TrimSpace(doc.Find(r.Title).First().Text())
+}
+
+// ExtractBody returns the text of every element matching the body selector.
+func (r *SiteRule) ExtractBody(doc *goquery.Document) string {
+	if r.Body == "" {
+		return ""
+	}
+
+	texts := []string{}
+	doc.Find(r.Body).Each(func(i int, s *goquery.Selection) {
+		text := strings.TrimSpace(s.Text())
+		if text != "" {
+			texts = append(texts, text)
+		}
+	})
+	return strings.Join(texts, "\n")
+}
+
+// ExtractDate parses the date of the first element matching the date selector,
+// read from its datetime or content attribute or else its text.
+func (r *SiteRule) ExtractDate(doc *goquery.Document) time.Time {
+	if r.Date == "" {
+		return time.Time{}
+	}
+
+	s := doc.Find(r.Date).First()
+	value, ok := s.Attr("datetime")
+	if !ok {
+		value, ok = s.Attr("content")
+	}
+	if !ok {
+		value = s.Text()
+	}
+	return pageDate(strings.TrimSpace(value))
+}
@@ -0,0 +1,152 @@
+package miru
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var rulesConfig = `
+[[extraction.sites]]
+host = "Example.com"
+title = "h2.headline"
+body = ".story-body"
+date = "time.published"
+exclude = [".share", ".related"]
+unwanted_tags = ["figure"]
+
+[[extraction.sites]]
+host = "blog.example.com"
+body = "div.entry"
+`
+
+var rulesPage = []byte(`<html><head><title>Example News | Home</title></head><body>
+<h2 class="headline">Rain expected</h2>
+<time class="published" datetime="2015-06-01T10:00:00Z">1 June</time>
+<div class="story-body">
+	<div>Heavy rain is expected across the north.</div>
+	<div class="share">Share this story</div>
+	<figure><img src="/cloud.jpg" alt="A rain cloud"><figcaption>A cloud</figcaption></figure>
+</div>
+<div class="story-body"><div>Take an umbrella.</div></div>
+<div class="related"><a href="/other">Other news</a></div>
+<p>Subscribe to our newsletter.</p>
+</body></html>`)
+
+func loadRules(t *testing.T, data string) SiteRules {
+	conf, err := LoadConfig(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := LoadSiteRules(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rules
+}
+
+func TestRules_LoadSiteRules(t *testing.T) {
+	rules := loadRules(t, rulesConfig)
+	assert.Equal(t, 2, len(rules))
+	assert.Equal(t, "example.com", rules[0].Host)
+	assert.Equal(t, []string{".share", ".related"}, rules[0].Exclude)
+	assert.Equal(t, "figure", rules[0].UnwantedTags)
+
+	assert.Equal(t, SiteRules{}, loadRules(t, DefaultConfig))
+}
+
+func TestRules_LoadSiteRules_Invalid(t *testing.T) {
+	conf, _ := LoadConfig("[[extraction.sites]]\nbody = \".content\"\n")
+	_, err := LoadSiteRules(conf)
+	assert.Equal(t, ErrRuleHost, err)
+
+	conf, _ = LoadConfig("[[extraction.sites]]\nhost = \"example.com\"\nexclude = [\"div[\"]\n")
+	_, err = LoadSiteRules(conf)
+	assert.Error(t, err)
+}
+
+func TestRules_Match(t *testing.T) {
+	rules := loadRules(t, rulesConfig)
+
+	assert.Equal(t, rules[0], rules.Match("http://example.com/news/"))
+	assert.Equal(t, rules[0], rules.Match("https://www.example.com:8080/"))
+	// The most specific host wins.
+	assert.Equal(t, rules[1], rules.Match("http://blog.example.com/post"))
+	assert.Nil(t, rules.Match("http://notexample.com/"))
+	assert.Nil(t, rules.Match("http://example.org/"))
+	assert.Nil(t, rules.Match("%"))
+}
+
+func TestRules_NewSiteDoc(t *testing.T) {
+	rule := loadRules(t, rulesConfig)[0]
+
+	d := NewSiteDoc(parseDocument(rulesPage), "http://example.com/news/", "example.com", ExtractText, rule)
+	assert.Equal(t, "Rain expected", d.Title)
+	assert.Equal(t, "Heavy rain is expected across the north.\nTake an umbrella.", d.Content)
+	if assert.NotNil(t, d.Published) {
+		assert.Equal(t, time.Date(2015, 6, 1, 10, 0, 0, 0, time.UTC), *d.Published)
+	}
+
+	// Without a rule the page is extracted as usual.
+	d = NewSiteDoc(parseDocument(rulesPage), "http://example.com/news/", "example.com", ExtractText, nil)
+	assert.Equal(t, "Example News | Home", d.Title)
+	assert.Equal(t, "Subscribe to our newsletter.", d.Content)
+	assert.Nil(t, d.Published)
+
+	// Selectors that match nothing fall back to the usual extraction.
+	rule = &SiteRule{Host: "example.com", Title: "h5", Body: ".missing", Date: "h5"}
+	d = NewSiteDoc(parseDocument(rulesPage), "http://example.com/news/", "example.com", ExtractText, rule)
+	assert.Equal(t, "Example News | Home", d.Title)
+	assert.Equal(t, "Subscribe to our newsletter.", d.Content)
+	assert.Nil(t, d.Published)
+}
+
+func TestRules_ExtractDate(t *testing.T) {
+	doc := parseDocument([]byte(`<meta itemprop="date" content="2015-06-01"><span class="date">2015-06-02</span>`))
+
+	rule := &SiteRule{Date: `meta[itemprop="date"]`}
+	assert.Equal(t, time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC), rule.ExtractDate(doc))
+
+	rule = &SiteRule{Date: ".date"}
+	assert.Equal(t, time.Date(2015, 6, 2, 0, 0, 0, 0, time.UTC), rule.ExtractDate(doc))
+}
+
+func TestRules_NewPage(t *testing.T) {
+	ctx := writerContext()
+	ctx.SiteRules = loadRules(t, rulesConfig)
+
+	p, _, err := NewPage(ctx, rulesPage, "http://www.example.com/news/", "www.example.com", "text/html", 200)
+	assert.NoError(t, err)
+	assert.Equal(t, "Rain expected", p.Document.Title)
+	assert.NotContains(t, p.Document.Content, "Share this story")
+	assert.NotContains(t, p.Document.Content, "A cloud")
+	// Links and media in stripped regions aren't indexed but are still found.
+	assert.Equal(t, 1, len(p.Links))
+	assert.Equal(t, 1, len(p.Media))
+
+	p, _, err = NewPage(ctx, rulesPage, "http://example.org/news/", "example.org", "text/html", 200)
+	assert.NoError(t, err)
+	assert.Equal(t, "Example News | Home", p.Document.Title)
+	assert.Equal(t, 1, len(p.Links))
+}
+
+func TestRules_IndexPage(t *testing.T) {
+	ts := Handler(200, rulesPage)
+	defer ts.Close()
+	site := ts.URL[len("http://"):]
+
+	ctx := writerContext()
+	ctx.SiteRules = loadRules(t, "[[extraction.sites]]\nhost = \"127.0.0.1\"\nexclude = [\".related\"]\n")
+	defer ctx.Writer.Close()
+
+	// Links in excluded regions are still crawled.
+	q := NewQueue()
+	assert.NoError(t, IndexPage(ctx, q, ts.URL+"/news/", site))
+	assert.Equal(t, 1, q.Len())
+
+	ctx.SiteRules = nil
+	q = NewQueue()
+	assert.NoError(t, IndexPage(ctx, q, ts.URL+"/news/", site))
+	assert.Equal(t, 1, q.Len())
+}
@@ -3,24 +3,81 @@ package miru
 import (
 	"fmt"
 	"html/template"
+	"sort"
 	"strings"
 	"time"
-
-	rdb "github.com/dancannon/gorethink"
 )
 
-// Result holds data for a result's document and index
+// Result holds data for a result's document and index, Score is the sum of the
+// boosted counts of every index that matched the document.
 type Result struct {
 	Document
-	Index
+	Index `json:"-"`
+	Score float64 `gorethink:"-" json:"score"`
+}
+
+type byScore []Result
+
+func (rs byScore) Len() int { return len(rs) }
+
+func (rs byScore) Swap(i, j int) { rs[i], rs[j] = rs[j], rs[i] }
+
+func (rs byScore) Less(i, j int) bool { return rs[i].Score > rs[j].Score }
+
+// rank collapses matching indexes into a single result per document and orders
+// them by score, highest first. Each document's score is then multiplied by
+// 1 + weight times its authority.
+func rank(rows []Result, b boost, a *Authority, weight float64) []Result {
+	var ranked []Result
+	positions := map[string]int{}
+
+	for _, row := range rows {
+		score := float64(row.Count) * b.Weight(row.Field)
+
+		if pos, ok := positions[row.Document.DocID]; ok {
+			ranked[pos].Score += score
+			continue
+		}
+
+		row.Score = score
+		positions[row.Document.DocID] = len(ranked)
+		ranked = append(ranked, row)
+	}
+
+	if a != nil && weight > 0 {
+		for i := range ranked {
+			ranked[i].Score *= 1 + weight*a.Score(ranked[i].Document.DocID)
+		}
+	}
+
+	sort.Stable(byScore(ranked))
+	return ranked
 }
 
 // Results holds all of the results, the time taken to perform the query and the
-// number of results.
+// number of results. When nothing matched DidYouMean holds alternative queries,
+// Corrected is set to the query that was run instead if it was autocorrected.
 type Results struct {
-	Speed   float64  `json:"speed"`
-	Count   int64    `json:"count"`
-	Results []Result `json:"results"`
+	Speed      float64  `json:"speed"`
+	Count      int64    `json:"count"`
+	Language   string   `json:"language"`
+	DidYouMean []string `json:"did_you_mean,omitempty"`
+	Corrected  string   `json:"corrected,omitempty"`
+	Facets     Facets   `json:"facets"`
+	Results    []Result `json:"results"`
+}
+
+// Query describes a search. Language selects how the text is analysed, when it
+// is empty the language is detected from the text, falling back to the default
+// language. AutoCorrect retries a query that has no results with the best
+// spelling suggestion. Filter restricts which documents can match. With
+// MatchAll set only documents containing every word of the query match.
+type Query struct {
+	Text        string
+	Language    string
+	AutoCorrect bool
+	MatchAll    bool
+	Filter      Filter
 }
 
 // RenderSpeed formats the speed of the query
@@ -45,23 +102,49 @@ func (rxs *Results) RenderCountHTML() template.HTML {
 
 // Search returns a list of Results for a given query.
 func (rxs *Results) Search(query string, c *Context) error {
+	return rxs.Find(&Query{Text: query}, c)
+}
+
+// Find returns a list of Results for a query.
+func (rxs *Results) Find(q *Query, c *Context) error {
 	start := time.Now()
 
-	query = Normalise(query)
+	lang := q.Language
+	if lang == "" {
+		lang = DetectLanguage(q.Text)
+	}
+	rxs.Language = c.Language(lang)
+
+	rxs.Results = nil
+	rxs.Facets = NewFacets(nil, q.Filter)
 
-	keywords := rxs.ParseQuery(query)
-	results, err := rdb.Db(
-		c.Config.Database.Name).Table(
-		c.Config.Tables.Index).GetAllByIndex(
-		"word", rdb.Args(keywords)).EqJoin(
-		"doc_id", rdb.Db(c.Config.Database.Name).Table(
-			c.Config.Tables.Document)).Zip().OrderBy(
-		rdb.Desc("count")).Run(c.Db)
+	keywords := Terms(c.AnalyzerFor(rxs.Language).Analyze(q.Text))
+	if len(keywords) == 0 {
+		rxs.Speed = time.Since(start).Seconds()
+		rxs.Count = 0
+		return nil
+	}
 
+	// Documents are looked up without the filter's facets so that each facet
+	// can be counted without its own filter, they are filtered afterwards.
+	var rows []Result
+	var err error
+	if q.MatchAll {
+		rows, err = LookupAll(c.Store, keywords, q.Filter.unfaceted())
+	} else {
+		rows, err = c.Store.Lookup(keywords, q.Filter.unfaceted())
+	}
 	if err != nil {
 		return err
 	}
-	results.All(&rxs.Results)
+
+	ranked := rank(rows, c.Config.Boost, c.Authority, c.Config.Authority.Weight)
+	rxs.Facets = NewFacets(ranked, q.Filter)
+	for _, r := range ranked {
+		if q.Filter.Match(&r.Document) {
+			rxs.Results = append(rxs.Results, r)
+		}
+	}
 
 	t := time.Since(start).Seconds()
 	rxs.Speed = t
@@ -71,6 +154,30 @@ func (rxs *Results) Search(query string, c *Context) error {
 	return nil
 }
 
+// Suggest fills DidYouMean when a query had no results, with AutoCorrect set
+// the best suggestion is searched for in its place.
+func (rxs *Results) Suggest(q *Query, c *Context) error {
+	if rxs.Count > 0 {
+		return nil
+	}
+
+	a := c.AnalyzerFor(rxs.Language)
+	rxs.DidYouMean = c.Vocabulary.Corrections(q.Text, a, c.Config.Search.Suggestions)
+	if !q.AutoCorrect || len(rxs.DidYouMean) == 0 {
+		return nil
+	}
+
+	corrected := *q
+	corrected.Text = rxs.DidYouMean[0]
+	suggestions := rxs.DidYouMean
+	if err := rxs.Find(&corrected, c); err != nil {
+		return err
+	}
+	rxs.DidYouMean = suggestions
+	rxs.Corrected = corrected.Text
+	return nil
+}
+
 // ParseQuery splits words into a list of individual words.
 func (rxs *Results) ParseQuery(query string) []string {
 	return strings.Split(query, " ")
@@ -4,7 +4,6 @@ import (
 	"html/template"
 	"testing"
 
-	rdb "github.com/dancannon/gorethink"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -144,6 +143,57 @@ func TestSearch_ParseQuery(t *testing.T) {
 	}
 }
 
+func TestSearch_Rank(t *testing.T) {
+	row := func(docID, field string, count int64) Result {
+		r := Result{}
+		r.Document.DocID = docID
+		r.Index.DocID = docID
+		r.Field = field
+		r.Count = count
+		return r
+	}
+
+	rows := []Result{
+		row("1", FieldContent, 2),
+		row("2", FieldTitle, 1),
+		row("1", FieldHeadings, 1),
+		row("3", FieldContent, 1),
+	}
+
+	b := boost{Title: 5, Headings: 2, Content: 1}
+	ranked := rank(rows, b, nil, 0)
+
+	assert.Equal(t, 3, len(ranked))
+	assert.Equal(t, "2", ranked[0].Document.DocID)
+	assert.Equal(t, 5.0, ranked[0].Score)
+	assert.Equal(t, "1", ranked[1].Document.DocID)
+	assert.Equal(t, 4.0, ranked[1].Score)
+	assert.Equal(t, "3", ranked[2].Document.DocID)
+
+	assert.Nil(t, rank([]Result{}, b, nil, 0))
+}
+
+func TestSearch_Suggest(t *testing.T) {
+	ctx := NewContext()
+	if err := ctx.LoadConfig(DefaultConfig); err != nil {
+		t.Fatal(err.Error())
+	}
+	ctx.Vocabulary.Add("example", 1)
+
+	res := new(Results)
+	err := res.Suggest(&Query{Text: "exmaple"}, ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"example"}, res.DidYouMean)
+	assert.Equal(t, "", res.Corrected)
+
+	// Queries with results don't get suggestions.
+	res = new(Results)
+	res.Count = 1
+	err = res.Suggest(&Query{Text: "exmaple"}, ctx)
+	assert.NoError(t, err)
+	assert.Nil(t, res.DidYouMean)
+}
+
 func TestSearch_Search(t *testing.T) {
 	defer TearDown(_ctx)
 
@@ -171,6 +221,25 @@ func TestSearch_Search(t *testing.T) {
 	assert.Equal(t, len(res.Results), 1)
 }
 
+func TestSearch_Find_MatchAll(t *testing.T) {
+	defer TearDown(_ctx)
+
+	for _, content := range []string{"world news", "local news"} {
+		d := NewDocument("http://example.com/", "example.com", "", content)
+		d.Put(_ctx)
+		i := Indexer(d.Content, d.DocID)
+		i.Put(_ctx)
+	}
+
+	res := new(Results)
+	assert.NoError(t, res.Find(&Query{Text: "world news"}, _ctx))
+	assert.Equal(t, int64(2), res.Count)
+
+	assert.NoError(t, res.Find(&Query{Text: "world news", MatchAll: true}, _ctx))
+	assert.Equal(t, int64(1), res.Count)
+	assert.Equal(t, "world news", res.Results[0].Content)
+}
+
 func TestSearch_Search_NoIndexRaisesError(t *testing.T) {
 	defer TearDown(_ctx)
 
@@ -191,15 +260,13 @@ func TestSearch_Search_NoIndexRaisesError(t *testing.T) {
 		t.Log(err.Error())
 	}
 
-	// Remove index
-	rdb.Db(_db).Table(_index).IndexDrop("word").Exec(_ctx.Db)
+	store := _ctx.Store
+	_ctx.Store = brokenStore{store}
+	defer func() { _ctx.Store = store }()
 
 	res := new(Results)
 	err := res.Search("exampl", _ctx)
 
 	assert.Error(t, err)
 	assert.Equal(t, len(res.Results), 0)
-
-	// Re-add index
-	rdb.Db(_db).Table(_index).IndexCreate("word").Exec(_ctx.Db)
 }
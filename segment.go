@@ -0,0 +1,707 @@
+package miru
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"sort"
+	"sync"
+)
+
+// segmentMagic starts every segment file.
+const segmentMagic = "MIRUSEG1"
+
+// ErrCorruptSegment for when a segment can't be decoded.
+var ErrCorruptSegment = errors.New("Segment is corrupt.")
+
+// posting is an occurrence of a term in one field of a document.
+type posting struct {
+	DocID string
+	Field string
+	Count int64
+	Text  string
+}
+
+// index turns a posting of word back into an index.
+func (p posting) index(word string) *Index {
+	return &Index{
+		IndexID: indexID(p.DocID, p.Field, word),
+		DocID:   p.DocID,
+		Field:   p.Field,
+		Word:    word,
+		Text:    p.Text,
+		Count:   p.Count,
+	}
+}
+
+type byDocument []posting
+
+func (ps byDocument) Len() int { return len(ps) }
+
+func (ps byDocument) Swap(i, j int) { ps[i], ps[j] = ps[j], ps[i] }
+
+func (ps byDocument) Less(i, j int) bool {
+	if ps[i].DocID != ps[j].DocID {
+		return ps[i].DocID < ps[j].DocID
+	}
+	return ps[i].Field < ps[j].Field
+}
+
+// segmentKey identifies the posting of a word in one field of a numbered
+// document, a document has at most one posting for each.
+type segmentKey struct {
+	Doc   uint32
+	Field string
+	Word  string
+}
+
+// segmentBuilder collects postings in memory until they are written out as a
+// segment. Documents are numbered in the order they are first seen.
+type segmentBuilder struct {
+	docs     []string
+	docNums  map[string]uint32
+	postings map[string][]segmentEntry
+	keys     map[segmentKey]bool
+	size     int
+}
+
+// segmentEntry is a posting with its document number and interned strings.
+type segmentEntry struct {
+	Doc   uint32
+	Field string
+	Count int64
+	Text  string
+}
+
+func newSegmentBuilder() *segmentBuilder {
+	return &segmentBuilder{
+		docNums:  make(map[string]uint32),
+		postings: make(map[string][]segmentEntry),
+		keys:     make(map[segmentKey]bool),
+	}
+}
+
+// Has reports whether a document already has a posting for word in field.
+func (b *segmentBuilder) Has(docID, field, word string) bool {
+	num, ok := b.docNums[docID]
+	return ok && b.keys[segmentKey{num, field, word}]
+}
+
+// Posting returns a document's posting for word in field.
+func (b *segmentBuilder) Posting(docID, field, word string) (posting, bool) {
+	if !b.Has(docID, field, word) {
+		return posting{}, false
+	}
+	num := b.docNums[docID]
+	for _, e := range b.postings[word] {
+		if e.Doc == num && e.Field == field {
+			return posting{DocID: docID, Field: field, Count: e.Count, Text: e.Text}, true
+		}
+	}
+	return posting{}, false
+}
+
+// Add appends a posting for word, replacing the document's posting for word in
+// the same field if it has one.
+func (b *segmentBuilder) Add(word string, p posting) {
+	num, ok := b.docNums[p.DocID]
+	if !ok {
+		num = uint32(len(b.docs))
+		b.docNums[p.DocID] = num
+		b.docs = append(b.docs, p.DocID)
+	}
+
+	entry := segmentEntry{
+		Doc:   num,
+		Field: p.Field,
+		Count: p.Count,
+		Text:  p.Text,
+	}
+	key := segmentKey{num, p.Field, word}
+	if b.keys[key] {
+		for n, e := range b.postings[word] {
+			if e.Doc == num && e.Field == p.Field {
+				b.postings[word][n] = entry
+			}
+		}
+		return
+	}
+
+	b.postings[word] = append(b.postings[word], entry)
+	b.keys[key] = true
+	b.size++
+}
+
+// Remove drops every posting of a document.
+func (b *segmentBuilder) Remove(docID string) {
+	num, ok := b.docNums[docID]
+	if !ok {
+		return
+	}
+
+	for word, entries := range b.postings {
+		kept := entries[:0]
+		for _, e := range entries {
+			if e.Doc != num {
+				kept = append(kept, e)
+			} else {
+				delete(b.keys, segmentKey{num, e.Field, word})
+				b.size--
+			}
+		}
+		if len(kept) == 0 {
+			delete(b.postings, word)
+		} else {
+			b.postings[word] = kept
+		}
+	}
+}
+
+// Indexes returns the postings of a document as indexes.
+func (b *segmentBuilder) Indexes(docID string) Indexes {
+	ixs := Indexes{}
+	num, ok := b.docNums[docID]
+	if !ok {
+		return ixs
+	}
+
+	for key := range b.keys {
+		if key.Doc != num {
+			continue
+		}
+		for _, e := range b.postings[key.Word] {
+			if e.Doc == num && e.Field == key.Field {
+				ixs = append(ixs, posting{DocID: docID, Field: e.Field, Count: e.Count, Text: e.Text}.index(key.Word))
+			}
+		}
+	}
+	return ixs
+}
+
+// Postings returns the postings of word.
+func (b *segmentBuilder) Postings(word string) []posting {
+	entries := b.postings[word]
+	ps := make([]posting, len(entries))
+	for n, e := range entries {
+		ps[n] = posting{DocID: b.docs[e.Doc], Field: e.Field, Count: e.Count, Text: e.Text}
+	}
+	return ps
+}
+
+// Each calls fn for every posting.
+func (b *segmentBuilder) Each(fn func(word string, p posting) error) error {
+	words := make([]string, 0, len(b.postings))
+	for word := range b.postings {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+
+	for _, word := range words {
+		for _, p := range b.Postings(word) {
+			if err := fn(word, p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Len returns the number of postings.
+func (b *segmentBuilder) Len() int {
+	return b.size
+}
+
+// Encode writes the postings as a segment. The layout is the magic, the
+// document IDs, a table of field names and texts, then the terms in sorted
+// order each followed by its postings and finally a CRC32 of everything before
+// it. Postings are sorted by document number, which is delta encoded, and every
+// number is a varint.
+func (b *segmentBuilder) Encode() []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteString(segmentMagic)
+
+	putUvarint(buf, uint64(len(b.docs)))
+	for _, doc := range b.docs {
+		putString(buf, doc)
+	}
+
+	strs := []string{}
+	refs := map[string]uint64{}
+	ref := func(s string) uint64 {
+		if n, ok := refs[s]; ok {
+			return n
+		}
+		refs[s] = uint64(len(strs))
+		strs = append(strs, s)
+		return refs[s]
+	}
+
+	words := make([]string, 0, len(b.postings))
+	for word := range b.postings {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+
+	lists := make([][]byte, len(words))
+	for n, word := range words {
+		entries := append([]segmentEntry{}, b.postings[word]...)
+		sort.Sort(byEntry(entries))
+
+		list := new(bytes.Buffer)
+		putUvarint(list, uint64(len(entries)))
+		var last uint32
+		for _, e := range entries {
+			putUvarint(list, uint64(e.Doc-last))
+			putUvarint(list, ref(e.Field))
+			putUvarint(list, uint64(e.Count))
+			putUvarint(list, ref(e.Text))
+			last = e.Doc
+		}
+		lists[n] = list.Bytes()
+	}
+
+	putUvarint(buf, uint64(len(strs)))
+	for _, s := range strs {
+		putString(buf, s)
+	}
+
+	putUvarint(buf, uint64(len(words)))
+	for n, word := range words {
+		putString(buf, word)
+		putUvarint(buf, uint64(len(lists[n])))
+		buf.Write(lists[n])
+	}
+
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, crc32.ChecksumIEEE(buf.Bytes()))
+	buf.Write(sum)
+	return buf.Bytes()
+}
+
+type byEntry []segmentEntry
+
+func (es byEntry) Len() int { return len(es) }
+
+func (es byEntry) Swap(i, j int) { es[i], es[j] = es[j], es[i] }
+
+func (es byEntry) Less(i, j int) bool {
+	if es[i].Doc != es[j].Doc {
+		return es[i].Doc < es[j].Doc
+	}
+	return es[i].Field < es[j].Field
+}
+
+func putUvarint(buf *bytes.Buffer, x uint64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	buf.Write(b[:binary.PutUvarint(b, x)])
+}
+
+func putString(buf *bytes.Buffer, s string) {
+	putUvarint(buf, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+// decoder reads varints and strings from a segment, the first error sticks.
+type decoder struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	x, n := binary.Uvarint(d.data[d.pos:])
+	if n <= 0 {
+		d.err = ErrCorruptSegment
+		return 0
+	}
+	d.pos += n
+	return x
+}
+
+func (d *decoder) bytes(n uint64) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if uint64(len(d.data)-d.pos) < n {
+		d.err = ErrCorruptSegment
+		return nil
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes(d.uvarint()))
+}
+
+// Segment is an immutable set of postings read from a segment file. The term
+// dictionary is held in memory and searched with a binary search, postings are
+// decoded when they are looked up. Each document also has the terms and fields
+// of its postings listed in term order, delta encoded as varints like the
+// postings, so its postings are found without decoding every term. Deleted
+// marks documents that have been removed since the segment was written, they
+// are skipped and dropped when the segment is merged.
+type Segment struct {
+	Name     string
+	docs     []string
+	docNums  map[string]uint32
+	strs     []string
+	terms    []string
+	postings [][]byte
+	forward  [][]byte
+	deleted  map[uint32]bool
+	size     int
+	sync.RWMutex
+}
+
+// DecodeSegment reads a segment written by a segmentBuilder.
+func DecodeSegment(data []byte) (*Segment, error) {
+	if len(data) < len(segmentMagic)+4 || string(data[:len(segmentMagic)]) != segmentMagic {
+		return nil, ErrCorruptSegment
+	}
+	body, sum := data[:len(data)-4], data[len(data)-4:]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(sum) {
+		return nil, ErrCorruptSegment
+	}
+
+	d := &decoder{data: body, pos: len(segmentMagic)}
+	s := &Segment{
+		docNums: make(map[string]uint32),
+		deleted: make(map[uint32]bool),
+	}
+
+	docs := d.uvarint()
+	for n := uint64(0); n < docs && d.err == nil; n++ {
+		doc := d.string()
+		s.docNums[doc] = uint32(len(s.docs))
+		s.docs = append(s.docs, doc)
+	}
+
+	strs := d.uvarint()
+	for n := uint64(0); n < strs && d.err == nil; n++ {
+		s.strs = append(s.strs, d.string())
+	}
+
+	terms := d.uvarint()
+	for n := uint64(0); n < terms && d.err == nil; n++ {
+		s.terms = append(s.terms, d.string())
+		list := d.bytes(d.uvarint())
+		s.postings = append(s.postings, list)
+		if d.err == nil {
+			count, _ := binary.Uvarint(list)
+			s.size += int(count)
+		}
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	fields := map[string]uint64{}
+	for n, str := range s.strs {
+		fields[str] = uint64(n)
+	}
+	s.forward = make([][]byte, len(s.docs))
+	last := make([]int, len(s.docs))
+	scratch := make([]byte, binary.MaxVarintLen64)
+	for n := range s.terms {
+		entries, err := s.entries(n)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			list := s.forward[e.Doc]
+			list = append(list, scratch[:binary.PutUvarint(scratch, uint64(n-last[e.Doc]))]...)
+			list = append(list, scratch[:binary.PutUvarint(scratch, fields[e.Field])]...)
+			s.forward[e.Doc] = list
+			last[e.Doc] = n
+		}
+	}
+	for doc, list := range s.forward {
+		s.forward[doc] = append([]byte(nil), list...)
+	}
+	return s, nil
+}
+
+// eachTerm calls fn with the term number and field of every posting of a
+// numbered document, in term order, until fn returns false.
+func (s *Segment) eachTerm(num uint32, fn func(term int, field string) bool) {
+	d := &decoder{data: s.forward[num]}
+	term := 0
+	for d.pos < len(d.data) {
+		term += int(d.uvarint())
+		field := d.uvarint()
+		if d.err != nil || field >= uint64(len(s.strs)) || !fn(term, s.strs[field]) {
+			return
+		}
+	}
+}
+
+// Len returns the number of postings in the segment, including deleted ones.
+func (s *Segment) Len() int {
+	return s.size
+}
+
+// Live reports whether the segment holds a document that hasn't been deleted.
+func (s *Segment) Live(docID string) bool {
+	s.RLock()
+	defer s.RUnlock()
+
+	num, ok := s.docNums[docID]
+	return ok && !s.deleted[num]
+}
+
+// Delete marks a document as deleted, returning false if the segment doesn't
+// hold it.
+func (s *Segment) Delete(docID string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	num, ok := s.docNums[docID]
+	if !ok || s.deleted[num] {
+		return false
+	}
+	s.deleted[num] = true
+	return true
+}
+
+// Deleted returns the IDs of deleted documents.
+func (s *Segment) Deleted() []string {
+	s.RLock()
+	defer s.RUnlock()
+
+	ids := []string{}
+	for num := range s.deleted {
+		ids = append(ids, s.docs[num])
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Has reports whether a live document has a posting for word in field.
+func (s *Segment) Has(docID, field, word string) bool {
+	s.RLock()
+	defer s.RUnlock()
+
+	num, ok := s.docNums[docID]
+	if !ok || s.deleted[num] {
+		return false
+	}
+	n := sort.SearchStrings(s.terms, word)
+	if n == len(s.terms) || s.terms[n] != word {
+		return false
+	}
+
+	found := false
+	s.eachTerm(num, func(term int, f string) bool {
+		found = term == n && f == field
+		return !found && term <= n
+	})
+	return found
+}
+
+// Posting returns a live document's posting for word in field.
+func (s *Segment) Posting(docID, field, word string) (posting, bool, error) {
+	if !s.Has(docID, field, word) {
+		return posting{}, false, nil
+	}
+
+	entries, err := s.entries(sort.SearchStrings(s.terms, word))
+	if err != nil {
+		return posting{}, false, err
+	}
+	num := s.docNums[docID]
+	for _, e := range entries {
+		if e.Doc == num && e.Field == field {
+			return posting{DocID: docID, Field: field, Count: e.Count, Text: e.Text}, true, nil
+		}
+	}
+	return posting{}, false, nil
+}
+
+// Indexes returns the postings of a live document as indexes, the words are
+// found in the document's term list and only their postings are decoded.
+func (s *Segment) Indexes(docID string) (Indexes, error) {
+	ixs := Indexes{}
+	if !s.Live(docID) {
+		return ixs, nil
+	}
+
+	num := s.docNums[docID]
+	words := map[string]bool{}
+	s.eachTerm(num, func(term int, field string) bool {
+		words[s.terms[term]] = true
+		return true
+	})
+
+	for word := range words {
+		ps, err := s.Postings(word)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range ps {
+			if p.DocID == docID {
+				ixs = append(ixs, p.index(word))
+			}
+		}
+	}
+	return ixs, nil
+}
+
+// entries decodes the postings list of the nth term.
+func (s *Segment) entries(n int) ([]segmentEntry, error) {
+	d := &decoder{data: s.postings[n]}
+	count := d.uvarint()
+
+	entries := []segmentEntry{}
+	var doc uint64
+	for i := uint64(0); i < count; i++ {
+		doc += d.uvarint()
+		field := d.uvarint()
+		frequency := d.uvarint()
+		text := d.uvarint()
+		if d.err != nil {
+			return nil, d.err
+		}
+		if doc >= uint64(len(s.docs)) || field >= uint64(len(s.strs)) || text >= uint64(len(s.strs)) {
+			return nil, ErrCorruptSegment
+		}
+
+		entries = append(entries, segmentEntry{
+			Doc:   uint32(doc),
+			Field: s.strs[field],
+			Count: int64(frequency),
+			Text:  s.strs[text],
+		})
+	}
+	return entries, nil
+}
+
+// Postings decodes the postings of a term, skipping deleted documents.
+func (s *Segment) Postings(word string) ([]posting, error) {
+	n := sort.SearchStrings(s.terms, word)
+	if n == len(s.terms) || s.terms[n] != word {
+		return nil, nil
+	}
+
+	entries, err := s.entries(n)
+	if err != nil {
+		return nil, err
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	ps := []posting{}
+	for _, e := range entries {
+		if s.deleted[e.Doc] {
+			continue
+		}
+		ps = append(ps, posting{DocID: s.docs[e.Doc], Field: e.Field, Count: e.Count, Text: e.Text})
+	}
+	return ps, nil
+}
+
+// Each calls fn for every live posting in term order.
+func (s *Segment) Each(fn func(word string, p posting) error) error {
+	for _, word := range s.terms {
+		ps, err := s.Postings(word)
+		if err != nil {
+			return err
+		}
+		for _, p := range ps {
+			if err := fn(word, p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mergeSegments combines segments into a builder, dropping deleted documents.
+// Segments are given oldest first, so a later segment's posting for the same
+// document, field and word replaces an earlier one.
+func mergeSegments(segments []*Segment) (*segmentBuilder, error) {
+	b := newSegmentBuilder()
+	for _, s := range segments {
+		if err := s.Each(func(word string, p posting) error {
+			b.Add(word, p)
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// intersect returns the document IDs present in every list of postings.
+func intersect(lists [][]posting) map[string]bool {
+	if len(lists) == 0 {
+		return map[string]bool{}
+	}
+
+	sets := make([][]string, len(lists))
+	for n, list := range lists {
+		sets[n] = documentIDs(list)
+	}
+	sort.Sort(byLength(sets))
+
+	matches := sets[0]
+	for _, set := range sets[1:] {
+		matches = intersectSorted(matches, set)
+		if len(matches) == 0 {
+			break
+		}
+	}
+
+	docs := make(map[string]bool, len(matches))
+	for _, doc := range matches {
+		docs[doc] = true
+	}
+	return docs
+}
+
+// documentIDs returns the sorted, distinct document IDs of some postings.
+func documentIDs(ps []posting) []string {
+	sorted := append([]posting{}, ps...)
+	sort.Sort(byDocument(sorted))
+
+	ids := []string{}
+	for _, p := range sorted {
+		if len(ids) == 0 || ids[len(ids)-1] != p.DocID {
+			ids = append(ids, p.DocID)
+		}
+	}
+	return ids
+}
+
+// intersectSorted walks two sorted lists and keeps the values found in both.
+func intersectSorted(a, b []string) []string {
+	result := []string{}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+type byLength [][]string
+
+func (ls byLength) Len() int { return len(ls) }
+
+func (ls byLength) Swap(i, j int) { ls[i], ls[j] = ls[j], ls[i] }
+
+func (ls byLength) Less(i, j int) bool { return len(ls[i]) < len(ls[j]) }
@@ -0,0 +1,125 @@
+package miru
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSegment_EncodeDecode(t *testing.T) {
+	b := newSegmentBuilder()
+	b.Add("news", posting{DocID: "a", Field: FieldTitle, Count: 2, Text: "news"})
+	b.Add("news", posting{DocID: "b", Field: FieldContent, Count: 1, Text: "News"})
+	b.Add("world", posting{DocID: "a", Field: FieldContent, Count: 3, Text: "world"})
+
+	s, err := DecodeSegment(b.Encode())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, s.Len())
+
+	ps, err := s.Postings("news")
+	assert.NoError(t, err)
+	assert.Equal(t, []posting{
+		{DocID: "a", Field: FieldTitle, Count: 2, Text: "news"},
+		{DocID: "b", Field: FieldContent, Count: 1, Text: "News"},
+	}, ps)
+
+	ps, err = s.Postings("missing")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(ps))
+
+	assert.True(t, s.Has("a", FieldContent, "world"))
+	assert.False(t, s.Has("a", FieldTitle, "world"))
+	assert.False(t, s.Has("c", FieldContent, "world"))
+
+	s.Delete("a")
+	assert.False(t, s.Has("a", FieldContent, "world"))
+}
+
+func TestSegment_Compression(t *testing.T) {
+	b := newSegmentBuilder()
+	for n := 0; n < 1000; n++ {
+		b.Add("common", posting{DocID: string(rune('a'+n%26)) + string(rune(n)), Field: FieldContent, Count: 1, Text: "common"})
+	}
+	data := b.Encode()
+
+	s, err := DecodeSegment(data)
+	assert.NoError(t, err)
+
+	// Each posting is four single byte varints.
+	n := len(s.postings[0])
+	assert.True(t, n <= 1000*4+2, "postings took %d bytes", n)
+}
+
+func TestSegment_Forward(t *testing.T) {
+	b := newSegmentBuilder()
+	for n := 0; n < 300; n++ {
+		word := string(rune('a'+n%26)) + string(rune('a'+n/26))
+		b.Add(word, posting{DocID: "a", Field: FieldContent, Count: 1, Text: word})
+	}
+	b.Add("ab", posting{DocID: "a", Field: FieldTitle, Count: 1, Text: "ab"})
+	b.Add("ab", posting{DocID: "b", Field: FieldContent, Count: 1, Text: "ab"})
+
+	s, err := DecodeSegment(b.Encode())
+	assert.NoError(t, err)
+
+	// Each posting of a document's term list is a term delta and a field, both
+	// single byte varints.
+	assert.True(t, len(s.forward[0]) <= 301*2, "term list took %d bytes", len(s.forward[0]))
+
+	assert.True(t, s.Has("a", FieldTitle, "ab"))
+	assert.True(t, s.Has("a", FieldContent, "ab"))
+	assert.False(t, s.Has("b", FieldTitle, "ab"))
+	assert.False(t, s.Has("b", FieldContent, "zz"))
+
+	ixs, err := s.Indexes("a")
+	assert.NoError(t, err)
+	assert.Equal(t, 301, len(ixs))
+	ixs, err = s.Indexes("b")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(ixs))
+}
+
+func TestSegment_Corrupt(t *testing.T) {
+	b := newSegmentBuilder()
+	b.Add("news", posting{DocID: "a", Field: FieldContent, Count: 1, Text: "news"})
+	data := b.Encode()
+
+	data[len(data)/2] ^= 0xff
+	_, err := DecodeSegment(data)
+	assert.Equal(t, ErrCorruptSegment, err)
+
+	_, err = DecodeSegment([]byte("nope"))
+	assert.Equal(t, ErrCorruptSegment, err)
+}
+
+func TestSegment_Delete(t *testing.T) {
+	b := newSegmentBuilder()
+	b.Add("news", posting{DocID: "a", Field: FieldContent, Count: 1, Text: "news"})
+	b.Add("news", posting{DocID: "b", Field: FieldContent, Count: 1, Text: "news"})
+
+	s, _ := DecodeSegment(b.Encode())
+	assert.True(t, s.Delete("a"))
+	assert.False(t, s.Delete("a"))
+	assert.False(t, s.Delete("c"))
+	assert.Equal(t, []string{"a"}, s.Deleted())
+
+	ps, _ := s.Postings("news")
+	assert.Equal(t, 1, len(ps))
+	assert.Equal(t, "b", ps[0].DocID)
+
+	merged, err := mergeSegments([]*Segment{s})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, merged.Len())
+}
+
+func TestSegment_Intersect(t *testing.T) {
+	lists := [][]posting{
+		{{DocID: "c"}, {DocID: "a"}, {DocID: "b"}, {DocID: "a"}},
+		{{DocID: "b"}, {DocID: "c"}, {DocID: "d"}},
+		{{DocID: "c"}, {DocID: "b"}},
+	}
+
+	assert.Equal(t, map[string]bool{"b": true, "c": true}, intersect(lists))
+	assert.Equal(t, map[string]bool{}, intersect(nil))
+	assert.Equal(t, []string{"b"}, intersectSorted([]string{"a", "b"}, []string{"b", "c"}))
+}
@@ -0,0 +1,308 @@
+package miru
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	// MaxEditDistance is the furthest a suggestion can be from a misspelt word.
+	MaxEditDistance = 2
+	// PrefixLength is the number of characters at the start of a word that its
+	// deletes are made from, so long words don't have a delete for every pair
+	// of characters.
+	PrefixLength = 7
+)
+
+// Suggestion is a word from the vocabulary that is close to a misspelt word.
+type Suggestion struct {
+	Word      string
+	Distance  int
+	Frequency int64
+}
+
+type bySuggestion []Suggestion
+
+func (s bySuggestion) Len() int { return len(s) }
+
+func (s bySuggestion) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+func (s bySuggestion) Less(i, j int) bool {
+	if s[i].Distance != s[j].Distance {
+		return s[i].Distance < s[j].Distance
+	}
+	if s[i].Frequency != s[j].Frequency {
+		return s[i].Frequency > s[j].Frequency
+	}
+	return s[i].Word < s[j].Word
+}
+
+// Vocabulary holds every word that has been indexed along with how often it
+// occurs. Words are looked up using symmetric deletes: every variation of a
+// word's first PrefixLength characters with up to MaxEditDistance of them
+// removed points back to the word, so candidates for a misspelling are found by
+// generating the deletes of its own prefix.
+type Vocabulary struct {
+	words   map[string]int64
+	deletes map[string][]string
+	sync.RWMutex
+}
+
+// NewVocabulary creates an empty vocabulary.
+func NewVocabulary() *Vocabulary {
+	v := new(Vocabulary)
+	v.words = make(map[string]int64)
+	v.deletes = make(map[string][]string)
+	return v
+}
+
+// Add records count occurrences of a word.
+func (v *Vocabulary) Add(word string, count int64) {
+	if word == "" {
+		return
+	}
+
+	v.Lock()
+	defer v.Unlock()
+
+	if _, ok := v.words[word]; !ok {
+		for _, del := range prefixDeletes(word) {
+			v.deletes[del] = append(v.deletes[del], word)
+		}
+	}
+	v.words[word] += count
+}
+
+// AddIndexes records the words of a set of indexes.
+func (v *Vocabulary) AddIndexes(ixs Indexes) {
+	for _, i := range ixs {
+		v.Add(i.Text, i.Count)
+	}
+}
+
+// Remove forgets count occurrences of a word, the word is dropped once none
+// are left.
+func (v *Vocabulary) Remove(word string, count int64) {
+	v.Lock()
+	defer v.Unlock()
+
+	n, ok := v.words[word]
+	if !ok {
+		return
+	}
+	if n -= count; n > 0 {
+		v.words[word] = n
+		return
+	}
+
+	delete(v.words, word)
+	for _, del := range prefixDeletes(word) {
+		if v.deletes[del] = remove(v.deletes[del], word); len(v.deletes[del]) == 0 {
+			delete(v.deletes, del)
+		}
+	}
+}
+
+// RemoveIndexes forgets the words of a set of indexes, used when documents are
+// deleted.
+func (v *Vocabulary) RemoveIndexes(ixs Indexes) {
+	for _, i := range ixs {
+		v.Remove(i.Text, i.Count)
+	}
+}
+
+// Frequency returns the number of times a word has been seen.
+func (v *Vocabulary) Frequency(word string) int64 {
+	v.RLock()
+	defer v.RUnlock()
+
+	return v.words[word]
+}
+
+// Len returns the number of distinct words in the vocabulary.
+func (v *Vocabulary) Len() int {
+	v.RLock()
+	defer v.RUnlock()
+
+	return len(v.words)
+}
+
+// Suggest returns words within MaxEditDistance of word, closest and most
+// frequent first.
+func (v *Vocabulary) Suggest(word string) []Suggestion {
+	v.RLock()
+	defer v.RUnlock()
+
+	seen := map[string]bool{}
+	suggestions := []Suggestion{}
+
+	candidates := append(prefixDeletes(word), word)
+	for _, candidate := range candidates {
+		matches := v.deletes[candidate]
+		if _, ok := v.words[candidate]; ok {
+			matches = append(matches, candidate)
+		}
+
+		for _, match := range matches {
+			if seen[match] || match == word {
+				continue
+			}
+			seen[match] = true
+
+			distance := editDistance(word, match)
+			if distance > MaxEditDistance {
+				continue
+			}
+			suggestions = append(suggestions, Suggestion{
+				Word:      match,
+				Distance:  distance,
+				Frequency: v.words[match],
+			})
+		}
+	}
+
+	sort.Sort(bySuggestion(suggestions))
+	return suggestions
+}
+
+// Corrections returns up to limit alternative queries with each unknown word
+// replaced by a suggestion, the best alternative comes first. Stop words and
+// words that are already in the vocabulary are left alone.
+func (v *Vocabulary) Corrections(query string, a Analyzer, limit int) []string {
+	words := UnicodeTokenizer{}.Tokenize(query)
+	options := make([][]string, len(words))
+	misspelt := []int{}
+
+	for i, word := range words {
+		options[i] = []string{word}
+
+		tokens := a.Analyze(word)
+		if len(tokens) == 0 || v.Frequency(tokens[0].Text) > 0 {
+			continue
+		}
+
+		suggestions := v.Suggest(tokens[0].Text)
+		if len(suggestions) == 0 {
+			continue
+		}
+		options[i] = []string{}
+		for _, s := range suggestions {
+			options[i] = append(options[i], s.Word)
+		}
+		misspelt = append(misspelt, i)
+	}
+
+	if len(misspelt) == 0 {
+		return nil
+	}
+
+	best := make([]string, len(words))
+	for i := range words {
+		best[i] = options[i][0]
+	}
+	corrections := []string{strings.Join(best, " ")}
+
+	// Swap in the next best suggestion for one word at a time.
+	for rank := 1; len(corrections) < limit; rank++ {
+		added := false
+		for _, i := range misspelt {
+			if rank >= len(options[i]) || len(corrections) >= limit {
+				continue
+			}
+			alternative := append([]string{}, best...)
+			alternative[i] = options[i][rank]
+			corrections = append(corrections, strings.Join(alternative, " "))
+			added = true
+		}
+		if !added {
+			break
+		}
+	}
+	return corrections
+}
+
+// Load fills the vocabulary from the words already in the datastore.
+func (v *Vocabulary) Load(c *Context) error {
+	return c.Store.EachIndex(func(i *Index) error {
+		v.Add(i.Text, i.Count)
+		return nil
+	})
+}
+
+// deletes returns every variation of word with up to distance characters
+// removed.
+func deletes(word string, distance int) []string {
+	seen := map[string]bool{}
+	results := []string{}
+
+	current := []string{word}
+	for d := 0; d < distance; d++ {
+		next := []string{}
+		for _, w := range current {
+			runes := []rune(w)
+			if len(runes) <= 1 {
+				continue
+			}
+			for i := range runes {
+				del := string(runes[:i]) + string(runes[i+1:])
+				if !seen[del] {
+					seen[del] = true
+					results = append(results, del)
+					next = append(next, del)
+				}
+			}
+		}
+		current = next
+	}
+	return results
+}
+
+// prefixDeletes returns the deletes of a word's first PrefixLength characters,
+// along with the prefix itself when the word is longer.
+func prefixDeletes(word string) []string {
+	runes := []rune(word)
+	if PrefixLength <= 0 || len(runes) <= PrefixLength {
+		return deletes(word, MaxEditDistance)
+	}
+	prefix := string(runes[:PrefixLength])
+	return append(deletes(prefix, MaxEditDistance), prefix)
+}
+
+// editDistance returns the Damerau-Levenshtein (optimal string alignment)
+// distance between two words.
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	d := make([][]int, len(s)+1)
+	for i := range d {
+		d[i] = make([]int, len(t)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(s); i++ {
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+cost)
+			}
+		}
+	}
+	return d[len(s)][len(t)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
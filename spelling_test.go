@@ -0,0 +1,113 @@
+package miru
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpelling_EditDistance(t *testing.T) {
+	tests := []struct {
+		A, B     string
+		Distance int
+	}{
+		{"example", "example", 0},
+		{"exmaple", "example", 1},
+		{"exampel", "example", 1},
+		{"exampl", "example", 1},
+		{"xample", "example", 1},
+		{"kitten", "sitting", 3},
+		{"café", "cafe", 1},
+		{"", "abc", 3},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.Distance, editDistance(test.A, test.B))
+	}
+}
+
+func TestSpelling_Deletes(t *testing.T) {
+	assert.Equal(t, []string{"bc", "ac", "ab", "c", "b", "a"}, deletes("abc", 2))
+	assert.Equal(t, []string{"a"}, deletes("aa", 1))
+	assert.Equal(t, []string{}, deletes("a", 2))
+}
+
+func TestSpelling_Suggest(t *testing.T) {
+	v := NewVocabulary()
+	v.Add("example", 10)
+	v.Add("sample", 50)
+	v.Add("examples", 2)
+	v.Add("exemplar", 100)
+	v.Add("", 5)
+
+	assert.Equal(t, 4, v.Len())
+	assert.Equal(t, int64(10), v.Frequency("example"))
+
+	suggestions := v.Suggest("exmaple")
+	assert.Equal(t, []Suggestion{
+		{Word: "example", Distance: 1, Frequency: 10},
+		{Word: "examples", Distance: 2, Frequency: 2},
+	}, suggestions)
+
+	// Equally distant words are ordered by frequency.
+	suggestions = v.Suggest("xample")
+	assert.Equal(t, "sample", suggestions[0].Word)
+	assert.Equal(t, "example", suggestions[1].Word)
+
+	assert.Equal(t, 0, len(v.Suggest("zzzzzzzz")))
+}
+
+func TestSpelling_Suggest_Long(t *testing.T) {
+	v := NewVocabulary()
+	v.Add("internationalisation", 3)
+	long := strings.Repeat("a", 10000)
+	v.Add(long, 1)
+
+	// Only the deletes of a word's prefix are kept.
+	assert.Equal(t, len(deletes("interna", MaxEditDistance))+1, len(prefixDeletes("internationalisation")))
+	assert.Equal(t, []Suggestion{
+		{Word: "internationalisation", Distance: 1, Frequency: 3},
+	}, v.Suggest("internationalsation"))
+	assert.Equal(t, []Suggestion{
+		{Word: "internationalisation", Distance: 2, Frequency: 3},
+	}, v.Suggest("unternationalisaton"))
+
+	v.Remove(long, 1)
+	v.Remove("internationalisation", 3)
+	assert.Equal(t, 0, v.Len())
+	assert.Equal(t, 0, len(v.deletes))
+}
+
+func TestSpelling_Add_Frequency(t *testing.T) {
+	v := NewVocabulary()
+	v.AddIndexes(Indexes{
+		{Text: "news", Count: 2},
+		{Text: "news", Count: 3},
+		{Text: "new", Count: 1},
+	})
+
+	assert.Equal(t, int64(5), v.Frequency("news"))
+	assert.Equal(t, 2, v.Len())
+}
+
+func TestSpelling_Corrections(t *testing.T) {
+	v := NewVocabulary()
+	v.Add("quick", 10)
+	v.Add("quack", 2)
+	v.Add("brown", 10)
+	v.Add("fox", 5)
+
+	corrections := v.Corrections("the quikc brown fxo", DefaultAnalyzer, 3)
+	assert.Equal(t, []string{
+		"the quick brown fox",
+		"the quack brown fox",
+	}, corrections)
+
+	corrections = v.Corrections("the quikc brown fxo", DefaultAnalyzer, 1)
+	assert.Equal(t, []string{"the quick brown fox"}, corrections)
+
+	// Nothing to correct.
+	assert.Nil(t, v.Corrections("quick brown fox", DefaultAnalyzer, 3))
+	assert.Nil(t, v.Corrections("zzzzzzzz", DefaultAnalyzer, 3))
+}
@@ -0,0 +1,161 @@
+package miru
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Pinger is implemented by stores that can check their connection.
+type Pinger interface {
+	Ping() error
+}
+
+// SizedStore is implemented by stores that can report how many bytes they use
+// on disk or in memory.
+type SizedStore interface {
+	Size() (int64, error)
+}
+
+// CountedStore is implemented by stores that can count their documents, terms
+// and postings without each of them being read. Counts returns the statistics
+// apart from the storage size and the buffered count.
+type CountedStore interface {
+	Counts() (*Stats, error)
+}
+
+// SiteStats describes the documents of a single site.
+type SiteStats struct {
+	Documents   int       `json:"documents"`
+	LastCrawled time.Time `json:"last_crawled"`
+}
+
+// Stats describes the size of the index. AverageLength is the mean number of
+// words in a document's content, StorageBytes is only set for stores that
+// implement SizedStore.
+type Stats struct {
+	Documents     int                  `json:"documents"`
+	Sites         map[string]SiteStats `json:"sites"`
+	Terms         int                  `json:"terms"`
+	Postings      int                  `json:"postings"`
+	AverageLength float64              `json:"average_document_length"`
+	StorageBytes  int64                `json:"storage_bytes,omitempty"`
+	Buffered      int                  `json:"buffered"`
+}
+
+// NewStats builds statistics from the store's own counts, or by reading every
+// document and index in it for stores that don't implement CountedStore.
+func NewStats(c *Context) (*Stats, error) {
+	var stats *Stats
+	var err error
+	if counted, ok := c.Store.(CountedStore); ok {
+		stats, err = counted.Counts()
+	} else {
+		stats, err = documentStats(c.Store)
+		if err == nil {
+			err = indexStats(c.Store, stats)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if sized, ok := c.Store.(SizedStore); ok {
+		size, err := sized.Size()
+		if err != nil {
+			return nil, err
+		}
+		stats.StorageBytes = size
+	}
+
+	stats.Buffered = c.Writer.Len()
+	return stats, nil
+}
+
+// documentStats reads every document in a store to count them, their words
+// and the documents of each site.
+func documentStats(s Store) (*Stats, error) {
+	stats := &Stats{Sites: map[string]SiteStats{}}
+
+	words := 0
+	if err := s.EachDocument(func(d *Document) error {
+		stats.Documents++
+		words += len(strings.Fields(d.Content))
+
+		site := stats.Sites[d.Site]
+		site.Documents++
+		if d.Fetched.After(site.LastCrawled) {
+			site.LastCrawled = d.Fetched
+		}
+		stats.Sites[d.Site] = site
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if stats.Documents > 0 {
+		stats.AverageLength = float64(words) / float64(stats.Documents)
+	}
+	return stats, nil
+}
+
+// indexStats reads every index in a store to count its terms and postings.
+func indexStats(s Store, stats *Stats) error {
+	terms := map[string]bool{}
+	if err := s.EachIndex(func(i *Index) error {
+		stats.Postings++
+		terms[i.Word] = true
+		return nil
+	}); err != nil {
+		return err
+	}
+	stats.Terms = len(terms)
+	return nil
+}
+
+// StatsCache keeps the statistics built by NewStats for MaxAge, so the whole
+// store is only read again once they are that old. The number of buffered
+// documents is always current.
+type StatsCache struct {
+	MaxAge time.Duration
+
+	stats *Stats
+	built time.Time
+	sync.Mutex
+}
+
+// NewStatsCache creates an empty cache keeping statistics for maxAge.
+func NewStatsCache(maxAge time.Duration) *StatsCache {
+	return &StatsCache{MaxAge: maxAge}
+}
+
+// Get returns the cached statistics of a context's store, building them if
+// there are none or they are older than MaxAge.
+func (sc *StatsCache) Get(c *Context) (*Stats, error) {
+	sc.Lock()
+	defer sc.Unlock()
+
+	if sc.stats == nil || time.Since(sc.built) >= sc.MaxAge {
+		stats, err := NewStats(c)
+		if err != nil {
+			return nil, err
+		}
+		sc.stats = stats
+		sc.built = time.Now()
+	}
+
+	stats := *sc.stats
+	stats.Buffered = c.Writer.Len()
+	return &stats, nil
+}
+
+// Ping checks the store can be reached, stores that don't implement Pinger are
+// assumed to be reachable once opened.
+func (c *Context) Ping() error {
+	if c.Store == nil {
+		return ErrNotConnected
+	}
+	if pinger, ok := c.Store.(Pinger); ok {
+		return pinger.Ping()
+	}
+	return nil
+}
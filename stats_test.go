@@ -0,0 +1,98 @@
+package miru
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type pingStore struct {
+	Store
+	err error
+}
+
+func (s pingStore) Ping() error {
+	return s.err
+}
+
+func TestStats_NewStats(t *testing.T) {
+	defer TearDown(_ctx)
+
+	docs := putPages(_ctx, "http://example.com/", "http://example.com/about", "http://example.org/")
+	_ctx.Writer.Flush()
+	putPages(_ctx, "http://example.net/")
+
+	stats, err := NewStats(_ctx)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 3, stats.Documents)
+	assert.Equal(t, 2, stats.Sites["example.com"].Documents)
+	assert.Equal(t, docs[1].Fetched.Unix(), stats.Sites["example.com"].LastCrawled.Unix())
+	assert.Equal(t, 2.0, stats.AverageLength)
+	assert.Equal(t, 1, stats.Buffered)
+	assert.True(t, stats.StorageBytes > 0)
+
+	terms := map[string]bool{}
+	for _, i := range storedIndexes(_ctx) {
+		terms[i.Word] = true
+	}
+	assert.Equal(t, len(terms), stats.Terms)
+	assert.Equal(t, len(storedIndexes(_ctx)), stats.Postings)
+}
+
+func TestStats_Counts(t *testing.T) {
+	defer TearDown(_ctx)
+
+	putPages(_ctx, "http://example.com/", "http://example.com/about", "http://example.org/")
+	_ctx.Writer.Flush()
+	assert.NoError(t, _ctx.Store.DeleteDocument(storedIndexes(_ctx)[0].DocID))
+
+	// The embedded store's counts match those read from every index.
+	counted, err := _ctx.Store.(CountedStore).Counts()
+	assert.NoError(t, err)
+	scanned, err := documentStats(_ctx.Store)
+	assert.NoError(t, err)
+	assert.NoError(t, indexStats(_ctx.Store, scanned))
+	assert.Equal(t, scanned, counted)
+	assert.Equal(t, 2, counted.Documents)
+}
+
+func TestStats_Ping(t *testing.T) {
+	ctx := NewContext()
+	assert.Equal(t, ErrNotConnected, ctx.Ping())
+
+	ctx.Store = NewMemoryStore()
+	assert.NoError(t, ctx.Ping())
+
+	ctx.Store = pingStore{ctx.Store, errors.New("Down.")}
+	assert.Error(t, ctx.Ping())
+}
+
+func TestStats_StatsCache(t *testing.T) {
+	ctx := writerContext()
+	defer ctx.Writer.Close()
+	cache := NewStatsCache(time.Hour)
+
+	putPages(ctx, "http://example.com/")
+	ctx.Writer.Flush()
+	stats, err := cache.Get(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stats.Documents)
+
+	// Documents written since are only counted once the stats are rebuilt,
+	// the buffered count is always current.
+	putPages(ctx, "http://example.com/about")
+	ctx.Writer.Flush()
+	putPages(ctx, "http://example.com/contact")
+	stats, err = cache.Get(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stats.Documents)
+	assert.Equal(t, 1, stats.Buffered)
+
+	cache.MaxAge = 0
+	stats, err = cache.Get(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, stats.Documents)
+}
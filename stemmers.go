@@ -0,0 +1,378 @@
+package miru
+
+import "strings"
+
+// The stemmers in this file are simplified versions of the snowball stemmers
+// for each language. Words are folded before they reach a stemmer so the rules
+// are written without accents.
+
+func isVowel(b byte) bool {
+	return strings.IndexByte("aeiouy", b) >= 0
+}
+
+// region returns the position following the first non-vowel that comes after
+// a vowel, searching from start.
+func region(word string, start int) int {
+	for i := start + 1; i < len(word); i++ {
+		if !isVowel(word[i]) && isVowel(word[i-1]) {
+			return i + 1
+		}
+	}
+	return len(word)
+}
+
+// regions returns the start of the R1 and R2 regions of a word.
+func regions(word string) (int, int) {
+	r1 := region(word, 0)
+	return r1, region(word, r1)
+}
+
+// longestSuffix returns the longest of suffixes that word ends with.
+func longestSuffix(word string, suffixes ...string) string {
+	found := ""
+	for _, suffix := range suffixes {
+		if len(suffix) > len(found) && strings.HasSuffix(word, suffix) {
+			found = suffix
+		}
+	}
+	return found
+}
+
+// inRegion reports whether a suffix of word falls inside the region that
+// starts at pos.
+func inRegion(word, suffix string, pos int) bool {
+	return len(word)-len(suffix) >= pos
+}
+
+func trimSuffix(word, suffix string) string {
+	return word[:len(word)-len(suffix)]
+}
+
+// preceding returns the byte before a suffix, or zero if there is none.
+func preceding(word, suffix string) byte {
+	if i := len(word) - len(suffix) - 1; i >= 0 {
+		return word[i]
+	}
+	return 0
+}
+
+// SpanishStemmer stems Spanish words.
+type SpanishStemmer struct{}
+
+var spanishSuffixes = []string{
+	"anza", "anzas", "ico", "ica", "icos", "icas", "ismo", "ismos", "able",
+	"ables", "ible", "ibles", "ista", "istas", "oso", "osa", "osos", "osas",
+	"amiento", "amientos", "imiento", "imientos", "adora", "ador", "acion",
+	"adoras", "adores", "aciones", "ante", "antes", "ancia", "ancias", "logia",
+	"logias", "ucion", "uciones", "encia", "encias", "amente", "mente",
+	"idad", "idades", "iva", "ivo", "ivas", "ivos",
+}
+
+var spanishVerbs = []string{
+	"arian", "arias", "aran", "aras", "ariais", "aria", "areis", "ariamos",
+	"aremos", "ara", "are", "erian", "erias", "eran", "eras", "eriais",
+	"eria", "ereis", "eriamos", "eremos", "era", "ere", "irian", "irias",
+	"iran", "iras", "iriais", "iria", "ireis", "iriamos", "iremos", "ira",
+	"ire", "aba", "ada", "ida", "ia", "iera", "ad", "ed", "id", "ase",
+	"iese", "aste", "iste", "an", "aban", "ian", "ieran", "asen", "iesen",
+	"aron", "ieron", "ado", "ido", "ando", "iendo", "ar", "er", "ir", "as",
+	"abas", "adas", "idas", "ias", "ieras", "ases", "ieses", "is", "ais",
+	"abais", "iais", "arais", "ierais", "aseis", "ieseis", "asteis",
+	"isteis", "ados", "idos", "amos", "abamos", "iamos", "imos", "aramos",
+	"ieramos", "iesemos", "asemos", "en", "es", "eis", "emos",
+}
+
+// spanishRV returns the start of the RV region of a Spanish word.
+func spanishRV(word string) int {
+	if len(word) < 2 {
+		return len(word)
+	}
+	if !isVowel(word[1]) {
+		for i := 2; i < len(word); i++ {
+			if isVowel(word[i]) {
+				return i + 1
+			}
+		}
+		return len(word)
+	}
+	if isVowel(word[0]) {
+		for i := 2; i < len(word); i++ {
+			if !isVowel(word[i]) {
+				return i + 1
+			}
+		}
+		return len(word)
+	}
+	return 3
+}
+
+// Stem stems a Spanish word.
+func (SpanishStemmer) Stem(word string) string {
+	rv := spanishRV(word)
+	r1, r2 := regions(word)
+
+	stemmed := word
+	switch s := longestSuffix(word, spanishSuffixes...); s {
+	case "":
+	case "logia", "logias":
+		if inRegion(word, s, r2) {
+			stemmed = trimSuffix(word, s) + "log"
+		}
+	case "ucion", "uciones":
+		if inRegion(word, s, r2) {
+			stemmed = trimSuffix(word, s) + "u"
+		}
+	case "encia", "encias":
+		if inRegion(word, s, r2) {
+			stemmed = trimSuffix(word, s) + "ente"
+		}
+	case "amente":
+		if inRegion(word, s, r1) {
+			stemmed = trimSuffix(word, s)
+		}
+	default:
+		if inRegion(word, s, r2) {
+			stemmed = trimSuffix(word, s)
+		}
+	}
+
+	if stemmed == word {
+		if s := longestSuffix(word, spanishVerbs...); s != "" && inRegion(word, s, rv) {
+			stemmed = trimSuffix(word, s)
+		}
+	}
+
+	if s := longestSuffix(stemmed, "os", "a", "o", "e"); s != "" && inRegion(stemmed, s, rv) {
+		stemmed = trimSuffix(stemmed, s)
+	}
+	return stemmed
+}
+
+// FrenchStemmer stems French words.
+type FrenchStemmer struct{}
+
+var frenchSuffixes = []string{
+	"ance", "ique", "isme", "able", "iste", "eux", "ances", "iques",
+	"ismes", "ables", "istes", "atrice", "ateur", "ation", "atrices",
+	"ateurs", "ations", "logie", "logies", "usion", "ution", "usions",
+	"utions", "ence", "ences", "ement", "ements", "ite", "ites", "if",
+	"ive", "ifs", "ives", "eaux", "aux", "euse", "euses", "issement",
+	"issements", "amment", "emment", "ment", "ments",
+}
+
+var frenchIVerbs = []string{
+	"ie", "ies", "ir", "ira", "irai", "iraient", "irais", "irait", "iras",
+	"irent", "irez", "iriez", "irions", "irons", "iront", "is", "issaient",
+	"issais", "issait", "issant", "issante", "issantes", "issants", "isse",
+	"issent", "isses", "issez", "issiez", "issions", "issons", "it",
+}
+
+var frenchVerbs = []string{
+	"ions", "e", "ee", "ees", "es", "er", "erai", "eraient", "erais",
+	"erait", "eras", "erez", "eriez", "erions", "erons", "eront", "ez",
+	"iez", "a", "ai", "aient", "ais", "ait", "ant", "ante", "antes", "ants",
+	"as", "asse", "assent", "asses", "assiez", "assions",
+}
+
+// frenchRV returns the start of the RV region of a French word.
+func frenchRV(word string) int {
+	if len(word) >= 2 && isVowel(word[0]) && isVowel(word[1]) {
+		return 3
+	}
+	for i := 1; i < len(word); i++ {
+		if isVowel(word[i]) {
+			return i + 1
+		}
+	}
+	return len(word)
+}
+
+// Stem stems a French word.
+func (FrenchStemmer) Stem(word string) string {
+	rv := frenchRV(word)
+	r1, r2 := regions(word)
+
+	stemmed := word
+	switch s := longestSuffix(word, frenchSuffixes...); s {
+	case "":
+	case "logie", "logies":
+		if inRegion(word, s, r2) {
+			stemmed = trimSuffix(word, s) + "log"
+		}
+	case "usion", "ution", "usions", "utions":
+		if inRegion(word, s, r2) {
+			stemmed = trimSuffix(word, s) + "u"
+		}
+	case "ence", "ences":
+		if inRegion(word, s, r2) {
+			stemmed = trimSuffix(word, s) + "ent"
+		}
+	case "ement", "ements":
+		if inRegion(word, s, rv) {
+			stemmed = trimSuffix(word, s)
+		}
+	case "eaux":
+		stemmed = trimSuffix(word, s) + "eau"
+	case "aux":
+		if inRegion(word, s, r1) {
+			stemmed = trimSuffix(word, s) + "al"
+		}
+	case "euse", "euses":
+		if inRegion(word, s, r2) {
+			stemmed = trimSuffix(word, s)
+		} else if inRegion(word, s, r1) {
+			stemmed = trimSuffix(word, s) + "eux"
+		}
+	case "issement", "issements":
+		if inRegion(word, s, r1) && !isVowel(preceding(word, s)) {
+			stemmed = trimSuffix(word, s)
+		}
+	case "amment":
+		if inRegion(word, s, rv) {
+			stemmed = trimSuffix(word, s) + "ant"
+		}
+	case "emment":
+		if inRegion(word, s, rv) {
+			stemmed = trimSuffix(word, s) + "ent"
+		}
+	case "ment", "ments":
+		if inRegion(word, s, rv) && isVowel(preceding(word, s)) {
+			stemmed = trimSuffix(word, s)
+		}
+	default:
+		if inRegion(word, s, r2) {
+			stemmed = trimSuffix(word, s)
+		}
+	}
+
+	if stemmed == word {
+		s := longestSuffix(word, frenchIVerbs...)
+		if s != "" && inRegion(word, s, rv) && !isVowel(preceding(word, s)) {
+			stemmed = trimSuffix(word, s)
+		}
+	}
+
+	if stemmed == word {
+		s := longestSuffix(word, frenchVerbs...)
+		switch {
+		case s == "":
+		case s == "ions":
+			if inRegion(word, s, r2) {
+				stemmed = trimSuffix(word, s)
+			}
+		case inRegion(word, s, rv):
+			stemmed = trimSuffix(word, s)
+			if s[0] == 'a' && strings.HasSuffix(stemmed, "e") && inRegion(stemmed, "e", rv) {
+				stemmed = trimSuffix(stemmed, "e")
+			}
+		}
+	}
+
+	if stemmed == word {
+		stemmed = frenchResidual(word, rv, r2)
+	}
+
+	// Undouble the endings left over from removing a suffix.
+	if s := longestSuffix(stemmed, "enn", "onn", "ett", "ell", "eill"); s != "" {
+		stemmed = stemmed[:len(stemmed)-1]
+	}
+	return stemmed
+}
+
+func frenchResidual(word string, rv, r2 int) string {
+	if strings.HasSuffix(word, "s") && strings.IndexByte("aiouses", preceding(word, "s")) < 0 {
+		word = trimSuffix(word, "s")
+	}
+
+	switch s := longestSuffix(word, "ion", "ier", "iere", "e"); s {
+	case "ion":
+		if inRegion(word, s, rv) && inRegion(word, s, r2) && strings.IndexByte("st", preceding(word, s)) >= 0 {
+			word = trimSuffix(word, s)
+		}
+	case "ier", "iere":
+		if inRegion(word, s, rv) {
+			word = trimSuffix(word, s) + "i"
+		}
+	case "e":
+		if inRegion(word, s, rv) {
+			word = trimSuffix(word, s)
+		}
+	}
+	return word
+}
+
+// GermanStemmer stems German words.
+type GermanStemmer struct{}
+
+// Stem stems a German word.
+func (GermanStemmer) Stem(word string) string {
+	r1, r2 := regions(word)
+	if r1 < 3 {
+		r1 = 3
+	}
+	if r1 > len(word) {
+		r1 = len(word)
+	}
+
+	switch s := longestSuffix(word, "em", "ern", "er", "e", "en", "es", "s"); s {
+	case "":
+	case "s":
+		if inRegion(word, s, r1) && strings.IndexByte("bdfghklmnrt", preceding(word, s)) >= 0 {
+			word = trimSuffix(word, s)
+		}
+	case "e", "en", "es":
+		if inRegion(word, s, r1) {
+			word = trimSuffix(word, s)
+			if strings.HasSuffix(word, "niss") {
+				word = trimSuffix(word, "s")
+			}
+		}
+	default:
+		if inRegion(word, s, r1) {
+			word = trimSuffix(word, s)
+		}
+	}
+
+	switch s := longestSuffix(word, "en", "er", "est", "st"); s {
+	case "":
+	case "st":
+		if inRegion(word, s, r1) && len(word) > 5 &&
+			strings.IndexByte("bdfghklmnt", preceding(word, s)) >= 0 {
+			word = trimSuffix(word, s)
+		}
+	default:
+		if inRegion(word, s, r1) {
+			word = trimSuffix(word, s)
+		}
+	}
+
+	switch s := longestSuffix(word, "end", "ung", "ig", "ik", "isch", "lich", "heit", "keit"); s {
+	case "":
+	case "end", "ung":
+		if inRegion(word, s, r2) {
+			word = trimSuffix(word, s)
+			if strings.HasSuffix(word, "ig") && inRegion(word, "ig", r2) && preceding(word, "ig") != 'e' {
+				word = trimSuffix(word, "ig")
+			}
+		}
+	case "ig", "ik", "isch":
+		if inRegion(word, s, r2) && preceding(word, s) != 'e' {
+			word = trimSuffix(word, s)
+		}
+	case "lich", "heit":
+		if inRegion(word, s, r2) {
+			word = trimSuffix(word, s)
+			if e := longestSuffix(word, "er", "en"); e != "" && inRegion(word, e, r1) {
+				word = trimSuffix(word, e)
+			}
+		}
+	case "keit":
+		if inRegion(word, s, r2) {
+			word = trimSuffix(word, s)
+			if e := longestSuffix(word, "lich", "ig"); e != "" && inRegion(word, e, r2) {
+				word = trimSuffix(word, e)
+			}
+		}
+	}
+	return word
+}
@@ -0,0 +1,74 @@
+package miru
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStemmers_Regions(t *testing.T) {
+	r1, r2 := regions("beautiful")
+	assert.Equal(t, 5, r1)
+	assert.Equal(t, 7, r2)
+
+	r1, r2 = regions("beau")
+	assert.Equal(t, 4, r1)
+	assert.Equal(t, 4, r2)
+}
+
+func TestStemmers_SpanishStemmer(t *testing.T) {
+	tests := []struct {
+		Input  string
+		Output string
+	}{
+		{"bibliotecas", "bibliotec"},
+		{"felizmente", "feliz"},
+		{"habitaciones", "habit"},
+		{"rapidamente", "rapid"},
+		{"cantaban", "cant"},
+		{"casas", "cas"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.Output, SpanishStemmer{}.Stem(test.Input))
+	}
+}
+
+func TestStemmers_FrenchStemmer(t *testing.T) {
+	tests := []struct {
+		Input  string
+		Output string
+	}{
+		{"continuation", "continu"},
+		{"nationales", "national"},
+		{"maisons", "maison"},
+		{"rapidement", "rapid"},
+		{"finissons", "fin"},
+		{"mangeaient", "mang"},
+		{"chevaux", "cheval"},
+		{"voitures", "voitur"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.Output, FrenchStemmer{}.Stem(test.Input))
+	}
+}
+
+func TestStemmers_GermanStemmer(t *testing.T) {
+	tests := []struct {
+		Input  string
+		Output string
+	}{
+		{"hauser", "haus"},
+		{"kinder", "kind"},
+		{"freundlichkeit", "freundlich"},
+		{"zeitungen", "zeitung"},
+		{"ergebnisse", "ergebnis"},
+		{"katzen", "katz"},
+		{"haus", "haus"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.Output, GermanStemmer{}.Stem(test.Input))
+	}
+}
@@ -0,0 +1,134 @@
+package miru
+
+import "errors"
+
+// Database drivers.
+const (
+	DriverRethinkDB = "rethinkdb"
+	DriverEmbedded  = "embedded"
+	DriverMemory    = "memory"
+	DriverSegment   = "segment"
+)
+
+var (
+	// ErrDuplicateKey for when a document or index with the same ID has
+	// already been stored.
+	ErrDuplicateKey = errors.New("Duplicate primary key.")
+	// ErrNotFound for when a key doesn't exist.
+	ErrNotFound = errors.New("Key not found.")
+	// ErrUnknownDriver for when the configured database driver doesn't exist.
+	ErrUnknownDriver = errors.New("Database driver does not exist.")
+	// ErrNotConnected for when the context has no store.
+	ErrNotConnected = errors.New("Database is not connected.")
+)
+
+// Store persists documents, their indexes, the links between them, the media
+// found on them, their raw content and the feeds being polled. Writes fail with
+// ErrDuplicateKey if the ID already exists.
+type Store interface {
+	// PutDocument writes a single document.
+	PutDocument(d *Document) error
+	// PutDocuments writes a set of documents in one go, nothing is written if
+	// any of their IDs is already stored.
+	PutDocuments(docs []*Document) error
+	// Document returns a single document, or ErrNotFound.
+	Document(id string) (*Document, error)
+	// DocumentByURL returns the most recently fetched document with a URL, or
+	// ErrNotFound.
+	DocumentByURL(url string) (*Document, error)
+	// SiteDocuments returns up to limit of a site's documents ordered by URL,
+	// starting at offset, along with the total number the site has.
+	SiteDocuments(site string, offset, limit int) ([]*Document, int, error)
+	// PutIndexes writes a set of indexes, indexes that don't clash are still
+	// written when one does.
+	PutIndexes(ixs Indexes) error
+	// AddIndexes adds the count of each index to the stored index with the same
+	// ID and writes the rest, returning the indexes that were new.
+	AddIndexes(ixs Indexes) (Indexes, error)
+	// DocumentIndexes returns every index of a document.
+	DocumentIndexes(docID string) (Indexes, error)
+	// Lookup returns a row for each index of the given words joined with its
+	// document, only documents passing the filter are included.
+	Lookup(words []string, f *Filter) ([]Result, error)
+	// Sites returns every distinct site in sorted order.
+	Sites() ([]string, error)
+	// PutLinks writes a set of links, links that don't clash are still written
+	// when one does.
+	PutLinks(links []*Link) error
+	// LinksTo returns every link whose target is url.
+	LinksTo(url string) ([]*Link, error)
+	// LinksFrom returns every link on a document.
+	LinksFrom(docID string) ([]*Link, error)
+	// PutMedia writes a set of media, media that don't clash are still written
+	// when one does.
+	PutMedia(media []*Media) error
+	// LookupMedia returns the media with any of the given words.
+	LookupMedia(words []string) ([]*Media, error)
+	// DocumentMedia returns every media found on a document.
+	DocumentMedia(docID string) ([]*Media, error)
+	// PutRaw writes the raw content of a document.
+	PutRaw(r *Raw) error
+	// Raw returns the raw content of a document, or ErrNotFound.
+	Raw(docID string) (*Raw, error)
+	// PutSubscription writes a feed subscription, replacing one stored for the
+	// same feed.
+	PutSubscription(sub *Subscription) error
+	// DeleteSubscription removes the subscription to a feed.
+	DeleteSubscription(url string) error
+	// DeleteDocument removes a document along with its indexes, the links from
+	// it, its media and its raw content.
+	DeleteDocument(id string) error
+	// EachDocument calls fn for every document until fn returns an error.
+	EachDocument(fn func(d *Document) error) error
+	// EachIndex calls fn for every index until fn returns an error.
+	EachIndex(fn func(i *Index) error) error
+	// EachLink calls fn for every link until fn returns an error.
+	EachLink(fn func(l *Link) error) error
+	// EachMedia calls fn for every media until fn returns an error.
+	EachMedia(fn func(m *Media) error) error
+	// EachSubscription calls fn for every subscription until fn returns an
+	// error.
+	EachSubscription(fn func(sub *Subscription) error) error
+	// Close releases the store's resources.
+	Close() error
+}
+
+// AllStore is implemented by stores that can find documents containing every
+// word themselves.
+type AllStore interface {
+	LookupAll(words []string, f *Filter) ([]Result, error)
+}
+
+// LookupAll returns the rows of documents that contain every word. Stores that
+// don't implement AllStore have the rows of their Lookup filtered instead.
+func LookupAll(s Store, words []string, f *Filter) ([]Result, error) {
+	if all, ok := s.(AllStore); ok {
+		return all.LookupAll(words, f)
+	}
+
+	rows, err := s.Lookup(words, f)
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[string]map[string]bool{}
+	for _, row := range rows {
+		if found[row.Document.DocID] == nil {
+			found[row.Document.DocID] = map[string]bool{}
+		}
+		found[row.Document.DocID][row.Word] = true
+	}
+
+	distinct := map[string]bool{}
+	for _, word := range words {
+		distinct[word] = true
+	}
+
+	matches := []Result{}
+	for _, row := range rows {
+		if len(found[row.Document.DocID]) == len(distinct) {
+			matches = append(matches, row)
+		}
+	}
+	return matches, nil
+}
@@ -0,0 +1,805 @@
+package miru
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+)
+
+// Key prefixes used by the embedded store.
+const (
+	documentPrefix = "doc/"
+	indexPrefix    = "idx/"
+	linkPrefix     = "link/"
+	mediaPrefix    = "media/"
+	rawPrefix      = "raw/"
+	feedPrefix     = "feed/"
+)
+
+// EmbeddedStore stores documents, indexes, links, media, raw content and feed
+// subscriptions as JSON in a key-value store. The word, document, site, URL,
+// link and media lookups that RethinkDB provides with secondary indexes are
+// held in memory and rebuilt when the store is opened.
+type EmbeddedStore struct {
+	kv KV
+
+	words   map[string][]string
+	docs    map[string][]string
+	sites   map[string]map[string]string
+	urls    map[string]map[string]bool
+	indexes map[string]*Index
+	links   map[string][]string
+	targets map[string][]string
+	media   map[string][]string
+	images  map[string][]string
+	sync.RWMutex
+}
+
+// OpenEmbeddedStore opens a store in a log file at path, creating it if it
+// doesn't exist.
+func OpenEmbeddedStore(path string) (*EmbeddedStore, error) {
+	kv, err := OpenLogKV(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := NewEmbeddedStore(kv)
+	if err != nil {
+		kv.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewEmbeddedStore creates a store on top of a key-value store, reading any
+// existing indexes to build the lookup tables.
+func NewEmbeddedStore(kv KV) (*EmbeddedStore, error) {
+	s := &EmbeddedStore{
+		kv:      kv,
+		words:   make(map[string][]string),
+		docs:    make(map[string][]string),
+		sites:   make(map[string]map[string]string),
+		urls:    make(map[string]map[string]bool),
+		indexes: make(map[string]*Index),
+		links:   make(map[string][]string),
+		targets: make(map[string][]string),
+		media:   make(map[string][]string),
+		images:  make(map[string][]string),
+	}
+
+	if err := s.each(documentPrefix, func(data []byte) error {
+		d := new(Document)
+		if err := json.Unmarshal(data, d); err != nil {
+			return err
+		}
+		s.trackDocument(d)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := s.each(indexPrefix, func(data []byte) error {
+		i := new(Index)
+		if err := json.Unmarshal(data, i); err != nil {
+			return err
+		}
+		s.track(i)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := s.each(linkPrefix, func(data []byte) error {
+		l := new(Link)
+		if err := json.Unmarshal(data, l); err != nil {
+			return err
+		}
+		s.trackLink(l)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := s.each(mediaPrefix, func(data []byte) error {
+		m := new(Media)
+		if err := json.Unmarshal(data, m); err != nil {
+			return err
+		}
+		s.trackMedia(m)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// trackDocument adds a document to the site and URL lookup tables, a site's
+// table maps the IDs of its documents to their URLs.
+func (s *EmbeddedStore) trackDocument(d *Document) {
+	if s.sites[d.Site] == nil {
+		s.sites[d.Site] = map[string]string{}
+	}
+	s.sites[d.Site][d.DocID] = d.Url
+
+	if s.urls[d.Url] == nil {
+		s.urls[d.Url] = map[string]bool{}
+	}
+	s.urls[d.Url][d.DocID] = true
+}
+
+// untrackDocument removes a document from the site and URL lookup tables.
+func (s *EmbeddedStore) untrackDocument(d *Document) {
+	if delete(s.sites[d.Site], d.DocID); len(s.sites[d.Site]) == 0 {
+		delete(s.sites, d.Site)
+	}
+	if delete(s.urls[d.Url], d.DocID); len(s.urls[d.Url]) == 0 {
+		delete(s.urls, d.Url)
+	}
+}
+
+// trackLink adds a link to the source and target lookup tables.
+func (s *EmbeddedStore) trackLink(l *Link) {
+	s.links[l.Source] = append(s.links[l.Source], l.LinkID)
+	s.targets[l.Target] = append(s.targets[l.Target], l.LinkID)
+}
+
+// trackMedia adds media to the document and word lookup tables.
+func (s *EmbeddedStore) trackMedia(m *Media) {
+	s.media[m.DocID] = append(s.media[m.DocID], m.MediaID)
+	for _, word := range m.Words {
+		s.images[word] = append(s.images[word], m.MediaID)
+	}
+}
+
+// track adds an index to the lookup tables.
+func (s *EmbeddedStore) track(i *Index) {
+	s.words[i.Word] = append(s.words[i.Word], i.IndexID)
+	s.docs[i.DocID] = append(s.docs[i.DocID], i.IndexID)
+	s.indexes[i.IndexID] = i
+}
+
+// each calls fn with the value of every key starting with prefix.
+func (s *EmbeddedStore) each(prefix string, fn func(data []byte) error) error {
+	keys, err := s.kv.Keys(prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		data, err := s.kv.Get(key)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *EmbeddedStore) link(id string) (*Link, error) {
+	data, err := s.kv.Get(linkPrefix + id)
+	if err != nil {
+		return nil, err
+	}
+
+	l := new(Link)
+	if err := json.Unmarshal(data, l); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (s *EmbeddedStore) mediaItem(id string) (*Media, error) {
+	data, err := s.kv.Get(mediaPrefix + id)
+	if err != nil {
+		return nil, err
+	}
+
+	m := new(Media)
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (s *EmbeddedStore) document(id string) (*Document, error) {
+	data, err := s.kv.Get(documentPrefix + id)
+	if err != nil {
+		return nil, err
+	}
+
+	d := new(Document)
+	if err := json.Unmarshal(data, d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// PutDocument writes a document under its ID.
+func (s *EmbeddedStore) PutDocument(d *Document) error {
+	s.Lock()
+	defer s.Unlock()
+
+	key := documentPrefix + d.DocID
+	if _, err := s.kv.Get(key); err == nil {
+		return ErrDuplicateKey
+	}
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	if err := s.kv.Put(key, data); err != nil {
+		return err
+	}
+	s.trackDocument(d)
+	return nil
+}
+
+// PutDocuments writes each document under its ID, returning ErrDuplicateKey
+// without writing any of them if an ID was already stored.
+func (s *EmbeddedStore) PutDocuments(docs []*Document) error {
+	s.Lock()
+	defer s.Unlock()
+
+	values := make([][]byte, len(docs))
+	for n, d := range docs {
+		if _, err := s.kv.Get(documentPrefix + d.DocID); err == nil {
+			return ErrDuplicateKey
+		}
+
+		data, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		values[n] = data
+	}
+
+	for n, d := range docs {
+		if err := s.kv.Put(documentPrefix+d.DocID, values[n]); err != nil {
+			return err
+		}
+		s.trackDocument(d)
+	}
+	return nil
+}
+
+// Document reads a document by its ID.
+func (s *EmbeddedStore) Document(id string) (*Document, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.document(id)
+}
+
+// DocumentByURL reads the most recently fetched document with a URL.
+func (s *EmbeddedStore) DocumentByURL(url string) (*Document, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	var latest *Document
+	for id := range s.urls[url] {
+		d, err := s.document(id)
+		if err != nil {
+			return nil, err
+		}
+		if latest == nil || d.Fetched.After(latest.Fetched) {
+			latest = d
+		}
+	}
+	if latest == nil {
+		return nil, ErrNotFound
+	}
+	return latest, nil
+}
+
+// SiteDocuments returns a page of a site's documents ordered by URL, only the
+// documents on the page are read.
+func (s *EmbeddedStore) SiteDocuments(site string, offset, limit int) ([]*Document, int, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	keys := []docURL{}
+	for id, url := range s.sites[site] {
+		keys = append(keys, docURL{id: id, url: url})
+	}
+	sort.Sort(byURL(keys))
+
+	total := len(keys)
+	if offset > total {
+		offset = total
+	}
+	keys = keys[offset:]
+	if limit >= 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	docs := make([]*Document, len(keys))
+	for n, key := range keys {
+		d, err := s.document(key.id)
+		if err != nil {
+			return nil, 0, err
+		}
+		docs[n] = d
+	}
+	return docs, total, nil
+}
+
+// PutIndexes writes each index under its ID, returning ErrDuplicateKey after
+// writing the rest if any ID was already stored.
+func (s *EmbeddedStore) PutIndexes(ixs Indexes) error {
+	s.Lock()
+	defer s.Unlock()
+
+	var duplicate error
+	for _, i := range ixs {
+		if _, ok := s.indexes[i.IndexID]; ok {
+			duplicate = ErrDuplicateKey
+			continue
+		}
+
+		data, err := json.Marshal(i)
+		if err != nil {
+			return err
+		}
+		if err := s.kv.Put(indexPrefix+i.IndexID, data); err != nil {
+			return err
+		}
+
+		stored := *i
+		s.track(&stored)
+	}
+	return duplicate
+}
+
+// AddIndexes adds the count of each index to the stored index with its ID, or
+// writes it if there isn't one.
+func (s *EmbeddedStore) AddIndexes(ixs Indexes) (Indexes, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	added := Indexes{}
+	for _, i := range ixs {
+		stored := *i
+		old, ok := s.indexes[i.IndexID]
+		if ok {
+			stored = *old
+			stored.Count += i.Count
+		}
+
+		data, err := json.Marshal(&stored)
+		if err != nil {
+			return added, err
+		}
+		if err := s.kv.Put(indexPrefix+i.IndexID, data); err != nil {
+			return added, err
+		}
+
+		if ok {
+			s.indexes[i.IndexID] = &stored
+		} else {
+			s.track(&stored)
+			added = append(added, i)
+		}
+	}
+	return added, nil
+}
+
+// DocumentIndexes returns copies of a document's indexes from the lookup
+// tables.
+func (s *EmbeddedStore) DocumentIndexes(docID string) (Indexes, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	ixs := Indexes{}
+	for _, id := range s.docs[docID] {
+		i := *s.indexes[id]
+		ixs = append(ixs, &i)
+	}
+	return ixs, nil
+}
+
+// PutLinks writes each link under its ID, returning ErrDuplicateKey after
+// writing the rest if any ID was already stored.
+func (s *EmbeddedStore) PutLinks(links []*Link) error {
+	s.Lock()
+	defer s.Unlock()
+
+	var duplicate error
+	for _, l := range links {
+		key := linkPrefix + l.LinkID
+		if _, err := s.kv.Get(key); err == nil {
+			duplicate = ErrDuplicateKey
+			continue
+		}
+
+		data, err := json.Marshal(l)
+		if err != nil {
+			return err
+		}
+		if err := s.kv.Put(key, data); err != nil {
+			return err
+		}
+		s.trackLink(l)
+	}
+	return duplicate
+}
+
+// LinksTo reads every link with a target URL.
+func (s *EmbeddedStore) LinksTo(url string) ([]*Link, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	links := []*Link{}
+	for _, id := range s.targets[url] {
+		l, err := s.link(id)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	return links, nil
+}
+
+// LinksFrom reads the links on a document.
+func (s *EmbeddedStore) LinksFrom(docID string) ([]*Link, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	links := []*Link{}
+	for _, id := range s.links[docID] {
+		l, err := s.link(id)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	return links, nil
+}
+
+// PutMedia writes each media under its ID, returning ErrDuplicateKey after
+// writing the rest if any ID was already stored.
+func (s *EmbeddedStore) PutMedia(media []*Media) error {
+	s.Lock()
+	defer s.Unlock()
+
+	var duplicate error
+	for _, m := range media {
+		key := mediaPrefix + m.MediaID
+		if _, err := s.kv.Get(key); err == nil {
+			duplicate = ErrDuplicateKey
+			continue
+		}
+
+		data, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		if err := s.kv.Put(key, data); err != nil {
+			return err
+		}
+		s.trackMedia(m)
+	}
+	return duplicate
+}
+
+// LookupMedia reads the media with any of the words, each only once.
+func (s *EmbeddedStore) LookupMedia(words []string) ([]*Media, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	media := []*Media{}
+	seen := map[string]bool{}
+	for _, word := range words {
+		for _, id := range s.images[word] {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+
+			m, err := s.mediaItem(id)
+			if err != nil {
+				return nil, err
+			}
+			media = append(media, m)
+		}
+	}
+	return media, nil
+}
+
+// DocumentMedia reads the media found on a document.
+func (s *EmbeddedStore) DocumentMedia(docID string) ([]*Media, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	media := []*Media{}
+	for _, id := range s.media[docID] {
+		m, err := s.mediaItem(id)
+		if err != nil {
+			return nil, err
+		}
+		media = append(media, m)
+	}
+	return media, nil
+}
+
+// PutRaw writes raw content under its document's ID.
+func (s *EmbeddedStore) PutRaw(r *Raw) error {
+	s.Lock()
+	defer s.Unlock()
+
+	key := rawPrefix + r.DocID
+	if _, err := s.kv.Get(key); err == nil {
+		return ErrDuplicateKey
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.kv.Put(key, data)
+}
+
+// Raw reads the raw content of a document.
+func (s *EmbeddedStore) Raw(docID string) (*Raw, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	data, err := s.kv.Get(rawPrefix + docID)
+	if err != nil {
+		return nil, err
+	}
+
+	r := new(Raw)
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// PutSubscription writes a subscription under its feed's URL, replacing any
+// stored before.
+func (s *EmbeddedStore) PutSubscription(sub *Subscription) error {
+	s.Lock()
+	defer s.Unlock()
+
+	data, err := json.Marshal(sub)
+	if err != nil {
+		return err
+	}
+	return s.kv.Put(feedPrefix+sub.URL, data)
+}
+
+// DeleteSubscription removes the subscription to a feed.
+func (s *EmbeddedStore) DeleteSubscription(url string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.kv.Delete(feedPrefix + url)
+}
+
+// Lookup joins the indexes of each word with their documents.
+func (s *EmbeddedStore) Lookup(words []string, f *Filter) ([]Result, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	rows := []Result{}
+	docs := map[string]*Document{}
+
+	for _, word := range words {
+		for _, id := range s.words[word] {
+			i := s.indexes[id]
+
+			d, ok := docs[i.DocID]
+			if !ok {
+				var err error
+				d, err = s.document(i.DocID)
+				if err == ErrNotFound {
+					d = nil
+				} else if err != nil {
+					return nil, err
+				}
+				docs[i.DocID] = d
+			}
+			if d == nil || !f.Match(d) {
+				continue
+			}
+
+			rows = append(rows, Result{Document: *d, Index: *i})
+		}
+	}
+	return rows, nil
+}
+
+// Sites returns the distinct sites of stored documents.
+func (s *EmbeddedStore) Sites() ([]string, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	sites := []string{}
+	for site := range s.sites {
+		sites = append(sites, site)
+	}
+	sort.Strings(sites)
+	return sites, nil
+}
+
+// DeleteDocument deletes a document, its indexes, its links, its media and its
+// raw content, deleting a document that doesn't exist is not an error.
+func (s *EmbeddedStore) DeleteDocument(id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, indexID := range s.docs[id] {
+		if err := s.kv.Delete(indexPrefix + indexID); err != nil {
+			return err
+		}
+
+		i := s.indexes[indexID]
+		s.words[i.Word] = remove(s.words[i.Word], indexID)
+		if len(s.words[i.Word]) == 0 {
+			delete(s.words, i.Word)
+		}
+		delete(s.indexes, indexID)
+	}
+	delete(s.docs, id)
+
+	for _, linkID := range s.links[id] {
+		l, err := s.link(linkID)
+		if err != nil {
+			return err
+		}
+		if err := s.kv.Delete(linkPrefix + linkID); err != nil {
+			return err
+		}
+		if s.targets[l.Target] = remove(s.targets[l.Target], linkID); len(s.targets[l.Target]) == 0 {
+			delete(s.targets, l.Target)
+		}
+	}
+	delete(s.links, id)
+
+	for _, mediaID := range s.media[id] {
+		m, err := s.mediaItem(mediaID)
+		if err != nil {
+			return err
+		}
+		if err := s.kv.Delete(mediaPrefix + mediaID); err != nil {
+			return err
+		}
+		for _, word := range m.Words {
+			if s.images[word] = remove(s.images[word], mediaID); len(s.images[word]) == 0 {
+				delete(s.images, word)
+			}
+		}
+	}
+	delete(s.media, id)
+
+	if err := s.kv.Delete(rawPrefix + id); err != nil {
+		return err
+	}
+
+	d, err := s.document(id)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.kv.Delete(documentPrefix + id); err != nil {
+		return err
+	}
+
+	s.untrackDocument(d)
+	return nil
+}
+
+// EachDocument iterates over documents in ID order.
+func (s *EmbeddedStore) EachDocument(fn func(d *Document) error) error {
+	return s.each(documentPrefix, func(data []byte) error {
+		d := new(Document)
+		if err := json.Unmarshal(data, d); err != nil {
+			return err
+		}
+		return fn(d)
+	})
+}
+
+// EachIndex iterates over indexes in ID order.
+func (s *EmbeddedStore) EachIndex(fn func(i *Index) error) error {
+	return s.each(indexPrefix, func(data []byte) error {
+		i := new(Index)
+		if err := json.Unmarshal(data, i); err != nil {
+			return err
+		}
+		return fn(i)
+	})
+}
+
+// EachLink iterates over links in ID order.
+func (s *EmbeddedStore) EachLink(fn func(l *Link) error) error {
+	return s.each(linkPrefix, func(data []byte) error {
+		l := new(Link)
+		if err := json.Unmarshal(data, l); err != nil {
+			return err
+		}
+		return fn(l)
+	})
+}
+
+// EachMedia iterates over media in ID order.
+func (s *EmbeddedStore) EachMedia(fn func(m *Media) error) error {
+	return s.each(mediaPrefix, func(data []byte) error {
+		m := new(Media)
+		if err := json.Unmarshal(data, m); err != nil {
+			return err
+		}
+		return fn(m)
+	})
+}
+
+// EachSubscription iterates over subscriptions in URL order.
+func (s *EmbeddedStore) EachSubscription(fn func(sub *Subscription) error) error {
+	return s.each(feedPrefix, func(data []byte) error {
+		sub := new(Subscription)
+		if err := json.Unmarshal(data, sub); err != nil {
+			return err
+		}
+		return fn(sub)
+	})
+}
+
+// Counts reads the documents for their statistics, the terms and postings are
+// counted from the lookup tables.
+func (s *EmbeddedStore) Counts() (*Stats, error) {
+	stats, err := documentStats(s)
+	if err != nil {
+		return nil, err
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	stats.Terms = len(s.words)
+	stats.Postings = len(s.indexes)
+	return stats, nil
+}
+
+// Size returns the size of the underlying key-value store.
+func (s *EmbeddedStore) Size() (int64, error) {
+	return s.kv.Size(), nil
+}
+
+// Close closes the underlying key-value store.
+func (s *EmbeddedStore) Close() error {
+	return s.kv.Close()
+}
+
+// remove returns ids without id.
+func remove(ids []string, id string) []string {
+	for n, other := range ids {
+		if other == id {
+			return append(ids[:n], ids[n+1:]...)
+		}
+	}
+	return ids
+}
+
+// docURL is a document's ID and URL.
+type docURL struct{ id, url string }
+
+type byURL []docURL
+
+func (ds byURL) Len() int { return len(ds) }
+
+func (ds byURL) Swap(i, j int) { ds[i], ds[j] = ds[j], ds[i] }
+
+func (ds byURL) Less(i, j int) bool {
+	if ds[i].url != ds[j].url {
+		return ds[i].url < ds[j].url
+	}
+	return ds[i].id < ds[j].id
+}
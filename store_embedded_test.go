@@ -0,0 +1,345 @@
+package miru
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tempEmbeddedStore(t *testing.T) (*EmbeddedStore, string) {
+	dir, err := ioutil.TempDir("", "miru")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	path := filepath.Join(dir, "test.db")
+	s, err := OpenEmbeddedStore(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return s, path
+}
+
+func TestEmbeddedStore_PutLookup(t *testing.T) {
+	s, path := tempEmbeddedStore(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	defer s.Close()
+
+	d := NewDocument("http://example.com/", "example.com", "Example", "An example")
+	d.Language = "en"
+	assert.NoError(t, s.PutDocument(d))
+	assert.Equal(t, ErrDuplicateKey, s.PutDocument(d))
+
+	ixs := Indexer(d.Content, d.DocID)
+	assert.NoError(t, s.PutIndexes(ixs))
+	assert.Equal(t, ErrDuplicateKey, s.PutIndexes(ixs))
+
+	stored, err := s.Document(d.DocID)
+	assert.NoError(t, err)
+	assert.Equal(t, d.Url, stored.Url)
+
+	_, err = s.Document("missing")
+	assert.Equal(t, ErrNotFound, err)
+
+	rows, err := s.Lookup([]string{"exampl"}, &Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(rows))
+	assert.Equal(t, d.DocID, rows[0].Document.DocID)
+	assert.Equal(t, "exampl", rows[0].Word)
+
+	rows, err = s.Lookup([]string{"exampl"}, &Filter{Language: "fr"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(rows))
+}
+
+func TestEmbeddedStore_AddIndexes(t *testing.T) {
+	s, path := tempEmbeddedStore(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	d := NewDocument("http://example.com/", "example.com", "", "")
+	assert.NoError(t, s.PutDocument(d))
+	storm := NewFieldIndex(d.DocID, FieldAnchor, "storm", 1)
+	flood := NewFieldIndex(d.DocID, FieldAnchor, "flood", 2)
+
+	added, err := s.AddIndexes(Indexes{storm})
+	assert.NoError(t, err)
+	assert.Equal(t, Indexes{storm}, added)
+
+	// Counts are added to the stored index and only new indexes are returned.
+	added, err = s.AddIndexes(Indexes{storm, flood})
+	assert.NoError(t, err)
+	assert.Equal(t, Indexes{flood}, added)
+
+	rows, err := s.Lookup([]string{"storm", "flood"}, &Filter{})
+	assert.NoError(t, err)
+	counts := map[string]int64{}
+	for _, row := range rows {
+		counts[row.Word] = row.Count
+	}
+	assert.Equal(t, map[string]int64{"storm": 2, "flood": 2}, counts)
+	assert.Equal(t, int64(1), storm.Count)
+
+	assert.NoError(t, s.Close())
+	s, err = OpenEmbeddedStore(path)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	// The added counts are read back when the store is opened again.
+	ixs, err := s.DocumentIndexes(d.DocID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(ixs))
+	for _, i := range ixs {
+		assert.Equal(t, int64(2), i.Count)
+	}
+}
+
+func TestEmbeddedStore_PutDocuments(t *testing.T) {
+	s, path := tempEmbeddedStore(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	defer s.Close()
+
+	one := NewDocument("http://example.com/1", "example.com", "One", "")
+	two := NewDocument("http://example.com/2", "example.com", "Two", "")
+	assert.NoError(t, s.PutDocuments([]*Document{one}))
+
+	// Nothing is written when any document is already stored.
+	assert.Equal(t, ErrDuplicateKey, s.PutDocuments([]*Document{two, one}))
+	_, err := s.Document(two.DocID)
+	assert.Equal(t, ErrNotFound, err)
+
+	assert.NoError(t, s.PutDocuments([]*Document{two}))
+	_, total, err := s.SiteDocuments("example.com", 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, total)
+}
+
+func TestEmbeddedStore_Sites(t *testing.T) {
+	s, path := tempEmbeddedStore(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	defer s.Close()
+
+	s.PutDocument(NewDocument("http://example.org/", "example.org", "", ""))
+	s.PutDocument(NewDocument("http://example.com/", "example.com", "", ""))
+	s.PutDocument(NewDocument("http://example.com/a", "example.com", "", ""))
+
+	sites, err := s.Sites()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"example.com", "example.org"}, sites)
+}
+
+func TestEmbeddedStore_DeleteDocument(t *testing.T) {
+	s, path := tempEmbeddedStore(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	defer s.Close()
+
+	d := NewDocument("http://example.com/", "example.com", "", "An example")
+	s.PutDocument(d)
+	s.PutIndexes(Indexer(d.Content, d.DocID))
+
+	assert.NoError(t, s.DeleteDocument(d.DocID))
+	assert.NoError(t, s.DeleteDocument(d.DocID))
+
+	rows, _ := s.Lookup([]string{"exampl"}, &Filter{})
+	assert.Equal(t, 0, len(rows))
+
+	sites, _ := s.Sites()
+	assert.Equal(t, []string{}, sites)
+}
+
+func TestEmbeddedStore_Reopen(t *testing.T) {
+	s, path := tempEmbeddedStore(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	d := NewDocument("http://example.com/", "example.com", "Example", "An example")
+	s.PutDocument(d)
+	s.PutIndexes(Indexer(d.Content, d.DocID))
+	assert.NoError(t, s.Close())
+
+	s, err := OpenEmbeddedStore(path)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	rows, err := s.Lookup([]string{"exampl"}, &Filter{Site: "example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(rows))
+	assert.Equal(t, "Example", rows[0].Title)
+
+	docs := 0
+	s.EachDocument(func(d *Document) error {
+		docs++
+		return nil
+	})
+	assert.Equal(t, 1, docs)
+
+	indexes := 0
+	s.EachIndex(func(i *Index) error {
+		indexes++
+		return nil
+	})
+	assert.Equal(t, 1, indexes)
+}
+
+func TestEmbeddedStore_Browse(t *testing.T) {
+	s := NewMemoryStore()
+
+	old := NewDocument("http://example.com/b", "example.com", "Old", "")
+	old.Fetched = old.Fetched.Add(-time.Hour)
+	s.PutDocument(old)
+	s.PutDocument(NewDocument("http://example.com/b", "example.com", "New", ""))
+	s.PutDocument(NewDocument("http://example.com/a", "example.com", "A", ""))
+	s.PutDocument(NewDocument("http://example.org/", "example.org", "", ""))
+
+	d, err := s.DocumentByURL("http://example.com/b")
+	assert.NoError(t, err)
+	assert.Equal(t, "New", d.Title)
+
+	_, err = s.DocumentByURL("http://example.com/c")
+	assert.Equal(t, ErrNotFound, err)
+
+	docs, total, err := s.SiteDocuments("example.com", 0, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, total)
+	assert.Equal(t, 2, len(docs))
+	assert.Equal(t, "http://example.com/a", docs[0].Url)
+
+	docs, _, _ = s.SiteDocuments("example.com", 2, 2)
+	assert.Equal(t, 1, len(docs))
+
+	docs, _, _ = s.SiteDocuments("example.com", 5, 2)
+	assert.Equal(t, 0, len(docs))
+}
+
+func TestEmbeddedStore_Links(t *testing.T) {
+	s, path := tempEmbeddedStore(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	d := NewDocument("http://example.com/", "example.com", "", "")
+	s.PutDocument(d)
+	links := []*Link{
+		NewLink(d.DocID, "http://example.com/a", "A", false),
+		NewLink(d.DocID, "http://example.org/", "Elsewhere", true),
+	}
+	assert.NoError(t, s.PutLinks(links))
+	assert.Equal(t, ErrDuplicateKey, s.PutLinks(links[:1]))
+	assert.NoError(t, s.Close())
+
+	s, err := OpenEmbeddedStore(path)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	stored := map[string]*Link{}
+	s.EachLink(func(l *Link) error {
+		stored[l.Target] = l
+		return nil
+	})
+	assert.Equal(t, 2, len(stored))
+	assert.Equal(t, "Elsewhere", stored["http://example.org/"].Anchor)
+	assert.True(t, stored["http://example.org/"].NoFollow)
+
+	from, err := s.LinksFrom(d.DocID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(from))
+
+	assert.NoError(t, s.DeleteDocument(d.DocID))
+	count := 0
+	s.EachLink(func(l *Link) error {
+		count++
+		return nil
+	})
+	assert.Equal(t, 0, count)
+}
+
+func TestEmbeddedStore_Media(t *testing.T) {
+	s, path := tempEmbeddedStore(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	d := NewDocument("http://example.com/", "example.com", "", "")
+	s.PutDocument(d)
+	media := []*Media{
+		{MediaID: "1", DocID: d.DocID, URL: "http://example.com/cat.jpg", Alt: "A cat", Words: []string{"cat"}},
+		{MediaID: "2", DocID: d.DocID, URL: "http://example.com/dog.jpg", Alt: "A dog and a cat", Words: []string{"dog", "cat"}},
+	}
+	assert.NoError(t, s.PutMedia(media))
+	assert.Equal(t, ErrDuplicateKey, s.PutMedia(media[:1]))
+	assert.NoError(t, s.Close())
+
+	s, err := OpenEmbeddedStore(path)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	found, err := s.LookupMedia([]string{"cat", "dog"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(found))
+
+	found, err = s.LookupMedia([]string{"dog"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(found))
+	assert.Equal(t, "http://example.com/dog.jpg", found[0].URL)
+
+	found, err = s.DocumentMedia(d.DocID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(found))
+
+	assert.NoError(t, s.DeleteDocument(d.DocID))
+	found, _ = s.LookupMedia([]string{"cat"})
+	assert.Equal(t, 0, len(found))
+	count := 0
+	s.EachMedia(func(m *Media) error {
+		count++
+		return nil
+	})
+	assert.Equal(t, 0, count)
+}
+
+func TestEmbeddedStore_Raw(t *testing.T) {
+	s, path := tempEmbeddedStore(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	d := NewDocument("http://example.com/", "example.com", "", "")
+	s.PutDocument(d)
+	raw, _ := NewRaw(d.DocID, []byte("<p>Stored</p>"), true)
+	assert.NoError(t, s.PutRaw(raw))
+	assert.Equal(t, ErrDuplicateKey, s.PutRaw(raw))
+	assert.NoError(t, s.Close())
+
+	s, err := OpenEmbeddedStore(path)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	found, err := s.Raw(d.DocID)
+	assert.NoError(t, err)
+	contents, err := found.Contents()
+	assert.NoError(t, err)
+	assert.Equal(t, "<p>Stored</p>", string(contents))
+
+	assert.NoError(t, s.DeleteDocument(d.DocID))
+	_, err = s.Raw(d.DocID)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestEmbeddedStore_Subscriptions(t *testing.T) {
+	s, path := tempEmbeddedStore(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	assert.NoError(t, s.PutSubscription(&Subscription{URL: "http://a.com/feed", Site: "a.com"}))
+	assert.NoError(t, s.PutSubscription(&Subscription{URL: "http://b.com/feed", Site: "b.com"}))
+	// A subscription is replaced when it is polled.
+	assert.NoError(t, s.PutSubscription(&Subscription{URL: "http://a.com/feed", Site: "a.com", Items: 3}))
+	assert.NoError(t, s.DeleteSubscription("http://b.com/feed"))
+	assert.NoError(t, s.Close())
+
+	s, err := OpenEmbeddedStore(path)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	subs := []*Subscription{}
+	assert.NoError(t, s.EachSubscription(func(sub *Subscription) error {
+		subs = append(subs, sub)
+		return nil
+	}))
+	assert.Equal(t, []*Subscription{{URL: "http://a.com/feed", Site: "a.com", Items: 3}}, subs)
+}
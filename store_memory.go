@@ -0,0 +1,91 @@
+package miru
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryKV is a KV held entirely in memory, nothing survives a restart.
+type MemoryKV struct {
+	values map[string][]byte
+	size   int64
+	sync.RWMutex
+}
+
+// NewMemoryKV creates an empty in-memory KV.
+func NewMemoryKV() *MemoryKV {
+	return &MemoryKV{values: make(map[string][]byte)}
+}
+
+// Get returns a copy of the value of a key.
+func (kv *MemoryKV) Get(key string) ([]byte, error) {
+	kv.RLock()
+	defer kv.RUnlock()
+
+	value, ok := kv.values[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, value...), nil
+}
+
+// Put stores a copy of a value.
+func (kv *MemoryKV) Put(key string, value []byte) error {
+	kv.Lock()
+	defer kv.Unlock()
+
+	if old, ok := kv.values[key]; ok {
+		kv.size -= int64(len(key) + len(old))
+	}
+	kv.values[key] = append([]byte{}, value...)
+	kv.size += int64(len(key) + len(value))
+	return nil
+}
+
+// Delete removes a key.
+func (kv *MemoryKV) Delete(key string) error {
+	kv.Lock()
+	defer kv.Unlock()
+
+	if old, ok := kv.values[key]; ok {
+		kv.size -= int64(len(key) + len(old))
+		delete(kv.values, key)
+	}
+	return nil
+}
+
+// Keys returns the keys starting with prefix in sorted order.
+func (kv *MemoryKV) Keys(prefix string) ([]string, error) {
+	kv.RLock()
+	defer kv.RUnlock()
+
+	keys := []string{}
+	for key := range kv.values {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Size returns the number of bytes held by keys and values.
+func (kv *MemoryKV) Size() int64 {
+	kv.RLock()
+	defer kv.RUnlock()
+
+	return kv.size
+}
+
+// Close does nothing, the values are kept until the KV is garbage collected.
+func (kv *MemoryKV) Close() error {
+	return nil
+}
+
+// NewMemoryStore creates an empty store that is kept in memory, it's meant for
+// tests and for crawls that don't need to outlive the process.
+func NewMemoryStore() *EmbeddedStore {
+	s, _ := NewEmbeddedStore(NewMemoryKV())
+	return s
+}
@@ -0,0 +1,48 @@
+package miru
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryKV(t *testing.T) {
+	kv := NewMemoryKV()
+
+	value := []byte("one")
+	assert.NoError(t, kv.Put("a", value))
+	value[0] = 'x'
+
+	got, err := kv.Get("a")
+	assert.NoError(t, err)
+	assert.Equal(t, "one", string(got))
+	assert.Equal(t, int64(4), kv.Size())
+
+	kv.Put("b", []byte("two"))
+	kv.Delete("a")
+
+	keys, _ := kv.Keys("")
+	assert.Equal(t, []string{"b"}, keys)
+	assert.Equal(t, int64(4), kv.Size())
+
+	_, err = kv.Get("a")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestMemoryStore_Crawl(t *testing.T) {
+	ctx := NewContext()
+	ctx.LoadConfig(DefaultConfig)
+	ctx.Store = NewMemoryStore()
+
+	ts := Handler(200, []byte(`<html><head><title>Example</title></head>
+<body><p>Some example content</p></body></html>`))
+	defer ts.Close()
+
+	assert.NoError(t, Crawl(ts.URL, ctx, NewQueue()))
+	assert.NoError(t, ctx.Writer.Flush())
+
+	res := new(Results)
+	assert.NoError(t, res.Search("example", ctx))
+	assert.Equal(t, int64(1), res.Count)
+	assert.Equal(t, "Example", res.Results[0].Title)
+}
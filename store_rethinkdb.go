@@ -0,0 +1,652 @@
+package miru
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	rdb "github.com/dancannon/gorethink"
+)
+
+// RethinkStore stores documents and indexes in RethinkDB, table names are read
+// from the config on each query.
+type RethinkStore struct {
+	Session *rdb.Session
+	Config  *Config
+}
+
+// NewRethinkStore creates a store from an open session.
+func NewRethinkStore(session *rdb.Session, config *Config) *RethinkStore {
+	return &RethinkStore{Session: session, Config: config}
+}
+
+// writeError returns the error of a write, either from running it or the first
+// error the server reported for it, inserting an existing ID is reported as
+// ErrDuplicateKey.
+func writeError(res rdb.WriteResponse, err error) error {
+	if err != nil {
+		return err
+	}
+	if res.Errors == 0 {
+		return nil
+	}
+	if strings.HasPrefix(res.FirstError, "Duplicate primary key") {
+		return ErrDuplicateKey
+	}
+	return errors.New(res.FirstError)
+}
+
+// CreateIndexes creates the secondary indexes the store's queries use where
+// they don't exist yet, then waits for them to be ready. The tables have to
+// exist already.
+func (s *RethinkStore) CreateIndexes() error {
+	siteURL := func(d rdb.Term) interface{} {
+		return []interface{}{d.Field("site"), d.Field("url")}
+	}
+	indexes := []struct {
+		table rdb.Term
+		name  string
+		multi bool
+		fn    interface{}
+	}{
+		{s.indexes(), "word", false, nil},
+		{s.indexes(), "doc_id", false, nil},
+		{s.documents(), "url", false, nil},
+		{s.documents(), "site", false, nil},
+		{s.documents(), "site_url", false, siteURL},
+		{s.links(), "source", false, nil},
+		{s.links(), "target", false, nil},
+		{s.media(), "doc_id", false, nil},
+		{s.media(), "words", true, nil},
+	}
+
+	for _, ix := range indexes {
+		res, err := ix.table.IndexList().Run(s.Session)
+		if err != nil {
+			return err
+		}
+		names := []string{}
+		err = res.All(&names)
+		res.Close()
+		if err != nil {
+			return err
+		}
+
+		exists := false
+		for _, name := range names {
+			exists = exists || name == ix.name
+		}
+		if !exists {
+			opts := rdb.IndexCreateOpts{Multi: ix.multi}
+			create := ix.table.IndexCreate(ix.name, opts)
+			if ix.fn != nil {
+				create = ix.table.IndexCreateFunc(ix.name, ix.fn, opts)
+			}
+			if err := create.Exec(s.Session); err != nil {
+				return err
+			}
+		}
+		if err := ix.table.IndexWait(ix.name).Exec(s.Session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RethinkStore) documents() rdb.Term {
+	return rdb.Db(s.Config.Database.Name).Table(s.Config.Tables.Document)
+}
+
+func (s *RethinkStore) indexes() rdb.Term {
+	return rdb.Db(s.Config.Database.Name).Table(s.Config.Tables.Index)
+}
+
+func (s *RethinkStore) links() rdb.Term {
+	return rdb.Db(s.Config.Database.Name).Table(s.Config.Tables.Link)
+}
+
+func (s *RethinkStore) media() rdb.Term {
+	return rdb.Db(s.Config.Database.Name).Table(s.Config.Tables.Media)
+}
+
+func (s *RethinkStore) raw() rdb.Term {
+	return rdb.Db(s.Config.Database.Name).Table(s.Config.Tables.Raw)
+}
+
+func (s *RethinkStore) feeds() rdb.Term {
+	return rdb.Db(s.Config.Database.Name).Table(s.Config.Tables.Feed)
+}
+
+// PutDocument writes a document to the documents table.
+func (s *RethinkStore) PutDocument(d *Document) error {
+	return writeError(s.documents().Insert(d).RunWrite(s.Session))
+}
+
+// PutDocuments inserts documents into the documents table in one query, after
+// checking none of their keys are already stored.
+func (s *RethinkStore) PutDocuments(docs []*Document) error {
+	if len(docs) == 0 {
+		return nil
+	}
+
+	ids := make([]interface{}, len(docs))
+	for n, d := range docs {
+		ids[n] = d.DocID
+	}
+	var stored int
+	if err := s.one(s.documents().GetAll(ids...).Count(), &stored); err != nil {
+		return err
+	}
+	if stored > 0 {
+		return ErrDuplicateKey
+	}
+
+	return writeError(s.documents().Insert(docs).RunWrite(s.Session))
+}
+
+// Document gets a document by its primary key.
+func (s *RethinkStore) Document(id string) (*Document, error) {
+	res, err := s.documents().Get(id).Run(s.Session)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	if res.IsNil() {
+		return nil, ErrNotFound
+	}
+
+	d := new(Document)
+	if err := res.One(d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// DocumentByURL gets the latest document with a URL using the url secondary
+// index.
+func (s *RethinkStore) DocumentByURL(url string) (*Document, error) {
+	res, err := s.documents().GetAllByIndex("url", url).OrderBy(
+		rdb.Desc("fetched")).Limit(1).Run(s.Session)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	docs := []*Document{}
+	if err := res.All(&docs); err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, ErrNotFound
+	}
+	return docs[0], nil
+}
+
+// SiteDocuments counts a site's documents using the site secondary index and
+// pages through them in URL order using the compound site_url index, so they
+// aren't sorted in memory. Every [site, url] key of the site sorts after [site]
+// and before [site + "\x00"].
+func (s *RethinkStore) SiteDocuments(site string, offset, limit int) ([]*Document, int, error) {
+	var total int
+	if err := s.one(s.documents().GetAllByIndex("site", site).Count(), &total); err != nil {
+		return nil, 0, err
+	}
+
+	res, err := s.documents().Between(
+		[]interface{}{site}, []interface{}{site + "\x00"}, rdb.BetweenOpts{Index: "site_url"}).OrderBy(
+		rdb.OrderByOpts{Index: "site_url"}).Skip(offset).Limit(limit).Run(s.Session)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Close()
+
+	docs := []*Document{}
+	if err := res.All(&docs); err != nil {
+		return nil, 0, err
+	}
+	return docs, total, nil
+}
+
+// PutIndexes writes indexes to the indexes table.
+func (s *RethinkStore) PutIndexes(ixs Indexes) error {
+	return writeError(s.indexes().Insert(ixs).RunWrite(s.Session))
+}
+
+// AddIndexes reads which of the indexes are already stored, inserts the rest
+// in one query and adds the counts of the stored ones in another.
+func (s *RethinkStore) AddIndexes(ixs Indexes) (Indexes, error) {
+	added := Indexes{}
+	if len(ixs) == 0 {
+		return added, nil
+	}
+
+	ids := make([]interface{}, len(ixs))
+	for n, i := range ixs {
+		ids[n] = i.IndexID
+	}
+	res, err := s.indexes().GetAll(ids...).Field("id").Run(s.Session)
+	if err != nil {
+		return added, err
+	}
+	defer res.Close()
+
+	found := []string{}
+	if err := res.All(&found); err != nil {
+		return added, err
+	}
+	stored := map[string]bool{}
+	for _, id := range found {
+		stored[id] = true
+	}
+
+	counts := []map[string]interface{}{}
+	for _, i := range ixs {
+		if stored[i.IndexID] {
+			counts = append(counts, map[string]interface{}{"id": i.IndexID, "count": i.Count})
+		} else {
+			added = append(added, i)
+		}
+	}
+
+	if len(added) > 0 {
+		if err := writeError(s.indexes().Insert(added).RunWrite(s.Session)); err != nil {
+			return nil, err
+		}
+	}
+	if len(counts) > 0 {
+		err := writeError(rdb.Expr(counts).ForEach(func(c rdb.Term) interface{} {
+			return s.indexes().Get(c.Field("id")).Update(func(i rdb.Term) interface{} {
+				return map[string]interface{}{"count": i.Field("count").Add(c.Field("count"))}
+			})
+		}).RunWrite(s.Session))
+		if err != nil {
+			return added, err
+		}
+	}
+	return added, nil
+}
+
+// DocumentIndexes gets a document's indexes using the doc_id secondary index.
+func (s *RethinkStore) DocumentIndexes(docID string) (Indexes, error) {
+	res, err := s.indexes().GetAllByIndex("doc_id", docID).Run(s.Session)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	ixs := Indexes{}
+	if err := res.All(&ixs); err != nil {
+		return nil, err
+	}
+	return ixs, nil
+}
+
+// Lookup gets indexes using the word secondary index and joins them with their
+// documents.
+func (s *RethinkStore) Lookup(words []string, f *Filter) ([]Result, error) {
+	res, err := f.apply(s.indexes().GetAllByIndex(
+		"word", rdb.Args(words)).EqJoin(
+		"doc_id", s.documents()).Zip()).Run(s.Session)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	rows := []Result{}
+	if err := res.All(&rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// Sites returns the distinct sites of the documents table.
+func (s *RethinkStore) Sites() ([]string, error) {
+	res, err := s.documents().Pluck("site").Distinct().Run(s.Session)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	docs := []Document{}
+	if err := res.All(&docs); err != nil {
+		return nil, err
+	}
+
+	sites := []string{}
+	for _, d := range docs {
+		sites = append(sites, d.Site)
+	}
+	sort.Strings(sites)
+	return sites, nil
+}
+
+// PutLinks inserts links into the links table.
+func (s *RethinkStore) PutLinks(links []*Link) error {
+	if len(links) == 0 {
+		return nil
+	}
+
+	return writeError(s.links().Insert(links).RunWrite(s.Session))
+}
+
+// LinksTo gets every link with a target URL using the target secondary index.
+func (s *RethinkStore) LinksTo(url string) ([]*Link, error) {
+	res, err := s.links().GetAllByIndex("target", url).Run(s.Session)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	links := []*Link{}
+	if err := res.All(&links); err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+// LinksFrom gets the links on a document using the source secondary index.
+func (s *RethinkStore) LinksFrom(docID string) ([]*Link, error) {
+	res, err := s.links().GetAllByIndex("source", docID).Run(s.Session)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	links := []*Link{}
+	if err := res.All(&links); err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+// PutMedia inserts media into the media table.
+func (s *RethinkStore) PutMedia(media []*Media) error {
+	if len(media) == 0 {
+		return nil
+	}
+
+	return writeError(s.media().Insert(media).RunWrite(s.Session))
+}
+
+// LookupMedia gets every media with any of the words using the words multi
+// index, media with more than one of them are only returned once.
+func (s *RethinkStore) LookupMedia(words []string) ([]*Media, error) {
+	res, err := s.media().GetAllByIndex("words", rdb.Args(words)).Distinct().Run(s.Session)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	media := []*Media{}
+	if err := res.All(&media); err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+// DocumentMedia gets the media on a document using the doc_id secondary index.
+func (s *RethinkStore) DocumentMedia(docID string) ([]*Media, error) {
+	res, err := s.media().GetAllByIndex("doc_id", docID).Run(s.Session)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	media := []*Media{}
+	if err := res.All(&media); err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+// PutRaw inserts raw content into the raw table.
+func (s *RethinkStore) PutRaw(r *Raw) error {
+	return writeError(s.raw().Insert(r).RunWrite(s.Session))
+}
+
+// Raw gets the raw content of a document by its primary key.
+func (s *RethinkStore) Raw(docID string) (*Raw, error) {
+	res, err := s.raw().Get(docID).Run(s.Session)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	if res.IsNil() {
+		return nil, ErrNotFound
+	}
+
+	r := new(Raw)
+	if err := res.One(r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// PutSubscription inserts a subscription into the feeds table, replacing one
+// with the same URL.
+func (s *RethinkStore) PutSubscription(sub *Subscription) error {
+	return writeError(s.feeds().Insert(sub, rdb.InsertOpts{Conflict: "replace"}).RunWrite(s.Session))
+}
+
+// DeleteSubscription deletes a subscription by its primary key.
+func (s *RethinkStore) DeleteSubscription(url string) error {
+	return s.feeds().Get(url).Delete().Exec(s.Session)
+}
+
+// DeleteDocument deletes a document along with its indexes, the links from it,
+// its media and its raw content, found by their doc_id and source secondary
+// indexes.
+func (s *RethinkStore) DeleteDocument(id string) error {
+	if err := s.indexes().GetAllByIndex("doc_id", id).Delete().Exec(s.Session); err != nil {
+		return err
+	}
+	if err := s.links().GetAllByIndex("source", id).Delete().Exec(s.Session); err != nil {
+		return err
+	}
+	if err := s.media().GetAllByIndex("doc_id", id).Delete().Exec(s.Session); err != nil {
+		return err
+	}
+	if err := s.raw().Get(id).Delete().Exec(s.Session); err != nil {
+		return err
+	}
+	return s.documents().Get(id).Delete().Exec(s.Session)
+}
+
+// EachDocument iterates over the documents table.
+func (s *RethinkStore) EachDocument(fn func(d *Document) error) error {
+	res, err := s.documents().Run(s.Session)
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	d := new(Document)
+	for res.Next(d) {
+		if err := fn(d); err != nil {
+			return err
+		}
+		d = new(Document)
+	}
+	return res.Err()
+}
+
+// EachIndex iterates over the indexes table.
+func (s *RethinkStore) EachIndex(fn func(i *Index) error) error {
+	res, err := s.indexes().Run(s.Session)
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	i := new(Index)
+	for res.Next(i) {
+		if err := fn(i); err != nil {
+			return err
+		}
+		i = new(Index)
+	}
+	return res.Err()
+}
+
+// EachLink iterates over the links table.
+func (s *RethinkStore) EachLink(fn func(l *Link) error) error {
+	res, err := s.links().Run(s.Session)
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	l := new(Link)
+	for res.Next(l) {
+		if err := fn(l); err != nil {
+			return err
+		}
+		l = new(Link)
+	}
+	return res.Err()
+}
+
+// EachMedia iterates over the media table.
+func (s *RethinkStore) EachMedia(fn func(m *Media) error) error {
+	res, err := s.media().Run(s.Session)
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	m := new(Media)
+	for res.Next(m) {
+		if err := fn(m); err != nil {
+			return err
+		}
+		m = new(Media)
+	}
+	return res.Err()
+}
+
+// EachSubscription iterates over the feeds table.
+func (s *RethinkStore) EachSubscription(fn func(sub *Subscription) error) error {
+	res, err := s.feeds().Run(s.Session)
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	sub := new(Subscription)
+	for res.Next(sub) {
+		if err := fn(sub); err != nil {
+			return err
+		}
+		sub = new(Subscription)
+	}
+	return res.Err()
+}
+
+// one runs a query and reads its single result into v.
+func (s *RethinkStore) one(query rdb.Term, v interface{}) error {
+	res, err := query.Run(s.Session)
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	return res.One(v)
+}
+
+// siteCounts is the number of documents of a site and when it was last
+// crawled, as grouped by the server.
+type siteCounts struct {
+	Site      string `gorethink:"group"`
+	Reduction struct {
+		Documents   int       `gorethink:"documents"`
+		LastCrawled time.Time `gorethink:"last_crawled"`
+	} `gorethink:"reduction"`
+}
+
+// Counts has the server count the documents and the words in them, group them
+// by site and count the postings and the distinct words of the word index.
+func (s *RethinkStore) Counts() (*Stats, error) {
+	stats := &Stats{Sites: map[string]SiteStats{}}
+
+	if err := s.one(s.documents().Count(), &stats.Documents); err != nil {
+		return nil, err
+	}
+	var words int
+	if err := s.one(s.documents().Map(func(d rdb.Term) interface{} {
+		return d.Field("content").Split().Count()
+	}).Sum(), &words); err != nil {
+		return nil, err
+	}
+	if stats.Documents > 0 {
+		stats.AverageLength = float64(words) / float64(stats.Documents)
+	}
+
+	res, err := s.documents().Group("site").Map(func(d rdb.Term) interface{} {
+		return map[string]interface{}{"documents": 1, "last_crawled": d.Field("fetched")}
+	}).Reduce(func(a, b rdb.Term) interface{} {
+		return map[string]interface{}{
+			"documents":    a.Field("documents").Add(b.Field("documents")),
+			"last_crawled": rdb.Branch(a.Field("last_crawled").Gt(b.Field("last_crawled")), a.Field("last_crawled"), b.Field("last_crawled")),
+		}
+	}).Ungroup().Run(s.Session)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	sites := []siteCounts{}
+	if err := res.All(&sites); err != nil {
+		return nil, err
+	}
+	for _, site := range sites {
+		stats.Sites[site.Site] = SiteStats{
+			Documents:   site.Reduction.Documents,
+			LastCrawled: site.Reduction.LastCrawled,
+		}
+	}
+
+	if err := s.one(s.indexes().Count(), &stats.Postings); err != nil {
+		return nil, err
+	}
+	if err := s.one(s.indexes().Distinct(rdb.DistinctOpts{Index: "word"}).Count(), &stats.Terms); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// Size adds up the disk space the server reports using for each of the
+// store's tables in the rethinkdb.stats system table.
+func (s *RethinkStore) Size() (int64, error) {
+	t := s.Config.Tables
+	tables := []interface{}{t.Index, t.Document, t.Link, t.Media, t.Raw, t.Feed}
+
+	var size int64
+	err := s.one(rdb.Db("rethinkdb").Table("stats").Filter(func(row rdb.Term) interface{} {
+		return row.Field("id").Nth(0).Eq("table_server").And(
+			row.Field("db").Eq(s.Config.Database.Name)).And(
+			rdb.Expr(tables).Contains(row.Field("table")))
+	}).Map(func(row rdb.Term) interface{} {
+		usage := row.Field("storage_engine").Field("disk").Field("space_usage")
+		return usage.Field("data_bytes").Add(
+			usage.Field("garbage_bytes"),
+			usage.Field("metadata_bytes"),
+			usage.Field("preallocated_bytes"))
+	}).Sum(), &size)
+	if err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// Ping runs a trivial query to check the server is reachable.
+func (s *RethinkStore) Ping() error {
+	return rdb.Expr(1).Exec(s.Session)
+}
+
+// Close closes the session.
+func (s *RethinkStore) Close() error {
+	return s.Session.Close()
+}
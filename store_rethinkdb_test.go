@@ -0,0 +1,183 @@
+package miru
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	rdb "github.com/dancannon/gorethink"
+	"github.com/stretchr/testify/assert"
+)
+
+// rethinkContext connects to the server in RETHINKDB_URL and creates an empty
+// test database, tests are skipped when it isn't set.
+func rethinkContext(t *testing.T) *Context {
+	host := os.Getenv("RETHINKDB_URL")
+	if host == "" {
+		t.Skip("RETHINKDB_URL is not set.")
+	}
+
+	ctx := NewContext()
+	if err := ctx.LoadConfig(DefaultConfig); err != nil {
+		t.Fatal(err.Error())
+	}
+	ctx.Config.Database.Name = "miru_test"
+
+	if err := ctx.Connect(host); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	db := ctx.Config.Database.Name
+	rdb.DbCreate(db).Exec(ctx.Db)
+	rdb.Db(db).TableCreate(ctx.Config.Tables.Document).Exec(ctx.Db)
+	rdb.Db(db).TableCreate(ctx.Config.Tables.Index).Exec(ctx.Db)
+	rdb.Db(db).TableCreate(ctx.Config.Tables.Link).Exec(ctx.Db)
+	rdb.Db(db).TableCreate(ctx.Config.Tables.Media).Exec(ctx.Db)
+	rdb.Db(db).TableCreate(ctx.Config.Tables.Raw).Exec(ctx.Db)
+	rdb.Db(db).TableCreate(ctx.Config.Tables.Feed).Exec(ctx.Db)
+	if err := ctx.Store.(*RethinkStore).CreateIndexes(); err != nil {
+		t.Fatal(err.Error())
+	}
+	return ctx
+}
+
+func rethinkTearDown(ctx *Context) {
+	db := ctx.Config.Database.Name
+	rdb.Db(db).Table(ctx.Config.Tables.Document).Delete().Exec(ctx.Db)
+	rdb.Db(db).Table(ctx.Config.Tables.Index).Delete().Exec(ctx.Db)
+	rdb.Db(db).Table(ctx.Config.Tables.Link).Delete().Exec(ctx.Db)
+	rdb.Db(db).Table(ctx.Config.Tables.Feed).Delete().Exec(ctx.Db)
+	ctx.Store.Close()
+}
+
+func TestRethinkStore_PutLookup(t *testing.T) {
+	ctx := rethinkContext(t)
+	defer rethinkTearDown(ctx)
+
+	d := NewDocument("http://example.com/", "example.com", "Example", "An example")
+	assert.NoError(t, ctx.Store.PutDocument(d))
+	assert.Error(t, ctx.Store.PutDocument(d))
+
+	stored, err := ctx.Store.Document(d.DocID)
+	assert.NoError(t, err)
+	assert.Equal(t, d.Url, stored.Url)
+	_, err = ctx.Store.Document("missing")
+	assert.Equal(t, ErrNotFound, err)
+
+	stored, err = ctx.Store.DocumentByURL(d.Url)
+	assert.NoError(t, err)
+	assert.Equal(t, d.DocID, stored.DocID)
+
+	docs, total, err := ctx.Store.SiteDocuments("example.com", 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, 1, len(docs))
+
+	assert.NoError(t, ctx.Store.PutIndexes(Indexer(d.Content, d.DocID)))
+
+	rows, err := ctx.Store.Lookup([]string{"exampl"}, &Filter{Site: "example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(rows))
+
+	sites, err := ctx.Store.Sites()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"example.com"}, sites)
+
+	assert.NoError(t, ctx.Store.DeleteDocument(d.DocID))
+
+	rows, err = ctx.Store.Lookup([]string{"exampl"}, &Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(rows))
+}
+
+func TestRethinkStore_Lookup_NoIndexRaisesError(t *testing.T) {
+	ctx := rethinkContext(t)
+	defer rethinkTearDown(ctx)
+
+	db := ctx.Config.Database.Name
+	rdb.Db(db).Table(ctx.Config.Tables.Index).IndexDrop("word").Exec(ctx.Db)
+
+	_, err := ctx.Store.Lookup([]string{"exampl"}, &Filter{})
+	assert.Error(t, err)
+
+	rdb.Db(db).Table(ctx.Config.Tables.Index).IndexCreate("word").Exec(ctx.Db)
+}
+
+func TestRethinkStore_writeError(t *testing.T) {
+	failed := errors.New("Connection closed.")
+	assert.Equal(t, failed, writeError(rdb.WriteResponse{}, failed))
+	assert.NoError(t, writeError(rdb.WriteResponse{Inserted: 1}, nil))
+
+	res := rdb.WriteResponse{Errors: 1, FirstError: "Duplicate primary key `id`: ..."}
+	assert.Equal(t, ErrDuplicateKey, writeError(res, nil))
+	res = rdb.WriteResponse{Errors: 1, FirstError: "Table `miru.documents` does not exist."}
+	assert.EqualError(t, writeError(res, nil), res.FirstError)
+}
+
+func TestRethinkStore_Subscriptions(t *testing.T) {
+	ctx := rethinkContext(t)
+	defer rethinkTearDown(ctx)
+
+	assert.NoError(t, ctx.Store.PutSubscription(&Subscription{URL: "http://a.com/feed", Site: "a.com"}))
+	assert.NoError(t, ctx.Store.PutSubscription(&Subscription{URL: "http://b.com/feed", Site: "b.com"}))
+	assert.NoError(t, ctx.Store.PutSubscription(&Subscription{URL: "http://a.com/feed", Site: "a.com", Items: 3}))
+	assert.NoError(t, ctx.Store.DeleteSubscription("http://b.com/feed"))
+
+	subs := []*Subscription{}
+	assert.NoError(t, ctx.Store.EachSubscription(func(sub *Subscription) error {
+		subs = append(subs, sub)
+		return nil
+	}))
+	assert.Equal(t, 1, len(subs))
+	assert.Equal(t, 3, subs[0].Items)
+}
+
+func TestRethinkStore_Counts(t *testing.T) {
+	ctx := rethinkContext(t)
+	defer rethinkTearDown(ctx)
+
+	a := NewDocument("http://example.com/", "example.com", "Example", "An example page")
+	b := NewDocument("http://example.org/", "example.org", "Example", "Another")
+	assert.NoError(t, ctx.Store.PutDocuments([]*Document{a, b}))
+	assert.NoError(t, ctx.Store.PutIndexes(Indexer(a.Content, a.DocID)))
+	assert.NoError(t, ctx.Store.PutIndexes(Indexer(b.Content, b.DocID)))
+
+	// The server's counts match those read from every document and index.
+	counted, err := ctx.Store.(*RethinkStore).Counts()
+	assert.NoError(t, err)
+	scanned, err := documentStats(ctx.Store)
+	assert.NoError(t, err)
+	assert.NoError(t, indexStats(ctx.Store, scanned))
+	assert.Equal(t, scanned.Documents, counted.Documents)
+	assert.Equal(t, scanned.AverageLength, counted.AverageLength)
+	assert.Equal(t, scanned.Terms, counted.Terms)
+	assert.Equal(t, scanned.Postings, counted.Postings)
+	assert.Equal(t, 1, counted.Sites["example.com"].Documents)
+	assert.Equal(t, a.Fetched.Unix(), counted.Sites["example.com"].LastCrawled.Unix())
+
+	size, err := ctx.Store.(*RethinkStore).Size()
+	assert.NoError(t, err)
+	assert.True(t, size > 0)
+}
+
+func TestRethinkStore_SiteDocuments(t *testing.T) {
+	ctx := rethinkContext(t)
+	defer rethinkTearDown(ctx)
+
+	assert.NoError(t, ctx.Store.PutDocuments([]*Document{
+		NewDocument("http://example.com/b", "example.com", "", ""),
+		NewDocument("http://example.com/a", "example.com", "", ""),
+		NewDocument("http://example.com/c", "example.com", "", ""),
+		NewDocument("http://example.com.au/", "example.com.au", "", ""),
+	}))
+
+	// Pages are in URL order and a site whose name starts with another's
+	// isn't included.
+	docs, total, err := ctx.Store.SiteDocuments("example.com", 1, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, total)
+	if assert.Equal(t, 2, len(docs)) {
+		assert.Equal(t, "http://example.com/b", docs[0].Url)
+		assert.Equal(t, "http://example.com/c", docs[1].Url)
+	}
+}
@@ -0,0 +1,951 @@
+package miru
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Files kept in a segment store's directory.
+const (
+	segmentManifest  = "segments"
+	segmentDocuments = "documents.db"
+	segmentPostings  = "postings.log"
+)
+
+var (
+	// FlushSize is the number of buffered postings that causes them to be
+	// written out as a new segment.
+	FlushSize = 50000
+	// MergeFactor is the number of segments of a similar size that are merged
+	// together in the background.
+	MergeFactor = 8
+)
+
+// SegmentStore keeps postings in immutable segment files alongside an embedded
+// store for documents. New postings are buffered in memory and written as a
+// segment once FlushSize is reached or the store is flushed or closed. Segments
+// are grouped into tiers by size and MergeFactor segments of the same tier are
+// merged in the background, so each posting is only rewritten once per tier.
+// Flushes don't wait for merges to finish. A posting in the buffer or a newer
+// segment replaces the same document, field and word in older segments, which
+// is how counts are added to. The live segments are listed in a manifest that
+// is replaced atomically, so a crash never leaves half-written or merged
+// segments in use. Postings that aren't in a live segment yet are also kept in
+// a log, which is read back into the buffer when the store is opened.
+type SegmentStore struct {
+	dir      string
+	docs     *EmbeddedStore
+	log      *LogKV
+	buffer   *segmentBuilder
+	flushing *segmentBuilder
+	dropped  map[string]bool
+	segments []*Segment
+	next     int
+
+	merges  chan bool
+	closed  bool
+	flushes sync.Mutex
+	merging sync.Mutex
+	wg      sync.WaitGroup
+	sync.RWMutex
+}
+
+// OpenSegmentStore opens a segment store in dir, creating it if it doesn't
+// exist.
+func OpenSegmentStore(dir string) (*SegmentStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	docs, err := OpenEmbeddedStore(filepath.Join(dir, segmentDocuments))
+	if err != nil {
+		return nil, err
+	}
+
+	log, err := OpenLogKV(filepath.Join(dir, segmentPostings))
+	if err != nil {
+		docs.Close()
+		return nil, err
+	}
+
+	s := &SegmentStore{
+		dir:    dir,
+		docs:   docs,
+		log:    log,
+		buffer: newSegmentBuilder(),
+		merges: make(chan bool, 1),
+	}
+	if err := s.load(); err != nil {
+		log.Close()
+		docs.Close()
+		return nil, err
+	}
+
+	s.wg.Add(1)
+	go s.merger()
+	return s, nil
+}
+
+// load reads the segments named in the manifest along with their deletes, then
+// replays the log.
+func (s *SegmentStore) load() error {
+	manifest, err := ioutil.ReadFile(filepath.Join(s.dir, segmentManifest))
+	if os.IsNotExist(err) {
+		return s.replay()
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, name := range strings.Fields(string(manifest)) {
+		data, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+		if err != nil {
+			return err
+		}
+
+		segment, err := DecodeSegment(data)
+		if err != nil {
+			return err
+		}
+		segment.Name = name
+
+		deleted, err := ioutil.ReadFile(filepath.Join(s.dir, name+".del"))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for _, id := range strings.Fields(string(deleted)) {
+			segment.Delete(id)
+		}
+
+		var n int
+		if _, err := fmt.Sscanf(name, "seg-%d", &n); err == nil && n >= s.next {
+			s.next = n + 1
+		}
+		s.segments = append(s.segments, segment)
+	}
+	return s.replay()
+}
+
+// replay reads the logged postings back into the buffer. Postings that are the
+// newest stored for their document, field and word were flushed just before a
+// crash and are skipped.
+func (s *SegmentStore) replay() error {
+	keys, err := s.log.Keys("")
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		data, err := s.log.Get(key)
+		if err != nil {
+			return err
+		}
+		i := new(Index)
+		if err := json.Unmarshal(data, i); err != nil {
+			return err
+		}
+		p := posting{DocID: i.DocID, Field: i.Field, Count: i.Count, Text: i.Text}
+		stored, ok, err := s.find(i.DocID, i.Field, i.Word)
+		if err != nil {
+			return err
+		}
+		if !ok || stored != p {
+			s.buffer.Add(i.Word, p)
+		}
+	}
+	return nil
+}
+
+// postingKey is the key of a posting in the log, a document's postings share
+// its ID as a prefix.
+func postingKey(docID, field, word string) string {
+	return docID + "\x00" + field + "\x00" + word
+}
+
+// unlog removes the postings of a builder from the log, apart from those of
+// documents that were deleted while it was written and those buffered again
+// since.
+func (s *SegmentStore) unlog(b *segmentBuilder) error {
+	return b.Each(func(word string, p posting) error {
+		if s.dropped[p.DocID] || s.buffer.Has(p.DocID, p.Field, word) {
+			return nil
+		}
+		return s.log.Delete(postingKey(p.DocID, p.Field, word))
+	})
+}
+
+// writeFile writes a file atomically by renaming a temporary file over it once
+// it has been synced, then syncs the directory so the rename is kept.
+func (s *SegmentStore) writeFile(name string, data []byte) error {
+	path := filepath.Join(s.dir, name)
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	dir, err := os.Open(s.dir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// writeManifest lists segments as the live set.
+func (s *SegmentStore) writeManifest(segments []*Segment) error {
+	names := make([]string, len(segments))
+	for n, segment := range segments {
+		names[n] = segment.Name
+	}
+	return s.writeFile(segmentManifest, []byte(strings.Join(names, "\n")+"\n"))
+}
+
+// writeSegment encodes a builder to a new segment file without making it live.
+func (s *SegmentStore) writeSegment(b *segmentBuilder) (*Segment, error) {
+	data := b.Encode()
+	segment, err := DecodeSegment(data)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Lock()
+	segment.Name = fmt.Sprintf("seg-%06d", s.next)
+	s.next++
+	s.Unlock()
+
+	if err := s.writeFile(segment.Name, data); err != nil {
+		return nil, err
+	}
+	return segment, nil
+}
+
+// Flush writes buffered postings to a new segment.
+func (s *SegmentStore) Flush() error {
+	s.flushes.Lock()
+	defer s.flushes.Unlock()
+
+	return s.flush()
+}
+
+// flush swaps in an empty buffer and writes the full one out, it stays
+// searchable until the new segment is live.
+func (s *SegmentStore) flush() error {
+	s.Lock()
+	if s.buffer.Len() == 0 {
+		s.Unlock()
+		return nil
+	}
+	flushing := s.buffer
+	s.flushing = flushing
+	s.dropped = map[string]bool{}
+	s.buffer = newSegmentBuilder()
+	s.Unlock()
+
+	segment, err := s.writeSegment(flushing)
+
+	s.Lock()
+	defer s.Unlock()
+
+	if err != nil {
+		s.restore()
+		return err
+	}
+
+	// Documents deleted while the segment was written.
+	for id := range s.dropped {
+		segment.Delete(id)
+	}
+
+	segments := append(append([]*Segment{}, s.segments...), segment)
+	if err := s.writeManifest(segments); err != nil {
+		os.Remove(filepath.Join(s.dir, segment.Name))
+		s.restore()
+		return err
+	}
+	if err := s.writeDeletes(segment); err != nil {
+		return err
+	}
+
+	s.segments = segments
+	s.flushing = nil
+
+	// Merges aren't signalled once Close has stopped the merger.
+	if !s.closed && mergeable(s.segments) != nil {
+		select {
+		case s.merges <- true:
+		default:
+		}
+	}
+
+	// Postings left in the log if this fails are skipped when it's replayed.
+	return s.unlog(flushing)
+}
+
+// restore moves postings that failed to flush back into the buffer, unless the
+// buffer has a newer one.
+func (s *SegmentStore) restore() {
+	s.flushing.Each(func(word string, p posting) error {
+		if !s.dropped[p.DocID] && !s.buffer.Has(p.DocID, p.Field, word) {
+			s.buffer.Add(word, p)
+		}
+		return nil
+	})
+	s.flushing = nil
+}
+
+func (s *SegmentStore) writeDeletes(segment *Segment) error {
+	deleted := segment.Deleted()
+	if len(deleted) == 0 {
+		return nil
+	}
+	return s.writeFile(segment.Name+".del", []byte(strings.Join(deleted, "\n")+"\n"))
+}
+
+// merger merges segments whenever it is signalled until the store is closed,
+// errors are logged and the segments are left as they were.
+func (s *SegmentStore) merger() {
+	defer s.wg.Done()
+	for range s.merges {
+		if err := s.mergeTiers(); err != nil {
+			log.Println("Could not merge segments in", s.dir, err)
+		}
+	}
+}
+
+// segmentTier returns the tier of a segment with size postings. The first tier
+// holds segments of up to FlushSize postings and each tier holds segments up to
+// MergeFactor times larger than the one before.
+func segmentTier(size int) int {
+	factor := MergeFactor
+	if factor < 2 {
+		factor = 2
+	}
+
+	tier := 0
+	for limit := FlushSize; size > limit; limit *= factor {
+		tier++
+	}
+	return tier
+}
+
+// mergeable returns the oldest run of MergeFactor adjacent segments in the
+// lowest tier that has one, or nil if none does. Only adjacent segments are
+// merged so newer postings keep replacing older ones.
+func mergeable(segments []*Segment) []*Segment {
+	if MergeFactor < 2 {
+		return nil
+	}
+
+	var found []*Segment
+	lowest := -1
+	for start := 0; start+MergeFactor <= len(segments); {
+		tier := segmentTier(segments[start].Len())
+		end := start + 1
+		for end < len(segments) && end-start < MergeFactor && segmentTier(segments[end].Len()) == tier {
+			end++
+		}
+		if end-start == MergeFactor && (lowest < 0 || tier < lowest) {
+			lowest, found = tier, segments[start:end]
+		}
+		start = end
+	}
+	return found
+}
+
+// mergeTiers merges segments of the same tier until no tier has MergeFactor of
+// them.
+func (s *SegmentStore) mergeTiers() error {
+	s.merging.Lock()
+	defer s.merging.Unlock()
+
+	for {
+		s.RLock()
+		old := mergeable(s.segments)
+		s.RUnlock()
+		if old == nil {
+			return nil
+		}
+		if err := s.merge(old); err != nil {
+			return err
+		}
+	}
+}
+
+// Merge combines every segment into one, dropping deleted documents.
+func (s *SegmentStore) Merge() error {
+	s.merging.Lock()
+	defer s.merging.Unlock()
+
+	s.RLock()
+	old := append([]*Segment{}, s.segments...)
+	s.RUnlock()
+	if len(old) < 2 {
+		return nil
+	}
+	return s.merge(old)
+}
+
+// merge replaces segments with a single segment holding their live postings.
+func (s *SegmentStore) merge(old []*Segment) error {
+	before := map[string]bool{}
+	for _, segment := range old {
+		for _, id := range segment.Deleted() {
+			before[segment.Name+":"+id] = true
+		}
+	}
+
+	b, err := mergeSegments(old)
+	if err != nil {
+		return err
+	}
+	merged, err := s.writeSegment(b)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	// Carry over documents deleted while the merge ran.
+	merging := map[*Segment]bool{}
+	for _, segment := range old {
+		merging[segment] = true
+		for _, id := range segment.Deleted() {
+			if !before[segment.Name+":"+id] {
+				merged.Delete(id)
+			}
+		}
+	}
+
+	// Segments flushed while the merge ran are kept, the merged segment takes
+	// the place of the first segment it replaces. Only merges remove segments
+	// and they run one at a time, so every old segment is still live.
+	segments := []*Segment{}
+	for _, segment := range s.segments {
+		switch {
+		case segment == old[0]:
+			segments = append(segments, merged)
+		case !merging[segment]:
+			segments = append(segments, segment)
+		}
+	}
+	if err := s.writeManifest(segments); err != nil {
+		os.Remove(filepath.Join(s.dir, merged.Name))
+		return err
+	}
+	if err := s.writeDeletes(merged); err != nil {
+		return err
+	}
+	s.segments = segments
+
+	for _, segment := range old {
+		os.Remove(filepath.Join(s.dir, segment.Name))
+		os.Remove(filepath.Join(s.dir, segment.Name+".del"))
+	}
+	return nil
+}
+
+// Size returns the size of the document store and every live segment file.
+func (s *SegmentStore) Size() (int64, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	size, err := s.docs.Size()
+	if err != nil {
+		return 0, err
+	}
+	size += s.log.Size()
+	for _, segment := range s.segments {
+		info, err := os.Stat(filepath.Join(s.dir, segment.Name))
+		if err != nil {
+			return 0, err
+		}
+		size += info.Size()
+	}
+	return size, nil
+}
+
+// Segments returns the number of live segments.
+func (s *SegmentStore) Segments() int {
+	s.RLock()
+	defer s.RUnlock()
+
+	return len(s.segments)
+}
+
+// PutDocument writes a document to the document store.
+func (s *SegmentStore) PutDocument(d *Document) error {
+	return s.docs.PutDocument(d)
+}
+
+// PutDocuments writes documents to the document store.
+func (s *SegmentStore) PutDocuments(docs []*Document) error {
+	return s.docs.PutDocuments(docs)
+}
+
+// Document reads a document from the document store.
+func (s *SegmentStore) Document(id string) (*Document, error) {
+	return s.docs.Document(id)
+}
+
+// DocumentByURL reads a document from the document store by its URL.
+func (s *SegmentStore) DocumentByURL(url string) (*Document, error) {
+	return s.docs.DocumentByURL(url)
+}
+
+// SiteDocuments pages through a site's documents in the document store.
+func (s *SegmentStore) SiteDocuments(site string, offset, limit int) ([]*Document, int, error) {
+	return s.docs.SiteDocuments(site, offset, limit)
+}
+
+// has reports whether a document already has a posting for word in field.
+func (s *SegmentStore) has(docID, field, word string) bool {
+	if s.buffer.Has(docID, field, word) {
+		return true
+	}
+	if s.flushing != nil && !s.dropped[docID] && s.flushing.Has(docID, field, word) {
+		return true
+	}
+	for _, segment := range s.segments {
+		if segment.Has(docID, field, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// find returns the newest posting a document has for word in field, looking
+// in the buffer, then the postings being flushed and then the segments from
+// newest to oldest.
+func (s *SegmentStore) find(docID, field, word string) (posting, bool, error) {
+	if p, ok := s.buffer.Posting(docID, field, word); ok {
+		return p, true, nil
+	}
+	if s.flushing != nil && !s.dropped[docID] {
+		if p, ok := s.flushing.Posting(docID, field, word); ok {
+			return p, true, nil
+		}
+	}
+	for n := len(s.segments) - 1; n >= 0; n-- {
+		p, ok, err := s.segments[n].Posting(docID, field, word)
+		if ok || err != nil {
+			return p, ok, err
+		}
+	}
+	return posting{}, false, nil
+}
+
+// add logs a posting and adds it to the buffer.
+func (s *SegmentStore) add(word string, p posting) error {
+	data, err := json.Marshal(p.index(word))
+	if err != nil {
+		return err
+	}
+	if err := s.log.Put(postingKey(p.DocID, p.Field, word), data); err != nil {
+		return err
+	}
+	s.buffer.Add(word, p)
+	return nil
+}
+
+// flushFull flushes the buffer once it holds FlushSize postings.
+func (s *SegmentStore) flushFull() error {
+	s.RLock()
+	full := s.buffer.Len() >= FlushSize
+	s.RUnlock()
+
+	if full {
+		return s.Flush()
+	}
+	return nil
+}
+
+// PutIndexes logs and buffers the postings of indexes, an index is a duplicate
+// if its document already has a posting for the word in the same field.
+func (s *SegmentStore) PutIndexes(ixs Indexes) error {
+	s.Lock()
+
+	var duplicate error
+	for _, i := range ixs {
+		if s.has(i.DocID, i.Field, i.Word) {
+			duplicate = ErrDuplicateKey
+			continue
+		}
+
+		if err := s.add(i.Word, posting{DocID: i.DocID, Field: i.Field, Count: i.Count, Text: i.Text}); err != nil {
+			s.Unlock()
+			return err
+		}
+	}
+	s.Unlock()
+
+	if err := s.flushFull(); err != nil {
+		return err
+	}
+	return duplicate
+}
+
+// AddIndexes buffers a posting with the count of each index added to the
+// newest posting its document has for the word, which replaces it.
+func (s *SegmentStore) AddIndexes(ixs Indexes) (Indexes, error) {
+	s.Lock()
+
+	added := Indexes{}
+	for _, i := range ixs {
+		p, ok, err := s.find(i.DocID, i.Field, i.Word)
+		if err != nil {
+			s.Unlock()
+			return added, err
+		}
+		if ok {
+			p.Count += i.Count
+		} else {
+			p = posting{DocID: i.DocID, Field: i.Field, Count: i.Count, Text: i.Text}
+		}
+
+		if err := s.add(i.Word, p); err != nil {
+			s.Unlock()
+			return added, err
+		}
+		if !ok {
+			added = append(added, i)
+		}
+	}
+	s.Unlock()
+
+	return added, s.flushFull()
+}
+
+// DocumentIndexes gathers the newest postings of a document from every segment
+// and the buffer.
+func (s *SegmentStore) DocumentIndexes(docID string) (Indexes, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	ixs := Indexes{}
+	for _, segment := range s.segments {
+		found, err := segment.Indexes(docID)
+		if err != nil {
+			return nil, err
+		}
+		ixs = append(ixs, found...)
+	}
+	if s.flushing != nil && !s.dropped[docID] {
+		ixs = append(ixs, s.flushing.Indexes(docID)...)
+	}
+	ixs = append(ixs, s.buffer.Indexes(docID)...)
+
+	// Later indexes are newer and take the place of the ones they replace.
+	kept := Indexes{}
+	seen := map[string]int{}
+	for _, i := range ixs {
+		if n, ok := seen[i.IndexID]; ok {
+			kept[n] = i
+			continue
+		}
+		seen[i.IndexID] = len(kept)
+		kept = append(kept, i)
+	}
+	return kept, nil
+}
+
+// latest keeps the last of the postings each document has in a field, in the
+// place of the first.
+func latest(ps []posting) []posting {
+	kept := []posting{}
+	seen := map[string]int{}
+	for _, p := range ps {
+		key := p.DocID + "\x00" + p.Field
+		if n, ok := seen[key]; ok {
+			kept[n] = p
+			continue
+		}
+		seen[key] = len(kept)
+		kept = append(kept, p)
+	}
+	return kept
+}
+
+// postings gathers the newest postings of a word from every segment and the
+// buffer.
+func (s *SegmentStore) postings(word string) ([]posting, error) {
+	ps := []posting{}
+	for _, segment := range s.segments {
+		found, err := segment.Postings(word)
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, found...)
+	}
+	if s.flushing != nil {
+		for _, p := range s.flushing.Postings(word) {
+			if !s.dropped[p.DocID] {
+				ps = append(ps, p)
+			}
+		}
+	}
+	return latest(append(ps, s.buffer.Postings(word)...)), nil
+}
+
+// rows joins postings with their documents, keeping those where keep is true.
+func (s *SegmentStore) rows(words []string, lists [][]posting, f *Filter, keep func(string) bool) ([]Result, error) {
+	rows := []Result{}
+	docs := map[string]*Document{}
+
+	for n, list := range lists {
+		for _, p := range list {
+			if !keep(p.DocID) {
+				continue
+			}
+
+			d, ok := docs[p.DocID]
+			if !ok {
+				var err error
+				d, err = s.docs.document(p.DocID)
+				if err == ErrNotFound {
+					d = nil
+				} else if err != nil {
+					return nil, err
+				}
+				docs[p.DocID] = d
+			}
+			if d == nil || !f.Match(d) {
+				continue
+			}
+
+			rows = append(rows, Result{Document: *d, Index: *p.index(words[n])})
+		}
+	}
+	return rows, nil
+}
+
+func (s *SegmentStore) lists(words []string) ([][]posting, error) {
+	lists := make([][]posting, len(words))
+	for n, word := range words {
+		ps, err := s.postings(word)
+		if err != nil {
+			return nil, err
+		}
+		lists[n] = ps
+	}
+	return lists, nil
+}
+
+// Lookup returns the postings of any of the words.
+func (s *SegmentStore) Lookup(words []string, f *Filter) ([]Result, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	lists, err := s.lists(words)
+	if err != nil {
+		return nil, err
+	}
+	return s.rows(words, lists, f, func(string) bool { return true })
+}
+
+// LookupAll returns the postings of documents that contain every word, the
+// postings lists are intersected before any documents are read.
+func (s *SegmentStore) LookupAll(words []string, f *Filter) ([]Result, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	lists, err := s.lists(words)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := intersect(lists)
+	return s.rows(words, lists, f, func(id string) bool { return matches[id] })
+}
+
+// Sites returns the distinct sites of stored documents.
+func (s *SegmentStore) Sites() ([]string, error) {
+	return s.docs.Sites()
+}
+
+// DeleteDocument removes a document, its postings are marked as deleted in
+// each segment and dropped from the buffer and the log.
+func (s *SegmentStore) DeleteDocument(id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.buffer.Remove(id)
+	if s.flushing != nil {
+		s.dropped[id] = true
+	}
+	keys, err := s.log.Keys(id + "\x00")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := s.log.Delete(key); err != nil {
+			return err
+		}
+	}
+	for _, segment := range s.segments {
+		if segment.Delete(id) {
+			if err := s.writeDeletes(segment); err != nil {
+				return err
+			}
+		}
+	}
+	return s.docs.DeleteDocument(id)
+}
+
+// PutLinks writes links to the document store.
+func (s *SegmentStore) PutLinks(links []*Link) error {
+	return s.docs.PutLinks(links)
+}
+
+// LinksTo reads the links to a URL from the document store.
+func (s *SegmentStore) LinksTo(url string) ([]*Link, error) {
+	return s.docs.LinksTo(url)
+}
+
+// LinksFrom reads the links on a document from the document store.
+func (s *SegmentStore) LinksFrom(docID string) ([]*Link, error) {
+	return s.docs.LinksFrom(docID)
+}
+
+// PutMedia writes media to the document store.
+func (s *SegmentStore) PutMedia(media []*Media) error {
+	return s.docs.PutMedia(media)
+}
+
+// LookupMedia reads the media with any of the words from the document store.
+func (s *SegmentStore) LookupMedia(words []string) ([]*Media, error) {
+	return s.docs.LookupMedia(words)
+}
+
+// DocumentMedia reads the media on a document from the document store.
+func (s *SegmentStore) DocumentMedia(docID string) ([]*Media, error) {
+	return s.docs.DocumentMedia(docID)
+}
+
+// EachMedia iterates over the media in the document store.
+func (s *SegmentStore) EachMedia(fn func(m *Media) error) error {
+	return s.docs.EachMedia(fn)
+}
+
+// PutRaw writes raw content to the document store.
+func (s *SegmentStore) PutRaw(r *Raw) error {
+	return s.docs.PutRaw(r)
+}
+
+// Raw reads the raw content of a document from the document store.
+func (s *SegmentStore) Raw(docID string) (*Raw, error) {
+	return s.docs.Raw(docID)
+}
+
+// PutSubscription writes a subscription to the document store.
+func (s *SegmentStore) PutSubscription(sub *Subscription) error {
+	return s.docs.PutSubscription(sub)
+}
+
+// DeleteSubscription removes a subscription from the document store.
+func (s *SegmentStore) DeleteSubscription(url string) error {
+	return s.docs.DeleteSubscription(url)
+}
+
+// EachSubscription iterates over the subscriptions in the document store.
+func (s *SegmentStore) EachSubscription(fn func(sub *Subscription) error) error {
+	return s.docs.EachSubscription(fn)
+}
+
+// EachLink iterates over the links in the document store.
+func (s *SegmentStore) EachLink(fn func(l *Link) error) error {
+	return s.docs.EachLink(fn)
+}
+
+// EachDocument iterates over documents in ID order.
+func (s *SegmentStore) EachDocument(fn func(d *Document) error) error {
+	return s.docs.EachDocument(fn)
+}
+
+// EachIndex iterates over the newest postings of every segment and the buffer,
+// index IDs are made up of the document ID, field and word.
+func (s *SegmentStore) EachIndex(fn func(i *Index) error) error {
+	s.RLock()
+	segments := append([]*Segment{}, s.segments...)
+	buffered := []*Index{}
+	newer := map[string]bool{}
+	for _, b := range []*segmentBuilder{s.buffer, s.flushing} {
+		if b == nil {
+			continue
+		}
+		b.Each(func(word string, p posting) error {
+			key := postingKey(p.DocID, p.Field, word)
+			if !newer[key] && (b != s.flushing || !s.dropped[p.DocID]) {
+				buffered = append(buffered, p.index(word))
+			}
+			newer[key] = true
+			return nil
+		})
+	}
+	s.RUnlock()
+
+	for n, segment := range segments {
+		if err := segment.Each(func(word string, p posting) error {
+			if newer[postingKey(p.DocID, p.Field, word)] {
+				return nil
+			}
+			for _, later := range segments[n+1:] {
+				if later.Has(p.DocID, p.Field, word) {
+					return nil
+				}
+			}
+			return fn(p.index(word))
+		}); err != nil {
+			return err
+		}
+	}
+	for _, i := range buffered {
+		if err := fn(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close flushes buffered postings, waits for any merge and closes the log and
+// the document store.
+func (s *SegmentStore) Close() error {
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.Unlock()
+
+	close(s.merges)
+	s.wg.Wait()
+
+	if err := s.Flush(); err != nil {
+		return err
+	}
+	if err := s.log.Close(); err != nil {
+		return err
+	}
+	return s.docs.Close()
+}
@@ -0,0 +1,370 @@
+package miru
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tempSegmentStore(t *testing.T) (*SegmentStore, string) {
+	dir, err := ioutil.TempDir("", "miru")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	s, err := OpenSegmentStore(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return s, dir
+}
+
+func putDocument(t *testing.T, s Store, url, content string) *Document {
+	d := NewDocument(url, "example.com", "", content)
+	if err := s.PutDocument(d); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := s.PutIndexes(Indexer(d.Content, d.DocID)); err != nil {
+		t.Fatal(err.Error())
+	}
+	return d
+}
+
+func TestSegmentStore_PutLookup(t *testing.T) {
+	s, dir := tempSegmentStore(t)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+
+	d := putDocument(t, s, "http://example.com/", "world news")
+	assert.Equal(t, ErrDuplicateKey, s.PutIndexes(Indexer(d.Content, d.DocID)))
+
+	rows, err := s.Lookup([]string{Normalise("news")}, &Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(rows))
+
+	// Postings stay searchable and duplicates are caught once flushed.
+	assert.NoError(t, s.Flush())
+	assert.Equal(t, 1, s.Segments())
+	assert.Equal(t, ErrDuplicateKey, s.PutIndexes(Indexer(d.Content, d.DocID)))
+
+	rows, err = s.Lookup([]string{Normalise("news"), "world"}, &Filter{Site: "example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(rows))
+	assert.Equal(t, d.DocID, rows[0].Document.DocID)
+	assert.Equal(t, d.DocID+":"+FieldContent+":"+Normalise("news"), rows[0].IndexID)
+}
+
+func TestSegmentStore_LookupAll(t *testing.T) {
+	s, dir := tempSegmentStore(t)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+
+	both := putDocument(t, s, "http://example.com/1", "world news")
+	s.Flush()
+	putDocument(t, s, "http://example.com/2", "local news")
+
+	rows, err := s.LookupAll([]string{Normalise("news"), "world"}, &Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(rows))
+	for _, row := range rows {
+		assert.Equal(t, both.DocID, row.Document.DocID)
+	}
+}
+
+func TestSegmentStore_Reopen(t *testing.T) {
+	s, dir := tempSegmentStore(t)
+	defer os.RemoveAll(dir)
+
+	kept := putDocument(t, s, "http://example.com/1", "world news")
+	deleted := putDocument(t, s, "http://example.com/2", "local news")
+	s.Flush()
+	assert.NoError(t, s.DeleteDocument(deleted.DocID))
+	putDocument(t, s, "http://example.com/3", "weather")
+	assert.NoError(t, s.Close())
+
+	s, err := OpenSegmentStore(dir)
+	assert.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, 2, s.Segments())
+
+	rows, err := s.Lookup([]string{Normalise("news")}, &Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(rows))
+	assert.Equal(t, kept.DocID, rows[0].Document.DocID)
+
+	rows, err = s.Lookup([]string{"weather"}, &Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(rows))
+
+	indexes := 0
+	s.EachIndex(func(i *Index) error {
+		indexes++
+		return nil
+	})
+	assert.Equal(t, 3, indexes)
+}
+
+// crash stops a store without flushing, as if the process had died.
+func crash(s *SegmentStore) {
+	close(s.merges)
+	s.wg.Wait()
+	s.log.Close()
+	s.docs.Close()
+}
+
+func TestSegmentStore_Crash(t *testing.T) {
+	s, dir := tempSegmentStore(t)
+	defer os.RemoveAll(dir)
+
+	flushed := putDocument(t, s, "http://example.com/1", "world news")
+	assert.NoError(t, s.Flush())
+	buffered := putDocument(t, s, "http://example.com/2", "local news")
+	deleted := putDocument(t, s, "http://example.com/3", "old news")
+	assert.NoError(t, s.DeleteDocument(deleted.DocID))
+	crash(s)
+
+	s, err := OpenSegmentStore(dir)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	// Buffered postings are read back from the log, without those of deleted
+	// documents or those already in a segment.
+	rows, err := s.Lookup([]string{Normalise("news")}, &Filter{})
+	assert.NoError(t, err)
+	ids := []string{}
+	for _, row := range rows {
+		ids = append(ids, row.Document.DocID)
+	}
+	assert.Equal(t, 2, len(ids))
+	assert.Contains(t, ids, flushed.DocID)
+	assert.Contains(t, ids, buffered.DocID)
+
+	keys, err := s.log.Keys("")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(keys))
+}
+
+// segmentCounts returns the count of each word's posting for a document.
+func segmentCounts(t *testing.T, s *SegmentStore, docID string) map[string]int64 {
+	ixs, err := s.DocumentIndexes(docID)
+	assert.NoError(t, err)
+	counts := map[string]int64{}
+	for _, i := range ixs {
+		counts[i.Word] = i.Count
+	}
+	return counts
+}
+
+func TestSegmentStore_AddIndexes(t *testing.T) {
+	s, dir := tempSegmentStore(t)
+	defer os.RemoveAll(dir)
+
+	d := NewDocument("http://example.com/", "example.com", "", "")
+	assert.NoError(t, s.PutDocument(d))
+	storm := NewFieldIndex(d.DocID, FieldAnchor, "storm", 1)
+	flood := NewFieldIndex(d.DocID, FieldAnchor, "flood", 1)
+
+	added, err := s.AddIndexes(Indexes{storm})
+	assert.NoError(t, err)
+	assert.Equal(t, Indexes{storm}, added)
+	added, err = s.AddIndexes(Indexes{storm})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(added))
+	assert.Equal(t, map[string]int64{"storm": 2}, segmentCounts(t, s, d.DocID))
+
+	// A count in a segment is replaced by a newer posting in the buffer, then
+	// by the newer segment it's flushed to.
+	assert.NoError(t, s.Flush())
+	added, err = s.AddIndexes(Indexes{storm, flood})
+	assert.NoError(t, err)
+	assert.Equal(t, Indexes{flood}, added)
+	assert.Equal(t, map[string]int64{"storm": 3, "flood": 1}, segmentCounts(t, s, d.DocID))
+	assert.NoError(t, s.Flush())
+	assert.Equal(t, map[string]int64{"storm": 3, "flood": 1}, segmentCounts(t, s, d.DocID))
+
+	rows, err := s.Lookup([]string{"storm"}, &Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(rows))
+	assert.Equal(t, int64(3), rows[0].Count)
+
+	indexes := 0
+	s.EachIndex(func(i *Index) error {
+		indexes++
+		return nil
+	})
+	assert.Equal(t, 2, indexes)
+
+	// Merging keeps the newest count.
+	assert.NoError(t, s.Merge())
+	assert.Equal(t, 1, s.Segments())
+	assert.Equal(t, map[string]int64{"storm": 3, "flood": 1}, segmentCounts(t, s, d.DocID))
+
+	// A count added after a crash is read back from the log.
+	_, err = s.AddIndexes(Indexes{storm})
+	assert.NoError(t, err)
+	crash(s)
+
+	s, err = OpenSegmentStore(dir)
+	assert.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, map[string]int64{"storm": 4, "flood": 1}, segmentCounts(t, s, d.DocID))
+}
+
+func TestSegmentStore_Merge(t *testing.T) {
+	s, dir := tempSegmentStore(t)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+
+	docs := []*Document{}
+	for _, content := range []string{"one news", "two news", "three news"} {
+		docs = append(docs, putDocument(t, s, "http://example.com/"+content, content))
+		s.Flush()
+	}
+	s.DeleteDocument(docs[1].DocID)
+	assert.Equal(t, 3, s.Segments())
+
+	assert.NoError(t, s.Merge())
+	assert.Equal(t, 1, s.Segments())
+
+	files, _ := filepath.Glob(filepath.Join(dir, "seg-*"))
+	assert.Equal(t, 1, len(files))
+
+	rows, err := s.Lookup([]string{Normalise("news")}, &Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(rows))
+
+	// A document re-added under a deleted ID is searchable again.
+	docs[1].Content = "weather"
+	s.PutDocument(docs[1])
+	s.PutIndexes(Indexer(docs[1].Content, docs[1].DocID))
+	rows, _ = s.Lookup([]string{"weather"}, &Filter{})
+	assert.Equal(t, 1, len(rows))
+}
+
+func TestSegmentStore_Mergeable(t *testing.T) {
+	factor, size := MergeFactor, FlushSize
+	MergeFactor, FlushSize = 3, 10
+	defer func() { MergeFactor, FlushSize = factor, size }()
+
+	segments := func(sizes ...int) []*Segment {
+		segments := []*Segment{}
+		for _, size := range sizes {
+			segments = append(segments, &Segment{size: size})
+		}
+		return segments
+	}
+
+	assert.Equal(t, 0, segmentTier(10))
+	assert.Equal(t, 1, segmentTier(11))
+	assert.Equal(t, 1, segmentTier(30))
+	assert.Equal(t, 2, segmentTier(31))
+
+	assert.Nil(t, mergeable(segments(30, 5, 25, 5)))
+	// Adjacent segments of similar size are merged, the lowest tier first.
+	s := segments(30, 25, 20, 5, 8, 4, 3)
+	assert.Equal(t, []*Segment{s[3], s[4], s[5]}, mergeable(s))
+	s = segments(30, 25, 20, 5, 8)
+	assert.Equal(t, []*Segment{s[0], s[1], s[2]}, mergeable(s))
+	// Segments of the same tier with another between them aren't merged.
+	assert.Nil(t, mergeable(segments(30, 5, 25, 5, 20)))
+}
+
+func TestSegmentStore_Merge_Tier(t *testing.T) {
+	s, dir := tempSegmentStore(t)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+
+	for _, content := range []string{"one news", "two news", "three news"} {
+		putDocument(t, s, "http://example.com/"+content, content)
+		s.Flush()
+	}
+
+	// A segment that isn't part of a merge, as one flushed while it runs, is
+	// kept.
+	s.RLock()
+	old := append([]*Segment{}, s.segments[:2]...)
+	last := s.segments[2]
+	s.RUnlock()
+	assert.NoError(t, s.merge(old))
+	assert.Equal(t, 2, s.Segments())
+	assert.Equal(t, last, s.segments[1])
+
+	files, _ := filepath.Glob(filepath.Join(dir, "seg-*"))
+	assert.Equal(t, 2, len(files))
+	rows, err := s.Lookup([]string{Normalise("news")}, &Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(rows))
+}
+
+func TestSegmentStore_BackgroundMerge(t *testing.T) {
+	s, dir := tempSegmentStore(t)
+	defer os.RemoveAll(dir)
+
+	for n := 0; n < MergeFactor; n++ {
+		putDocument(t, s, "http://example.com/", "news")
+		s.Flush()
+	}
+	assert.NoError(t, s.Close())
+
+	s, err := OpenSegmentStore(dir)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	assert.Equal(t, 1, s.Segments())
+	rows, _ := s.Lookup([]string{Normalise("news")}, &Filter{})
+	assert.Equal(t, MergeFactor, len(rows))
+}
+
+func TestSegmentStore_Close_Pending(t *testing.T) {
+	factor := MergeFactor
+	MergeFactor = 3
+	defer func() { MergeFactor = factor }()
+
+	s, dir := tempSegmentStore(t)
+	defer os.RemoveAll(dir)
+
+	for n := 0; n < MergeFactor-1; n++ {
+		putDocument(t, s, "http://example.com/", "news")
+		assert.NoError(t, s.Flush())
+	}
+	// Flushing on close makes enough segments for a merge, after the merger
+	// has stopped.
+	putDocument(t, s, "http://example.com/", "news")
+	assert.Equal(t, MergeFactor-1, s.Segments())
+	assert.NoError(t, s.Close())
+	assert.NoError(t, s.Close())
+
+	s, err := OpenSegmentStore(dir)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	rows, _ := s.Lookup([]string{Normalise("news")}, &Filter{})
+	assert.Equal(t, MergeFactor, len(rows))
+}
+
+func TestSegmentStore_DocumentIndexes(t *testing.T) {
+	s, dir := tempSegmentStore(t)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+
+	flushed := putDocument(t, s, "http://example.com/1", "world news")
+	assert.NoError(t, s.Flush())
+	buffered := putDocument(t, s, "http://example.com/2", "local weather")
+
+	for _, d := range []*Document{flushed, buffered} {
+		ixs, err := s.DocumentIndexes(d.DocID)
+		assert.NoError(t, err)
+		assert.Equal(t, len(Indexer(d.Content, d.DocID)), len(ixs))
+		for _, i := range ixs {
+			assert.Equal(t, d.DocID, i.DocID)
+		}
+	}
+
+	assert.NoError(t, s.DeleteDocument(flushed.DocID))
+	ixs, err := s.DocumentIndexes(flushed.DocID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(ixs))
+}
@@ -0,0 +1,314 @@
+package miru
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Types of schema.org entity kept from a page's structured data.
+const (
+	EntityArticle = "Article"
+	EntityProduct = "Product"
+	EntityRecipe  = "Recipe"
+	EntityEvent   = "Event"
+)
+
+// entityTypes maps lowercased schema.org types onto the entity they are kept
+// as, any type ending in "Event" is kept as an event.
+var entityTypes = map[string]string{
+	"article":            EntityArticle,
+	"newsarticle":        EntityArticle,
+	"blogposting":        EntityArticle,
+	"liveblogposting":    EntityArticle,
+	"scholarlyarticle":   EntityArticle,
+	"techarticle":        EntityArticle,
+	"report":             EntityArticle,
+	"socialmediaposting": EntityArticle,
+	"product":            EntityProduct,
+	"individualproduct":  EntityProduct,
+	"productmodel":       EntityProduct,
+	"recipe":             EntityRecipe,
+	"event":              EntityEvent,
+}
+
+// Entity is a schema.org item found in a page's JSON-LD, microdata or RDFa.
+// Nested properties are flattened into dotted names such as "offers.price",
+// properties with several values have them separated by newlines.
+type Entity struct {
+	Type       string            `gorethink:"type" json:"type"`
+	Properties map[string]string `gorethink:"properties" json:"properties"`
+}
+
+// add appends a value to a property.
+func (e *Entity) add(name, value string) {
+	value = strings.TrimSpace(value)
+	if name == "" || value == "" {
+		return
+	}
+	if existing, ok := e.Properties[name]; ok {
+		value = existing + "\n" + value
+	}
+	e.Properties[name] = value
+}
+
+// Has reports whether any value of a property equals value, ignoring case.
+func (e *Entity) Has(name, value string) bool {
+	for _, v := range strings.Split(e.Properties[name], "\n") {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// EntityType returns the entity a schema.org type is kept as, or "" if it isn't
+// kept. Types are matched ignoring case and can be given as URLs or with a
+// "schema:" prefix.
+func EntityType(t string) string {
+	t = strings.ToLower(strings.TrimSpace(t))
+	if i := strings.LastIndexAny(t, "/:#"); i >= 0 {
+		t = t[i+1:]
+	}
+	if entity, ok := entityTypes[t]; ok {
+		return entity
+	}
+	if strings.HasSuffix(t, "event") {
+		return EntityEvent
+	}
+	return ""
+}
+
+// firstEntityType returns the first kept entity of a list of types.
+func firstEntityType(types []string) string {
+	for _, t := range types {
+		if entity := EntityType(t); entity != "" {
+			return entity
+		}
+	}
+	return ""
+}
+
+// ExtractEntities returns the articles, products, recipes and events described
+// by a page's JSON-LD, microdata and RDFa. It must be called before script tags
+// are stripped.
+func ExtractEntities(doc *goquery.Document) []Entity {
+	entities := []Entity{}
+
+	for _, item := range jsonLD(doc) {
+		entities = linkedEntities(entities, item)
+	}
+
+	entities = append(entities, scopedEntities(doc, "itemscope", "itemtype", "itemprop")...)
+	entities = append(entities, scopedEntities(doc, "typeof", "typeof", "property")...)
+
+	if len(entities) == 0 {
+		return nil
+	}
+	return entities
+}
+
+// jsonLD returns every object in a page's JSON-LD scripts, scripts that aren't
+// valid JSON are skipped.
+func jsonLD(doc *goquery.Document) []map[string]interface{} {
+	items := []map[string]interface{}{}
+	doc.Find(`script[type="application/ld+json"]`).Each(func(i int, s *goquery.Selection) {
+		var data interface{}
+		if err := json.Unmarshal([]byte(s.Text()), &data); err != nil {
+			return
+		}
+		items = append(items, linkedData(data)...)
+	})
+	return items
+}
+
+// linkedEntities appends the entity a JSON-LD value is kept as, or else the
+// entities nested in its properties, such as the Article that is the
+// mainEntity of a WebPage. Items nested in a kept entity are read as part of
+// it.
+func linkedEntities(entities []Entity, value interface{}) []Entity {
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			entities = linkedEntities(entities, item)
+		}
+	case map[string]interface{}:
+		types := []string{}
+		switch t := v["@type"].(type) {
+		case string:
+			types = append(types, t)
+		case []interface{}:
+			for _, value := range t {
+				if s, ok := value.(string); ok {
+					types = append(types, s)
+				}
+			}
+		}
+
+		if entity := firstEntityType(types); entity != "" {
+			e := Entity{Type: entity, Properties: map[string]string{}}
+			for name, value := range v {
+				linkedProperties(&e, name, value)
+			}
+			return append(entities, e)
+		}
+
+		names := []string{}
+		for name := range v {
+			if !strings.HasPrefix(name, "@") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			entities = linkedEntities(entities, v[name])
+		}
+	}
+	return entities
+}
+
+// linkedProperties flattens a JSON-LD value into an entity's properties,
+// keywords such as @type and @context are left out.
+func linkedProperties(e *Entity, name string, value interface{}) {
+	if strings.HasPrefix(name, "@") || strings.Contains(name, ".@") {
+		return
+	}
+
+	switch v := value.(type) {
+	case string:
+		e.add(name, v)
+	case float64:
+		e.add(name, strconv.FormatFloat(v, 'f', -1, 64))
+	case bool:
+		e.add(name, strconv.FormatBool(v))
+	case []interface{}:
+		for _, item := range v {
+			linkedProperties(e, name, item)
+		}
+	case map[string]interface{}:
+		for child, item := range v {
+			linkedProperties(e, name+"."+child, item)
+		}
+	}
+}
+
+// scopedEntities reads microdata or RDFa items, scope is the attribute that
+// starts an item, typeAttr holds its types and prop names its properties. Items
+// that are the value of a kept item's property are read as part of it.
+func scopedEntities(doc *goquery.Document, scope, typeAttr, prop string) []Entity {
+	entities := []Entity{}
+	doc.Find("[" + scope + "]").Each(func(i int, s *goquery.Selection) {
+		if withinEntity(s, scope, typeAttr, prop) {
+			return
+		}
+
+		types, _ := s.Attr(typeAttr)
+		entity := firstEntityType(strings.Fields(types))
+		if entity == "" {
+			return
+		}
+
+		e := Entity{Type: entity, Properties: map[string]string{}}
+		scopedProperties(&e, s, "", scope, prop)
+		entities = append(entities, e)
+	})
+	return entities
+}
+
+// withinEntity reports whether an item is the value of a property of a kept
+// item, directly or through items that aren't kept.
+func withinEntity(s *goquery.Selection, scope, typeAttr, prop string) bool {
+	for {
+		if _, ok := s.Attr(prop); !ok {
+			return false
+		}
+		parent := s.Parent().Closest("[" + scope + "]")
+		if parent.Length() == 0 {
+			return false
+		}
+		types, _ := parent.Attr(typeAttr)
+		if firstEntityType(strings.Fields(types)) != "" {
+			return true
+		}
+		s = parent
+	}
+}
+
+// scopedProperties adds the properties found under an element to an entity,
+// stopping at elements that start a separate item.
+func scopedProperties(e *Entity, s *goquery.Selection, prefix, scope, prop string) {
+	s.Children().Each(func(i int, child *goquery.Selection) {
+		names, hasProp := child.Attr(prop)
+		_, hasScope := child.Attr(scope)
+
+		if !hasProp {
+			if !hasScope {
+				scopedProperties(e, child, prefix, scope, prop)
+			}
+			return
+		}
+
+		for _, name := range strings.Fields(names) {
+			// RDFa properties may be prefixed, as in "schema:name".
+			if i := strings.LastIndexAny(name, "/:#"); i >= 0 {
+				name = name[i+1:]
+			}
+			if hasScope {
+				scopedProperties(e, child, prefix+name+".", scope, prop)
+			} else {
+				e.add(prefix+name, propertyValue(child))
+			}
+		}
+		if !hasScope {
+			scopedProperties(e, child, prefix, scope, prop)
+		}
+	})
+}
+
+// propertyValue returns the value of a microdata or RDFa property element.
+func propertyValue(s *goquery.Selection) string {
+	if content, ok := s.Attr("content"); ok {
+		return content
+	}
+
+	attrs := map[string]string{
+		"a":      "href",
+		"area":   "href",
+		"link":   "href",
+		"img":    "src",
+		"audio":  "src",
+		"video":  "src",
+		"source": "src",
+		"embed":  "src",
+		"iframe": "src",
+		"object": "data",
+		"time":   "datetime",
+		"data":   "value",
+		"meter":  "value",
+	}
+	if attr, ok := attrs[s.Nodes[0].Data]; ok {
+		if value, ok := s.Attr(attr); ok {
+			return value
+		}
+	}
+	return normaliseSpace(s.Text())
+}
+
+// entityText returns the text of a document's entities for indexing, values
+// that are links are left out.
+func entityText(entities []Entity) string {
+	texts := []string{}
+	for _, e := range entities {
+		for _, value := range e.Properties {
+			for _, v := range strings.Split(value, "\n") {
+				if !strings.HasPrefix(v, "http://") && !strings.HasPrefix(v, "https://") {
+					texts = append(texts, v)
+				}
+			}
+		}
+	}
+	return strings.Join(texts, "\n")
+}
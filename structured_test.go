@@ -0,0 +1,166 @@
+package miru
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var structuredHTML = []byte(`
+<!DOCTYPE html>
+<html>
+<head>
+	<script type="application/ld+json">
+	[{
+		"@context": "http://schema.org",
+		"@type": "Recipe",
+		"name": "Pancakes",
+		"recipeCuisine": "French",
+		"recipeIngredient": ["flour", "eggs", "milk"],
+		"author": {"@type": "Person", "name": "Jane Smith"}
+	}, {
+		"@type": "WebSite",
+		"name": "Example"
+	}]
+	</script>
+</head>
+<body>
+	<div itemscope itemtype="http://schema.org/Product">
+		<h1 itemprop="name">Kettle</h1>
+		<div itemprop="offers" itemscope itemtype="http://schema.org/Offer">
+			<span itemprop="price" content="19.99">£19.99</span>
+			<meta itemprop="priceCurrency" content="GBP">
+		</div>
+		<div itemprop="review" itemscope itemtype="http://schema.org/Review">
+			<span itemprop="author">Joe</span>
+		</div>
+	</div>
+	<div vocab="http://schema.org/" typeof="MusicEvent">
+		<span property="name">Summer Gig</span>
+		<time property="startDate" datetime="2015-07-01T19:00">1st July</time>
+		<a property="url" href="http://example.com/gig">Tickets</a>
+	</div>
+</body>
+</html>`)
+
+func TestStructured_ExtractEntities(t *testing.T) {
+	entities := ExtractEntities(parseDocument(structuredHTML))
+
+	if assert.Equal(t, 3, len(entities)) {
+		recipe := entities[0]
+		assert.Equal(t, EntityRecipe, recipe.Type)
+		assert.Equal(t, "Pancakes", recipe.Properties["name"])
+		assert.Equal(t, "flour\neggs\nmilk", recipe.Properties["recipeIngredient"])
+		assert.Equal(t, "Jane Smith", recipe.Properties["author.name"])
+		assert.Equal(t, "", recipe.Properties["@type"])
+
+		product := entities[1]
+		assert.Equal(t, EntityProduct, product.Type)
+		assert.Equal(t, "Kettle", product.Properties["name"])
+		assert.Equal(t, "19.99", product.Properties["offers.price"])
+		assert.Equal(t, "GBP", product.Properties["offers.priceCurrency"])
+		assert.Equal(t, "Joe", product.Properties["review.author"])
+
+		event := entities[2]
+		assert.Equal(t, EntityEvent, event.Type)
+		assert.Equal(t, "Summer Gig", event.Properties["name"])
+		assert.Equal(t, "2015-07-01T19:00", event.Properties["startDate"])
+		assert.Equal(t, "http://example.com/gig", event.Properties["url"])
+	}
+
+	assert.Nil(t, ExtractEntities(parseDocument([]byte(`<p>Nothing</p>`))))
+}
+
+func TestStructured_ExtractEntities_Nested(t *testing.T) {
+	entities := ExtractEntities(parseDocument([]byte(`<html><head>
+	<script type="application/ld+json">
+	{
+		"@type": "WebPage",
+		"name": "News",
+		"mainEntity": {"@type": "NewsArticle", "headline": "Floods"}
+	}
+	</script>
+</head><body>
+	<div itemscope itemtype="http://schema.org/WebPage">
+		<div itemprop="mainEntity" itemscope itemtype="http://schema.org/Product">
+			<span itemprop="name">Kettle</span>
+			<div itemprop="offers" itemscope itemtype="http://schema.org/Offer">
+				<div itemprop="itemOffered" itemscope itemtype="http://schema.org/Product">
+					<span itemprop="name">Lid</span>
+				</div>
+			</div>
+		</div>
+	</div>
+</body></html>`)))
+
+	// Items are kept when the item they are a property of isn't, and read as
+	// part of the nearest one that is.
+	if assert.Equal(t, 2, len(entities)) {
+		assert.Equal(t, EntityArticle, entities[0].Type)
+		assert.Equal(t, "Floods", entities[0].Properties["headline"])
+
+		assert.Equal(t, EntityProduct, entities[1].Type)
+		assert.Equal(t, "Kettle", entities[1].Properties["name"])
+		assert.Equal(t, "Lid", entities[1].Properties["offers.itemOffered.name"])
+	}
+}
+
+func TestStructured_EntityType(t *testing.T) {
+	tests := []struct {
+		Input    string
+		Expected string
+	}{
+		{"NewsArticle", EntityArticle},
+		{"http://schema.org/BlogPosting", EntityArticle},
+		{"https://schema.org/Product", EntityProduct},
+		{"schema:Recipe", EntityRecipe},
+		{"SportsEvent", EntityEvent},
+		{"Person", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.Expected, EntityType(test.Input), test.Input)
+	}
+}
+
+func TestStructured_Search(t *testing.T) {
+	defer TearDown(_ctx)
+
+	d := NewDoc(parseDocument(structuredHTML), "http://example.com/pancakes", "example.com", ExtractMainContent)
+	_ctx.Writer.Add(d, IndexDocument(_ctx.Analyzer, d), nil)
+	other := NewDocument("http://example.com/other", "example.com", "Pancakes", "pancakes")
+	_ctx.Writer.Add(other, IndexDocument(_ctx.Analyzer, other), nil)
+	assert.NoError(t, _ctx.Writer.Flush())
+
+	tests := []struct {
+		Text   string
+		Filter Filter
+		Count  int64
+	}{
+		{"pancakes", Filter{}, 2},
+		{"pancakes", Filter{Entity: "recipe"}, 1},
+		{"pancakes", Filter{Entity: "Recipe", Properties: map[string]string{"recipeCuisine": "french"}}, 1},
+		{"pancakes", Filter{Properties: map[string]string{"recipeIngredient": "eggs"}}, 1},
+		{"pancakes", Filter{Properties: map[string]string{"recipeCuisine": "italian"}}, 0},
+		{"pancakes", Filter{Entity: EntityArticle}, 0},
+		// Entity text is indexed.
+		{"smith", Filter{}, 1},
+	}
+
+	for _, test := range tests {
+		res := new(Results)
+		assert.NoError(t, res.Find(&Query{Text: test.Text, Filter: test.Filter}, _ctx))
+		assert.Equal(t, test.Count, res.Count, "%s %+v", test.Text, test.Filter)
+	}
+
+	res := new(Results)
+	res.Find(&Query{Text: "pancakes"}, _ctx)
+	assert.Equal(t, map[string]int64{EntityRecipe: 1, EntityProduct: 1, EntityEvent: 1}, res.Facets.Entities)
+
+	// Entity types are counted without the entity filter, sites with it.
+	res.Find(&Query{Text: "pancakes", Filter: Filter{Entity: EntityRecipe}}, _ctx)
+	assert.Equal(t, int64(1), res.Count)
+	assert.Equal(t, map[string]int64{EntityRecipe: 1, EntityProduct: 1, EntityEvent: 1}, res.Facets.Entities)
+	assert.Equal(t, map[string]int64{"example.com": 1}, res.Facets.Sites)
+}
@@ -0,0 +1,372 @@
+package miru
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+// WARC record types written by the archive.
+const (
+	WARCInfo     = "warcinfo"
+	WARCRequest  = "request"
+	WARCResponse = "response"
+)
+
+// ErrInvalidWARC for when an archive can't be read as WARC records.
+var ErrInvalidWARC = errors.New("Archive is not a valid WARC file.")
+
+// maxContents is the most bytes of a response body read by Contents, longer
+// bodies are archived as truncated.
+const maxContents = 4194304
+
+// maxBlock is the most bytes of a record's block read by ReadWARC, enough for
+// the longest body that is archived and its HTTP headers.
+const maxBlock = maxContents + 1<<20
+
+// WARCWriter writes every fetch as a pair of WARC/1.1 request and response
+// records to files in Dir. A file is started with a warcinfo record and a new
+// one is opened once a file reaches MaxSize bytes. With Compress set each
+// record is a separate gzip member, as in .warc.gz files.
+type WARCWriter struct {
+	Dir      string
+	Prefix   string
+	MaxSize  int64
+	Compress bool
+
+	file   *os.File
+	name   string
+	size   int64
+	serial int
+	sync.Mutex
+}
+
+// OpenWARCWriter creates a writer from the archive config, making its directory
+// if needed. The first file is opened on the first write.
+func OpenWARCWriter(conf *Config) (*WARCWriter, error) {
+	if err := os.MkdirAll(conf.Archive.Path, 0755); err != nil {
+		return nil, err
+	}
+
+	w := &WARCWriter{
+		Dir:      conf.Archive.Path,
+		Prefix:   conf.Archive.Prefix,
+		MaxSize:  conf.Archive.MaxSize,
+		Compress: conf.Archive.Compress,
+	}
+	if w.Prefix == "" {
+		w.Prefix = "miru"
+	}
+	return w, nil
+}
+
+// Name returns the path of the file being written, "" if none is open.
+func (w *WARCWriter) Name() string {
+	w.Lock()
+	defer w.Unlock()
+
+	return w.name
+}
+
+// Write archives a fetch, resp.Request is the request that was sent after any
+// redirects and body is what was read of the response. Headers are recorded as
+// Go received them, so a body that was decompressed or dechunked in transit is
+// recorded without its Content-Encoding or Transfer-Encoding.
+func (w *WARCWriter) Write(resp *http.Response, body []byte, fetched time.Time) error {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.file == nil || (w.MaxSize > 0 && w.size >= w.MaxSize) {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	req := resp.Request
+	target := req.URL.String()
+	requestID := recordID()
+	responseID := recordID()
+
+	requestHeaders := []string{
+		"WARC-Type: " + WARCRequest,
+		"WARC-Record-ID: " + requestID,
+		"WARC-Date: " + warcDate(fetched),
+		"WARC-Target-URI: " + target,
+		"WARC-Concurrent-To: " + responseID,
+		"Content-Type: application/http;msgtype=request",
+	}
+	if err := w.record(requestHeaders, requestBlock(req), nil); err != nil {
+		return err
+	}
+
+	responseHeaders := []string{
+		"WARC-Type: " + WARCResponse,
+		"WARC-Record-ID: " + responseID,
+		"WARC-Date: " + warcDate(fetched),
+		"WARC-Target-URI: " + target,
+		"Content-Type: application/http;msgtype=response",
+		"WARC-Payload-Digest: " + digest(body),
+	}
+	if len(body) >= maxContents {
+		responseHeaders = append(responseHeaders, "WARC-Truncated: length")
+	}
+	return w.record(responseHeaders, responseBlock(resp), body)
+}
+
+// Close closes the file being written.
+func (w *WARCWriter) Close() error {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	w.name = ""
+	return err
+}
+
+// rotate closes the current file and opens the next, named after the prefix,
+// the time it was opened and a serial number.
+func (w *WARCWriter) rotate() error {
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return err
+		}
+		w.file = nil
+	}
+
+	ext := ".warc"
+	if w.Compress {
+		ext += ".gz"
+	}
+	stamp := time.Now().UTC().Format("20060102150405")
+
+	for {
+		w.serial++
+		name := filepath.Join(w.Dir, fmt.Sprintf("%s-%s-%05d%s", w.Prefix, stamp, w.serial, ext))
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		w.file = f
+		w.name = name
+		w.size = 0
+		break
+	}
+
+	info := []string{
+		"WARC-Type: " + WARCInfo,
+		"WARC-Record-ID: " + recordID(),
+		"WARC-Date: " + warcDate(time.Now()),
+		"WARC-Filename: " + filepath.Base(w.name),
+		"Content-Type: application/warc-fields",
+	}
+	fields := "software: " + UserAgent + "\r\nformat: WARC File Format 1.1\r\n"
+	return w.record(info, []byte(fields), nil)
+}
+
+// record writes a record whose block is head followed by payload, adding the
+// block's length and digest to its headers.
+func (w *WARCWriter) record(headers []string, head, payload []byte) error {
+	block := append(head, payload...)
+
+	buf := new(bytes.Buffer)
+	buf.WriteString("WARC/1.1\r\n")
+	for _, h := range headers {
+		buf.WriteString(h + "\r\n")
+	}
+	fmt.Fprintf(buf, "WARC-Block-Digest: %s\r\n", digest(block))
+	fmt.Fprintf(buf, "Content-Length: %d\r\n\r\n", len(block))
+	buf.Write(block)
+	buf.WriteString("\r\n\r\n")
+
+	var out io.Reader = buf
+	if w.Compress {
+		compressed := new(bytes.Buffer)
+		gz := gzip.NewWriter(compressed)
+		if _, err := buf.WriteTo(gz); err != nil {
+			return err
+		}
+		if err := gz.Close(); err != nil {
+			return err
+		}
+		out = compressed
+	}
+
+	n, err := io.Copy(w.file, out)
+	w.size += n
+	return err
+}
+
+// requestBlock formats a request's line and headers as they were sent.
+func requestBlock(req *http.Request) []byte {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "%s %s HTTP/1.1\r\n", req.Method, req.URL.RequestURI())
+	fmt.Fprintf(buf, "Host: %s\r\n", req.URL.Host)
+	req.Header.Write(buf)
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+// responseBlock formats a response's status line and headers.
+func responseBlock(resp *http.Response) []byte {
+	buf := new(bytes.Buffer)
+	proto := resp.Proto
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+	status := resp.Status
+	if status == "" {
+		status = fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	fmt.Fprintf(buf, "%s %s\r\n", proto, strings.TrimSpace(status))
+	resp.Header.Write(buf)
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+// recordID returns a new WARC record ID.
+func recordID() string {
+	return "<urn:uuid:" + uuid.NewV4().String() + ">"
+}
+
+// warcDate formats a time as WARC/1.1 expects, in UTC.
+func warcDate(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05Z")
+}
+
+// digest returns the base32 SHA-1 digest of some data as WARC labels it.
+func digest(data []byte) string {
+	sum := sha1.Sum(data)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+// WARCRecord is a record read from an archive, Headers holds its named fields
+// as written.
+type WARCRecord struct {
+	Type      string
+	TargetURI string
+	Date      time.Time
+	Headers   map[string]string
+	Block     []byte
+}
+
+// Header returns the value of a named field, names are matched ignoring case.
+func (r *WARCRecord) Header(name string) string {
+	if value, ok := r.Headers[name]; ok {
+		return value
+	}
+	for field, value := range r.Headers {
+		if strings.EqualFold(field, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// Response parses a response record's block into the response's status and
+// headers and the payload that follows them.
+func (r *WARCRecord) Response() (*http.Response, []byte, error) {
+	end := bytes.Index(r.Block, []byte("\r\n\r\n"))
+	if r.Type != WARCResponse || end < 0 {
+		return nil, nil, ErrInvalidWARC
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(r.Block[:end+4])), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp.Body.Close()
+	return resp, r.Block[end+4:], nil
+}
+
+// ReadWARC calls fn with every record of an archive, gzipped archives are read
+// whether each record or the whole file was compressed. Blocks longer than
+// maxBlock are cut short, as the crawler cuts short long bodies.
+func ReadWARC(r io.Reader, fn func(record *WARCRecord) error) error {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+	}
+
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if strings.TrimSpace(line) == "" {
+			if err == io.EOF {
+				return nil
+			}
+			continue
+		}
+		if err == io.EOF || !strings.HasPrefix(line, "WARC/") {
+			return ErrInvalidWARC
+		}
+
+		record := &WARCRecord{Headers: map[string]string{}}
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				return ErrInvalidWARC
+			}
+			line = strings.TrimRight(line, "\r\n")
+			if line == "" {
+				break
+			}
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) != 2 {
+				return ErrInvalidWARC
+			}
+			record.Headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+
+		length, err := strconv.ParseInt(record.Header("Content-Length"), 10, 64)
+		if err != nil || length < 0 {
+			return ErrInvalidWARC
+		}
+		size := length
+		if size > maxBlock {
+			size = maxBlock
+		}
+		record.Block = make([]byte, size)
+		if _, err := io.ReadFull(br, record.Block); err != nil {
+			return ErrInvalidWARC
+		}
+		if _, err := io.CopyN(ioutil.Discard, br, length-size); err != nil {
+			return ErrInvalidWARC
+		}
+		record.Type = record.Header("WARC-Type")
+		record.TargetURI = record.Header("WARC-Target-URI")
+		record.Date, _ = time.Parse(time.RFC3339, record.Header("WARC-Date"))
+
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package miru
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// warcRecord is a record read back from an archive.
+type warcRecord struct {
+	Headers map[string]string
+	Block   string
+}
+
+// readWARC reads every record of an archive, checking each block's length and
+// digest.
+func readWARC(t *testing.T, path string) []warcRecord {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		if r, err = gzip.NewReader(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	br := bufio.NewReader(r)
+
+	records := []warcRecord{}
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF {
+			return records
+		}
+		assert.Equal(t, "WARC/1.1\r\n", line)
+
+		record := warcRecord{Headers: map[string]string{}}
+		for {
+			line, _ = br.ReadString('\n')
+			if line == "\r\n" {
+				break
+			}
+			parts := strings.SplitN(strings.TrimSpace(line), ": ", 2)
+			record.Headers[parts[0]] = parts[1]
+		}
+
+		length, _ := strconv.Atoi(record.Headers["Content-Length"])
+		block := make([]byte, length)
+		io.ReadFull(br, block)
+		record.Block = string(block)
+		assert.Equal(t, digest(block), record.Headers["WARC-Block-Digest"])
+
+		end := make([]byte, 4)
+		io.ReadFull(br, end)
+		assert.Equal(t, "\r\n\r\n", string(end))
+		records = append(records, record)
+	}
+}
+
+func tempWARCWriter(t *testing.T, compress bool) *WARCWriter {
+	dir, err := ioutil.TempDir("", "miru-warc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, _ := LoadConfig(DefaultConfig)
+	conf.Archive.Path = dir
+	conf.Archive.Compress = compress
+
+	w, err := OpenWARCWriter(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func fetch(t *testing.T, url string) (*http.Response, []byte) {
+	resp, err := Get(Request(url))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, Contents(resp)
+}
+
+func TestWARC_Write(t *testing.T) {
+	ts := Handler(200, []byte("<p>Archived</p>"))
+	defer ts.Close()
+
+	for _, compress := range []bool{false, true} {
+		w := tempWARCWriter(t, compress)
+		defer os.RemoveAll(w.Dir)
+
+		resp, body := fetch(t, ts.URL+"/page?a=1")
+		fetched := time.Date(2015, 6, 1, 10, 0, 0, 0, time.UTC)
+		assert.NoError(t, w.Write(resp, body, fetched))
+		name := w.Name()
+		assert.NoError(t, w.Close())
+		assert.Equal(t, compress, strings.HasSuffix(name, ".warc.gz"))
+
+		records := readWARC(t, name)
+		assert.Equal(t, 3, len(records))
+
+		info := records[0]
+		assert.Equal(t, WARCInfo, info.Headers["WARC-Type"])
+		assert.Equal(t, filepath.Base(name), info.Headers["WARC-Filename"])
+
+		request, response := records[1], records[2]
+		assert.Equal(t, WARCRequest, request.Headers["WARC-Type"])
+		assert.Equal(t, ts.URL+"/page?a=1", request.Headers["WARC-Target-URI"])
+		assert.Equal(t, response.Headers["WARC-Record-ID"], request.Headers["WARC-Concurrent-To"])
+		assert.True(t, strings.HasPrefix(request.Block, "GET /page?a=1 HTTP/1.1\r\n"))
+		assert.Contains(t, request.Block, "User-Agent: "+UserAgent+"\r\n")
+
+		assert.Equal(t, WARCResponse, response.Headers["WARC-Type"])
+		assert.Equal(t, "2015-06-01T10:00:00Z", response.Headers["WARC-Date"])
+		assert.Equal(t, digest([]byte("<p>Archived</p>")), response.Headers["WARC-Payload-Digest"])
+		assert.True(t, strings.HasPrefix(response.Block, "HTTP/1.1 200 OK\r\n"))
+		assert.True(t, strings.HasSuffix(response.Block, "\r\n\r\n<p>Archived</p>"))
+		assert.Equal(t, "", response.Headers["WARC-Truncated"])
+	}
+}
+
+func TestWARC_Rotate(t *testing.T) {
+	ts := Handler(200, []byte("<p>Archived</p>"))
+	defer ts.Close()
+
+	w := tempWARCWriter(t, false)
+	defer os.RemoveAll(w.Dir)
+	w.MaxSize = 1
+
+	for i := 0; i < 3; i++ {
+		resp, body := fetch(t, ts.URL)
+		assert.NoError(t, w.Write(resp, body, time.Now()))
+	}
+	assert.NoError(t, w.Close())
+	assert.NoError(t, w.Close())
+
+	files, _ := filepath.Glob(filepath.Join(w.Dir, "miru-*.warc"))
+	assert.Equal(t, 3, len(files))
+	for _, name := range files {
+		assert.Equal(t, 3, len(readWARC(t, name)))
+	}
+}
+
+func TestWARC_IndexPage(t *testing.T) {
+	defer TearDown(_ctx)
+
+	w := tempWARCWriter(t, false)
+	defer os.RemoveAll(w.Dir)
+	_ctx.Archive = w
+	defer func() { _ctx.Archive = nil }()
+
+	ts := Handler(200, []byte("<p>Archived</p>"))
+	defer ts.Close()
+	missing := Handler(404, []byte("Not found"))
+	defer missing.Close()
+
+	assert.NoError(t, IndexPage(_ctx, NewQueue(), ts.URL+"/", ts.URL[len("http://"):]))
+	// Failed fetches are archived too.
+	assert.Equal(t, ErrUnreachableURL, IndexPage(_ctx, NewQueue(), missing.URL+"/", missing.URL[len("http://"):]))
+	name := w.Name()
+	assert.NoError(t, w.Close())
+
+	records := readWARC(t, name)
+	assert.Equal(t, 5, len(records))
+	assert.Equal(t, ts.URL+"/", records[2].Headers["WARC-Target-URI"])
+	assert.True(t, strings.HasPrefix(records[4].Block, "HTTP/1.1 404 Not Found\r\n"))
+}
+
+func TestWARC_ReadWARC(t *testing.T) {
+	ts := Handler(200, []byte("<p>Archived</p>"))
+	defer ts.Close()
+
+	for _, compress := range []bool{false, true} {
+		w := tempWARCWriter(t, compress)
+		defer os.RemoveAll(w.Dir)
+
+		resp, body := fetch(t, ts.URL+"/page")
+		fetched := time.Date(2015, 6, 1, 10, 0, 0, 0, time.UTC)
+		assert.NoError(t, w.Write(resp, body, fetched))
+		name := w.Name()
+		assert.NoError(t, w.Close())
+
+		f, err := os.Open(name)
+		assert.NoError(t, err)
+		defer f.Close()
+
+		records := []*WARCRecord{}
+		assert.NoError(t, ReadWARC(f, func(record *WARCRecord) error {
+			records = append(records, record)
+			return nil
+		}))
+		assert.Equal(t, 3, len(records))
+		assert.Equal(t, []string{WARCInfo, WARCRequest, WARCResponse}, []string{records[0].Type, records[1].Type, records[2].Type})
+
+		response := records[2]
+		assert.Equal(t, ts.URL+"/page", response.TargetURI)
+		assert.Equal(t, fetched, response.Date)
+		r, payload, err := response.Response()
+		assert.NoError(t, err)
+		assert.Equal(t, 200, r.StatusCode)
+		assert.Equal(t, "<p>Archived</p>", string(payload))
+
+		_, _, err = records[1].Response()
+		assert.Equal(t, ErrInvalidWARC, err)
+	}
+}
+
+func TestWARC_ReadWARC_Invalid(t *testing.T) {
+	err := ReadWARC(strings.NewReader("<html></html>"), func(record *WARCRecord) error { return nil })
+	assert.Equal(t, ErrInvalidWARC, err)
+
+	err = ReadWARC(strings.NewReader("WARC/1.1\r\nWARC-Type: response\r\nContent-Length: 100\r\n\r\nshort"), func(record *WARCRecord) error { return nil })
+	assert.Equal(t, ErrInvalidWARC, err)
+
+	// A huge length isn't allocated up front.
+	err = ReadWARC(strings.NewReader("WARC/1.1\r\nContent-Length: 1099511627776\r\n\r\nshort"), func(record *WARCRecord) error { return nil })
+	assert.Equal(t, ErrInvalidWARC, err)
+}
+
+func TestWARC_ReadWARC_Fields(t *testing.T) {
+	body := strings.Repeat("a", maxBlock+10)
+	data := "WARC/1.1\r\nwarc-type: response\r\nWARC-TARGET-URI: http://example.com/\r\n" +
+		"content-length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body + "\r\n\r\n" +
+		"WARC/1.1\r\nWARC-Type: request\r\nContent-Length: 0\r\n\r\n\r\n\r\n"
+
+	records := []*WARCRecord{}
+	assert.NoError(t, ReadWARC(strings.NewReader(data), func(record *WARCRecord) error {
+		records = append(records, record)
+		return nil
+	}))
+
+	// Field names are matched ignoring case and long blocks are cut short.
+	if assert.Equal(t, 2, len(records)) {
+		assert.Equal(t, WARCResponse, records[0].Type)
+		assert.Equal(t, "http://example.com/", records[0].TargetURI)
+		assert.Equal(t, maxBlock, len(records[0].Block))
+		assert.Equal(t, WARCRequest, records[1].Type)
+	}
+}
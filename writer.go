@@ -0,0 +1,348 @@
+package miru
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrWriterClosed for when a document is added after the writer was closed.
+var ErrWriterClosed = errors.New("Index writer is closed.")
+
+// pendingDocument is a document waiting to be written along with its indexes,
+// links, media and raw content.
+type pendingDocument struct {
+	document *Document
+	indexes  Indexes
+	links    []*Link
+	media    []*Media
+	raw      *Raw
+	done     func(error)
+}
+
+// IndexWriter buffers documents and their indexes and writes them to the store
+// in batches. A batch is written once it holds BatchDocuments documents or
+// BatchIndexes indexes, and at least every FlushInterval. Each document's done
+// function is called with the outcome of its write.
+type IndexWriter struct {
+	BatchDocuments int
+	BatchIndexes   int
+	FlushInterval  time.Duration
+
+	c       *Context
+	pending []pendingDocument
+	indexes int
+	err     error
+	closed  bool
+	stop    chan bool
+	ticking bool
+
+	flushing sync.Mutex
+	wg       sync.WaitGroup
+	sync.Mutex
+}
+
+// NewIndexWriter creates a writer for a context's store, batch sizes are read
+// from the context's config when it has one.
+func NewIndexWriter(c *Context) *IndexWriter {
+	w := &IndexWriter{
+		BatchDocuments: 100,
+		BatchIndexes:   10000,
+		FlushInterval:  5 * time.Second,
+		c:              c,
+		stop:           make(chan bool),
+	}
+
+	if c.Config != nil {
+		if n := c.Config.Writer.BatchDocuments; n > 0 {
+			w.BatchDocuments = n
+		}
+		if n := c.Config.Writer.BatchIndexes; n > 0 {
+			w.BatchIndexes = n
+		}
+		if n := c.Config.Writer.FlushInterval; n > 0 {
+			w.FlushInterval = time.Duration(n) * time.Second
+		}
+	}
+	return w
+}
+
+// Add queues a document and its indexes, done may be nil. The batch is written
+// straight away if it is full, errors writing it are only passed to the done
+// functions.
+func (w *IndexWriter) Add(d *Document, ixs Indexes, done func(error)) error {
+	return w.AddPage(&Page{Document: d, Indexes: ixs}, done)
+}
+
+// AddPage queues everything built from a page, as Add.
+func (w *IndexWriter) AddPage(p *Page, done func(error)) error {
+	w.Lock()
+	if w.closed {
+		w.Unlock()
+		return ErrWriterClosed
+	}
+
+	w.pending = append(w.pending, pendingDocument{
+		document: p.Document,
+		indexes:  p.Indexes,
+		links:    p.Links,
+		media:    p.Media,
+		raw:      p.Raw,
+		done:     done,
+	})
+	w.indexes += len(p.Indexes)
+	full := len(w.pending) >= w.BatchDocuments || w.indexes >= w.BatchIndexes
+
+	if !w.ticking {
+		w.ticking = true
+		w.wg.Add(1)
+		go w.tick()
+	}
+	w.Unlock()
+
+	if full {
+		w.Flush()
+	}
+	return nil
+}
+
+// tick flushes the writer every FlushInterval until it is closed.
+func (w *IndexWriter) tick() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.Flush()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// Len returns the number of documents waiting to be written.
+func (w *IndexWriter) Len() int {
+	w.Lock()
+	defer w.Unlock()
+
+	return len(w.pending)
+}
+
+// Flush writes every pending document in a single call, then the indexes,
+// links and media of those that were written in a single call each and their
+// raw content, followed by the indexes of anchor text. Each document's done
+// function is only passed the errors writing its own part of the batch, and the
+// first error is returned after they have all been called.
+func (w *IndexWriter) Flush() error {
+	w.flushing.Lock()
+	defer w.flushing.Unlock()
+
+	w.Lock()
+	batch := w.pending
+	w.pending = nil
+	w.indexes = 0
+	w.Unlock()
+
+	return w.write(batch)
+}
+
+// Err returns the error of the last batch written, or nil if it was written
+// without one other than documents already being stored.
+func (w *IndexWriter) Err() error {
+	w.Lock()
+	defer w.Unlock()
+
+	return w.err
+}
+
+// WritePage writes everything built from a page straight away rather than
+// queueing it, as a batch of its own.
+func (w *IndexWriter) WritePage(p *Page) error {
+	w.flushing.Lock()
+	defer w.flushing.Unlock()
+
+	return w.write([]pendingDocument{{
+		document: p.Document,
+		indexes:  p.Indexes,
+		links:    p.Links,
+		media:    p.Media,
+		raw:      p.Raw,
+	}})
+}
+
+// write stores a batch as Flush describes.
+func (w *IndexWriter) write(batch []pendingDocument) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	errs := make([]error, len(batch))
+	written := w.putDocuments(batch, errs)
+
+	docs := []*Document{}
+	ixs := Indexes{}
+	links := []*Link{}
+	media := []*Media{}
+	for _, n := range written {
+		p := batch[n]
+		w.c.Completer.AddTitle(p.document.Title)
+
+		docs = append(docs, p.document)
+		ixs = append(ixs, p.indexes...)
+		links = append(links, p.links...)
+		media = append(media, p.media...)
+	}
+
+	putEach(batch, written, errs, w.c.Store.PutIndexes(ixs), func(p pendingDocument) error {
+		return w.c.Store.PutIndexes(p.indexes)
+	})
+	for _, n := range written {
+		if errs[n] == nil {
+			w.c.Vocabulary.AddIndexes(batch[n].indexes)
+			w.c.Completer.AddIndexes(batch[n].indexes)
+		}
+	}
+	if len(links) > 0 {
+		putEach(batch, written, errs, w.c.Store.PutLinks(links), func(p pendingDocument) error {
+			return w.c.Store.PutLinks(p.links)
+		})
+	}
+	if len(media) > 0 {
+		putEach(batch, written, errs, w.c.Store.PutMedia(media), func(p pendingDocument) error {
+			return w.c.Store.PutMedia(p.media)
+		})
+	}
+	for _, n := range written {
+		if raw := batch[n].raw; raw != nil {
+			if err := w.c.Store.PutRaw(raw); err != nil && err != ErrDuplicateKey && errs[n] == nil {
+				errs[n] = err
+			}
+		}
+	}
+	anchorErr := w.writeAnchors(docs, links)
+
+	var first error
+	for n, p := range batch {
+		if errs[n] != nil && first == nil {
+			first = errs[n]
+		}
+		if p.done != nil {
+			p.done(errs[n])
+		}
+	}
+	if first == nil {
+		first = anchorErr
+	}
+
+	// Documents that were already stored don't mean the store is failing.
+	w.Lock()
+	w.err = first
+	if first == ErrDuplicateKey {
+		w.err = nil
+	}
+	w.Unlock()
+	return first
+}
+
+// putDocuments writes the documents of a batch in a single call, falling back
+// to a call each when one is already stored to find out which. It returns the
+// positions of the documents that were written, the errors of the rest are set
+// in errs.
+func (w *IndexWriter) putDocuments(batch []pendingDocument, errs []error) []int {
+	docs := make([]*Document, len(batch))
+	written := make([]int, len(batch))
+	for n, p := range batch {
+		docs[n] = p.document
+		written[n] = n
+	}
+
+	err := w.c.Store.PutDocuments(docs)
+	if err == nil {
+		return written
+	}
+	if err != ErrDuplicateKey {
+		for n := range batch {
+			errs[n] = err
+		}
+		return nil
+	}
+
+	written = written[:0]
+	for n, p := range batch {
+		if err := w.c.Store.PutDocument(p.document); err != nil {
+			errs[n] = err
+			continue
+		}
+		written = append(written, n)
+	}
+	return written
+}
+
+// putEach handles the error of writing part of every written document in a
+// single call. When it failed the part is written again for each document with
+// put, so only the documents it failed for are given an error. Duplicates are
+// already stored, so aren't an error.
+func putEach(batch []pendingDocument, written []int, errs []error, err error, put func(p pendingDocument) error) {
+	if err == nil || err == ErrDuplicateKey {
+		return
+	}
+
+	for _, n := range written {
+		if err := put(batch[n]); err != nil && err != ErrDuplicateKey && errs[n] == nil {
+			errs[n] = err
+		}
+	}
+}
+
+// writeAnchors indexes the anchor text of links to the written documents and of
+// the written links to documents already stored. The counts of a document's
+// indexes for the same anchor word are summed and the batch is added to the
+// stored counts in one call, so more links with the same text give the word
+// more weight. Every occurrence is added to the vocabulary and only the new
+// indexes to the completer, if the call fails only the indexes it reports as
+// new are.
+func (w *IndexWriter) writeAnchors(docs []*Document, links []*Link) error {
+	ixs, first := anchorIndexes(w.c, docs, links)
+
+	summed := Indexes{}
+	seen := map[string]*Index{}
+	for _, i := range ixs {
+		if s, ok := seen[i.IndexID]; ok {
+			s.Count += i.Count
+			continue
+		}
+		s := *i
+		seen[i.IndexID] = &s
+		summed = append(summed, &s)
+	}
+
+	added, err := w.c.Store.AddIndexes(summed)
+	if err != nil {
+		if first == nil {
+			first = err
+		}
+		ixs = added
+	}
+	w.c.Vocabulary.AddIndexes(ixs)
+	w.c.Completer.AddIndexes(added)
+	return first
+}
+
+// Close writes anything still pending and stops the writer, later calls to Add
+// fail with ErrWriterClosed.
+func (w *IndexWriter) Close() error {
+	w.Lock()
+	if w.closed {
+		w.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.Unlock()
+
+	close(w.stop)
+	w.wg.Wait()
+	return w.Flush()
+}
@@ -0,0 +1,128 @@
+package miru
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writerContext() *Context {
+	ctx := NewContext()
+	ctx.LoadConfig(DefaultConfig)
+	ctx.Store = NewMemoryStore()
+	return ctx
+}
+
+func TestWriter_Batch(t *testing.T) {
+	ctx := writerContext()
+	w := ctx.Writer
+	w.BatchDocuments = 2
+	defer w.Close()
+
+	d := NewDocument("http://example.com/1", "example.com", "One", "world news")
+	assert.NoError(t, w.Add(d, Indexer(d.Content, d.DocID), nil))
+	assert.Equal(t, 1, w.Len())
+	assert.Equal(t, 0, len(storedIndexes(ctx)))
+
+	d = NewDocument("http://example.com/2", "example.com", "Two", "local news")
+	assert.NoError(t, w.Add(d, Indexer(d.Content, d.DocID), nil))
+	assert.Equal(t, 0, w.Len())
+	assert.Equal(t, 4, len(storedIndexes(ctx)))
+
+	assert.Equal(t, int64(2), ctx.Vocabulary.Frequency("news"))
+	assert.Equal(t, 1, len(ctx.Completer.Complete("on", 10)))
+}
+
+func TestWriter_Errors(t *testing.T) {
+	ctx := writerContext()
+	w := ctx.Writer
+	defer w.Close()
+
+	d := NewDocument("http://example.com/", "example.com", "", "news")
+	ctx.Store.PutDocument(d)
+
+	var reported error
+	w.Add(d, Indexer(d.Content, d.DocID), func(err error) {
+		reported = err
+	})
+	assert.Equal(t, ErrDuplicateKey, w.Flush())
+	assert.Equal(t, ErrDuplicateKey, reported)
+
+	// Indexes of a document that failed to write are dropped.
+	assert.Equal(t, 0, len(storedIndexes(ctx)))
+}
+
+// failingDocIndexes fails to write the indexes of one document.
+type failingDocIndexes struct {
+	Store
+	docID string
+}
+
+func (s failingDocIndexes) PutIndexes(ixs Indexes) error {
+	for _, i := range ixs {
+		if i.DocID == s.docID {
+			return errors.New("Index too large.")
+		}
+	}
+	return s.Store.PutIndexes(ixs)
+}
+
+func TestWriter_Errors_PerDocument(t *testing.T) {
+	ctx := writerContext()
+	w := ctx.Writer
+	defer w.Close()
+
+	bad := NewDocument("http://example.com/bad", "example.com", "", "bad news")
+	good := NewDocument("http://example.com/good", "example.com", "", "good news")
+	ctx.Store = failingDocIndexes{Store: ctx.Store, docID: bad.DocID}
+
+	reported := map[string]error{}
+	for _, d := range []*Document{bad, good} {
+		id := d.DocID
+		w.Add(d, Indexer(d.Content, d.DocID), func(err error) {
+			reported[id] = err
+		})
+	}
+	assert.Error(t, w.Flush())
+
+	// Only the document whose indexes failed is given the error.
+	assert.Error(t, reported[bad.DocID])
+	assert.NoError(t, reported[good.DocID])
+	assert.Equal(t, 2, len(storedIndexes(ctx)))
+	assert.Equal(t, int64(1), ctx.Vocabulary.Frequency("news"))
+}
+
+func TestWriter_Interval(t *testing.T) {
+	ctx := writerContext()
+	w := ctx.Writer
+	w.FlushInterval = 10 * time.Millisecond
+	defer w.Close()
+
+	d := NewDocument("http://example.com/", "example.com", "", "news")
+	done := make(chan error, 1)
+	w.Add(d, Indexer(d.Content, d.DocID), func(err error) {
+		done <- err
+	})
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Writer was not flushed.")
+	}
+}
+
+func TestWriter_Close(t *testing.T) {
+	ctx := writerContext()
+	w := ctx.Writer
+
+	d := NewDocument("http://example.com/", "example.com", "", "news")
+	w.Add(d, Indexer(d.Content, d.DocID), nil)
+
+	assert.NoError(t, w.Close())
+	assert.NoError(t, w.Close())
+	assert.Equal(t, 1, len(storedIndexes(ctx)))
+	assert.Equal(t, ErrWriterClosed, w.Add(d, nil, nil))
+}